@@ -82,7 +82,7 @@ func ExampleToolWithSTDIOServer() {
 				},
 			}
 
-			response, err := mpcServer.GetServer().CreateMessage(types.CreateMessageParams{
+			response, err := mpcServer.GetServer().CreateMessage(extra.Context, types.CreateMessageParams{
 				Messages: []types.SamplingMessage{
 					{
 						Role: "user",
@@ -98,12 +98,11 @@ func ExampleToolWithSTDIOServer() {
 			}
 
 			resultText := "Unable to generate summary"
-			safeTypeResponse, okType := response.(*types.CreateMessageResult)
 			logger.Info(utilsLogger.LogFields{
-				"is_create_message_result": okType,
+				"model": response.Model,
 			}, "Callback response")
-			if okType && safeTypeResponse != nil && safeTypeResponse.Content != nil {
-				if safeContentText, okType := safeTypeResponse.Content.(*types.TextContent); okType && safeContentText != nil {
+			if response.Content != nil {
+				if safeContentText, okType := response.Content.(*types.TextContent); okType && safeContentText != nil {
 					resultText = safeContentText.Text
 				}
 			}
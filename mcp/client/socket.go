@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/shared"
+	"github.com/victorvbello/gomcp/mcp/types"
+	utils "github.com/victorvbello/gomcp/mcp/utils/logger"
+)
+
+const (
+	//The default dial timeout
+	DEFAULT_SOCKET_DIAL_TIMEOUT = 10 * time.Second
+)
+
+type SocketClientTransportOptions struct {
+	//Network to dial: "unix", "tcp", "tcp4" or "tcp6".
+	//
+	//Default is "unix".
+	Network string
+	//Address to dial, the socket file path for "unix" or host:port for tcp networks.
+	Address string
+	//Maximum time to wait for the connection to be established.
+	//
+	//Default is DEFAULT_SOCKET_DIAL_TIMEOUT.
+	DialTimeout time.Duration
+	//Optional custom dialer, useful for tests or to tune keep alive settings.
+	//If is nil a net.Dialer with DialTimeout is used.
+	Dialer func(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+//Client transport for stream connections (unix domain socket or TCP): this dials a SocketServer
+//and communicates using newline-delimited JSON-RPC messages over the connection.
+type SocketClientTransport struct {
+	mu                  sync.RWMutex
+	protocolVersion     string
+	globalOnClose       func()
+	globalOnError       func(err error)
+	globalOnMessage     func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo)
+	globalContext       context.Context
+	globalContextCancel context.CancelFunc
+	network             string
+	address             string
+	dialTimeout         time.Duration
+	dialer              func(ctx context.Context, network string, address string) (net.Conn, error)
+	conn                net.Conn
+	started             bool
+	closeOnce           sync.Once
+	readBuffer          shared.ReadBuffer
+	logger              utils.LogService
+}
+
+func NewSocketClientTransport(opts SocketClientTransportOptions) shared.Transport {
+	nct := &SocketClientTransport{
+		network:     opts.Network,
+		address:     opts.Address,
+		dialTimeout: opts.DialTimeout,
+		dialer:      opts.Dialer,
+		logger:      utils.NewLoggerService(),
+	}
+	if nct.network == "" {
+		nct.network = "unix"
+	}
+	if nct.dialTimeout == 0 {
+		nct.dialTimeout = DEFAULT_SOCKET_DIAL_TIMEOUT
+	}
+	if nct.dialer == nil {
+		d := &net.Dialer{Timeout: nct.dialTimeout}
+		nct.dialer = d.DialContext
+	}
+	//Created here so Close can cancel it, and the dial, while Start runs in another goroutine
+	nct.globalContext, nct.globalContextCancel = context.WithCancel(context.Background())
+	return nct
+}
+
+//Requests may wait for the server, so their handlers run concurrently,
+//the messages keep the wire order
+func (ct *SocketClientTransport) onMessage(message types.JSONRPCMessage) {
+	ct.OnMessage(message, &shared.MessageExtraInfo{ConcurrentHandling: true})
+}
+
+//Starts processing messages on the transport, dialing the server first.
+//
+//This method should only be called after callbacks are installed, or else messages may be lost.
+//
+//NOTE: This method should not be called explicitly when using Client, Server, or Protocol classes, as they will implicitly call start().
+func (ct *SocketClientTransport) Start() error {
+	if ct.address == "" {
+		return fmt.Errorf("address is required")
+	}
+	ct.mu.Lock()
+	if ct.started {
+		ct.mu.Unlock()
+		return fmt.Errorf("socketClientTransport already started! If using Client class, note that connect() calls start() automatically")
+	}
+	ct.started = true
+	ct.mu.Unlock()
+
+	dialCtx, dialCancel := context.WithTimeout(ct.globalContext, ct.dialTimeout)
+	defer dialCancel()
+	conn, err := ct.dialer(dialCtx, ct.network, ct.address)
+	if err != nil {
+		ct.globalContextCancel()
+		return fmt.Errorf("ct.dialer %v", err)
+	}
+	ct.mu.Lock()
+	ct.conn = conn
+	ct.mu.Unlock()
+	//Closed while dialing, the connection is not used
+	if ct.globalContext.Err() != nil {
+		conn.Close()
+		return fmt.Errorf("socketClientTransport closed")
+	}
+
+	go func() {
+		err := shared.ReadMessageStream(conn, &ct.readBuffer, ct.onMessage, ct.OnError)
+		select {
+		case <-ct.globalContext.Done():
+			ct.logger.Info(nil, "gracefully stop reading")
+			return //gracefully stop reading
+		default:
+		}
+		if !errors.Is(err, io.EOF) {
+			ct.OnError(fmt.Errorf("conn.Read %v", err))
+		}
+		//The server went away, release the connection
+		ct.Close()
+	}()
+	return nil
+}
+
+//Sends a JSON-RPC message (request or response).
+//
+//If present, `relatedRequestId` is used to indicate to the transport which incoming request to associate this outgoing message with.
+func (ct *SocketClientTransport) Send(request types.JSONRPCMessage, options *shared.TransportSendOptions) (*types.JSONRPCResponse, error) {
+	msgJSON, err := shared.StdioSerializeMessage(request)
+	if err != nil {
+		return nil, fmt.Errorf("shared.StdioSerializeMessage %v", err)
+	}
+	//Lock the whole write so concurrent callers never interleave lines
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if ct.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	_, err = ct.conn.Write([]byte(msgJSON))
+	if err != nil {
+		return nil, fmt.Errorf("ct.conn.Write %v", err)
+	}
+	return nil, nil
+}
+
+//Closes the connection.
+func (ct *SocketClientTransport) Close() error {
+	var closeErr error
+	ct.closeOnce.Do(func() {
+		ct.globalContextCancel()
+		ct.mu.RLock()
+		conn := ct.conn
+		ct.mu.RUnlock()
+		if conn != nil {
+			if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				ct.OnError(fmt.Errorf("conn.Close %v", err))
+			}
+		}
+		//Clear the buffer and notify closure
+		ct.readBuffer.Clear()
+		err := ct.OnClose()
+		if err != nil {
+			closeErr = fmt.Errorf("OnClose Error %v", err)
+			ct.OnError(closeErr)
+		}
+	})
+	return closeErr
+}
+
+//Callback for when the connection is closed for any reason.
+//
+//This should be invoked when close() is called as well.
+//
+//Always execute first the prop globalOnClose if is defined
+func (ct *SocketClientTransport) OnClose() error {
+	if ct.globalOnClose != nil {
+		ct.globalOnClose()
+	}
+	return nil
+}
+
+//Callback for when an error occurs.
+//
+//Note that errors are not necessarily fatal; they are used for reporting any kind of exceptional condition out of band.
+//
+//Always execute first the prop globalOnError if is defined
+func (ct *SocketClientTransport) OnError(err error) {
+	if ct.globalOnError != nil {
+		ct.globalOnError(err)
+	}
+}
+
+//Callback for when a message (request or response) is received over the connection.
+//
+//Always execute first the prop globalOnMessage if is defined
+func (ct *SocketClientTransport) OnMessage(message types.JSONRPCMessage, extra *shared.MessageExtraInfo) {
+	if ct.globalOnMessage != nil {
+		ct.globalOnMessage(message, extra)
+	}
+}
+
+//Sets the protocol version used for the connection (called when the initialize response is received).
+func (ct *SocketClientTransport) SetProtocolVersion(version string) {
+	ct.protocolVersion = version
+}
+
+//Return the session ID, stream connections have no session header so it is always empty
+func (ct *SocketClientTransport) GetSessionID() string {
+	return ""
+}
+
+//Set this if globalOnClose is needed, this must be executed into OnClose Func first
+func (ct *SocketClientTransport) SetGlobalOnClose(fn func()) {
+	ct.globalOnClose = fn
+}
+
+//Set this if globalOnError is needed, this must be executed into OnError Func first
+func (ct *SocketClientTransport) SetGlobalOnError(fn func(err error)) {
+	ct.globalOnError = fn
+}
+
+//Set this if globalOnMessage is needed, this must be executed into OnMessage Func first
+func (ct *SocketClientTransport) SetGlobalOnMessage(fn func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo)) {
+	ct.globalOnMessage = fn
+}
@@ -35,3 +35,21 @@ var REQUEST_METHODS = map[string]struct{}{
 	METHOD_AUTOCOMPLETE_COMPLETE:            struct{}{},
 	METHOD_LIST_ROOTS:                       struct{}{},
 }
+
+//Requests that can be sent again with no additional effect: the read-only methods plus the ones that set a state.
+//
+//The requests of other methods are idempotent only if marked so by the sender, e.g. a tools/call of a tool with the idempotent hint
+var IDEMPOTENT_REQUEST_METHODS = map[string]struct{}{
+	METHOD_REQUEST_PING:                     struct{}{},
+	METHOD_REQUEST_LIST_RESOURCES:           struct{}{},
+	METHOD_REQUEST_TEMPLATES_LIST_RESOURCES: struct{}{},
+	METHOD_REQUEST_READ_RESOURCES:           struct{}{},
+	METHOD_REQUEST_SUBSCRIBE_RESOURCES:      struct{}{},
+	METHOD_REQUEST_UNSUBSCRIBE_RESOURCES:    struct{}{},
+	METHOD_REQUEST_LIST_PROMPTS:             struct{}{},
+	METHOD_REQUEST_GET_PROMPTS:              struct{}{},
+	METHOD_REQUEST_LIST_TOOLS:               struct{}{},
+	METHOD_REQUEST_SET_LEVEL_LOGGING:        struct{}{},
+	METHOD_AUTOCOMPLETE_COMPLETE:            struct{}{},
+	METHOD_LIST_ROOTS:                       struct{}{},
+}
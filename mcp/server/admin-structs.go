@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/victorvbello/gomcp/mcp/shared"
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+const (
+	ADMIN_HEALTHZ_PATH    = "/healthz"
+	ADMIN_READYZ_PATH     = "/readyz"
+	ADMIN_INTROSPECT_PATH = "/introspect"
+	ADMIN_STATUS_OK       = "ok"
+	ADMIN_STATUS_FAIL     = "fail"
+)
+
+//Authorizes a request to the admin introspection endpoint
+type AdminAuthorizeFunc func(req *http.Request) bool
+
+//Readiness check, a non nil error marks the process as not ready
+type AdminReadinessCheck func() error
+
+type AdminHandlerOptions struct {
+	//Return the servers to report, it is called on every request so servers created per session are included.
+	Servers func() []*McpServer
+	//Prefix of the admin paths, e.g. "/admin" serves /admin/healthz.
+	//Default is empty.
+	BasePath string
+	//Authorizes the requests to the introspection endpoint, the probes are public.
+	//
+	//If is nil the introspection endpoint is disabled.
+	Authorize AdminAuthorizeFunc
+	//Additional checks for readiness, by name.
+	ReadinessChecks map[string]AdminReadinessCheck
+}
+
+//Response of the health and readiness probes
+type AdminProbeResult struct {
+	Status string `json:"status"`
+	//Failed checks, by name
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type AdminToolInfo struct {
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Enabled     bool   `json:"enabled"`
+}
+
+type AdminResourceInfo struct {
+	Name    string `json:"name"`
+	Title   string `json:"title,omitempty"`
+	URI     string `json:"uri"`
+	Enabled bool   `json:"enabled"`
+}
+
+type AdminResourceTemplateInfo struct {
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	URITemplate string `json:"uriTemplate"`
+	Enabled     bool   `json:"enabled"`
+}
+
+type AdminPromptInfo struct {
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Enabled     bool   `json:"enabled"`
+}
+
+//What a server exposes and the state of its session
+type AdminServerInfo struct {
+	ServerInfo types.Implementation `json:"serverInfo"`
+	Connected  bool                 `json:"connected"`
+	//Empty for transports without sessions
+	SessionID string `json:"sessionId,omitempty"`
+	//Empty until the client initializes
+	ProtocolVersion   string                      `json:"protocolVersion,omitempty"`
+	ClientInfo        *types.Implementation       `json:"clientInfo,omitempty"`
+	Tools             []AdminToolInfo             `json:"tools"`
+	Resources         []AdminResourceInfo         `json:"resources"`
+	ResourceTemplates []AdminResourceTemplateInfo `json:"resourceTemplates"`
+	Prompts           []AdminPromptInfo           `json:"prompts"`
+	//State of the concurrency limits, empty if there are no limits
+	Concurrency []shared.ConcurrencyStats `json:"concurrency,omitempty"`
+}
+
+//Active session of a server
+type AdminSessionInfo struct {
+	SessionID       string                `json:"sessionId"`
+	ProtocolVersion string                `json:"protocolVersion,omitempty"`
+	ClientInfo      *types.Implementation `json:"clientInfo,omitempty"`
+}
+
+//Response of the introspection endpoint
+type AdminIntrospection struct {
+	Servers  []AdminServerInfo  `json:"servers"`
+	Sessions []AdminSessionInfo `json:"sessions"`
+}
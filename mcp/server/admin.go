@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//HTTP handler with the liveness and readiness probes and an introspection endpoint,
+//useful to run the servers behind an orchestrator like Kubernetes and to inspect them without a MCP client.
+//
+//- /healthz answers 200 while the process can serve requests
+//- /readyz answers 200 once all the servers are connected to their transports and the readiness checks pass, 503 otherwise
+//- /introspect lists what each server exposes and the active sessions, only when AdminHandlerOptions.Authorize allows it
+type AdminHandler struct {
+	servers         func() []*McpServer
+	basePath        string
+	authorize       AdminAuthorizeFunc
+	readinessChecks map[string]AdminReadinessCheck
+}
+
+func NewAdminHandler(opts AdminHandlerOptions) *AdminHandler {
+	nah := &AdminHandler{
+		servers:         opts.Servers,
+		basePath:        strings.TrimSuffix(opts.BasePath, "/"),
+		authorize:       opts.Authorize,
+		readinessChecks: opts.ReadinessChecks,
+	}
+	if nah.servers == nil {
+		nah.servers = func() []*McpServer { return nil }
+	}
+	return nah
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	res := ResponseWriter{writer: w}
+	w.Header().Set("Cache-Control", "no-store")
+	var path string
+	if strings.HasPrefix(req.URL.Path, h.basePath) {
+		path = strings.TrimPrefix(req.URL.Path, h.basePath)
+	}
+	switch path {
+	case ADMIN_HEALTHZ_PATH, ADMIN_READYZ_PATH, ADMIN_INTROSPECT_PATH:
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch path {
+	case ADMIN_HEALTHZ_PATH:
+		res.WriteJSON(http.StatusOK, AdminProbeResult{Status: ADMIN_STATUS_OK})
+	case ADMIN_READYZ_PATH:
+		result := h.Readiness()
+		code := http.StatusOK
+		if result.Status != ADMIN_STATUS_OK {
+			code = http.StatusServiceUnavailable
+		}
+		res.WriteJSON(code, result)
+	case ADMIN_INTROSPECT_PATH:
+		if h.authorize == nil {
+			http.NotFound(w, req)
+			return
+		}
+		if !h.authorize(req) {
+			res.WriteJSON(http.StatusForbidden, AdminProbeResult{Status: ADMIN_STATUS_FAIL})
+			return
+		}
+		res.WriteJSON(http.StatusOK, h.Introspect())
+	}
+}
+
+//Return ok when all the servers are connected and the readiness checks pass, otherwise the failed checks
+func (h *AdminHandler) Readiness() AdminProbeResult {
+	failed := make(map[string]string)
+	for i, mcps := range h.servers() {
+		if !mcps.IsConnected() {
+			failed[fmt.Sprintf("server %d %s", i, mcps.server.serverInfo.Name)] = "transport not started"
+		}
+	}
+	for name, check := range h.readinessChecks {
+		if err := check(); err != nil {
+			failed[name] = err.Error()
+		}
+	}
+	if len(failed) > 0 {
+		return AdminProbeResult{Status: ADMIN_STATUS_FAIL, Checks: failed}
+	}
+	return AdminProbeResult{Status: ADMIN_STATUS_OK}
+}
+
+//Return what each server exposes and the active sessions
+func (h *AdminHandler) Introspect() AdminIntrospection {
+	result := AdminIntrospection{
+		Servers:  []AdminServerInfo{},
+		Sessions: []AdminSessionInfo{},
+	}
+	for _, mcps := range h.servers() {
+		info := mcps.Describe()
+		result.Servers = append(result.Servers, info)
+		if info.Connected && info.SessionID != "" {
+			result.Sessions = append(result.Sessions, AdminSessionInfo{
+				SessionID:       info.SessionID,
+				ProtocolVersion: info.ProtocolVersion,
+				ClientInfo:      info.ClientInfo,
+			})
+		}
+	}
+	return result
+}
+
+//Return the registered tools, resources, templates and prompts, sorted by name, and the state of the session
+func (mcps *McpServer) Describe() AdminServerInfo {
+	info := AdminServerInfo{
+		ServerInfo:        mcps.server.serverInfo,
+		Connected:         mcps.IsConnected(),
+		ProtocolVersion:   mcps.server.GetProtocolVersion(),
+		ClientInfo:        mcps.server.GetClientVersion(),
+		Tools:             []AdminToolInfo{},
+		Resources:         []AdminResourceInfo{},
+		ResourceTemplates: []AdminResourceTemplateInfo{},
+		Prompts:           []AdminPromptInfo{},
+		Concurrency:       mcps.ConcurrencyStats(),
+	}
+	if transport := mcps.server.GetTransport(); transport != nil {
+		info.SessionID = transport.GetSessionID()
+	}
+	for name, tool := range mcps.registeredTools.GetAll() {
+		info.Tools = append(info.Tools, AdminToolInfo{
+			Name:        name,
+			Title:       tool.Title,
+			Description: tool.Description,
+			Enabled:     tool.Enabled,
+		})
+	}
+	sort.Slice(info.Tools, func(i, j int) bool { return info.Tools[i].Name < info.Tools[j].Name })
+	for uri, resource := range mcps.registeredResources.GetAll() {
+		info.Resources = append(info.Resources, AdminResourceInfo{
+			Name:    resource.Name,
+			Title:   resource.Title,
+			URI:     uri,
+			Enabled: resource.Enabled,
+		})
+	}
+	sort.Slice(info.Resources, func(i, j int) bool { return info.Resources[i].Name < info.Resources[j].Name })
+	for name, template := range mcps.registeredResourceTemplates.GetAll() {
+		info.ResourceTemplates = append(info.ResourceTemplates, AdminResourceTemplateInfo{
+			Name:        name,
+			Title:       template.Title,
+			URITemplate: template.ResourceTemplate.uriTemplate.String(),
+			Enabled:     template.Enabled,
+		})
+	}
+	sort.Slice(info.ResourceTemplates, func(i, j int) bool { return info.ResourceTemplates[i].Name < info.ResourceTemplates[j].Name })
+	for name, prompt := range mcps.registeredPrompts.GetAll() {
+		info.Prompts = append(info.Prompts, AdminPromptInfo{
+			Name:        name,
+			Title:       prompt.Title,
+			Description: prompt.Description,
+			Enabled:     prompt.Enabled,
+		})
+	}
+	sort.Slice(info.Prompts, func(i, j int) bool { return info.Prompts[i].Name < info.Prompts[j].Name })
+	return info
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+	"github.com/victorvbello/gomcp/mcp/utils"
+)
+
+type JWTTokenVerifierOptions struct {
+	//Secret used to verify HS256/HS384/HS512 tokens.
+	HMACSecret []byte
+	//Public key used to verify RS256/RS384/RS512 tokens without `kid` header.
+	RSAPublicKey *rsa.PublicKey
+	//Public keys by `kid` header, used for RS256/RS384/RS512 tokens.
+	RSAPublicKeys map[string]*rsa.PublicKey
+	//If set, the `iss` claim must match.
+	Issuer string
+	//If set, the `aud` claim must contain this value.
+	Audience string
+	//Allowed clock difference when validating `exp` and `nbf`.
+	ClockSkew time.Duration
+	//Return the current time, useful for tests.
+	//
+	//If is nil time.Now is used.
+	Now func() time.Time
+}
+
+//TokenVerifier for JWT access tokens signed with HMAC (HS*) or RSA (RS*) keys, it only uses the standard library.
+//
+//The AuthInfo is filled with:
+//- ClientID from `client_id`, `azp` or `sub` claims
+//- Scopes from the space-delimited `scope` claim or the `scp` list claim
+//- ExpiresAt from the `exp` claim
+//- Extra with all the claims
+type JWTTokenVerifier struct {
+	hmacSecret    []byte
+	rsaPublicKey  *rsa.PublicKey
+	rsaPublicKeys map[string]*rsa.PublicKey
+	issuer        string
+	audience      string
+	clockSkew     time.Duration
+	now           func() time.Time
+}
+
+func NewJWTTokenVerifier(opts JWTTokenVerifierOptions) (*JWTTokenVerifier, error) {
+	if len(opts.HMACSecret) == 0 && opts.RSAPublicKey == nil && len(opts.RSAPublicKeys) == 0 {
+		return nil, fmt.Errorf("at least one verification key is required")
+	}
+	njv := &JWTTokenVerifier{
+		hmacSecret:    opts.HMACSecret,
+		rsaPublicKey:  opts.RSAPublicKey,
+		rsaPublicKeys: opts.RSAPublicKeys,
+		issuer:        opts.Issuer,
+		audience:      opts.Audience,
+		clockSkew:     opts.ClockSkew,
+		now:           opts.Now,
+	}
+	if njv.now == nil {
+		njv.now = time.Now
+	}
+	return njv, nil
+}
+
+func (jv *JWTTokenVerifier) verificationKey(header utils.JWTHeader) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(header.Alg, "HS"):
+		if len(jv.hmacSecret) == 0 {
+			return nil, fmt.Errorf("alg %s not allowed", header.Alg)
+		}
+		return jv.hmacSecret, nil
+	case strings.HasPrefix(header.Alg, "RS"):
+		if header.Kid != "" {
+			if key, ok := jv.rsaPublicKeys[header.Kid]; ok {
+				return key, nil
+			}
+		}
+		if jv.rsaPublicKey == nil {
+			return nil, fmt.Errorf("no key found for kid %q", header.Kid)
+		}
+		return jv.rsaPublicKey, nil
+	}
+	return nil, fmt.Errorf("alg %s not allowed", header.Alg)
+}
+
+//Verifies an access token and returns information about it.
+func (jv *JWTTokenVerifier) VerifyAccessToken(token string) (*types.AuthInfo, error) {
+	jwt, err := utils.ParseJWT(token)
+	if err != nil {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_TOKEN, "Malformed token")
+	}
+	key, err := jv.verificationKey(jwt.Header)
+	if err != nil {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_TOKEN, err.Error())
+	}
+	if err := jwt.VerifySignature(key); err != nil {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_TOKEN, "Invalid token signature")
+	}
+
+	now := jv.now()
+	if exp, ok := jwt.Claims.GetInt64("exp"); ok && now.After(time.Unix(exp, 0).Add(jv.clockSkew)) {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_TOKEN, "Token has expired")
+	}
+	if nbf, ok := jwt.Claims.GetInt64("nbf"); ok && now.Before(time.Unix(nbf, 0).Add(-jv.clockSkew)) {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_TOKEN, "Token is not valid yet")
+	}
+	if jv.issuer != "" && jwt.Claims.GetString("iss") != jv.issuer {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_TOKEN, "Invalid token issuer")
+	}
+	if jv.audience != "" {
+		var validAudience bool
+		for _, aud := range jwt.Claims.GetStrings("aud") {
+			if aud == jv.audience {
+				validAudience = true
+				break
+			}
+		}
+		if !validAudience {
+			return nil, NewOAuthError(OAUTH_ERROR_INVALID_TOKEN, "Invalid token audience")
+		}
+	}
+
+	authInfo := &types.AuthInfo{
+		Token:    token,
+		ClientID: jwt.Claims.GetString("client_id"),
+		Scopes:   jwtScopes(jwt.Claims),
+		Extra:    jwt.Claims,
+	}
+	if authInfo.ClientID == "" {
+		authInfo.ClientID = jwt.Claims.GetString("azp")
+	}
+	if authInfo.ClientID == "" {
+		authInfo.ClientID = jwt.Claims.GetString("sub")
+	}
+	if exp, ok := jwt.Claims.GetInt64("exp"); ok {
+		authInfo.ExpiresAt = int(exp)
+	}
+	return authInfo, nil
+}
+
+func jwtScopes(claims utils.JWTClaims) []string {
+	if scope := claims.GetString("scope"); scope != "" {
+		return strings.Fields(scope)
+	}
+	return claims.GetStrings("scp")
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+const (
+	OAUTH_PROTECTED_RESOURCE_METADATA_PATH    = "/.well-known/oauth-protected-resource"
+	OAUTH_AUTHORIZATION_SERVER_METADATA_PATH  = "/.well-known/oauth-authorization-server"
+	OPENID_CONFIGURATION_PATH                 = "/.well-known/openid-configuration"
+	DEFAULT_AUTHORIZATION_SERVER_METADATA_TTL = time.Hour
+)
+
+type OAuthMetadataOptions struct {
+	//The public URL of the MCP endpoint, published as `resource` (e.g. https://example.com/mcp).
+	ResourceServerURL string
+	//Issuer URLs of the authorization servers that can issue tokens for this resource, at least one is required.
+	AuthorizationServers []string
+	//Scopes that the resource understands.
+	ScopesSupported []string
+	//Human-readable name of the resource.
+	ResourceName string
+	//URL of the developer documentation for the resource.
+	ResourceDocumentation string
+	//If true, serve /.well-known/oauth-authorization-server by fetching the metadata
+	//of the first authorization server, useful for clients that only look for it on the resource host.
+	//Default is false.
+	ProxyAuthorizationServerMetadata *bool
+	//How long the proxied authorization server metadata is cached.
+	//Default is DEFAULT_AUTHORIZATION_SERVER_METADATA_TTL.
+	AuthorizationServerMetadataTTL time.Duration
+	//HTTP client used to fetch the authorization server metadata.
+	//If is nil http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+//Cached authorization server metadata
+type authorizationServerMetadataCache struct {
+	mu        sync.Mutex
+	metadata  *types.OAuthMetadata
+	expiresAt time.Time
+}
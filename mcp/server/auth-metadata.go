@@ -0,0 +1,234 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+	utils "github.com/victorvbello/gomcp/mcp/utils/logger"
+)
+
+//Handler for the OAuth discovery endpoints of a protected MCP server:
+//- /.well-known/oauth-protected-resource, RFC 9728 protected resource metadata
+//- /.well-known/oauth-protected-resource/<resource path>, the path-suffixed variant of the same document
+//- /.well-known/oauth-authorization-server, RFC 8414 metadata proxied from the first authorization server (optional)
+//
+//Usage example:
+//
+//metadataHandler, err := NewOAuthMetadataHandler(OAuthMetadataOptions{
+//   ResourceServerURL:    "https://example.com/mcp",
+//   AuthorizationServers: []string{"https://auth.example.com"},
+//})
+//http.Handle("/.well-known/", metadataHandler)
+//http.Handle("/mcp", RequireBearerAuth(BearerAuthMiddlewareOptions{
+//   Verifier:            verifier,
+//   ResourceMetadataURL: metadataHandler.ResourceMetadataURL(),
+//})(transport))
+type OAuthMetadataHandler struct {
+	resourceMetadata    types.OAuthProtectedResourceMetadata
+	resourceMetadataURL string
+	resourcePath        string
+	proxyASMetadata     bool
+	asMetadataTTL       time.Duration
+	httpClient          *http.Client
+	asMetadataCache     *authorizationServerMetadataCache
+	logger              utils.LogService
+}
+
+func NewOAuthMetadataHandler(opts OAuthMetadataOptions) (*OAuthMetadataHandler, error) {
+	if len(opts.AuthorizationServers) == 0 {
+		return nil, fmt.Errorf("at least one authorization server is required")
+	}
+	resourceURL, err := url.Parse(opts.ResourceServerURL)
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse resource server url %v", err)
+	}
+	if resourceURL.Scheme == "" || resourceURL.Host == "" || resourceURL.Fragment != "" {
+		return nil, fmt.Errorf("invalid resource server url %q, an absolute url without fragment is required", opts.ResourceServerURL)
+	}
+	for _, as := range opts.AuthorizationServers {
+		if _, err := authorizationServerMetadataURL(as, OAUTH_AUTHORIZATION_SERVER_METADATA_PATH); err != nil {
+			return nil, err
+		}
+	}
+	metadataURL, err := ProtectedResourceMetadataURL(opts.ResourceServerURL)
+	if err != nil {
+		return nil, err
+	}
+	nh := &OAuthMetadataHandler{
+		resourceMetadata: types.OAuthProtectedResourceMetadata{
+			Resource:               opts.ResourceServerURL,
+			AuthorizationServers:   opts.AuthorizationServers,
+			ScopesSupported:        opts.ScopesSupported,
+			BearerMethodsSupported: []string{"header"},
+			ResourceName:           opts.ResourceName,
+			ResourceDocumentation:  opts.ResourceDocumentation,
+		},
+		resourceMetadataURL: metadataURL,
+		resourcePath:        strings.TrimRight(resourceURL.EscapedPath(), "/"),
+		asMetadataTTL:       opts.AuthorizationServerMetadataTTL,
+		httpClient:          opts.HTTPClient,
+		asMetadataCache:     &authorizationServerMetadataCache{},
+		logger:              utils.NewLoggerService(),
+	}
+	if opts.ProxyAuthorizationServerMetadata != nil {
+		nh.proxyASMetadata = *opts.ProxyAuthorizationServerMetadata
+	}
+	if nh.asMetadataTTL <= 0 {
+		nh.asMetadataTTL = DEFAULT_AUTHORIZATION_SERVER_METADATA_TTL
+	}
+	if nh.httpClient == nil {
+		nh.httpClient = http.DefaultClient
+	}
+	return nh, nil
+}
+
+//Builds the RFC 9728 metadata URL for a resource, the well-known path is inserted between the host and the resource path.
+//
+//https://example.com/mcp -> https://example.com/.well-known/oauth-protected-resource/mcp
+func ProtectedResourceMetadataURL(resourceServerURL string) (string, error) {
+	u, err := url.Parse(resourceServerURL)
+	if err != nil {
+		return "", fmt.Errorf("url.Parse resource server url %v", err)
+	}
+	resourcePath := strings.TrimRight(u.EscapedPath(), "/")
+	return fmt.Sprintf("%s://%s%s%s", u.Scheme, u.Host, OAUTH_PROTECTED_RESOURCE_METADATA_PATH, resourcePath), nil
+}
+
+//Builds the RFC 8414 metadata URL for an issuer, the well-known path is inserted between the host and the issuer path.
+func authorizationServerMetadataURL(issuer string, wellKnownPath string) (string, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return "", fmt.Errorf("url.Parse authorization server url %v", err)
+	}
+	if u.Scheme == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid authorization server issuer %q", issuer)
+	}
+	issuerPath := strings.TrimRight(u.EscapedPath(), "/")
+	return fmt.Sprintf("%s://%s%s%s", u.Scheme, u.Host, wellKnownPath, issuerPath), nil
+}
+
+//Return the URL of the protected resource metadata document, used as `resource_metadata` in 401 challenges
+func (h *OAuthMetadataHandler) ResourceMetadataURL() string {
+	return h.resourceMetadataURL
+}
+
+//Return the protected resource metadata document
+func (h *OAuthMetadataHandler) ResourceMetadata() types.OAuthProtectedResourceMetadata {
+	return h.resourceMetadata
+}
+
+//Return true if the request path is served by this handler
+func (h *OAuthMetadataHandler) Handles(path string) bool {
+	switch path {
+	case OAUTH_PROTECTED_RESOURCE_METADATA_PATH, OAUTH_PROTECTED_RESOURCE_METADATA_PATH + h.resourcePath:
+		return true
+	case OAUTH_AUTHORIZATION_SERVER_METADATA_PATH:
+		return h.proxyASMetadata
+	}
+	return false
+}
+
+func (h *OAuthMetadataHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	res := ResponseWriter{writer: w}
+	//Metadata documents are public and fetched by browser based clients
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if !h.Handles(req.URL.Path) {
+		res.WriteJSON(http.StatusNotFound, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "Not Found"))
+		return
+	}
+	switch req.Method {
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, mcp-protocol-version")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+	default:
+		w.Header().Set("Allow", "GET, OPTIONS")
+		res.WriteJSON(http.StatusMethodNotAllowed, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "Method not allowed"))
+		return
+	}
+
+	if req.URL.Path == OAUTH_AUTHORIZATION_SERVER_METADATA_PATH {
+		metadata, err := h.authorizationServerMetadata()
+		if err != nil {
+			h.logger.Error(nil, fmt.Sprintf("OAuthMetadataHandler.authorizationServerMetadata %v", err))
+			res.WriteJSON(http.StatusBadGateway, NewOAuthError(OAUTH_ERROR_SERVER_ERROR, "Authorization server metadata unavailable"))
+			return
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.asMetadataTTL.Seconds())))
+		res.WriteJSON(http.StatusOK, metadata)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	res.WriteJSON(http.StatusOK, h.resourceMetadata)
+}
+
+//Return the cached metadata of the first authorization server, fetching it when missing or expired
+func (h *OAuthMetadataHandler) authorizationServerMetadata() (*types.OAuthMetadata, error) {
+	cache := h.asMetadataCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.metadata != nil && time.Now().Before(cache.expiresAt) {
+		return cache.metadata, nil
+	}
+	metadata, err := h.fetchAuthorizationServerMetadata(h.resourceMetadata.AuthorizationServers[0])
+	if err != nil {
+		//Serve stale metadata rather than failing when the authorization server is temporarily unreachable
+		if cache.metadata != nil {
+			h.logger.Warning(nil, fmt.Sprintf("OAuthMetadataHandler.fetchAuthorizationServerMetadata serving stale metadata %v", err))
+			return cache.metadata, nil
+		}
+		return nil, err
+	}
+	cache.metadata = metadata
+	cache.expiresAt = time.Now().Add(h.asMetadataTTL)
+	return metadata, nil
+}
+
+//Fetches the RFC 8414 metadata of an issuer, falling back to OpenID Connect discovery
+func (h *OAuthMetadataHandler) fetchAuthorizationServerMetadata(issuer string) (*types.OAuthMetadata, error) {
+	var errs []string
+	for _, wellKnownPath := range []string{OAUTH_AUTHORIZATION_SERVER_METADATA_PATH, OPENID_CONFIGURATION_PATH} {
+		metadataURL, err := authorizationServerMetadataURL(issuer, wellKnownPath)
+		if err != nil {
+			return nil, err
+		}
+		metadata, err := h.getAuthorizationServerMetadata(metadataURL)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if strings.TrimRight(metadata.Issuer, "/") != strings.TrimRight(issuer, "/") {
+			return nil, fmt.Errorf("issuer mismatch, expected %q got %q", issuer, metadata.Issuer)
+		}
+		return metadata, nil
+	}
+	return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+func (h *OAuthMetadataHandler) getAuthorizationServerMetadata(metadataURL string) (*types.OAuthMetadata, error) {
+	req, err := http.NewRequest(http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http.Client.Do %s %v", metadataURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s unexpected status %d", metadataURL, resp.StatusCode)
+	}
+	var metadata types.OAuthMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("json.Decode %s %v", metadataURL, err)
+	}
+	return &metadata, nil
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/shared"
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+//Middleware that requires a valid Bearer token in the Authorization header.
+//
+//This will validate the token with the verifier and add the resulting auth info to the request context,
+//the StreamableHTTPServerTransport forwards it to the handlers in RequestHandlerExtra.AuthInfo.
+//
+//Usage example:
+//
+//http.Handle("/mcp", RequireBearerAuth(BearerAuthMiddlewareOptions{
+//   Verifier: verifier,
+//})(transport))
+func RequireBearerAuth(opts BearerAuthMiddlewareOptions) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			authInfo, err := bearerAuthenticate(opts, req)
+			if err != nil {
+				writeBearerAuthError(w, opts, err)
+				return
+			}
+			ctx := shared.MakeAuthInfoRequest(req, *authInfo)
+			next.ServeHTTP(w, req.WithContext(ctx))
+		})
+	}
+}
+
+func bearerAuthenticate(opts BearerAuthMiddlewareOptions, req *http.Request) (*types.AuthInfo, error) {
+	if opts.Verifier == nil {
+		return nil, fmt.Errorf("token verifier is not configured")
+	}
+	authHeader := req.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_TOKEN, "Missing Authorization header")
+	}
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") || strings.TrimSpace(parts[1]) == "" {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_TOKEN, "Invalid Authorization header format, expected 'Bearer TOKEN'")
+	}
+	token := strings.TrimSpace(parts[1])
+
+	authInfo, err := opts.Verifier.VerifyAccessToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if authInfo == nil {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_TOKEN, "Token verification returned no auth info")
+	}
+	if authInfo.Token == "" {
+		authInfo.Token = token
+	}
+	//Check if token has the required scopes (if any)
+	if len(opts.RequiredScopes) > 0 && !authInfo.HasScopes(opts.RequiredScopes...) {
+		return nil, NewOAuthError(OAUTH_ERROR_INSUFFICIENT_SCOPE, "Insufficient scope")
+	}
+	//Check if the token is expired
+	if authInfo.ExpiresAt > 0 && int64(authInfo.ExpiresAt) < time.Now().Unix() {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_TOKEN, "Token has expired")
+	}
+	return authInfo, nil
+}
+
+func writeBearerAuthError(w http.ResponseWriter, opts BearerAuthMiddlewareOptions, err error) {
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		oauthErr = NewOAuthError(OAUTH_ERROR_SERVER_ERROR, "Internal Server Error")
+	}
+	statusCode := oauthErr.StatusCode()
+	if statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden {
+		w.Header().Set("WWW-Authenticate", bearerChallenge(opts, oauthErr))
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	b, mErr := json.Marshal(oauthErr)
+	if mErr != nil {
+		return
+	}
+	w.Write(b)
+}
+
+//Builds the `WWW-Authenticate` header value, https://datatracker.ietf.org/doc/html/rfc6750#section-3
+func bearerChallenge(opts BearerAuthMiddlewareOptions, oauthErr *OAuthError) string {
+	var params []string
+	if opts.Realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", opts.Realm))
+	}
+	params = append(params, fmt.Sprintf("error=%q", oauthErr.ErrorCode))
+	if oauthErr.ErrorDescription != "" {
+		params = append(params, fmt.Sprintf("error_description=%q", oauthErr.ErrorDescription))
+	}
+	if oauthErr.ErrorCode == OAUTH_ERROR_INSUFFICIENT_SCOPE && len(opts.RequiredScopes) > 0 {
+		params = append(params, fmt.Sprintf("scope=%q", strings.Join(opts.RequiredScopes, " ")))
+	}
+	if opts.ResourceMetadataURL != "" {
+		params = append(params, fmt.Sprintf("resource_metadata=%q", opts.ResourceMetadataURL))
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
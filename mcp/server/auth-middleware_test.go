@@ -0,0 +1,104 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/utils"
+)
+
+func TestBearerAuthRejectsInvalidTokens(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	now := time.Now()
+	verifier, err := NewJWTTokenVerifier(JWTTokenVerifierOptions{
+		RSAPublicKey: &rsaKey.PublicKey,
+		Issuer:       "https://auth.example.com",
+		Audience:     "https://mcp.example.com",
+		Now:          func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("NewJWTTokenVerifier: %v", err)
+	}
+	validClaims := func() utils.JWTClaims {
+		return utils.JWTClaims{
+			"sub": "client",
+			"iss": "https://auth.example.com",
+			"aud": "https://mcp.example.com",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+	}
+	sign := func(t *testing.T, alg string, claims utils.JWTClaims, key interface{}) string {
+		token, err := utils.SignJWT(utils.JWTHeader{Alg: alg}, claims, key)
+		if err != nil {
+			t.Fatalf("utils.SignJWT: %v", err)
+		}
+		return token
+	}
+	//The public key used as HMAC secret, the verifier must not accept an HS* token for an RSA key
+	publicKeyDER := x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)
+
+	expired := validClaims()
+	expired["exp"] = now.Add(-time.Minute).Unix()
+	wrongAudience := validClaims()
+	wrongAudience["aud"] = "https://other.example.com"
+	wrongIssuer := validClaims()
+	wrongIssuer["iss"] = "https://evil.example.com"
+
+	tests := []struct {
+		name        string
+		token       string
+		description string
+	}{
+		{"expired", sign(t, utils.JWT_ALG_RS256, expired, rsaKey), "Token has expired"},
+		{"wrong alg", sign(t, utils.JWT_ALG_HS256, validClaims(), publicKeyDER), "alg HS256 not allowed"},
+		{"wrong aud", sign(t, utils.JWT_ALG_RS256, wrongAudience, rsaKey), "Invalid token audience"},
+		{"wrong iss", sign(t, utils.JWT_ALG_RS256, wrongIssuer, rsaKey), "Invalid token issuer"},
+	}
+
+	var reached bool
+	handler := RequireBearerAuth(BearerAuthMiddlewareOptions{
+		Verifier:            verifier,
+		ResourceMetadataURL: "https://mcp.example.com/.well-known/oauth-protected-resource",
+	})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reached = true
+	}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = false
+			req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected 401, got %d", rec.Code)
+			}
+			if reached {
+				t.Fatal("expected the handler not to be reached")
+			}
+			challenge := rec.Header().Get("WWW-Authenticate")
+			for _, param := range []string{`error="invalid_token"`, `error_description="` + tt.description + `"`, `resource_metadata="https://mcp.example.com/.well-known/oauth-protected-resource"`} {
+				if !strings.Contains(challenge, param) {
+					t.Fatalf("expected %s in the challenge %q", param, challenge)
+				}
+			}
+		})
+	}
+
+	//The same verifier accepts a valid token
+	req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(t, utils.JWT_ALG_RS256, validClaims(), rsaKey))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !reached {
+		t.Fatalf("expected the valid token to reach the handler, got %d", rec.Code)
+	}
+}
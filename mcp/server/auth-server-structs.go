@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/rsa"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+const (
+	OAUTH_AUTHORIZE_PATH = "/authorize"
+	OAUTH_TOKEN_PATH     = "/token"
+	OAUTH_REGISTER_PATH  = "/register"
+	OAUTH_REVOKE_PATH    = "/revoke"
+
+	OAUTH_GRANT_TYPE_AUTHORIZATION_CODE = "authorization_code"
+	OAUTH_GRANT_TYPE_REFRESH_TOKEN      = "refresh_token"
+	OAUTH_RESPONSE_TYPE_CODE            = "code"
+	OAUTH_CODE_CHALLENGE_METHOD_S256    = "S256"
+
+	OAUTH_TOKEN_AUTH_METHOD_NONE                = "none"
+	OAUTH_TOKEN_AUTH_METHOD_CLIENT_SECRET_POST  = "client_secret_post"
+	OAUTH_TOKEN_AUTH_METHOD_CLIENT_SECRET_BASIC = "client_secret_basic"
+
+	DEFAULT_OAUTH_ACCESS_TOKEN_TTL       = time.Hour
+	DEFAULT_OAUTH_REFRESH_TOKEN_TTL      = 30 * 24 * time.Hour
+	DEFAULT_OAUTH_AUTHORIZATION_CODE_TTL = 10 * time.Minute
+)
+
+//The authorization request shown to the user for consent
+type AuthorizationConsentRequest struct {
+	//The registered client asking for access
+	Client types.OAuthClientInformation
+	//Requested scopes, empty if the client did not ask for specific scopes
+	Scopes []string
+	//RFC 8707 resource indicator sent by the client, the MCP server URL
+	Resource string
+	//Validated redirect URI
+	RedirectURI string
+	//Opaque value sent by the client, returned unchanged in the redirect
+	State string
+}
+
+//The user decision for an authorization request
+type AuthorizationConsentResult struct {
+	//If false the client receives an access_denied error
+	Approved bool
+	//Identifier of the user that granted the access, published as the `sub` claim
+	Subject string
+	//Granted scopes, if is nil the requested scopes are granted
+	Scopes []string
+}
+
+//Asks the user to approve an authorization request.
+//
+//Return a nil result and nil error when the callback already wrote the response, e.g. to render a login or consent page
+//that later submits back to the authorize endpoint with the same query parameters.
+type AuthorizationConsentFunc func(w http.ResponseWriter, req *http.Request, consent AuthorizationConsentRequest) (*AuthorizationConsentResult, error)
+
+//Storage of the dynamically registered clients
+type OAuthClientsStore interface {
+	//Return the client, nil if is not registered
+	GetClient(clientID string) (*types.OAuthClientInformation, error)
+	//Stores a new client, the ClientID and secret are already generated
+	RegisterClient(client types.OAuthClientInformation) error
+}
+
+type OAuthAuthorizationServerOptions struct {
+	//The issuer URL, endpoints are served under its path (e.g. http://localhost:8080/oauth).
+	Issuer string
+	//Secret used to sign the access tokens with HS256.
+	HMACSecret []byte
+	//Private key used to sign the access tokens with RS256, takes precedence over HMACSecret.
+	RSAPrivateKey *rsa.PrivateKey
+	//Optional `kid` header of the signed tokens.
+	KeyID string
+	//Scopes published in the metadata, if not empty requests with other scopes are rejected.
+	ScopesSupported []string
+	//Asks the user to approve the authorization requests, it is required.
+	ConsentCallback AuthorizationConsentFunc
+	//Storage of the registered clients, default is an in-memory store.
+	ClientsStore OAuthClientsStore
+	//If set, used as `aud` claim when the client does not send a `resource` parameter,
+	//default is the issuer.
+	Audience string
+	//Default is DEFAULT_OAUTH_ACCESS_TOKEN_TTL
+	AccessTokenTTL time.Duration
+	//Default is DEFAULT_OAUTH_REFRESH_TOKEN_TTL
+	RefreshTokenTTL time.Duration
+	//Default is DEFAULT_OAUTH_AUTHORIZATION_CODE_TTL
+	AuthorizationCodeTTL time.Duration
+	//Return the current time, useful for tests.
+	//
+	//If is nil time.Now is used.
+	Now func() time.Time
+}
+
+//A grant issued by the authorize endpoint or a refresh token
+type oauthGrant struct {
+	clientID      string
+	subject       string
+	scopes        []string
+	resource      string
+	redirectURI   string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+//In-memory OAuthClientsStore
+type memoryOAuthClientsStore struct {
+	clients *muxMapOAuthClients
+}
+
+func NewMemoryOAuthClientsStore() OAuthClientsStore {
+	return &memoryOAuthClientsStore{clients: newMuxMapOAuthClients()}
+}
+
+func (cs *memoryOAuthClientsStore) GetClient(clientID string) (*types.OAuthClientInformation, error) {
+	client, ok := cs.clients.Get(clientID)
+	if !ok {
+		return nil, nil
+	}
+	return &client, nil
+}
+
+func (cs *memoryOAuthClientsStore) RegisterClient(client types.OAuthClientInformation) error {
+	cs.clients.Set(client.ClientID, client)
+	return nil
+}
+
+//muxMapOAuthClients
+type muxMapOAuthClients struct {
+	mu sync.RWMutex
+	m  map[string]types.OAuthClientInformation
+}
+
+func newMuxMapOAuthClients() *muxMapOAuthClients {
+	return &muxMapOAuthClients{
+		m: make(map[string]types.OAuthClientInformation),
+	}
+}
+
+func (xm *muxMapOAuthClients) Get(key string) (types.OAuthClientInformation, bool) {
+	xm.mu.RLock()
+	val, ok := xm.m[key]
+	xm.mu.RUnlock()
+	return val, ok
+}
+
+func (xm *muxMapOAuthClients) Set(key string, value types.OAuthClientInformation) {
+	xm.mu.Lock()
+	xm.m[key] = value
+	xm.mu.Unlock()
+}
+
+//muxMapOAuthGrants
+type muxMapOAuthGrants struct {
+	mu sync.Mutex
+	m  map[string]oauthGrant
+}
+
+func newMuxMapOAuthGrants() *muxMapOAuthGrants {
+	return &muxMapOAuthGrants{
+		m: make(map[string]oauthGrant),
+	}
+}
+
+func (xm *muxMapOAuthGrants) Set(key string, value oauthGrant) {
+	xm.mu.Lock()
+	xm.m[key] = value
+	xm.mu.Unlock()
+}
+
+func (xm *muxMapOAuthGrants) Get(key string) (oauthGrant, bool) {
+	xm.mu.Lock()
+	val, ok := xm.m[key]
+	xm.mu.Unlock()
+	return val, ok
+}
+
+//Return and remove the grant, codes and refresh tokens are single use
+func (xm *muxMapOAuthGrants) Take(key string) (oauthGrant, bool) {
+	xm.mu.Lock()
+	val, ok := xm.m[key]
+	delete(xm.m, key)
+	xm.mu.Unlock()
+	return val, ok
+}
+
+func (xm *muxMapOAuthGrants) Delete(key string) {
+	xm.mu.Lock()
+	delete(xm.m, key)
+	xm.mu.Unlock()
+}
+
+//Removes the grants expired before now
+func (xm *muxMapOAuthGrants) DeleteExpired(now time.Time) {
+	xm.mu.Lock()
+	for key, value := range xm.m {
+		if now.After(value.expiresAt) {
+			delete(xm.m, key)
+		}
+	}
+	xm.mu.Unlock()
+}
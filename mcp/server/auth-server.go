@@ -0,0 +1,686 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/victorvbello/gomcp/mcp/types"
+	"github.com/victorvbello/gomcp/mcp/utils"
+	logger "github.com/victorvbello/gomcp/mcp/utils/logger"
+)
+
+//Embedded OAuth 2.1 authorization server, intended for local development and tests of OAuth protected MCP servers.
+//
+//It supports:
+//- RFC 8414 metadata at /.well-known/oauth-authorization-server
+//- RFC 7591 dynamic client registration at <issuer>/register
+//- authorization code grant with PKCE (S256 only) at <issuer>/authorize
+//- token issuance and refresh token rotation at <issuer>/token, access tokens are signed JWTs
+//- RFC 7009 refresh token revocation at <issuer>/revoke
+//
+//Clients, codes and refresh tokens are kept in memory unless a ClientsStore is provided.
+//
+//Usage example:
+//
+//authServer, err := NewOAuthAuthorizationServer(OAuthAuthorizationServerOptions{
+//   Issuer:          "http://localhost:8080",
+//   HMACSecret:      []byte("dev-secret"),
+//   ConsentCallback: AutoApproveConsent("dev-user"),
+//})
+//http.Handle("/", authServer)
+//http.Handle("/mcp", RequireBearerAuth(BearerAuthMiddlewareOptions{
+//   Verifier: authServer.TokenVerifier(),
+//})(transport))
+type OAuthAuthorizationServer struct {
+	issuer               string
+	basePath             string
+	signingAlg           string
+	signingKey           interface{}
+	keyID                string
+	tokenVerifier        *JWTTokenVerifier
+	scopesSupported      []string
+	consentCallback      AuthorizationConsentFunc
+	clientsStore         OAuthClientsStore
+	audience             string
+	accessTokenTTL       time.Duration
+	refreshTokenTTL      time.Duration
+	authorizationCodeTTL time.Duration
+	authorizationCodes   *muxMapOAuthGrants
+	refreshTokens        *muxMapOAuthGrants
+	now                  func() time.Time
+	logger               logger.LogService
+}
+
+func NewOAuthAuthorizationServer(opts OAuthAuthorizationServerOptions) (*OAuthAuthorizationServer, error) {
+	issuerURL, err := url.Parse(opts.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse issuer %v", err)
+	}
+	if issuerURL.Scheme == "" || issuerURL.Host == "" || issuerURL.RawQuery != "" || issuerURL.Fragment != "" {
+		return nil, fmt.Errorf("invalid issuer %q, an absolute url without query or fragment is required", opts.Issuer)
+	}
+	if opts.ConsentCallback == nil {
+		return nil, fmt.Errorf("consent callback is required")
+	}
+	nas := &OAuthAuthorizationServer{
+		issuer:               strings.TrimRight(opts.Issuer, "/"),
+		basePath:             strings.TrimRight(issuerURL.Path, "/"),
+		keyID:                opts.KeyID,
+		scopesSupported:      opts.ScopesSupported,
+		consentCallback:      opts.ConsentCallback,
+		clientsStore:         opts.ClientsStore,
+		audience:             opts.Audience,
+		accessTokenTTL:       opts.AccessTokenTTL,
+		refreshTokenTTL:      opts.RefreshTokenTTL,
+		authorizationCodeTTL: opts.AuthorizationCodeTTL,
+		authorizationCodes:   newMuxMapOAuthGrants(),
+		refreshTokens:        newMuxMapOAuthGrants(),
+		now:                  opts.Now,
+		logger:               logger.NewLoggerService(),
+	}
+	verifierOpts := JWTTokenVerifierOptions{Issuer: nas.issuer, Now: opts.Now}
+	switch {
+	case opts.RSAPrivateKey != nil:
+		nas.signingAlg = utils.JWT_ALG_RS256
+		nas.signingKey = opts.RSAPrivateKey
+		verifierOpts.RSAPublicKey = &opts.RSAPrivateKey.PublicKey
+	case len(opts.HMACSecret) > 0:
+		nas.signingAlg = utils.JWT_ALG_HS256
+		nas.signingKey = opts.HMACSecret
+		verifierOpts.HMACSecret = opts.HMACSecret
+	default:
+		return nil, fmt.Errorf("a signing key is required, set RSAPrivateKey or HMACSecret")
+	}
+	if nas.tokenVerifier, err = NewJWTTokenVerifier(verifierOpts); err != nil {
+		return nil, fmt.Errorf("NewJWTTokenVerifier %v", err)
+	}
+	if nas.clientsStore == nil {
+		nas.clientsStore = NewMemoryOAuthClientsStore()
+	}
+	if nas.audience == "" {
+		nas.audience = nas.issuer
+	}
+	if nas.accessTokenTTL <= 0 {
+		nas.accessTokenTTL = DEFAULT_OAUTH_ACCESS_TOKEN_TTL
+	}
+	if nas.refreshTokenTTL <= 0 {
+		nas.refreshTokenTTL = DEFAULT_OAUTH_REFRESH_TOKEN_TTL
+	}
+	if nas.authorizationCodeTTL <= 0 {
+		nas.authorizationCodeTTL = DEFAULT_OAUTH_AUTHORIZATION_CODE_TTL
+	}
+	if nas.now == nil {
+		nas.now = time.Now
+	}
+	return nas, nil
+}
+
+//Consent callback that approves every request for the given subject, only for local development and tests
+func AutoApproveConsent(subject string) AuthorizationConsentFunc {
+	return func(w http.ResponseWriter, req *http.Request, consent AuthorizationConsentRequest) (*AuthorizationConsentResult, error) {
+		return &AuthorizationConsentResult{Approved: true, Subject: subject}, nil
+	}
+}
+
+//Return the RFC 8414 metadata of the server
+func (as *OAuthAuthorizationServer) Metadata() types.OAuthMetadata {
+	return types.OAuthMetadata{
+		Issuer:                                 as.issuer,
+		AuthorizationEndpoint:                  as.issuer + OAUTH_AUTHORIZE_PATH,
+		TokenEndpoint:                          as.issuer + OAUTH_TOKEN_PATH,
+		RegistrationEndpoint:                   as.issuer + OAUTH_REGISTER_PATH,
+		RevocationEndpoint:                     as.issuer + OAUTH_REVOKE_PATH,
+		ScopesSupported:                        as.scopesSupported,
+		ResponseTypesSupported:                 []string{OAUTH_RESPONSE_TYPE_CODE},
+		GrantTypesSupported:                    []string{OAUTH_GRANT_TYPE_AUTHORIZATION_CODE, OAUTH_GRANT_TYPE_REFRESH_TOKEN},
+		TokenEndpointAuthMethodsSupported:      oauthTokenAuthMethods,
+		RevocationEndpointAuthMethodsSupported: oauthTokenAuthMethods,
+		CodeChallengeMethodsSupported:          []string{OAUTH_CODE_CHALLENGE_METHOD_S256},
+	}
+}
+
+var oauthTokenAuthMethods = []string{
+	OAUTH_TOKEN_AUTH_METHOD_CLIENT_SECRET_BASIC,
+	OAUTH_TOKEN_AUTH_METHOD_CLIENT_SECRET_POST,
+	OAUTH_TOKEN_AUTH_METHOD_NONE,
+}
+
+//Return a TokenVerifier that accepts the access tokens issued by this server, to be used with RequireBearerAuth
+func (as *OAuthAuthorizationServer) TokenVerifier() TokenVerifier {
+	return as.tokenVerifier
+}
+
+func (as *OAuthAuthorizationServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	res := ResponseWriter{writer: w}
+	//The endpoints are called by browser based clients
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if req.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, mcp-protocol-version")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	switch req.URL.Path {
+	case OAUTH_AUTHORIZATION_SERVER_METADATA_PATH + as.basePath, as.basePath + OAUTH_AUTHORIZATION_SERVER_METADATA_PATH:
+		if req.Method != http.MethodGet {
+			as.writeMethodNotAllowed(res, http.MethodGet)
+			return
+		}
+		res.WriteJSON(http.StatusOK, as.Metadata())
+	case as.basePath + OAUTH_REGISTER_PATH:
+		if req.Method != http.MethodPost {
+			as.writeMethodNotAllowed(res, http.MethodPost)
+			return
+		}
+		as.handleRegister(res, req)
+	case as.basePath + OAUTH_AUTHORIZE_PATH:
+		if req.Method != http.MethodGet && req.Method != http.MethodPost {
+			as.writeMethodNotAllowed(res, http.MethodGet, http.MethodPost)
+			return
+		}
+		as.handleAuthorize(res, req)
+	case as.basePath + OAUTH_TOKEN_PATH:
+		if req.Method != http.MethodPost {
+			as.writeMethodNotAllowed(res, http.MethodPost)
+			return
+		}
+		as.handleToken(res, req)
+	case as.basePath + OAUTH_REVOKE_PATH:
+		if req.Method != http.MethodPost {
+			as.writeMethodNotAllowed(res, http.MethodPost)
+			return
+		}
+		as.handleRevoke(res, req)
+	default:
+		res.WriteJSON(http.StatusNotFound, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "Not Found"))
+	}
+}
+
+func (as *OAuthAuthorizationServer) writeMethodNotAllowed(res ResponseWriter, methods ...string) {
+	res.Writer().Header().Set("Allow", strings.Join(methods, ", "))
+	res.WriteJSON(http.StatusMethodNotAllowed, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "Method not allowed"))
+}
+
+func (as *OAuthAuthorizationServer) writeOAuthError(res ResponseWriter, oauthErr *OAuthError) {
+	if oauthErr.ErrorCode == OAUTH_ERROR_INVALID_CLIENT {
+		res.Writer().Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	res.Writer().Header().Set("Cache-Control", "no-store")
+	res.WriteJSON(oauthErr.StatusCode(), oauthErr)
+}
+
+//Handles the RFC 7591 client registration
+func (as *OAuthAuthorizationServer) handleRegister(res ResponseWriter, req *http.Request) {
+	var metadata types.OAuthClientMetadata
+	if err := json.NewDecoder(http.MaxBytesReader(res.Writer(), req.Body, MAXIMUM_MESSAGE_SIZE)).Decode(&metadata); err != nil {
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT_METADATA, fmt.Sprintf("Invalid JSON body: %v", err)))
+		return
+	}
+	if len(metadata.RedirectURIs) == 0 {
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_REDIRECT_URI, "At least one redirect_uri is required"))
+		return
+	}
+	for _, redirectURI := range metadata.RedirectURIs {
+		u, err := url.Parse(redirectURI)
+		if err != nil || u.Scheme == "" || u.Fragment != "" {
+			as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_REDIRECT_URI, fmt.Sprintf("Invalid redirect_uri %q", redirectURI)))
+			return
+		}
+	}
+	if metadata.TokenEndpointAuthMethod == "" {
+		metadata.TokenEndpointAuthMethod = OAUTH_TOKEN_AUTH_METHOD_CLIENT_SECRET_BASIC
+	}
+	if !oauthContains(oauthTokenAuthMethods, metadata.TokenEndpointAuthMethod) {
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT_METADATA,
+			fmt.Sprintf("Unsupported token_endpoint_auth_method %s", metadata.TokenEndpointAuthMethod)))
+		return
+	}
+	if len(metadata.GrantTypes) == 0 {
+		metadata.GrantTypes = []string{OAUTH_GRANT_TYPE_AUTHORIZATION_CODE, OAUTH_GRANT_TYPE_REFRESH_TOKEN}
+	}
+	for _, grantType := range metadata.GrantTypes {
+		if grantType != OAUTH_GRANT_TYPE_AUTHORIZATION_CODE && grantType != OAUTH_GRANT_TYPE_REFRESH_TOKEN {
+			as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT_METADATA, fmt.Sprintf("Unsupported grant_type %s", grantType)))
+			return
+		}
+	}
+	if len(metadata.ResponseTypes) == 0 {
+		metadata.ResponseTypes = []string{OAUTH_RESPONSE_TYPE_CODE}
+	}
+	for _, responseType := range metadata.ResponseTypes {
+		if responseType != OAUTH_RESPONSE_TYPE_CODE {
+			as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT_METADATA, fmt.Sprintf("Unsupported response_type %s", responseType)))
+			return
+		}
+	}
+	if metadata.Scope != "" {
+		if err := as.validateScopes(strings.Fields(metadata.Scope), nil); err != nil {
+			as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT_METADATA, err.ErrorDescription))
+			return
+		}
+	}
+
+	client := types.OAuthClientInformation{
+		OAuthClientMetadata: metadata,
+		ClientID:            strings.ReplaceAll(uuid.New().String(), "-", ""),
+		ClientIDIssuedAt:    as.now().Unix(),
+	}
+	if metadata.TokenEndpointAuthMethod != OAUTH_TOKEN_AUTH_METHOD_NONE {
+		secret, err := oauthRandomToken()
+		if err != nil {
+			as.logger.Error(nil, fmt.Sprintf("OAuthAuthorizationServer.handleRegister oauthRandomToken %v", err))
+			as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_SERVER_ERROR, "Internal Server Error"))
+			return
+		}
+		client.ClientSecret = secret
+	}
+	if err := as.clientsStore.RegisterClient(client); err != nil {
+		as.logger.Error(nil, fmt.Sprintf("OAuthAuthorizationServer.handleRegister clientsStore.RegisterClient %v", err))
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_SERVER_ERROR, "Internal Server Error"))
+		return
+	}
+	res.Writer().Header().Set("Cache-Control", "no-store")
+	res.WriteJSON(http.StatusCreated, client)
+}
+
+//Handles the authorization request, https://datatracker.ietf.org/doc/html/draft-ietf-oauth-v2-1#section-4.1.1
+func (as *OAuthAuthorizationServer) handleAuthorize(res ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "Invalid request parameters"))
+		return
+	}
+	params := req.Form
+
+	//Errors before the redirect_uri is validated must not redirect
+	client, err := as.clientsStore.GetClient(params.Get("client_id"))
+	if err != nil {
+		as.logger.Error(nil, fmt.Sprintf("OAuthAuthorizationServer.handleAuthorize clientsStore.GetClient %v", err))
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_SERVER_ERROR, "Internal Server Error"))
+		return
+	}
+	if client == nil {
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT, "Unknown client_id"))
+		return
+	}
+	redirectURI := params.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !oauthContains(client.RedirectURIs, redirectURI) {
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "Unregistered redirect_uri"))
+		return
+	}
+
+	state := params.Get("state")
+	redirectError := func(oauthErr *OAuthError) {
+		as.redirect(res, req, redirectURI, url.Values{
+			"error":             {oauthErr.ErrorCode},
+			"error_description": {oauthErr.ErrorDescription},
+		}, state)
+	}
+	if params.Get("response_type") != OAUTH_RESPONSE_TYPE_CODE {
+		redirectError(NewOAuthError(OAUTH_ERROR_UNSUPPORTED_RESPONSE_TYPE, "Only response_type=code is supported"))
+		return
+	}
+	if !oauthContains(client.GrantTypes, OAUTH_GRANT_TYPE_AUTHORIZATION_CODE) {
+		redirectError(NewOAuthError(OAUTH_ERROR_UNAUTHORIZED_CLIENT, "Client is not allowed to use the authorization_code grant"))
+		return
+	}
+	codeChallenge := params.Get("code_challenge")
+	if codeChallenge == "" {
+		redirectError(NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "code_challenge is required"))
+		return
+	}
+	if params.Get("code_challenge_method") != OAUTH_CODE_CHALLENGE_METHOD_S256 {
+		redirectError(NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "Only code_challenge_method=S256 is supported"))
+		return
+	}
+	scopes := strings.Fields(params.Get("scope"))
+	var clientScopes []string
+	if client.Scope != "" {
+		clientScopes = strings.Fields(client.Scope)
+	}
+	if oauthErr := as.validateScopes(scopes, clientScopes); oauthErr != nil {
+		redirectError(oauthErr)
+		return
+	}
+	resource := params.Get("resource")
+	if resource != "" {
+		if u, err := url.Parse(resource); err != nil || u.Scheme == "" || u.Fragment != "" {
+			redirectError(NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "Invalid resource"))
+			return
+		}
+	}
+
+	consent, err := as.consentCallback(res.Writer(), req, AuthorizationConsentRequest{
+		Client:      *client,
+		Scopes:      scopes,
+		Resource:    resource,
+		RedirectURI: redirectURI,
+		State:       state,
+	})
+	if err != nil {
+		as.logger.Error(nil, fmt.Sprintf("OAuthAuthorizationServer.handleAuthorize consentCallback %v", err))
+		redirectError(NewOAuthError(OAUTH_ERROR_SERVER_ERROR, "Consent failed"))
+		return
+	}
+	if consent == nil {
+		//The callback wrote the response
+		return
+	}
+	if !consent.Approved {
+		redirectError(NewOAuthError(OAUTH_ERROR_ACCESS_DENIED, "The user denied the request"))
+		return
+	}
+	if consent.Scopes != nil {
+		scopes = consent.Scopes
+	}
+
+	code, err := oauthRandomToken()
+	if err != nil {
+		as.logger.Error(nil, fmt.Sprintf("OAuthAuthorizationServer.handleAuthorize oauthRandomToken %v", err))
+		redirectError(NewOAuthError(OAUTH_ERROR_SERVER_ERROR, "Internal Server Error"))
+		return
+	}
+	now := as.now()
+	as.authorizationCodes.DeleteExpired(now)
+	as.authorizationCodes.Set(code, oauthGrant{
+		clientID:      client.ClientID,
+		subject:       consent.Subject,
+		scopes:        scopes,
+		resource:      resource,
+		redirectURI:   redirectURI,
+		codeChallenge: codeChallenge,
+		expiresAt:     now.Add(as.authorizationCodeTTL),
+	})
+	as.redirect(res, req, redirectURI, url.Values{"code": {code}}, state)
+}
+
+//Redirects to the client with the params, state and iss (RFC 9207) are added
+func (as *OAuthAuthorizationServer) redirect(res ResponseWriter, req *http.Request, redirectURI string, params url.Values, state string) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "Invalid redirect_uri"))
+		return
+	}
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	query.Set("iss", as.issuer)
+	u.RawQuery = query.Encode()
+	res.Writer().Header().Set("Cache-Control", "no-store")
+	http.Redirect(res.Writer(), req, u.String(), http.StatusFound)
+}
+
+//Handles the token request, https://datatracker.ietf.org/doc/html/draft-ietf-oauth-v2-1#section-3.2
+func (as *OAuthAuthorizationServer) handleToken(res ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "Invalid request parameters"))
+		return
+	}
+	client, oauthErr := as.authenticateClient(req)
+	if oauthErr != nil {
+		as.writeOAuthError(res, oauthErr)
+		return
+	}
+	grantType := req.PostForm.Get("grant_type")
+	if !oauthContains(client.GrantTypes, grantType) {
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_UNAUTHORIZED_CLIENT, fmt.Sprintf("Client is not allowed to use the %s grant", grantType)))
+		return
+	}
+
+	var grant oauthGrant
+	switch grantType {
+	case OAUTH_GRANT_TYPE_AUTHORIZATION_CODE:
+		grant, oauthErr = as.exchangeAuthorizationCode(client, req.PostForm)
+	case OAUTH_GRANT_TYPE_REFRESH_TOKEN:
+		grant, oauthErr = as.exchangeRefreshToken(client, req.PostForm)
+	default:
+		oauthErr = NewOAuthError(OAUTH_ERROR_UNSUPPORTED_GRANT_TYPE, fmt.Sprintf("Unsupported grant_type %s", grantType))
+	}
+	if oauthErr != nil {
+		as.writeOAuthError(res, oauthErr)
+		return
+	}
+
+	tokens, err := as.issueTokens(client, grant)
+	if err != nil {
+		as.logger.Error(nil, fmt.Sprintf("OAuthAuthorizationServer.handleToken issueTokens %v", err))
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_SERVER_ERROR, "Internal Server Error"))
+		return
+	}
+	res.Writer().Header().Set("Cache-Control", "no-store")
+	res.WriteJSON(http.StatusOK, tokens)
+}
+
+func (as *OAuthAuthorizationServer) exchangeAuthorizationCode(client *types.OAuthClientInformation, params url.Values) (oauthGrant, *OAuthError) {
+	code := params.Get("code")
+	if code == "" {
+		return oauthGrant{}, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "code is required")
+	}
+	grant, ok := as.authorizationCodes.Take(code)
+	if !ok || grant.clientID != client.ClientID || as.now().After(grant.expiresAt) {
+		return oauthGrant{}, NewOAuthError(OAUTH_ERROR_INVALID_GRANT, "Invalid or expired authorization code")
+	}
+	if redirectURI := params.Get("redirect_uri"); redirectURI != "" && redirectURI != grant.redirectURI {
+		return oauthGrant{}, NewOAuthError(OAUTH_ERROR_INVALID_GRANT, "redirect_uri does not match the authorization request")
+	}
+	codeVerifier := params.Get("code_verifier")
+	if len(codeVerifier) < 43 || len(codeVerifier) > 128 {
+		return oauthGrant{}, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "code_verifier must have between 43 and 128 characters")
+	}
+	if !verifyPKCEChallenge(codeVerifier, grant.codeChallenge) {
+		return oauthGrant{}, NewOAuthError(OAUTH_ERROR_INVALID_GRANT, "code_verifier does not match the code_challenge")
+	}
+	if resource := params.Get("resource"); resource != "" && resource != grant.resource {
+		return oauthGrant{}, NewOAuthError(OAUTH_ERROR_INVALID_GRANT, "resource does not match the authorization request")
+	}
+	return grant, nil
+}
+
+func (as *OAuthAuthorizationServer) exchangeRefreshToken(client *types.OAuthClientInformation, params url.Values) (oauthGrant, *OAuthError) {
+	refreshToken := params.Get("refresh_token")
+	if refreshToken == "" {
+		return oauthGrant{}, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "refresh_token is required")
+	}
+	//Validated before it is consumed, a rejected request does not revoke the token
+	grant, ok := as.refreshTokens.Get(refreshToken)
+	if !ok || grant.clientID != client.ClientID || as.now().After(grant.expiresAt) {
+		return oauthGrant{}, NewOAuthError(OAUTH_ERROR_INVALID_GRANT, "Invalid or expired refresh token")
+	}
+	//The client can ask for a subset of the granted scopes
+	if scope := params.Get("scope"); scope != "" {
+		requested := strings.Fields(scope)
+		for _, s := range requested {
+			if !oauthContains(grant.scopes, s) {
+				return oauthGrant{}, NewOAuthError(OAUTH_ERROR_INVALID_SCOPE, fmt.Sprintf("Scope %s was not granted", s))
+			}
+		}
+		grant.scopes = requested
+	}
+	if resource := params.Get("resource"); resource != "" && resource != grant.resource {
+		return oauthGrant{}, NewOAuthError(OAUTH_ERROR_INVALID_GRANT, "resource does not match the original grant")
+	}
+	//Refresh tokens are rotated, the used one is no longer valid.
+	//Take fails if a concurrent request already used it
+	if _, ok := as.refreshTokens.Take(refreshToken); !ok {
+		return oauthGrant{}, NewOAuthError(OAUTH_ERROR_INVALID_GRANT, "Invalid or expired refresh token")
+	}
+	return grant, nil
+}
+
+//Issues a signed access token and a new refresh token for the grant
+func (as *OAuthAuthorizationServer) issueTokens(client *types.OAuthClientInformation, grant oauthGrant) (*types.OAuthTokens, error) {
+	now := as.now()
+	jti, err := oauthRandomToken()
+	if err != nil {
+		return nil, fmt.Errorf("oauthRandomToken %v", err)
+	}
+	audience := grant.resource
+	if audience == "" {
+		audience = as.audience
+	}
+	claims := utils.JWTClaims{
+		"iss":       as.issuer,
+		"aud":       audience,
+		"client_id": client.ClientID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(as.accessTokenTTL).Unix(),
+		"jti":       jti,
+	}
+	if grant.subject != "" {
+		claims["sub"] = grant.subject
+	}
+	if len(grant.scopes) > 0 {
+		claims["scope"] = strings.Join(grant.scopes, " ")
+	}
+	accessToken, err := utils.SignJWT(utils.JWTHeader{Alg: as.signingAlg, Kid: as.keyID}, claims, as.signingKey)
+	if err != nil {
+		return nil, fmt.Errorf("utils.SignJWT %v", err)
+	}
+	tokens := &types.OAuthTokens{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(as.accessTokenTTL.Seconds()),
+		Scope:       strings.Join(grant.scopes, " "),
+	}
+	if oauthContains(client.GrantTypes, OAUTH_GRANT_TYPE_REFRESH_TOKEN) {
+		refreshToken, err := oauthRandomToken()
+		if err != nil {
+			return nil, fmt.Errorf("oauthRandomToken %v", err)
+		}
+		grant.codeChallenge = ""
+		grant.expiresAt = now.Add(as.refreshTokenTTL)
+		as.refreshTokens.DeleteExpired(now)
+		as.refreshTokens.Set(refreshToken, grant)
+		tokens.RefreshToken = refreshToken
+	}
+	return tokens, nil
+}
+
+//Handles the RFC 7009 revocation request, only refresh tokens can be revoked because access tokens are self-contained JWTs
+func (as *OAuthAuthorizationServer) handleRevoke(res ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "Invalid request parameters"))
+		return
+	}
+	client, oauthErr := as.authenticateClient(req)
+	if oauthErr != nil {
+		as.writeOAuthError(res, oauthErr)
+		return
+	}
+	token := req.PostForm.Get("token")
+	if token == "" {
+		as.writeOAuthError(res, NewOAuthError(OAUTH_ERROR_INVALID_REQUEST, "token is required"))
+		return
+	}
+	if grant, ok := as.refreshTokens.Take(token); ok && grant.clientID != client.ClientID {
+		//Tokens of other clients are left untouched
+		as.refreshTokens.Set(token, grant)
+	}
+	//Invalid or unknown tokens are not an error, https://datatracker.ietf.org/doc/html/rfc7009#section-2.2
+	res.Writer().WriteHeader(http.StatusOK)
+}
+
+//Authenticates the client with client_secret_basic, client_secret_post or only client_id for public clients
+func (as *OAuthAuthorizationServer) authenticateClient(req *http.Request) (*types.OAuthClientInformation, *OAuthError) {
+	clientID, clientSecret, basicAuth := req.BasicAuth()
+	authMethod := OAUTH_TOKEN_AUTH_METHOD_CLIENT_SECRET_BASIC
+	if basicAuth {
+		//The client credentials are form-urlencoded before the base64 encoding, https://datatracker.ietf.org/doc/html/rfc6749#section-2.3.1
+		if id, err := url.QueryUnescape(clientID); err == nil {
+			clientID = id
+		}
+		if secret, err := url.QueryUnescape(clientSecret); err == nil {
+			clientSecret = secret
+		}
+	} else {
+		clientID = req.PostForm.Get("client_id")
+		clientSecret = req.PostForm.Get("client_secret")
+		authMethod = OAUTH_TOKEN_AUTH_METHOD_CLIENT_SECRET_POST
+		if clientSecret == "" {
+			authMethod = OAUTH_TOKEN_AUTH_METHOD_NONE
+		}
+	}
+	if clientID == "" {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT, "Client authentication is required")
+	}
+	client, err := as.clientsStore.GetClient(clientID)
+	if err != nil {
+		as.logger.Error(nil, fmt.Sprintf("OAuthAuthorizationServer.authenticateClient clientsStore.GetClient %v", err))
+		return nil, NewOAuthError(OAUTH_ERROR_SERVER_ERROR, "Internal Server Error")
+	}
+	if client == nil {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT, "Unknown client")
+	}
+	if client.TokenEndpointAuthMethod == OAUTH_TOKEN_AUTH_METHOD_NONE {
+		if authMethod != OAUTH_TOKEN_AUTH_METHOD_NONE {
+			return nil, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT, "Public clients must not send a client_secret")
+		}
+		return client, nil
+	}
+	if authMethod == OAUTH_TOKEN_AUTH_METHOD_NONE {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT, "client_secret is required")
+	}
+	if subtle.ConstantTimeCompare([]byte(client.ClientSecret), []byte(clientSecret)) != 1 {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT, "Invalid client_secret")
+	}
+	if client.ClientSecretExpiresAt > 0 && as.now().Unix() > client.ClientSecretExpiresAt {
+		return nil, NewOAuthError(OAUTH_ERROR_INVALID_CLIENT, "client_secret has expired")
+	}
+	return client, nil
+}
+
+//Validates the requested scopes against the supported scopes and the scopes allowed to the client
+func (as *OAuthAuthorizationServer) validateScopes(scopes []string, allowed []string) *OAuthError {
+	for _, s := range scopes {
+		if len(as.scopesSupported) > 0 && !oauthContains(as.scopesSupported, s) {
+			return NewOAuthError(OAUTH_ERROR_INVALID_SCOPE, fmt.Sprintf("Unsupported scope %s", s))
+		}
+		if allowed != nil && !oauthContains(allowed, s) {
+			return NewOAuthError(OAUTH_ERROR_INVALID_SCOPE, fmt.Sprintf("Scope %s is not allowed for the client", s))
+		}
+	}
+	return nil
+}
+
+//Return true if BASE64URL(SHA256(verifier)) matches the challenge, https://datatracker.ietf.org/doc/html/rfc7636#section-4.6
+func verifyPKCEChallenge(verifier string, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+//Return a random URL safe token with 256 bits of entropy
+func oauthRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("rand.Read %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func oauthContains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
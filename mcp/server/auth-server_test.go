@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+func TestRejectedRefreshDoesNotConsumeToken(t *testing.T) {
+	as := &OAuthAuthorizationServer{refreshTokens: newMuxMapOAuthGrants(), now: time.Now}
+	as.refreshTokens.Set("rt", oauthGrant{
+		clientID:  "client",
+		scopes:    []string{"read"},
+		resource:  "https://mcp.example.com",
+		expiresAt: time.Now().Add(time.Hour),
+	})
+	client := &types.OAuthClientInformation{ClientID: "client"}
+
+	rejected := []url.Values{
+		{"refresh_token": {"rt"}, "scope": {"write"}},
+		{"refresh_token": {"rt"}, "resource": {"https://other.example.com"}},
+	}
+	for _, params := range rejected {
+		if _, oauthErr := as.exchangeRefreshToken(client, params); oauthErr == nil {
+			t.Fatalf("expected %v to be rejected", params)
+		}
+	}
+	if _, oauthErr := as.exchangeRefreshToken(&types.OAuthClientInformation{ClientID: "other"}, url.Values{"refresh_token": {"rt"}}); oauthErr == nil {
+		t.Fatal("expected the token of another client to be rejected")
+	}
+
+	if _, oauthErr := as.exchangeRefreshToken(client, url.Values{"refresh_token": {"rt"}, "scope": {"read"}}); oauthErr != nil {
+		t.Fatalf("expected the token to be still valid, got %v", oauthErr)
+	}
+	if _, oauthErr := as.exchangeRefreshToken(client, url.Values{"refresh_token": {"rt"}}); oauthErr == nil {
+		t.Fatal("expected the used token to be rotated")
+	}
+}
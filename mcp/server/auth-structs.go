@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+//OAuth 2.1 error codes, https://datatracker.ietf.org/doc/html/rfc6750#section-3.1
+const (
+	OAUTH_ERROR_INVALID_REQUEST    = "invalid_request"
+	OAUTH_ERROR_INVALID_TOKEN      = "invalid_token"
+	OAUTH_ERROR_INSUFFICIENT_SCOPE = "insufficient_scope"
+	OAUTH_ERROR_SERVER_ERROR       = "server_error"
+)
+
+//OAuth 2.0 authorization server error codes, https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+//and https://datatracker.ietf.org/doc/html/rfc7591#section-3.2.2
+const (
+	OAUTH_ERROR_INVALID_CLIENT            = "invalid_client"
+	OAUTH_ERROR_INVALID_GRANT             = "invalid_grant"
+	OAUTH_ERROR_UNAUTHORIZED_CLIENT       = "unauthorized_client"
+	OAUTH_ERROR_UNSUPPORTED_GRANT_TYPE    = "unsupported_grant_type"
+	OAUTH_ERROR_UNSUPPORTED_RESPONSE_TYPE = "unsupported_response_type"
+	OAUTH_ERROR_INVALID_SCOPE             = "invalid_scope"
+	OAUTH_ERROR_ACCESS_DENIED             = "access_denied"
+	OAUTH_ERROR_INVALID_REDIRECT_URI      = "invalid_redirect_uri"
+	OAUTH_ERROR_INVALID_CLIENT_METADATA   = "invalid_client_metadata"
+)
+
+var oauthErrorStatusCodes = map[string]int{
+	OAUTH_ERROR_INVALID_REQUEST:           http.StatusBadRequest,
+	OAUTH_ERROR_INVALID_TOKEN:             http.StatusUnauthorized,
+	OAUTH_ERROR_INSUFFICIENT_SCOPE:        http.StatusForbidden,
+	OAUTH_ERROR_SERVER_ERROR:              http.StatusInternalServerError,
+	OAUTH_ERROR_INVALID_CLIENT:            http.StatusUnauthorized,
+	OAUTH_ERROR_INVALID_GRANT:             http.StatusBadRequest,
+	OAUTH_ERROR_UNAUTHORIZED_CLIENT:       http.StatusBadRequest,
+	OAUTH_ERROR_UNSUPPORTED_GRANT_TYPE:    http.StatusBadRequest,
+	OAUTH_ERROR_UNSUPPORTED_RESPONSE_TYPE: http.StatusBadRequest,
+	OAUTH_ERROR_INVALID_SCOPE:             http.StatusBadRequest,
+	OAUTH_ERROR_ACCESS_DENIED:             http.StatusForbidden,
+	OAUTH_ERROR_INVALID_REDIRECT_URI:      http.StatusBadRequest,
+	OAUTH_ERROR_INVALID_CLIENT_METADATA:   http.StatusBadRequest,
+}
+
+//An OAuth error, returned by a TokenVerifier to choose the HTTP status and the `WWW-Authenticate` challenge.
+type OAuthError struct {
+	//One of the OAUTH_ERROR_* codes
+	ErrorCode string `json:"error"`
+	//Human-readable description of the error.
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func NewOAuthError(code string, description string) *OAuthError {
+	return &OAuthError{ErrorCode: code, ErrorDescription: description}
+}
+
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("%s: %s", e.ErrorCode, e.ErrorDescription)
+}
+
+//Return the HTTP status code for the error, 400 for unknown codes
+func (e *OAuthError) StatusCode() int {
+	code, ok := oauthErrorStatusCodes[e.ErrorCode]
+	if !ok {
+		return http.StatusBadRequest
+	}
+	return code
+}
+
+//Verifies access tokens and returns information about them.
+type TokenVerifier interface {
+	//Verifies an access token and returns information about it.
+	//
+	//Return an *OAuthError to control the HTTP response, any other error is reported as server_error.
+	VerifyAccessToken(token string) (*types.AuthInfo, error)
+}
+
+//Adapter to allow the use of ordinary functions as TokenVerifier.
+type TokenVerifierFunc func(token string) (*types.AuthInfo, error)
+
+func (f TokenVerifierFunc) VerifyAccessToken(token string) (*types.AuthInfo, error) {
+	return f(token)
+}
+
+type BearerAuthMiddlewareOptions struct {
+	//A verifier used to validate access tokens.
+	Verifier TokenVerifier
+	//Optional scopes that the token must have.
+	RequiredScopes []string
+	//Optional realm included in the `WWW-Authenticate` challenge.
+	Realm string
+	//Optional protected resource metadata URL included as `resource_metadata` in the `WWW-Authenticate` challenge,
+	//https://datatracker.ietf.org/doc/html/rfc9728#section-5.1
+	ResourceMetadataURL string
+}
@@ -8,6 +8,34 @@ import (
 	"github.com/victorvbello/gomcp/mcp/utils"
 )
 
+//Predicate over the caller auth info, return true to allow the access.
+//authInfo is nil when the request is not authenticated.
+type AuthorizationPredicate func(authInfo *types.AuthInfo) bool
+
+//Access rule for a tool, resource, resource template or prompt.
+//
+//Unauthorized callers don't see the item in list results and its use is rejected with ERROR_CODE_FORBIDDEN.
+type Authorization struct {
+	//Scopes that the caller access token must have, all of them are required.
+	RequiredScopes []string
+	//Optional custom rule, evaluated after the scopes check.
+	Predicate AuthorizationPredicate
+}
+
+//Return true if the caller is allowed, a nil Authorization allows everyone.
+func (a *Authorization) Allows(authInfo *types.AuthInfo) bool {
+	if a == nil {
+		return true
+	}
+	if len(a.RequiredScopes) > 0 && (authInfo == nil || !authInfo.HasScopes(a.RequiredScopes...)) {
+		return false
+	}
+	if a.Predicate != nil && !a.Predicate(authInfo) {
+		return false
+	}
+	return true
+}
+
 //Callback to read a resource at a given URI.
 type ReadResourceCallback = func(uri string, extra *shared.RequestHandlerExtra) (*types.ReadResourceResult, error)
 
@@ -25,15 +53,16 @@ type RegisteredResourceUpdateOpts struct {
 }
 
 type RegisteredResource struct {
-	Name         string
-	Title        string
-	Metadata     *ResourceMetadata
-	ReadCallback ReadResourceCallback
-	Enabled      bool
-	Enable       func()
-	Disable      func()
-	Update       func(updates RegisteredResourceUpdateOpts) error
-	Remove       func()
+	Name          string
+	Title         string
+	Metadata      *ResourceMetadata
+	ReadCallback  ReadResourceCallback
+	Authorization *Authorization
+	Enabled       bool
+	Enable        func()
+	Disable       func()
+	Update        func(updates RegisteredResourceUpdateOpts) error
+	Remove        func()
 }
 
 //Callback to list all resources matching a given template.
@@ -96,6 +125,7 @@ type RegisteredResourceTemplate struct {
 	Title            string
 	Metadata         *ResourceMetadata
 	ReadCallback     ReadResourceTemplateCallback
+	Authorization    *Authorization
 	Enabled          bool
 	Enable           func()
 	Disable          func()
@@ -117,17 +147,18 @@ type RegisteredToolUpdateOpts struct {
 }
 
 type RegisteredTool struct {
-	Title        string
-	Description  string
-	InputSchema  types.ToolInputSchema
-	OutputSchema types.ToolOutputSchema
-	Annotations  *types.ToolAnnotations
-	Callback     ToolCallback
-	Enabled      bool
-	Enable       func()
-	Disable      func()
-	Update       func(updates RegisteredToolUpdateOpts) error
-	Remove       func()
+	Title         string
+	Description   string
+	InputSchema   types.ToolInputSchema
+	OutputSchema  types.ToolOutputSchema
+	Annotations   *types.ToolAnnotations
+	Callback      ToolCallback
+	Authorization *Authorization
+	Enabled       bool
+	Enable        func()
+	Disable       func()
+	Update        func(updates RegisteredToolUpdateOpts) error
+	Remove        func()
 }
 
 type PromptCallback func(args map[string]string, extra *shared.RequestHandlerExtra) (*types.GetPromptResult, error)
@@ -148,15 +179,16 @@ type PromptArgsSchemaField struct {
 }
 
 type RegisteredPrompt struct {
-	Title       string
-	Description string
-	ArgsSchema  map[string]PromptArgsSchemaField
-	Callback    PromptCallback
-	Enabled     bool
-	Enable      func()
-	Disable     func()
-	Update      func(updates RegisteredPromptUpdateOpts) error
-	Remove      func()
+	Title         string
+	Description   string
+	ArgsSchema    map[string]PromptArgsSchemaField
+	Callback      PromptCallback
+	Authorization *Authorization
+	Enabled       bool
+	Enable        func()
+	Disable       func()
+	Update        func(updates RegisteredPromptUpdateOpts) error
+	Remove        func()
 }
 
 //muxMapRegisteredResource
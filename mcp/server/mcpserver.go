@@ -3,9 +3,13 @@ package server
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strconv"
 
 	"github.com/victorvbello/gomcp/mcp/shared"
 	"github.com/victorvbello/gomcp/mcp/types"
+	"github.com/victorvbello/gomcp/mcp/utils/metrics"
+	"github.com/victorvbello/gomcp/mcp/utils/tracing"
 )
 
 //High-level MCP server that provides a simpler API for working with resources, tools, and prompts.
@@ -22,6 +26,10 @@ type McpServer struct {
 	completionHandlerInitialized bool
 	resourceHandlersInitialized  bool
 	promptHandlersInitialized    bool
+	toolCalls                    *metrics.CounterVec
+	tracer                       tracing.Tracer
+	toolLimiters                 map[string]*shared.ConcurrencyLimiter
+	rateLimiter                  *RateLimiter
 }
 
 func NewMcpServer(serverInfo types.Implementation, opts ServerOptions) (*McpServer, error) {
@@ -31,11 +39,22 @@ func NewMcpServer(serverInfo types.Implementation, opts ServerOptions) (*McpServ
 		registeredResourceTemplates: newMuxMapRegisteredResourceTemplate(),
 		registeredTools:             newMuxMapRegisteredTool(),
 		registeredPrompts:           newMuxMapRegisteredPrompt(),
+		tracer:                      opts.Tracer,
+		rateLimiter:                 opts.RateLimiter,
 	}
 	nMcpServer.server, err = NewServer(serverInfo, opts)
 	if err != nil {
 		return nil, fmt.Errorf("newServer,%v", err)
 	}
+	if opts.Metrics != nil {
+		nMcpServer.toolCalls = opts.Metrics.NewCounterVec(shared.METRIC_TOOL_CALLS_TOTAL, "Tool calls by tool name and whether the result is an error.", "tool", "is_error")
+	}
+	if limits := opts.ConcurrencyLimits; limits != nil {
+		nMcpServer.toolLimiters = shared.NewConcurrencyLimiters(shared.CONCURRENCY_SCOPE_TOOL, limits.MaxInFlightPerTool, limits.MaxQueue, limits.QueueTimeout)
+		for _, limiter := range nMcpServer.toolLimiters {
+			limiter.RegisterMetrics(opts.Metrics)
+		}
+	}
 	return nMcpServer, nil
 }
 
@@ -50,6 +69,48 @@ func (mcps *McpServer) wrapperOnErrorServer(fn func()) error {
 	return sError
 }
 
+//Return the auth info of the request, nil if the request is not authenticated
+func requestAuthInfo(extra *shared.RequestHandlerExtra) *types.AuthInfo {
+	if extra == nil {
+		return nil
+	}
+	return extra.AuthInfo
+}
+
+//Return the error for a caller that is not allowed to use an item
+func forbiddenError(kind string, name string, authorization *Authorization) error {
+	data := map[string]interface{}{
+		"status": http.StatusForbidden,
+	}
+	if authorization != nil && len(authorization.RequiredScopes) > 0 {
+		data["requiredScopes"] = authorization.RequiredScopes
+	}
+	err := types.NewMcpError(types.ERROR_CODE_FORBIDDEN, fmt.Sprintf("%s %s forbidden", kind, name), data)
+	return err.ToError()
+}
+
+//Wraps a handler with the global, session and subject rate limits
+func (mcps *McpServer) rateLimited(handler shared.RequestHandler) shared.RequestHandler {
+	if mcps.rateLimiter == nil {
+		return handler
+	}
+	return func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
+		if err := mcps.allowRequest("", extra); err != nil {
+			return nil, err
+		}
+		return handler(request, extra)
+	}
+}
+
+//Takes the rate limit tokens of a request, tool is empty for the requests that are not tool calls
+func (mcps *McpServer) allowRequest(tool string, extra *shared.RequestHandlerExtra) error {
+	var sessionID string
+	if extra != nil {
+		sessionID = extra.SessionID
+	}
+	return mcps.rateLimiter.Allow(tool, sessionID, requestAuthInfo(extra))
+}
+
 func (mcps *McpServer) setToolRequestHandlers() error {
 	if mcps.toolHandlersInitialized {
 		return nil
@@ -67,10 +128,11 @@ func (mcps *McpServer) setToolRequestHandlers() error {
 	}
 
 	mcps.server.SetRequestHandler(types.NewListToolsRequest(nil),
-		func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
+		mcps.rateLimited(func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
 			lt := new(types.ListToolsResult)
+			authInfo := requestAuthInfo(extra)
 			for name, rT := range mcps.registeredTools.GetAll() {
-				if !rT.Enabled {
+				if !rT.Enabled || !rT.Authorization.Allows(authInfo) {
 					continue
 				}
 				tool := types.Tool{
@@ -84,7 +146,7 @@ func (mcps *McpServer) setToolRequestHandlers() error {
 				lt.Tools = append(lt.Tools, tool)
 			}
 			return lt, nil
-		})
+		}))
 	mcps.server.SetRequestHandler(types.NewCallToolRequest(nil),
 		func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
 			req, okType := request.(*types.CallToolRequest)
@@ -92,6 +154,9 @@ func (mcps *McpServer) setToolRequestHandlers() error {
 				err := types.NewMcpError(types.ERROR_CODE_INVALID_PARAMS, "invalid request type CallToolRequest", nil)
 				return nil, err.ToError()
 			}
+			if err := mcps.allowRequest(req.Params.Name, extra); err != nil {
+				return nil, err
+			}
 			tool, okTool := mcps.registeredTools.Get(req.Params.Name)
 			if !okTool {
 				err := types.NewMcpError(types.ERROR_CODE_INVALID_PARAMS,
@@ -103,10 +168,30 @@ func (mcps *McpServer) setToolRequestHandlers() error {
 					fmt.Sprintf("tool %s disabled", req.Params.Name), nil)
 				return nil, err.ToError()
 			}
+			if !tool.Authorization.Allows(requestAuthInfo(extra)) {
+				return nil, forbiddenError("tool", req.Params.Name, tool.Authorization)
+			}
 
 			var result *types.CallToolResult
-			var err error
+			parentCtx := context.Background()
+			if extra != nil && extra.Context != nil {
+				parentCtx = extra.Context
+			}
+			release, err := mcps.toolLimiters[req.Params.Name].Acquire(parentCtx)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			toolCtx, span := tracing.StartSpan(parentCtx, mcps.tracer, shared.TRACE_SPAN_NAME_TOOL_PREFIX+req.Params.Name, tracing.SPAN_KIND_INTERNAL, map[string]interface{}{
+				shared.TRACE_ATTRIBUTE_TOOL_NAME: req.Params.Name,
+			})
+			defer span.End()
+			if extra != nil {
+				//The requests and notifications sent by the tool are children of its span
+				extra.Context = toolCtx
+			}
 			result, err = tool.Callback(req.Params.Arguments, extra)
+			span.RecordError(err)
 			if err != nil {
 				txtContent := types.NewTextContent(fmt.Sprintf("tool.Callback, %v", err))
 				isErr := true
@@ -115,6 +200,9 @@ func (mcps *McpServer) setToolRequestHandlers() error {
 					IsError: &isErr,
 				}
 			}
+			isError := result != nil && result.IsError != nil && *result.IsError
+			mcps.toolCalls.Inc(req.Params.Name, strconv.FormatBool(isError))
+			span.SetAttribute(shared.TRACE_ATTRIBUTE_TOOL_IS_ERROR, isError)
 			if tool.OutputSchema.Type != "" && result.IsError != nil && !*result.IsError {
 				if result.StructuredContent == nil {
 					err := types.NewMcpError(types.ERROR_CODE_INVALID_PARAMS,
@@ -146,7 +234,7 @@ func (mcps *McpServer) setCompletionRequestHandler() error {
 	}
 
 	mcps.server.SetRequestHandler(types.NewCompleteRequest(nil),
-		func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
+		mcps.rateLimited(func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
 			req, okType := request.(*types.CompleteRequest)
 			if !okType {
 				err := types.NewMcpError(types.ERROR_CODE_INVALID_PARAMS, "invalid request type CompleteRequest", nil)
@@ -154,21 +242,21 @@ func (mcps *McpServer) setCompletionRequestHandler() error {
 			}
 			switch rt := req.Params.Ref.(type) {
 			case *types.PromptReference:
-				return mcps.handlePromptCompletion(req, rt)
+				return mcps.handlePromptCompletion(req, rt, extra)
 			case *types.ResourceTemplateReference:
-				return mcps.handleResourceCompletion(req, rt)
+				return mcps.handleResourceCompletion(req, rt, extra)
 			default:
 				err := types.NewMcpError(
 					types.ERROR_CODE_INVALID_PARAMS,
 					fmt.Sprintf("invalid completion reference: %T", rt), nil)
 				return nil, err.ToError()
 			}
-		})
+		}))
 	mcps.completionHandlerInitialized = true
 	return nil
 }
 
-func (mcps *McpServer) handlePromptCompletion(request *types.CompleteRequest, ref *types.PromptReference) (*types.CompleteResult, error) {
+func (mcps *McpServer) handlePromptCompletion(request *types.CompleteRequest, ref *types.PromptReference, extra *shared.RequestHandlerExtra) (*types.CompleteResult, error) {
 	prompt, okPrompt := mcps.registeredPrompts.Get(ref.Name)
 	if !okPrompt {
 		err := types.NewMcpError(
@@ -183,6 +271,9 @@ func (mcps *McpServer) handlePromptCompletion(request *types.CompleteRequest, re
 			fmt.Sprintf("prompt %s disabled", ref.Name), nil)
 		return nil, err.ToError()
 	}
+	if !prompt.Authorization.Allows(requestAuthInfo(extra)) {
+		return nil, forbiddenError("prompt", ref.Name, prompt.Authorization)
+	}
 
 	if prompt.ArgsSchema == nil {
 		return &types.CompleteResult{}, nil
@@ -199,7 +290,7 @@ func (mcps *McpServer) handlePromptCompletion(request *types.CompleteRequest, re
 	return mcps.createCompletionResult(suggestions), nil
 }
 
-func (mcps *McpServer) handleResourceCompletion(request *types.CompleteRequest, ref *types.ResourceTemplateReference) (*types.CompleteResult, error) {
+func (mcps *McpServer) handleResourceCompletion(request *types.CompleteRequest, ref *types.ResourceTemplateReference, extra *shared.RequestHandlerExtra) (*types.CompleteResult, error) {
 	var template *RegisteredResourceTemplate
 	reqRef := request.Params.Ref.(*types.ResourceTemplateReference)
 	for _, t := range mcps.registeredResourceTemplates.GetAll() {
@@ -209,7 +300,10 @@ func (mcps *McpServer) handleResourceCompletion(request *types.CompleteRequest,
 		}
 	}
 	if template == nil {
-		if _, ok := mcps.registeredResources.Get(ref.URI); ok {
+		if resource, ok := mcps.registeredResources.Get(ref.URI); ok {
+			if !resource.Authorization.Allows(requestAuthInfo(extra)) {
+				return nil, forbiddenError("resource", ref.URI, resource.Authorization)
+			}
 			return &types.CompleteResult{}, nil
 		}
 		err := types.NewMcpError(
@@ -217,6 +311,9 @@ func (mcps *McpServer) handleResourceCompletion(request *types.CompleteRequest,
 			fmt.Sprintf("resource template %s not found", reqRef.URI), nil)
 		return nil, err.ToError()
 	}
+	if !template.Authorization.Allows(requestAuthInfo(extra)) {
+		return nil, forbiddenError("resource template", ref.URI, template.Authorization)
+	}
 	completer := template.ResourceTemplate.CompleteCallback(request.Params.Argument.Name)
 	if completer == nil {
 		return &types.CompleteResult{}, nil
@@ -258,10 +355,11 @@ func (mcps *McpServer) setResourceRequestHandlers() error {
 	}
 
 	mcps.server.SetRequestHandler(types.NewListResourcesRequest(nil),
-		func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
+		mcps.rateLimited(func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
 			var resources, templateResources []types.Resource
+			authInfo := requestAuthInfo(extra)
 			for uri, rr := range mcps.registeredResources.GetAll() {
-				if !rr.Enabled {
+				if !rr.Enabled || !rr.Authorization.Allows(authInfo) {
 					continue
 				}
 				nR := types.Resource{
@@ -274,6 +372,9 @@ func (mcps *McpServer) setResourceRequestHandlers() error {
 				resources = append(resources, nR)
 			}
 			for uri, template := range mcps.registeredResourceTemplates.GetAll() {
+				if !template.Authorization.Allows(authInfo) {
+					continue
+				}
 				listCallback := template.ResourceTemplate.GetListCallback()
 				if listCallback == nil {
 					continue
@@ -303,12 +404,16 @@ func (mcps *McpServer) setResourceRequestHandlers() error {
 			result.Resources = append(result.Resources, resources...)
 			result.Resources = append(result.Resources, templateResources...)
 			return result, nil
-		})
+		}))
 
 	mcps.server.SetRequestHandler(types.NewListResourceTemplatesRequest(nil),
-		func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
+		mcps.rateLimited(func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
 			var resourceTemplates []types.ResourceTemplate
+			authInfo := requestAuthInfo(extra)
 			for name, template := range mcps.registeredResourceTemplates.GetAll() {
+				if !template.Authorization.Allows(authInfo) {
+					continue
+				}
 				uri := template.ResourceTemplate.GetUriTemplate()
 				nrt := types.ResourceTemplate{
 					URITemplate: uri,
@@ -323,10 +428,10 @@ func (mcps *McpServer) setResourceRequestHandlers() error {
 			result := new(types.ListResourceTemplatesResult)
 			result.ResourceTemplates = append(result.ResourceTemplates, resourceTemplates...)
 			return result, nil
-		})
+		}))
 
 	mcps.server.SetRequestHandler(types.NewReadResourceRequest(nil),
-		func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
+		mcps.rateLimited(func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
 			req, okType := request.(*types.ReadResourceRequest)
 			if !okType {
 				err := types.NewMcpError(types.ERROR_CODE_INVALID_PARAMS, "invalid request type ReadResourceRequest", nil)
@@ -342,6 +447,9 @@ func (mcps *McpServer) setResourceRequestHandlers() error {
 						fmt.Sprintf("resource %s disabled", uri), nil)
 					return nil, err.ToError()
 				}
+				if !resource.Authorization.Allows(requestAuthInfo(extra)) {
+					return nil, forbiddenError("resource", uri, resource.Authorization)
+				}
 				if resource.ReadCallback != nil {
 					return resource.ReadCallback(uri, extra)
 				}
@@ -357,6 +465,9 @@ func (mcps *McpServer) setResourceRequestHandlers() error {
 					return nil, err.ToError()
 				}
 				if variables != nil {
+					if !template.Authorization.Allows(requestAuthInfo(extra)) {
+						return nil, forbiddenError("resource", uri, template.Authorization)
+					}
 					if template.ReadCallback != nil {
 						return template.ReadCallback(uri, variables, extra)
 					}
@@ -367,7 +478,7 @@ func (mcps *McpServer) setResourceRequestHandlers() error {
 			err := types.NewMcpError(types.ERROR_CODE_INVALID_PARAMS,
 				fmt.Sprintf("resource %s not found", uri), nil)
 			return nil, err.ToError()
-		})
+		}))
 
 	if err := mcps.setCompletionRequestHandler(); err != nil {
 		return fmt.Errorf("mcps.setCompletionRequestHandler, %v", err)
@@ -403,12 +514,13 @@ func (mcps *McpServer) setPromptRequestHandlers() error {
 	}
 
 	mcps.server.SetRequestHandler(types.NewListPromptsRequest(nil),
-		func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
+		mcps.rateLimited(func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
 			result := &types.ListPromptsResult{
 				Prompts: []types.Prompt{},
 			}
+			authInfo := requestAuthInfo(extra)
 			for name, prompt := range mcps.registeredPrompts.GetAll() {
-				if !prompt.Enabled {
+				if !prompt.Enabled || !prompt.Authorization.Allows(authInfo) {
 					continue
 				}
 				np := types.Prompt{
@@ -420,10 +532,10 @@ func (mcps *McpServer) setPromptRequestHandlers() error {
 				result.Prompts = append(result.Prompts, np)
 			}
 			return result, nil
-		})
+		}))
 
 	mcps.server.SetRequestHandler(types.NewGetPromptRequest(nil),
-		func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
+		mcps.rateLimited(func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
 			req, okType := request.(*types.GetPromptRequest)
 			if !okType {
 				err := types.NewMcpError(types.ERROR_CODE_INVALID_PARAMS, "invalid request type GetPromptRequest", nil)
@@ -443,6 +555,9 @@ func (mcps *McpServer) setPromptRequestHandlers() error {
 					fmt.Sprintf("prompt %s disabled", req.Params.Name), nil)
 				return nil, err.ToError()
 			}
+			if !prompt.Authorization.Allows(requestAuthInfo(extra)) {
+				return nil, forbiddenError("prompt", req.Params.Name, prompt.Authorization)
+			}
 			var args map[string]string
 			if prompt.ArgsSchema != nil {
 				args = req.Params.Arguments
@@ -451,7 +566,7 @@ func (mcps *McpServer) setPromptRequestHandlers() error {
 				return prompt.Callback(nil, extra)
 			}
 
-		})
+		}))
 
 	if err := mcps.setCompletionRequestHandler(); err != nil {
 		return fmt.Errorf("mcps.setCompletionRequestHandler, %v", err)
@@ -493,6 +608,8 @@ type RegisterResourceOpts struct {
 	Uri      string
 	Meta     *ResourceMetadata
 	Callback ReadResourceCallback
+	//Optional access rule, if is nil the resource is available to everyone
+	Authorization *Authorization
 }
 
 //Registers a resource `name` at a fixed URI, which will use the given callback to respond to read requests.
@@ -506,10 +623,11 @@ func (mcps *McpServer) RegisterResource(opts RegisterResourceOpts) (*RegisteredR
 		return nil, fmt.Errorf("resource %s is already registered", opts.Uri)
 	}
 	result := RegisteredResource{
-		Name:         opts.Name,
-		Metadata:     opts.Meta,
-		ReadCallback: opts.Callback,
-		Enabled:      true,
+		Name:          opts.Name,
+		Metadata:      opts.Meta,
+		ReadCallback:  opts.Callback,
+		Authorization: opts.Authorization,
+		Enabled:       true,
 	}
 	result.Disable = func() {
 		result.Update(RegisteredResourceUpdateOpts{Enabled: false})
@@ -566,6 +684,8 @@ type RegisterResourceTemplateOpts struct {
 	Template ResourceTemplate
 	Meta     *ResourceMetadata
 	Callback ReadResourceTemplateCallback
+	//Optional access rule, if is nil the resource template is available to everyone
+	Authorization *Authorization
 }
 
 //Registers a resource `name` with a template pattern, which will use the given callback to respond to read requests.
@@ -583,6 +703,7 @@ func (mcps *McpServer) RegisterResourceTemplate(opts RegisterResourceTemplateOpt
 		Title:            opts.Title,
 		Metadata:         opts.Meta,
 		ReadCallback:     opts.Callback,
+		Authorization:    opts.Authorization,
 		Enabled:          true,
 	}
 	result.Disable = func() {
@@ -639,6 +760,8 @@ type RegisterToolOpts struct {
 	OutputSchema types.ToolOutputSchema
 	Annotations  *types.ToolAnnotations
 	Callback     ToolCallback
+	//Optional access rule, if is nil the tool is available to everyone
+	Authorization *Authorization
 }
 
 //Registers a tool with a config object and callback.
@@ -652,13 +775,14 @@ func (mcps *McpServer) RegisterTool(opts RegisterToolOpts) (*RegisteredTool, err
 	}
 
 	result := RegisteredTool{
-		Title:        opts.Title,
-		Description:  opts.Description,
-		InputSchema:  opts.InputSchema,
-		OutputSchema: opts.OutputSchema,
-		Annotations:  opts.Annotations,
-		Callback:     opts.Callback,
-		Enabled:      true,
+		Title:         opts.Title,
+		Description:   opts.Description,
+		InputSchema:   opts.InputSchema,
+		OutputSchema:  opts.OutputSchema,
+		Annotations:   opts.Annotations,
+		Callback:      opts.Callback,
+		Authorization: opts.Authorization,
+		Enabled:       true,
 	}
 	result.Disable = func() {
 		result.Update(RegisteredToolUpdateOpts{Enabled: false})
@@ -718,6 +842,8 @@ type RegisterPromptOpts struct {
 	Description string
 	Arguments   map[string]PromptArgsSchemaField
 	Callback    PromptCallback
+	//Optional access rule, if is nil the prompt is available to everyone
+	Authorization *Authorization
 }
 
 //Registers a prompt with a config object and callback.
@@ -730,11 +856,12 @@ func (mcps *McpServer) RegisterPrompt(opts RegisterPromptOpts) (*RegisteredPromp
 		return nil, fmt.Errorf("prompt %s is already registered", opts.Name)
 	}
 	result := RegisteredPrompt{
-		Title:       opts.Title,
-		Description: opts.Description,
-		Enabled:     true,
-		ArgsSchema:  opts.Arguments,
-		Callback:    opts.Callback,
+		Title:         opts.Title,
+		Description:   opts.Description,
+		Enabled:       true,
+		ArgsSchema:    opts.Arguments,
+		Callback:      opts.Callback,
+		Authorization: opts.Authorization,
 	}
 	result.Disable = func() {
 		result.Update(RegisteredPromptUpdateOpts{Enabled: false})
@@ -840,6 +967,43 @@ func (mcps *McpServer) Close() error {
 	return connError
 }
 
+//Closes the connection gracefully: the new requests are rejected, the running ones can finish until ctx is done
+//and the queued notifications and SSE events are written before the transport is closed.
+//
+//The events of the EventStore of a StreamableHTTPServerTransport are kept, the clients can resume their streams
+//on a new process sharing the store.
+//
+//Return the error of ctx if the requests did not finish in time, they are cancelled by the close
+func (mcps *McpServer) Shutdown(ctx context.Context) error {
+	if err := mcps.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("mcps.server.Shutdown %w", err)
+	}
+	return nil
+}
+
+//Shuts down the servers of several sessions at the same time, e.g. the servers kept by session ID for a
+//StreamableHTTPServerTransport each, return the first error
+func ShutdownServers(ctx context.Context, servers []*McpServer) error {
+	errs := make(chan error, len(servers))
+	for _, mcps := range servers {
+		go func(mcps *McpServer) {
+			errs <- mcps.Shutdown(ctx)
+		}(mcps)
+	}
+	var gErr error
+	for range servers {
+		if err := <-errs; err != nil && gErr == nil {
+			gErr = err
+		}
+	}
+	return gErr
+}
+
+//Return the state of the session, method and tool concurrency limits, empty if there are no limits
+func (mcps *McpServer) ConcurrencyStats() []shared.ConcurrencyStats {
+	return append(mcps.server.ConcurrencyStats(), shared.ConcurrencyLimitersStats(mcps.toolLimiters)...)
+}
+
 //Get server read only
 func (mcps *McpServer) GetServer() *Server {
 	return mcps.server
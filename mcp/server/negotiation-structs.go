@@ -0,0 +1,73 @@
+package server
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+const (
+	CONTENT_TYPE_JSON         = "application/json"
+	CONTENT_TYPE_EVENT_STREAM = "text/event-stream"
+	CONTENT_ENCODING_GZIP     = "gzip"
+	CONTENT_ENCODING_DEFLATE  = "deflate"
+	//JSON responses smaller than this are not compressed, the gain does not pay the cost
+	DEFAULT_COMPRESSION_MIN_SIZE = 1024
+)
+
+type CompressionOptions struct {
+	//JSON responses smaller than this (in bytes) are sent uncompressed.
+	//SSE streams are always compressed when negotiated, their size is unknown when they start.
+	//Default is DEFAULT_COMPRESSION_MIN_SIZE.
+	MinSize int
+	//Compression level, from gzip.HuffmanOnly to gzip.BestCompression.
+	//Default is gzip.DefaultCompression, other values make Start return an error.
+	Level *int
+	//Also offer deflate to the clients that do not accept gzip.
+	//Default is false.
+	EnableDeflate *bool
+}
+
+//Compression negotiated for a request
+type responseCompression struct {
+	encoding string
+	minSize  int
+	level    int
+}
+
+//Writer of a compressed SSE stream, Flush sends the pending data so every event reaches the client
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+//Return nil and the error if the level is invalid, never a nil writer in a non nil interface
+func (rc *responseCompression) newWriter(w io.Writer) (flushWriteCloser, error) {
+	if rc.encoding == CONTENT_ENCODING_DEFLATE {
+		fw, err := flate.NewWriter(w, rc.level)
+		if err != nil {
+			return nil, err
+		}
+		return fw, nil
+	}
+	gw, err := gzip.NewWriterLevel(w, rc.level)
+	if err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+//Return an error if the compression level is not accepted by gzip and flate
+func validCompressionLevel(level int) error {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return fmt.Errorf("invalid compression level %d, it must be between %d and %d", level, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	return nil
+}
+
+//One media range of an Accept header
+type acceptMediaRange struct {
+	mediaType string
+	quality   float64
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//Parses an Accept or Accept-Encoding header into its ranges, the quality defaults to 1
+func parseAcceptHeader(header string) []acceptMediaRange {
+	var ranges []acceptMediaRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || strings.ToLower(strings.TrimSpace(kv[0])) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+				quality = q
+			}
+		}
+		ranges = append(ranges, acceptMediaRange{mediaType: mediaType, quality: quality})
+	}
+	return ranges
+}
+
+//Return true if the Accept header accepts the media type, considering the wildcards and that q=0 means not acceptable.
+//
+//The most specific range wins, e.g. "*/*, text/event-stream;q=0" does not accept text/event-stream
+func acceptsMediaType(header string, mediaType string) bool {
+	mainType := strings.SplitN(mediaType, "/", 2)[0]
+	bestSpecificity := -1
+	var bestQuality float64
+	for _, r := range parseAcceptHeader(header) {
+		specificity := -1
+		switch r.mediaType {
+		case mediaType:
+			specificity = 2
+		case mainType + "/*":
+			specificity = 1
+		case "*/*":
+			specificity = 0
+		}
+		if specificity > bestSpecificity {
+			bestSpecificity = specificity
+			bestQuality = r.quality
+		}
+	}
+	return bestSpecificity >= 0 && bestQuality > 0
+}
+
+//Return the encoding to use for the Accept-Encoding header, gzip is preferred on equal quality.
+//
+//Return empty if the client accepts none of the supported encodings
+func negotiateContentEncoding(header string, enableDeflate bool) string {
+	supported := []string{CONTENT_ENCODING_GZIP}
+	if enableDeflate {
+		supported = append(supported, CONTENT_ENCODING_DEFLATE)
+	}
+	ranges := parseAcceptHeader(header)
+	var best string
+	var bestQuality float64
+	for _, encoding := range supported {
+		quality := -1.0
+		wildcard := -1.0
+		for _, r := range ranges {
+			switch r.mediaType {
+			case encoding:
+				quality = r.quality
+			case "*":
+				wildcard = r.quality
+			}
+		}
+		if quality < 0 {
+			quality = wildcard
+		}
+		if quality > bestQuality {
+			best = encoding
+			bestQuality = quality
+		}
+	}
+	return best
+}
+
+//Return the compression to use for the request, nil if compression is disabled or the client does not support it
+func newResponseCompression(opts *CompressionOptions, req *http.Request) *responseCompression {
+	if opts == nil {
+		return nil
+	}
+	var enableDeflate bool
+	if opts.EnableDeflate != nil {
+		enableDeflate = *opts.EnableDeflate
+	}
+	encoding := negotiateContentEncoding(req.Header.Get("Accept-Encoding"), enableDeflate)
+	if encoding == "" {
+		return nil
+	}
+	rc := &responseCompression{
+		encoding: encoding,
+		minSize:  opts.MinSize,
+		level:    gzip.DefaultCompression,
+	}
+	if rc.minSize <= 0 {
+		rc.minSize = DEFAULT_COMPRESSION_MIN_SIZE
+	}
+	if opts.Level != nil {
+		rc.level = *opts.Level
+	}
+	return rc
+}
+
+//Compresses a complete body
+func (rc *responseCompression) compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := rc.newWriter(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("rc.newWriter %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("w.Write %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("w.Close %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewWriterInvalidLevelReturnsNil(t *testing.T) {
+	for _, encoding := range []string{CONTENT_ENCODING_GZIP, CONTENT_ENCODING_DEFLATE} {
+		rc := &responseCompression{encoding: encoding, level: 42}
+		w, err := rc.newWriter(httptest.NewRecorder())
+		if err == nil {
+			t.Fatalf("%s: expected an error for level 42", encoding)
+		}
+		if w != nil {
+			t.Fatalf("%s: expected an untyped nil writer, got %#v", encoding, w)
+		}
+	}
+}
+
+func TestInvalidCompressionLevelRejectedByStart(t *testing.T) {
+	level := 42
+	tr := NewStreamableHTTPServerTransport(StreamableHTTPServerTransportOptions{
+		Compression: &CompressionOptions{Level: &level},
+	})
+	if err := tr.Start(); err == nil {
+		t.Fatal("expected Start to reject the compression level")
+	}
+}
+
+func TestSSEHeadersFallBackToUncompressed(t *testing.T) {
+	s := NewStreamableHTTPServerTransport(StreamableHTTPServerTransportOptions{}).(*StreamableHTTPServerTransport)
+	rec := httptest.NewRecorder()
+	res := ResponseWriter{writer: rec}
+	res.compression = &responseCompression{encoding: CONTENT_ENCODING_GZIP, level: 42}
+	res = s.writeSSEHeaders(res)
+	if encoding := rec.Header().Get("Content-Encoding"); encoding != "" {
+		t.Fatalf("expected no Content-Encoding, got %q", encoding)
+	}
+	if err := res.sse.write(rec, []byte("data: {}\n\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if body := rec.Body.String(); body != "data: {}\n\n" {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
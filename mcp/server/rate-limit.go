@@ -0,0 +1,234 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+	"github.com/victorvbello/gomcp/mcp/utils/ratelimit"
+)
+
+const (
+	RATE_LIMIT_SCOPE_GLOBAL  = "global"
+	RATE_LIMIT_SCOPE_TOOL    = "tool"
+	RATE_LIMIT_SCOPE_SESSION = "session"
+	RATE_LIMIT_SCOPE_SUBJECT = "subject"
+	//Time after which the buckets of the idle sessions and subjects are removed
+	RATE_LIMIT_IDLE_PURGE_INTERVAL = time.Minute
+)
+
+//Token bucket limit, the zero value means no limit
+type RateLimit struct {
+	//Requests allowed per second on average.
+	Rate float64
+	//Requests allowed at once.
+	//Default is the Rate rounded up, with a minimum of 1.
+	Burst int
+}
+
+func (rl RateLimit) enabled() bool {
+	return rl.Rate > 0
+}
+
+//Return the subject of the caller used as key of RateLimiterOptions.PerSubject, empty to skip the subject limit
+type RateLimitSubjectFunc func(authInfo *types.AuthInfo) string
+
+type RateLimiterOptions struct {
+	//Requests of all the sessions using the limiter.
+	Global RateLimit
+	//Calls by tool name, of all the sessions using the limiter.
+	PerTool map[string]RateLimit
+	//Requests of each session.
+	PerSession RateLimit
+	//Requests of each authenticated caller, the unauthenticated requests are not counted.
+	PerSubject RateLimit
+	//Return the subject of the caller.
+	//Default is the `sub` claim of the access token, or else the ClientID.
+	Subject RateLimitSubjectFunc
+	//Return the current time, useful for tests.
+	//
+	//If is nil time.Now is used.
+	Now func() time.Time
+}
+
+//Rate limits of the requests handled by McpServer, share the same limiter between the servers of all the sessions
+//to apply the global, tool and subject limits across them.
+//
+//Rejected requests get an error with code ERROR_CODE_RATE_LIMITED, whose data has the scope of the exceeded limit
+//and the milliseconds to wait in `retryAfterMs`.
+//The ping and initialize requests are not limited.
+type RateLimiter struct {
+	global     *ratelimit.TokenBucket
+	tools      map[string]*ratelimit.TokenBucket
+	perSession RateLimit
+	perSubject RateLimit
+	subject    RateLimitSubjectFunc
+	sessions   *muxMapTokenBucket
+	subjects   *muxMapTokenBucket
+}
+
+func NewRateLimiter(opts RateLimiterOptions) *RateLimiter {
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	nrl := &RateLimiter{
+		tools:      make(map[string]*ratelimit.TokenBucket),
+		perSession: opts.PerSession,
+		perSubject: opts.PerSubject,
+		subject:    opts.Subject,
+		sessions:   newMuxMapTokenBucket(now),
+		subjects:   newMuxMapTokenBucket(now),
+	}
+	if opts.Global.enabled() {
+		nrl.global = ratelimit.NewTokenBucketWithClock(opts.Global.Rate, opts.Global.Burst, now)
+	}
+	for name, limit := range opts.PerTool {
+		if limit.enabled() {
+			nrl.tools[name] = ratelimit.NewTokenBucketWithClock(limit.Rate, limit.Burst, now)
+		}
+	}
+	if nrl.subject == nil {
+		nrl.subject = defaultRateLimitSubject
+	}
+	return nrl
+}
+
+func defaultRateLimitSubject(authInfo *types.AuthInfo) string {
+	if authInfo == nil {
+		return ""
+	}
+	if sub, ok := authInfo.Extra["sub"].(string); ok && sub != "" {
+		return sub
+	}
+	return authInfo.ClientID
+}
+
+//Takes a token of each limit that applies to the request, tool is empty for the requests that are not tool calls.
+//
+//Return an McpError with code ERROR_CODE_RATE_LIMITED if a limit is exceeded, in which case no token is taken
+func (rl *RateLimiter) Allow(tool string, sessionID string, authInfo *types.AuthInfo) error {
+	if rl == nil {
+		return nil
+	}
+	type limitBucket struct {
+		scope  string
+		key    string
+		bucket *ratelimit.TokenBucket
+	}
+	var buckets []limitBucket
+	if rl.global != nil {
+		buckets = append(buckets, limitBucket{RATE_LIMIT_SCOPE_GLOBAL, "", rl.global})
+	}
+	if bucket, ok := rl.tools[tool]; ok && tool != "" {
+		buckets = append(buckets, limitBucket{RATE_LIMIT_SCOPE_TOOL, tool, bucket})
+	}
+	if rl.perSession.enabled() && sessionID != "" {
+		buckets = append(buckets, limitBucket{RATE_LIMIT_SCOPE_SESSION, sessionID, rl.sessions.GetOrCreate(sessionID, rl.perSession)})
+	}
+	if subject := rl.subject(authInfo); rl.perSubject.enabled() && subject != "" {
+		buckets = append(buckets, limitBucket{RATE_LIMIT_SCOPE_SUBJECT, subject, rl.subjects.GetOrCreate(subject, rl.perSubject)})
+	}
+	for i, lb := range buckets {
+		ok, retryAfter := lb.bucket.Allow()
+		if ok {
+			continue
+		}
+		//The request is not handled, the tokens already taken are given back
+		for _, taken := range buckets[:i] {
+			taken.bucket.Return()
+		}
+		return rateLimitedError(lb.scope, lb.key, retryAfter)
+	}
+	return nil
+}
+
+//Return the time until the session can make a request, 0 if it is within its budget, without taking a token
+func (rl *RateLimiter) SessionRetryAfter(sessionID string) time.Duration {
+	if rl == nil || !rl.perSession.enabled() || sessionID == "" {
+		return 0
+	}
+	bucket, ok := rl.sessions.Get(sessionID)
+	if !ok {
+		return 0
+	}
+	return bucket.RetryAfter()
+}
+
+//Removes the bucket of a closed session
+func (rl *RateLimiter) ForgetSession(sessionID string) {
+	if rl == nil {
+		return
+	}
+	rl.sessions.Delete(sessionID)
+}
+
+func rateLimitedError(scope string, key string, retryAfter time.Duration) error {
+	subject := scope
+	if key != "" && scope == RATE_LIMIT_SCOPE_TOOL {
+		subject = fmt.Sprintf("%s %s", scope, key)
+	}
+	err := types.NewMcpError(types.ERROR_CODE_RATE_LIMITED, fmt.Sprintf("rate limit exceeded for %s", subject), map[string]interface{}{
+		"status":       http.StatusTooManyRequests,
+		"scope":        scope,
+		"retryAfterMs": retryAfter.Milliseconds(),
+	})
+	return err.ToError()
+}
+
+//Value of the Retry-After header, whole seconds rounded up
+func retryAfterHeader(retryAfter time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+}
+
+//muxMapTokenBucket, the full buckets are removed from time to time as they are the same as new ones
+type muxMapTokenBucket struct {
+	mu        sync.Mutex
+	m         map[string]*ratelimit.TokenBucket
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+func newMuxMapTokenBucket(now func() time.Time) *muxMapTokenBucket {
+	return &muxMapTokenBucket{
+		m:         make(map[string]*ratelimit.TokenBucket),
+		lastPurge: now(),
+		now:       now,
+	}
+}
+
+func (xm *muxMapTokenBucket) Get(key string) (*ratelimit.TokenBucket, bool) {
+	xm.mu.Lock()
+	val, ok := xm.m[key]
+	xm.mu.Unlock()
+	return val, ok
+}
+
+func (xm *muxMapTokenBucket) GetOrCreate(key string, limit RateLimit) *ratelimit.TokenBucket {
+	xm.mu.Lock()
+	defer xm.mu.Unlock()
+	if xm.now().Sub(xm.lastPurge) > RATE_LIMIT_IDLE_PURGE_INTERVAL {
+		for k, bucket := range xm.m {
+			if bucket.Full() {
+				delete(xm.m, k)
+			}
+		}
+		xm.lastPurge = xm.now()
+	}
+	val, ok := xm.m[key]
+	if !ok {
+		val = ratelimit.NewTokenBucketWithClock(limit.Rate, limit.Burst, xm.now)
+		xm.m[key] = val
+	}
+	return val
+}
+
+func (xm *muxMapTokenBucket) Delete(key string) {
+	xm.mu.Lock()
+	delete(xm.m, key)
+	xm.mu.Unlock()
+}
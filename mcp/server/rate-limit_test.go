@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/shared"
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+//Clock moved by the test, read by the handlers of the server
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock { return &testClock{now: time.Unix(0, 0)} }
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+//Expects a rate limit error of the scope, return its retryAfterMs
+func expectRateLimited(t *testing.T, err error, scope string) int64 {
+	t.Helper()
+	var mcpErr *types.McpError
+	if !errors.As(err, &mcpErr) || mcpErr.GetErrorCode() != types.ERROR_CODE_RATE_LIMITED {
+		t.Fatalf("expected a rate limit error, got %v", err)
+	}
+	data, _ := mcpErr.GetErrorData().(map[string]interface{})
+	if data["scope"] != scope || data["status"] != http.StatusTooManyRequests {
+		t.Fatalf("expected the scope %s and status 429, got %v", scope, data)
+	}
+	retryAfterMs, _ := data["retryAfterMs"].(int64)
+	return retryAfterMs
+}
+
+func TestRateLimiterReturnsTokensOnRejection(t *testing.T) {
+	clock := newTestClock()
+	rl := NewRateLimiter(RateLimiterOptions{
+		Global:  RateLimit{Rate: 1, Burst: 2},
+		PerTool: map[string]RateLimit{"slow": {Rate: 0.5, Burst: 1}},
+		Now:     clock.Now,
+	})
+	if err := rl.Allow("slow", "", nil); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	//The global limit allows the call, the tool limit rejects it
+	if retryAfterMs := expectRateLimited(t, rl.Allow("slow", "", nil), RATE_LIMIT_SCOPE_TOOL); retryAfterMs != 2000 {
+		t.Fatalf("expected to retry the tool after 2000ms, got %d", retryAfterMs)
+	}
+	//The global token taken by the rejected call was given back
+	if err := rl.Allow("", "", nil); err != nil {
+		t.Fatalf("expected the returned global token, got %v", err)
+	}
+	if retryAfterMs := expectRateLimited(t, rl.Allow("", "", nil), RATE_LIMIT_SCOPE_GLOBAL); retryAfterMs != 1000 {
+		t.Fatalf("expected to retry after 1000ms, got %d", retryAfterMs)
+	}
+
+	clock.Advance(2 * time.Second)
+	if err := rl.Allow("slow", "", nil); err != nil {
+		t.Fatalf("expected the limits to be refilled, got %v", err)
+	}
+}
+
+func TestRateLimiterSessionsAndSubjects(t *testing.T) {
+	clock := newTestClock()
+	rl := NewRateLimiter(RateLimiterOptions{
+		PerSession: RateLimit{Rate: 1, Burst: 1},
+		PerSubject: RateLimit{Rate: 1, Burst: 2},
+		Now:        clock.Now,
+	})
+	alice := &types.AuthInfo{ClientID: "client", Extra: map[string]interface{}{"sub": "alice"}}
+	if err := rl.Allow("", "first", alice); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	expectRateLimited(t, rl.Allow("", "first", alice), RATE_LIMIT_SCOPE_SESSION)
+	if retryAfter := rl.SessionRetryAfter("first"); retryAfter != time.Second {
+		t.Fatalf("expected the session to wait 1s, got %v", retryAfter)
+	}
+	//Other session of the same subject, the subject token of the rejected request was given back
+	if err := rl.Allow("", "second", alice); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	expectRateLimited(t, rl.Allow("", "third", alice), RATE_LIMIT_SCOPE_SUBJECT)
+	//The unauthenticated requests only count for the session
+	if err := rl.Allow("", "third", nil); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+
+	rl.ForgetSession("first")
+	if retryAfter := rl.SessionRetryAfter("first"); retryAfter != 0 {
+		t.Fatalf("expected the forgotten session to start over, got %v", retryAfter)
+	}
+}
+
+//Posts the message to the session, return the response with its body read
+func postMessage(t *testing.T, srv *httptest.Server, sessionID string, body string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+	req.Header.Set("Content-Type", CONTENT_TYPE_JSON)
+	req.Header.Set("Accept", CONTENT_TYPE_JSON+", "+CONTENT_TYPE_EVENT_STREAM)
+	if sessionID != "" {
+		req.Header.Set(shared.TRANSPORT_HEADER_SESSION_ID, sessionID)
+	}
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("io.ReadAll: %v", err)
+	}
+	return res, string(data)
+}
+
+func TestSessionOverBudgetGetsTooManyRequests(t *testing.T) {
+	clock := newTestClock()
+	rl := NewRateLimiter(RateLimiterOptions{PerSession: RateLimit{Rate: 0.5, Burst: 1}, Now: clock.Now})
+	opts := ServerOptions{RateLimiter: rl}
+	mcps := newTestMcpServer(t, opts)
+	_, err := mcps.RegisterTool(RegisterToolOpts{Name: "echo", Callback: func(args map[string]interface{}, extra *shared.RequestHandlerExtra) (*types.CallToolResult, error) {
+		return &types.CallToolResult{Content: []types.Content{types.NewTextContent("done")}}, nil
+	}})
+	if err != nil {
+		t.Fatalf("RegisterTool: %v", err)
+	}
+	s := NewStreamableHTTPServerTransport(StreamableHTTPServerTransportOptions{
+		SessionIDGenerator: func() string { return "session" },
+		RateLimiter:        rl,
+	})
+	mcps.GetServer().Protocol.Connect(context.Background(), s)
+	srv := httptest.NewServer(s.(*StreamableHTTPServerTransport))
+	defer srv.Close()
+
+	initialize := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`
+	if res, body := postMessage(t, srv, "", initialize); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the session to be initialized, got %d %s", res.StatusCode, body)
+	}
+	call := func(id int) string {
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":"echo"}}`, id)
+	}
+	if res, body := postMessage(t, srv, "session", call(2)); res.StatusCode != http.StatusOK || !strings.Contains(body, "done") {
+		t.Fatalf("expected the tool result, got %d %s", res.StatusCode, body)
+	}
+
+	//The session used its budget, the request is not handled
+	res, body := postMessage(t, srv, "session", call(3))
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "2" {
+		t.Fatalf("expected 429 with Retry-After 2, got %d %q", res.StatusCode, res.Header.Get("Retry-After"))
+	}
+	var rejected struct {
+		Error struct {
+			Code int `json:"code"`
+			Data struct {
+				Scope        string `json:"scope"`
+				RetryAfterMs int64  `json:"retryAfterMs"`
+			} `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &rejected); err != nil {
+		t.Fatalf("json.Unmarshal %s: %v", body, err)
+	}
+	if rejected.Error.Code != types.ERROR_CODE_RATE_LIMITED || rejected.Error.Data.Scope != RATE_LIMIT_SCOPE_SESSION || rejected.Error.Data.RetryAfterMs != 2000 {
+		t.Fatalf("unexpected error %s", body)
+	}
+	//The pings are not limited
+	if res, body := postMessage(t, srv, "session", `{"jsonrpc":"2.0","id":4,"method":"ping"}`); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the ping to be answered, got %d %s", res.StatusCode, body)
+	}
+
+	clock.Advance(2 * time.Second)
+	if res, body := postMessage(t, srv, "session", call(5)); res.StatusCode != http.StatusOK || !strings.Contains(body, "done") {
+		t.Fatalf("expected the tool result after the refill, got %d %s", res.StatusCode, body)
+	}
+}
@@ -3,7 +3,9 @@ package server
 import (
 	"context"
 	"fmt"
+	"sync"
 
+	"github.com/victorvbello/gomcp/mcp/methods"
 	"github.com/victorvbello/gomcp/mcp/shared"
 	"github.com/victorvbello/gomcp/mcp/types"
 	utils "github.com/victorvbello/gomcp/mcp/utils/logger"
@@ -15,6 +17,9 @@ type ServerOptions struct {
 	Capabilities types.ServerCapabilities
 	//Optional instructions describing how to use the server and its features.
 	Instructions string
+	//Rate limits of the requests handled by McpServer, share it between the servers of all the sessions.
+	//If is nil the requests are not rate limited.
+	RateLimiter *RateLimiter
 }
 
 //An MCP server on top of a pluggable transport.
@@ -24,13 +29,19 @@ type ServerOptions struct {
 //To use with custom types, extend the base Request/Notification/Result types and pass them as type parameters:
 type Server struct {
 	*shared.Protocol
+	//Guards the session state set by the initialize request, read concurrently e.g. by McpServer.Describe
+	sessionMu          sync.RWMutex
 	clientCapabilities *types.ClientCapabilities
 	clientVersion      *types.Implementation
+	protocolVersion    string
 	capabilities       types.ServerCapabilities
 	instructions       string
 	serverInfo         types.Implementation
 	onErrorCallBack    func(err error)
 	logger             utils.LogService
+	//Handlers of the methods without their own handler, e.g. custom methods
+	fallbackRequestHandler      shared.RequestHandler
+	fallbackNotificationHandler shared.NotificationHandler
 	//Callback for when initialization has fully completed (i.e., the client has sent an `initialized` notification).
 	OnInitialized func() error
 	loggingLevels *muxloggingLevelBySessionID
@@ -113,31 +124,44 @@ func (s *Server) SetOnErrorCallBack(fn func(err error)) {
 }
 
 //A handler to invoke for any request types that do not have their own handler installed.
+//
+//Nil unless set with SetFallbackRequestHandler, the requests are then answered with a `Method not found` error
 func (s *Server) FallbackRequestHandler() shared.RequestHandler {
-	return func(request types.RequestInterface, extra *shared.RequestHandlerExtra) (types.ResultInterface, error) {
-		return nil, nil
-	}
+	return s.fallbackRequestHandler
 }
 
 //A handler to invoke for any notification types that do not have their own handler installed.
+//
+//Nil unless set with SetFallbackNotificationHandler, the notifications are then ignored
 func (s *Server) FallbackNotificationHandler() shared.NotificationHandler {
-	return func(ctx context.Context, notification types.NotificationInterface) error {
-		return nil
-	}
+	return s.fallbackNotificationHandler
+}
+
+//Sets the handler of the requests without their own handler, the requests of custom methods without
+//a registered type are received as *types.RawRequest
+func (s *Server) SetFallbackRequestHandler(handler shared.RequestHandler) {
+	s.fallbackRequestHandler = handler
+}
+
+//Sets the handler of the notifications without their own handler, the notifications of custom methods without
+//a registered type are received as *types.RawNotification
+func (s *Server) SetFallbackNotificationHandler(handler shared.NotificationHandler) {
+	s.fallbackNotificationHandler = handler
 }
 
 //A method to check if a capability is supported by the remote side, for the given method to be called.
 //
 //This should be implemented by parent struct
 func (s *Server) AssertCapabilityForMethod(sReq types.RequestInterface) error {
+	clientCapabilities := s.GetClientCapabilities()
 	switch r := sReq.(type) {
 	case *types.CreateMessageRequest:
-		if s.clientCapabilities == nil || s.clientCapabilities.Sampling == nil {
+		if clientCapabilities == nil || clientCapabilities.Sampling == nil {
 			return fmt.Errorf("client does not support sampling (required for %s)", r.Method)
 		}
 		return nil
 	case *types.ListRootsRequest:
-		if s.clientCapabilities == nil || s.clientCapabilities.Roots == nil {
+		if clientCapabilities == nil || clientCapabilities.Roots == nil {
 			return fmt.Errorf("client does not support listing roots (required for %s)", r.Method)
 		}
 		return nil
@@ -229,8 +253,6 @@ func (s *Server) AssertRequestHandlerCapability(req types.RequestInterface) erro
 
 func (s *Server) onInitialize(request *types.InitializeRequest) (*types.InitializeResult, error) {
 	requestedVersion := request.Params.ProtocolVersion
-	s.clientCapabilities = &request.Params.Capabilities
-	s.clientVersion = &request.Params.ClientInfo
 
 	protocolVersion := requestedVersion
 	_, okVersion := types.SUPPORTED_PROTOCOL_VERSIONS[requestedVersion]
@@ -238,6 +260,12 @@ func (s *Server) onInitialize(request *types.InitializeRequest) (*types.Initiali
 		protocolVersion = types.LATEST_PROTOCOL_VERSION
 	}
 
+	s.sessionMu.Lock()
+	s.clientCapabilities = &request.Params.Capabilities
+	s.clientVersion = &request.Params.ClientInfo
+	s.protocolVersion = protocolVersion
+	s.sessionMu.Unlock()
+
 	result := &types.InitializeResult{
 		ProtocolVersion: protocolVersion,
 		Capabilities:    s.getCapabilities(),
@@ -265,36 +293,55 @@ func (s *Server) RegisterCapabilities(capabilities types.ServerCapabilities) err
 
 //After initialization has completed, this will be populated with the client's reported capabilities.
 func (s *Server) GetClientCapabilities() *types.ClientCapabilities {
+	s.sessionMu.RLock()
+	defer s.sessionMu.RUnlock()
 	return s.clientCapabilities
 }
 
 //After initialization has completed, this will be populated with information about the client's name and version.
 func (s *Server) GetClientVersion() *types.Implementation {
+	s.sessionMu.RLock()
+	defer s.sessionMu.RUnlock()
 	return s.clientVersion
 }
 
-func (s *Server) Ping() error {
-	_, err := s.Protocol.Request(types.NewPingRequest(), nil)
+//After initialization has completed, this will be populated with the protocol version negotiated with the client.
+func (s *Server) GetProtocolVersion() string {
+	s.sessionMu.RLock()
+	defer s.sessionMu.RUnlock()
+	return s.protocolVersion
+}
+
+func (s *Server) Ping(ctx context.Context) error {
+	_, err := s.Protocol.Request(ctx, types.NewPingRequest(), nil)
 	if err != nil {
 		return fmt.Errorf("s.Protocol.Request %v", err)
 	}
 	return nil
 }
 
-func (s *Server) CreateMessage(params types.CreateMessageParams, opts *shared.RequestOptions) (types.ResultInterface, error) {
-	result, err := s.Request(types.NewCreateMessageRequest(&params), opts)
+func (s *Server) CreateMessage(ctx context.Context, params types.CreateMessageParams, opts *shared.RequestOptions) (*types.CreateMessageResult, error) {
+	result, err := s.Request(ctx, types.NewCreateMessageRequest(&params), opts)
 	if err != nil {
 		return nil, fmt.Errorf("s.Request, %v", err)
 	}
-	return result, nil
+	createMessageResult, ok := result.(*types.CreateMessageResult)
+	if !ok {
+		return nil, fmt.Errorf("invalid result type %T for %s", result, methods.METHOD_SAMPLING_CREATE_MESSAGE)
+	}
+	return createMessageResult, nil
 }
 
-func (s *Server) ListRoots(params *types.BaseRequestParams, opts *shared.RequestOptions) (types.ResultInterface, error) {
-	result, err := s.Request(types.NewListRootsRequest(params), opts)
+func (s *Server) ListRoots(ctx context.Context, params *types.BaseRequestParams, opts *shared.RequestOptions) (*types.ListRootsResult, error) {
+	result, err := s.Request(ctx, types.NewListRootsRequest(params), opts)
 	if err != nil {
 		return nil, fmt.Errorf("s.Request, %v", err)
 	}
-	return result, nil
+	listRootsResult, ok := result.(*types.ListRootsResult)
+	if !ok {
+		return nil, fmt.Errorf("invalid result type %T for %s", result, methods.METHOD_LIST_ROOTS)
+	}
+	return listRootsResult, nil
 }
 
 func (s *Server) SendLoggingMessage(params types.LoggingMessageNotificationParams) error {
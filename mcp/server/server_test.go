@@ -0,0 +1,397 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/shared"
+	"github.com/victorvbello/gomcp/mcp/types"
+	"github.com/victorvbello/gomcp/mcp/utils/metrics"
+	"github.com/victorvbello/gomcp/mcp/utils/tracing"
+)
+
+//Run with -race, Describe reads the session state while the initialize request writes it
+func TestDescribeDuringInitialize(t *testing.T) {
+	var serverInfo types.Implementation
+	serverInfo.Name = "test"
+	mcps, err := NewMcpServer(serverInfo, ServerOptions{})
+	if err != nil {
+		t.Fatalf("NewMcpServer: %v", err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			request := &types.InitializeRequest{}
+			request.Params.ProtocolVersion = types.LATEST_PROTOCOL_VERSION
+			request.Params.ClientInfo.Name = "client"
+			if _, err := mcps.server.onInitialize(request); err != nil {
+				t.Errorf("onInitialize: %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		mcps.Describe()
+	}
+	wg.Wait()
+	if version := mcps.server.GetProtocolVersion(); version != types.LATEST_PROTOCOL_VERSION {
+		t.Fatalf("expected %s, got %s", types.LATEST_PROTOCOL_VERSION, version)
+	}
+}
+
+//Transport of a remote side that can not receive requests, e.g. an HTTP client without a GET stream
+type noRequestChannelTransport struct {
+	mu              sync.Mutex
+	closed          bool
+	started         chan struct{}
+	globalOnMessage func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo)
+	globalOnClose   func()
+}
+
+func (t *noRequestChannelTransport) Start() error {
+	close(t.started)
+	return nil
+}
+func (t *noRequestChannelTransport) Send(msg types.JSONRPCMessage, opts *shared.TransportSendOptions) (*types.JSONRPCResponse, error) {
+	return nil, fmt.Errorf("send %w", shared.ErrNoRequestChannel)
+}
+func (t *noRequestChannelTransport) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	return t.OnClose()
+}
+func (t *noRequestChannelTransport) OnClose() error {
+	if t.globalOnClose != nil {
+		t.globalOnClose()
+	}
+	return nil
+}
+func (t *noRequestChannelTransport) OnError(err error) {}
+func (t *noRequestChannelTransport) OnMessage(message types.JSONRPCMessage, extra *shared.MessageExtraInfo) {
+	t.globalOnMessage(message, extra)
+}
+func (t *noRequestChannelTransport) SetProtocolVersion(version string)  {}
+func (t *noRequestChannelTransport) GetSessionID() string               { return "" }
+func (t *noRequestChannelTransport) SetGlobalOnClose(f func())          { t.globalOnClose = f }
+func (t *noRequestChannelTransport) SetGlobalOnError(f func(err error)) {}
+func (t *noRequestChannelTransport) SetGlobalOnMessage(f func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo)) {
+	t.globalOnMessage = f
+}
+
+func (t *noRequestChannelTransport) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+func TestKeepAliveClosesIdleSessionWithoutRequestChannel(t *testing.T) {
+	peerDead := make(chan error, 1)
+	opts := ServerOptions{}
+	opts.KeepAlive = &shared.KeepAliveOptions{
+		Interval:    10 * time.Millisecond,
+		IdleTimeout: 100 * time.Millisecond,
+		OnPeerDead:  func(err error) { peerDead <- err },
+	}
+	var serverInfo types.Implementation
+	serverInfo.Name = "test"
+	mcps, err := NewMcpServer(serverInfo, opts)
+	if err != nil {
+		t.Fatalf("NewMcpServer: %v", err)
+	}
+	transport := &noRequestChannelTransport{started: make(chan struct{})}
+	//Connect returns with the first error of the connection
+	go mcps.Connect(context.Background(), transport)
+	<-transport.started
+
+	//Messages of the remote side keep the session alive
+	deadline := time.Now().Add(250 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		transport.OnMessage(&types.JSONRPCNotification{
+			JSONRPC:               types.JSONRPC_VERSION,
+			NotificationInterface: types.NewInitializedNotification(nil),
+		}, nil)
+		time.Sleep(20 * time.Millisecond)
+	}
+	if transport.isClosed() {
+		t.Fatal("expected the session to be kept while the remote side sends messages")
+	}
+
+	select {
+	case err := <-peerDead:
+		if !errors.Is(err, shared.ErrNoRequestChannel) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the idle session to be closed")
+	}
+	if !transport.isClosed() {
+		t.Fatal("expected the transport to be closed")
+	}
+}
+
+//Transport that records the sent messages, the test delivers the received ones with deliver
+type recordingTransport struct {
+	sent            chan types.JSONRPCMessage
+	globalOnMessage func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo)
+	globalOnClose   func()
+}
+
+func newRecordingTransport() *recordingTransport {
+	return &recordingTransport{sent: make(chan types.JSONRPCMessage, 100)}
+}
+
+func (t *recordingTransport) Start() error { return nil }
+func (t *recordingTransport) Send(msg types.JSONRPCMessage, opts *shared.TransportSendOptions) (*types.JSONRPCResponse, error) {
+	t.sent <- msg
+	return nil, nil
+}
+func (t *recordingTransport) Close() error { return t.OnClose() }
+func (t *recordingTransport) OnClose() error {
+	if t.globalOnClose != nil {
+		t.globalOnClose()
+	}
+	return nil
+}
+func (t *recordingTransport) OnError(err error) {}
+func (t *recordingTransport) OnMessage(message types.JSONRPCMessage, extra *shared.MessageExtraInfo) {
+	t.globalOnMessage(message, extra)
+}
+func (t *recordingTransport) SetProtocolVersion(version string)  {}
+func (t *recordingTransport) GetSessionID() string               { return "" }
+func (t *recordingTransport) SetGlobalOnClose(f func())          { t.globalOnClose = f }
+func (t *recordingTransport) SetGlobalOnError(f func(err error)) {}
+func (t *recordingTransport) SetGlobalOnMessage(f func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo)) {
+	t.globalOnMessage = f
+}
+
+//Delivers the request and return the response sent for it, other sent messages are skipped
+func (t *recordingTransport) request(tt *testing.T, id types.RequestID, request types.RequestInterface, extra *shared.MessageExtraInfo) types.JSONRPCGeneralResponse {
+	tt.Helper()
+	t.OnMessage(&types.JSONRPCRequest{JSONRPC: types.JSONRPC_VERSION, ID: id, RequestInterface: request}, extra)
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-t.sent:
+			if response, ok := msg.(types.JSONRPCGeneralResponse); ok && response.GetRequestID() == id {
+				return response
+			}
+		case <-timeout:
+			tt.Fatalf("expected a response for the request %d", id)
+			return nil
+		}
+	}
+}
+
+//Connect the server to a recordingTransport
+func connectRecording(t *testing.T, mcps *McpServer) *recordingTransport {
+	t.Helper()
+	transport := newRecordingTransport()
+	mcps.GetServer().Protocol.Connect(context.Background(), transport)
+	return transport
+}
+
+func newTestMcpServer(t *testing.T, opts ServerOptions) *McpServer {
+	t.Helper()
+	var serverInfo types.Implementation
+	serverInfo.Name = "test"
+	mcps, err := NewMcpServer(serverInfo, opts)
+	if err != nil {
+		t.Fatalf("NewMcpServer: %v", err)
+	}
+	return mcps
+}
+
+func TestForbiddenToolIsHiddenAndRejected(t *testing.T) {
+	mcps := newTestMcpServer(t, ServerOptions{})
+	callback := func(args map[string]interface{}, extra *shared.RequestHandlerExtra) (*types.CallToolResult, error) {
+		return &types.CallToolResult{Content: []types.Content{types.NewTextContent("done")}}, nil
+	}
+	if _, err := mcps.RegisterTool(RegisterToolOpts{Name: "public", Callback: callback}); err != nil {
+		t.Fatalf("RegisterTool: %v", err)
+	}
+	if _, err := mcps.RegisterTool(RegisterToolOpts{
+		Name:          "admin",
+		Callback:      callback,
+		Authorization: &Authorization{RequiredScopes: []string{"admin"}},
+	}); err != nil {
+		t.Fatalf("RegisterTool: %v", err)
+	}
+	transport := connectRecording(t, mcps)
+	extra := &shared.MessageExtraInfo{AuthInfo: &types.AuthInfo{ClientID: "client", Scopes: []string{"read"}}}
+
+	response, ok := transport.request(t, 1, types.NewListToolsRequest(nil), extra).(*types.JSONRPCResponse)
+	if !ok {
+		t.Fatalf("expected a tools/list result, got %#v", response)
+	}
+	list := response.Result.(*types.ListToolsResult)
+	if len(list.Tools) != 1 || list.Tools[0].Name != "public" {
+		t.Fatalf("expected only the public tool, got %+v", list.Tools)
+	}
+
+	callAdmin := types.NewCallToolRequest(&types.CallToolRequestParams{Name: "admin"})
+	jErr, ok := transport.request(t, 2, callAdmin, extra).(*types.JSONRPCError)
+	if !ok {
+		t.Fatal("expected the call of the admin tool to fail")
+	}
+	if code := jErr.Error.GetErrorCode(); code != types.ERROR_CODE_FORBIDDEN {
+		t.Fatalf("expected code %d, got %d", types.ERROR_CODE_FORBIDDEN, code)
+	}
+
+	adminExtra := &shared.MessageExtraInfo{AuthInfo: &types.AuthInfo{ClientID: "admin", Scopes: []string{"admin"}}}
+	if _, ok := transport.request(t, 3, callAdmin, adminExtra).(*types.JSONRPCResponse); !ok {
+		t.Fatal("expected the admin tool to be called with the admin scope")
+	}
+}
+
+func TestRequestAndToolMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	opts := ServerOptions{}
+	opts.Metrics = registry
+	mcps := newTestMcpServer(t, opts)
+	for _, name := range []string{"echo", "fail"} {
+		fail := name == "fail"
+		_, err := mcps.RegisterTool(RegisterToolOpts{Name: name, Callback: func(args map[string]interface{}, extra *shared.RequestHandlerExtra) (*types.CallToolResult, error) {
+			if fail {
+				return nil, errors.New("tool failed")
+			}
+			return &types.CallToolResult{Content: []types.Content{types.NewTextContent("done")}}, nil
+		}})
+		if err != nil {
+			t.Fatalf("RegisterTool: %v", err)
+		}
+	}
+	transport := connectRecording(t, mcps)
+	transport.request(t, 1, types.NewCallToolRequest(&types.CallToolRequestParams{Name: "echo"}), nil)
+	transport.request(t, 2, types.NewCallToolRequest(&types.CallToolRequestParams{Name: "fail"}), nil)
+	if _, ok := transport.request(t, 3, types.NewCallToolRequest(&types.CallToolRequestParams{Name: "missing"}), nil).(*types.JSONRPCError); !ok {
+		t.Fatal("expected the call of a missing tool to fail")
+	}
+	transport.request(t, 4, types.NewPingRequest(), nil)
+
+	//The instruments registered by the server are returned again by the registry
+	requests := registry.NewCounterVec(shared.METRIC_REQUESTS_TOTAL, "", "method")
+	if calls, pings := requests.Value("tools/call"), requests.Value("ping"); calls != 3 || pings != 1 {
+		t.Fatalf("expected 3 tools/call and 1 ping, got %v and %v", calls, pings)
+	}
+	requestErrors := registry.NewCounterVec(shared.METRIC_REQUEST_ERRORS_TOTAL, "", "method", "code")
+	if value := requestErrors.Value("tools/call", fmt.Sprint(types.ERROR_CODE_INVALID_PARAMS)); value != 1 {
+		t.Fatalf("expected 1 invalid params error, got %v", value)
+	}
+	if count := registry.NewHistogramVec(shared.METRIC_REQUEST_DURATION_SECONDS, "", nil, "method").Count("tools/call"); count != 3 {
+		t.Fatalf("expected 3 durations, got %d", count)
+	}
+	if inFlight := registry.NewGaugeVec(shared.METRIC_REQUESTS_IN_FLIGHT, "").Value(); inFlight != 0 {
+		t.Fatalf("expected no requests in flight, got %v", inFlight)
+	}
+	toolCalls := registry.NewCounterVec(shared.METRIC_TOOL_CALLS_TOTAL, "", "tool", "is_error")
+	if ok, failed := toolCalls.Value("echo", "false"), toolCalls.Value("fail", "true"); ok != 1 || failed != 1 {
+		t.Fatalf("expected 1 call of each tool, got %v and %v", ok, failed)
+	}
+	//The missing tool is not a tool call
+	if missing := toolCalls.Value("missing", "true") + toolCalls.Value("missing", "false"); missing != 0 {
+		t.Fatalf("expected no call of the missing tool, got %v", missing)
+	}
+
+	var exposition strings.Builder
+	registry.WriteTo(&exposition)
+	if line := `mcp_tool_calls_total{tool="echo",is_error="false"} 1`; !strings.Contains(exposition.String(), line) {
+		t.Fatalf("expected %s in the exposition:\n%s", line, exposition.String())
+	}
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		remoteSpanID = "00f067aa0ba902b7"
+	)
+	tracer := tracing.NewRecordingTracer()
+	opts := ServerOptions{}
+	opts.Tracer = tracer
+	mcps := newTestMcpServer(t, opts)
+	_, err := mcps.RegisterTool(RegisterToolOpts{Name: "traced", Callback: func(args map[string]interface{}, extra *shared.RequestHandlerExtra) (*types.CallToolResult, error) {
+		params := &types.ProgressNotificationParams{ProgressToken: "token"}
+		params.Progress.Progress = 1
+		extra.SendNotification(extra.Context, types.NewProgressNotification(params))
+		return &types.CallToolResult{Content: []types.Content{types.NewTextContent("done")}}, nil
+	}})
+	if err != nil {
+		t.Fatalf("RegisterTool: %v", err)
+	}
+	transport := connectRecording(t, mcps)
+	params := &types.CallToolRequestParams{Name: "traced"}
+	params.Meta = types.Meta{tracing.TRACEPARENT_KEY: "00-" + traceID + "-" + remoteSpanID + "-01"}
+	transport.OnMessage(&types.JSONRPCRequest{JSONRPC: types.JSONRPC_VERSION, ID: 1, RequestInterface: types.NewCallToolRequest(params)}, nil)
+
+	//The notification sent by the tool carries its trace context
+	var sentTraceParent string
+	for sentTraceParent == "" {
+		select {
+		case msg := <-transport.sent:
+			if _, ok := msg.(*types.JSONRPCNotification); !ok {
+				continue
+			}
+			data, err := types.JSONRPCMessageMarshalJSON(msg)
+			if err != nil {
+				t.Fatalf("JSONRPCMessageMarshalJSON: %v", err)
+			}
+			var notification struct {
+				Params struct {
+					Meta map[string]string `json:"_meta"`
+				} `json:"params"`
+			}
+			if err := json.Unmarshal(data, &notification); err != nil {
+				t.Fatalf("json.Unmarshal: %v", err)
+			}
+			sentTraceParent = notification.Params.Meta[tracing.TRACEPARENT_KEY]
+			if sentTraceParent == "" {
+				t.Fatalf("expected a traceparent in %s", data)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the progress notification")
+		}
+	}
+
+	spans := make(map[tracing.SpanKind]tracing.RecordedSpan)
+	for _, span := range tracer.Spans() {
+		if !span.Ended {
+			t.Fatalf("expected the span %s to be ended", span.Name)
+		}
+		spans[span.Kind] = span
+	}
+	server, tool, producer := spans[tracing.SPAN_KIND_SERVER], spans[tracing.SPAN_KIND_INTERNAL], spans[tracing.SPAN_KIND_PRODUCER]
+	if server.Name != "tools/call" || server.TraceID != traceID || server.ParentSpanID != remoteSpanID {
+		t.Fatalf("expected the request span to continue the remote trace, got %+v", server)
+	}
+	if tool.Name != shared.TRACE_SPAN_NAME_TOOL_PREFIX+"traced" || tool.TraceID != traceID || tool.ParentSpanID != server.SpanID {
+		t.Fatalf("expected the tool span to be a child of the request span, got %+v", tool)
+	}
+	if producer.TraceID != traceID || producer.ParentSpanID != tool.SpanID {
+		t.Fatalf("expected the notification span to be a child of the tool span, got %+v", producer)
+	}
+	if expected := "00-" + traceID + "-" + producer.SpanID + "-01"; sentTraceParent != expected {
+		t.Fatalf("expected the traceparent %s, got %s", expected, sentTraceParent)
+	}
+
+	//Without _meta the traceparent header of the HTTP request is used
+	tracer.Reset()
+	headers := make(http.Header)
+	headers.Set(tracing.TRACEPARENT_KEY, "00-"+traceID+"-"+remoteSpanID+"-01")
+	transport.request(t, 2, types.NewPingRequest(), &shared.MessageExtraInfo{RequestInfo: &shared.RequestInfo{Headers: headers}})
+	spans = make(map[tracing.SpanKind]tracing.RecordedSpan)
+	for _, span := range tracer.Spans() {
+		spans[span.Kind] = span
+	}
+	if ping := spans[tracing.SPAN_KIND_SERVER]; ping.TraceID != traceID || ping.ParentSpanID != remoteSpanID {
+		t.Fatalf("expected the ping span to continue the trace of the header, got %+v", ping)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"os"
+	"sync"
+)
+
+const (
+	SOCKET_NETWORK_UNIX = "unix"
+	SOCKET_NETWORK_TCP  = "tcp"
+	SOCKET_NETWORK_TCP4 = "tcp4"
+	SOCKET_NETWORK_TCP6 = "tcp6"
+)
+
+var supportedSocketNetworks = map[string]struct{}{
+	SOCKET_NETWORK_UNIX: struct{}{},
+	SOCKET_NETWORK_TCP:  struct{}{},
+	SOCKET_NETWORK_TCP4: struct{}{},
+	SOCKET_NETWORK_TCP6: struct{}{},
+}
+
+type SocketServerOptions struct {
+	//Network to listen on: "unix", "tcp", "tcp4" or "tcp6".
+	//
+	//Default is "unix".
+	Network string
+	//Address to listen on, the socket file path for "unix" or host:port for tcp networks.
+	Address string
+	//File mode applied to the socket path after listening, only used with "unix".
+	//
+	//Default is DEFAULT_SOCKET_FILE_MODE (0600).
+	SocketFileMode os.FileMode
+	//If true, a socket file left in the address path by a previous process is removed before listening.
+	//Files that are not sockets are never removed.
+	//Default is false.
+	RemoveExistingSocket *bool
+	//Factory that builds the McpServer used for every accepted connection.
+	//sessionID(string) The generated session ID of the connection
+	NewMcpServer func(sessionID string) (*McpServer, error)
+	//Function that generates a session ID for every accepted connection.
+	//
+	//If is nil a random UUID is used.
+	SessionIDGenerator func() string
+	//A callback for session initialization events
+	//This is called when a new connection is accepted and its server is connected.
+	//sessionID(string) The generated session ID
+	OnSessionInitialized func(sessionID string)
+	//A callback for session close events
+	//This is called when the connection is closed by any of the sides.
+	//sessionID(string) The generated session ID
+	OnSessionClosed func(sessionID string)
+}
+
+type socketSession struct {
+	server    *McpServer
+	transport *SocketServerTransport
+}
+
+//muxMapSocketSessions
+type muxMapSocketSessions struct {
+	mu sync.RWMutex
+	m  map[string]*socketSession
+}
+
+func newMuxMapSocketSessions() *muxMapSocketSessions {
+	return &muxMapSocketSessions{
+		m: make(map[string]*socketSession),
+	}
+}
+
+func (xm *muxMapSocketSessions) Clear() {
+	xm.mu.Lock()
+	xm.m = make(map[string]*socketSession)
+	xm.mu.Unlock()
+}
+
+func (xm *muxMapSocketSessions) Get(key string) (*socketSession, bool) {
+	xm.mu.RLock()
+	val, ok := xm.m[key]
+	xm.mu.RUnlock()
+	return val, ok
+}
+
+func (xm *muxMapSocketSessions) GetAll() map[string]*socketSession {
+	xm.mu.RLock()
+	clonedMap := make(map[string]*socketSession)
+	for key, value := range xm.m {
+		clonedMap[key] = value
+	}
+	xm.mu.RUnlock()
+	return clonedMap
+}
+
+func (xm *muxMapSocketSessions) Set(key string, value *socketSession) {
+	xm.mu.Lock()
+	xm.m[key] = value
+	xm.mu.Unlock()
+}
+
+func (xm *muxMapSocketSessions) Delete(key string) {
+	xm.mu.Lock()
+	delete(xm.m, key)
+	xm.mu.Unlock()
+}
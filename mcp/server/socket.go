@@ -0,0 +1,471 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/victorvbello/gomcp/mcp/shared"
+	"github.com/victorvbello/gomcp/mcp/types"
+	utils "github.com/victorvbello/gomcp/mcp/utils/logger"
+)
+
+const (
+	//Default file mode applied to unix socket paths, only the owner can connect.
+	DEFAULT_SOCKET_FILE_MODE = os.FileMode(0600)
+)
+
+//Server transport for a single stream connection (unix domain socket or TCP):
+//this communicates with a MCP client using newline-delimited JSON-RPC messages over the connection.
+//
+//A new transport is created by SocketServer for every accepted connection.
+type SocketServerTransport struct {
+	mu                  sync.RWMutex
+	protocolVersion     string
+	globalOnClose       func()
+	globalOnError       func(err error)
+	globalOnMessage     func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo)
+	globalContext       context.Context
+	globalContextCancel context.CancelFunc
+	conn                net.Conn
+	sessionID           string
+	started             bool
+	closeOnce           sync.Once
+	onConnClosed        func()
+	readBuffer          shared.ReadBuffer
+	logger              utils.LogService
+}
+
+func NewSocketServerTransport(conn net.Conn, sessionID string) shared.Transport {
+	return newSocketServerTransport(conn, sessionID)
+}
+
+func newSocketServerTransport(conn net.Conn, sessionID string) *SocketServerTransport {
+	nst := &SocketServerTransport{
+		conn:      conn,
+		sessionID: sessionID,
+		logger:    utils.NewLoggerService(),
+	}
+	//Created here so Close can cancel it while Start runs in another goroutine
+	nst.globalContext, nst.globalContextCancel = context.WithCancel(context.Background())
+	return nst
+}
+
+//Requests may wait for the client (sampling, roots), so their handlers run concurrently,
+//the messages keep the wire order
+func (st *SocketServerTransport) onMessage(message types.JSONRPCMessage) {
+	st.OnMessage(message, &shared.MessageExtraInfo{ConcurrentHandling: true})
+}
+
+//Starts processing messages on the transport, including any connection steps that might need to be taken.
+//
+//This method should only be called after callbacks are installed, or else messages may be lost.
+//
+//NOTE: This method should not be called explicitly when using Client, Server, or Protocol classes, as they will implicitly call start().
+func (st *SocketServerTransport) Start() error {
+	st.mu.Lock()
+	if st.started {
+		st.mu.Unlock()
+		return fmt.Errorf("socketServerTransport already started! If using Server class, note that connect() calls start() automatically")
+	}
+	st.started = true
+	st.mu.Unlock()
+
+	go func() {
+		err := shared.ReadMessageStream(st.conn, &st.readBuffer, st.onMessage, st.OnError)
+		select {
+		case <-st.globalContext.Done():
+			st.logger.Info(utils.LogFields{"sessionID": st.sessionID}, "gracefully stop reading")
+			return //gracefully stop reading
+		default:
+		}
+		if !errors.Is(err, io.EOF) {
+			st.OnError(fmt.Errorf("st.conn.Read %v", err))
+		}
+		//The peer went away, release the connection
+		st.Close()
+	}()
+	return nil
+}
+
+//Sends a JSON-RPC message (request or response).
+//
+//If present, `relatedRequestId` is used to indicate to the transport which incoming request to associate this outgoing message with.
+func (st *SocketServerTransport) Send(request types.JSONRPCMessage, options *shared.TransportSendOptions) (*types.JSONRPCResponse, error) {
+	msgJSON, err := shared.StdioSerializeMessage(request)
+	if err != nil {
+		return nil, fmt.Errorf("shared.StdioSerializeMessage %v", err)
+	}
+	//Lock the whole write so concurrent handlers never interleave lines
+	st.mu.Lock()
+	_, err = st.conn.Write([]byte(msgJSON))
+	st.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("st.conn.Write %v", err)
+	}
+	return nil, nil
+}
+
+//Closes the connection.
+func (st *SocketServerTransport) Close() error {
+	var closeErr error
+	st.closeOnce.Do(func() {
+		st.globalContextCancel()
+		if err := st.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			st.OnError(fmt.Errorf("st.conn.Close %v", err))
+		}
+		//Clear the buffer and notify closure
+		st.readBuffer.Clear()
+		err := st.OnClose()
+		if err != nil {
+			closeErr = fmt.Errorf("OnClose Error %v", err)
+			st.OnError(closeErr)
+		}
+		if st.onConnClosed != nil {
+			st.onConnClosed()
+		}
+	})
+	return closeErr
+}
+
+//Callback for when the connection is closed for any reason.
+//
+//This should be invoked when close() is called as well.
+//
+//Always execute first the prop globalOnClose if is defined
+func (st *SocketServerTransport) OnClose() error {
+	if st.globalOnClose != nil {
+		st.globalOnClose()
+	}
+	return nil
+}
+
+//Callback for when an error occurs.
+//
+//Note that errors are not necessarily fatal; they are used for reporting any kind of exceptional condition out of band.
+//
+//Always execute first the prop globalOnError if is defined
+func (st *SocketServerTransport) OnError(err error) {
+	if st.globalOnError != nil {
+		st.globalOnError(err)
+	}
+}
+
+//Callback for when a message (request or response) is received over the connection.
+//
+//Includes the authInfo if the transport is authenticated.
+//
+//Always execute first the prop globalOnMessage if is defined
+func (st *SocketServerTransport) OnMessage(message types.JSONRPCMessage, extra *shared.MessageExtraInfo) {
+	if st.globalOnMessage != nil {
+		st.globalOnMessage(message, extra)
+	}
+}
+
+//Sets the protocol version used for the connection (called when the initialize response is received).
+func (st *SocketServerTransport) SetProtocolVersion(version string) {
+	st.protocolVersion = version
+}
+
+//Return the session ID
+func (st *SocketServerTransport) GetSessionID() string {
+	return st.sessionID
+}
+
+//Return the remote address of the connection
+func (st *SocketServerTransport) RemoteAddr() net.Addr {
+	return st.conn.RemoteAddr()
+}
+
+//Set this if globalOnClose is needed, this must be executed into OnClose Func first
+func (st *SocketServerTransport) SetGlobalOnClose(fn func()) {
+	st.globalOnClose = fn
+}
+
+//Set this if globalOnError is needed, this must be executed into OnError Func first
+func (st *SocketServerTransport) SetGlobalOnError(fn func(err error)) {
+	st.globalOnError = fn
+}
+
+//Set this if globalOnMessage is needed, this must be executed into OnMessage Func first
+func (st *SocketServerTransport) SetGlobalOnMessage(fn func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo)) {
+	st.globalOnMessage = fn
+}
+
+//Listener based server for unix domain sockets and plain TCP.
+//
+//Every accepted connection gets its own SocketServerTransport and its own McpServer instance,
+//built by the NewMcpServer factory of the options.
+//
+//Usage example:
+//
+//socketServer, err := NewSocketServer(SocketServerOptions{
+//   Network: "unix",
+//   Address: "/run/mcp/mcp.sock",
+//   NewMcpServer: func(sessionID string) (*McpServer, error) {
+//      return buildMcpServer()
+//   },
+//})
+//err = socketServer.ListenAndServe(ctx)
+type SocketServer struct {
+	mu                   sync.RWMutex
+	network              string
+	address              string
+	socketFileMode       os.FileMode
+	removeExistingSocket bool
+	newMcpServer         func(sessionID string) (*McpServer, error)
+	sessionIDGenerator   func() string
+	onSessionInitialized func(sessionID string)
+	onSessionClosed      func(sessionID string)
+	listener             net.Listener
+	sessions             *muxMapSocketSessions
+	closed               bool
+	logger               utils.LogService
+	//Closed with the listener, ends the goroutine of Serve that waits for its context
+	done chan struct{}
+}
+
+func NewSocketServer(opts SocketServerOptions) (*SocketServer, error) {
+	if opts.NewMcpServer == nil {
+		return nil, fmt.Errorf("NewMcpServer factory is required")
+	}
+	network := opts.Network
+	if network == "" {
+		network = SOCKET_NETWORK_UNIX
+	}
+	if _, ok := supportedSocketNetworks[network]; !ok {
+		return nil, fmt.Errorf("unsupported network %s", network)
+	}
+	if opts.Address == "" {
+		return nil, fmt.Errorf("address is required")
+	}
+	nss := &SocketServer{
+		network:              network,
+		address:              opts.Address,
+		socketFileMode:       DEFAULT_SOCKET_FILE_MODE,
+		newMcpServer:         opts.NewMcpServer,
+		sessionIDGenerator:   opts.SessionIDGenerator,
+		onSessionInitialized: opts.OnSessionInitialized,
+		onSessionClosed:      opts.OnSessionClosed,
+		sessions:             newMuxMapSocketSessions(),
+		logger:               utils.NewLoggerService(),
+	}
+	if opts.SocketFileMode != 0 {
+		nss.socketFileMode = opts.SocketFileMode
+	}
+	if opts.RemoveExistingSocket != nil {
+		nss.removeExistingSocket = *opts.RemoveExistingSocket
+	}
+	if nss.sessionIDGenerator == nil {
+		nss.sessionIDGenerator = func() string {
+			return strings.ReplaceAll(uuid.New().String(), "-", "")
+		}
+	}
+	return nss, nil
+}
+
+//Opens the listener, for unix sockets the socket path gets the configured file mode.
+func (ss *SocketServer) Listen() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.listener != nil {
+		return fmt.Errorf("socket server already listening on %s", ss.address)
+	}
+	if ss.network == SOCKET_NETWORK_UNIX && ss.removeExistingSocket {
+		if err := removeStaleSocket(ss.address); err != nil {
+			return fmt.Errorf("removeStaleSocket %v", err)
+		}
+	}
+	listener, err := net.Listen(ss.network, ss.address)
+	if err != nil {
+		return fmt.Errorf("net.Listen %v", err)
+	}
+	if ss.network == SOCKET_NETWORK_UNIX {
+		if err := os.Chmod(ss.address, ss.socketFileMode); err != nil {
+			listener.Close()
+			return fmt.Errorf("os.Chmod %v", err)
+		}
+	}
+	ss.listener = listener
+	ss.closed = false
+	ss.done = make(chan struct{})
+	return nil
+}
+
+//Accepts connections until the context is done or the server is closed.
+//
+//Listen must be called first.
+func (ss *SocketServer) Serve(ctx context.Context) error {
+	ss.mu.RLock()
+	listener := ss.listener
+	done := ss.done
+	ss.mu.RUnlock()
+	if listener == nil {
+		return fmt.Errorf("socket server is not listening")
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			ss.Close()
+		case <-done:
+		}
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ss.isClosed() {
+				return nil
+			}
+			return fmt.Errorf("listener.Accept %v", err)
+		}
+		go ss.handleConn(ctx, conn)
+	}
+}
+
+//Listen and Serve in a single call.
+func (ss *SocketServer) ListenAndServe(ctx context.Context) error {
+	if err := ss.Listen(); err != nil {
+		return fmt.Errorf("ss.Listen %v", err)
+	}
+	return ss.Serve(ctx)
+}
+
+func (ss *SocketServer) handleConn(ctx context.Context, conn net.Conn) {
+	sessionID := ss.sessionIDGenerator()
+	mcpServer, err := ss.newMcpServer(sessionID)
+	if err != nil {
+		ss.logger.Error(utils.LogFields{"sessionID": sessionID}, fmt.Sprintf("ss.newMcpServer %v", err))
+		conn.Close()
+		return
+	}
+	transport := newSocketServerTransport(conn, sessionID)
+	//Release the session once the connection is gone
+	transport.onConnClosed = func() {
+		ss.sessions.Delete(sessionID)
+		if ss.onSessionClosed != nil {
+			ss.onSessionClosed(sessionID)
+		}
+	}
+	mcpServer.GetServer().Protocol.Connect(ctx, transport)
+	//Registered once connected, so Close and Shutdown find the callbacks of the protocol set
+	ss.sessions.Set(sessionID, &socketSession{
+		server:    mcpServer,
+		transport: transport,
+	})
+	//Closed meanwhile by the peer, or by the server before it could see the session
+	if transport.globalContext.Err() != nil || ss.isClosed() {
+		ss.sessions.Delete(sessionID)
+		transport.Close()
+		return
+	}
+	if ss.onSessionInitialized != nil {
+		ss.onSessionInitialized(sessionID)
+	}
+}
+
+func (ss *SocketServer) isClosed() bool {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.closed
+}
+
+//Return the listener address, nil if not listening
+func (ss *SocketServer) Addr() net.Addr {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	if ss.listener == nil {
+		return nil
+	}
+	return ss.listener.Addr()
+}
+
+//Return the ID of all the active sessions
+func (ss *SocketServer) SessionIDs() []string {
+	var result []string
+	for sessionID := range ss.sessions.GetAll() {
+		result = append(result, sessionID)
+	}
+	return result
+}
+
+//Stops accepting connections and closes every active session.
+func (ss *SocketServer) Close() error {
+	closed, gErr := ss.closeListener()
+	if closed {
+		return nil
+	}
+	for _, session := range ss.sessions.GetAll() {
+		if err := session.transport.Close(); err != nil {
+			ss.logger.Error(nil, fmt.Sprintf("session.transport.Close %v", err))
+		}
+	}
+	ss.sessions.Clear()
+	return gErr
+}
+
+//Stops accepting connections and shuts down every active session, letting their running requests finish until ctx is done.
+//
+//Return the error of ctx if some requests did not finish in time, their sessions are closed anyway
+func (ss *SocketServer) Shutdown(ctx context.Context) error {
+	closed, gErr := ss.closeListener()
+	if closed {
+		return nil
+	}
+	var servers []*McpServer
+	for _, session := range ss.sessions.GetAll() {
+		servers = append(servers, session.server)
+	}
+	if err := ShutdownServers(ctx, servers); err != nil && gErr == nil {
+		gErr = fmt.Errorf("ShutdownServers %w", err)
+	}
+	ss.sessions.Clear()
+	return gErr
+}
+
+//Marks the server closed and closes the listener, return true if it was already closed
+func (ss *SocketServer) closeListener() (bool, error) {
+	ss.mu.Lock()
+	if ss.closed {
+		ss.mu.Unlock()
+		return true, nil
+	}
+	ss.closed = true
+	listener := ss.listener
+	ss.listener = nil
+	if ss.done != nil {
+		close(ss.done)
+		ss.done = nil
+	}
+	ss.mu.Unlock()
+
+	if listener != nil {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			return false, fmt.Errorf("listener.Close %v", err)
+		}
+	}
+	return false, nil
+}
+
+//Removes a previous socket file left by a crashed process, any other kind of file is kept.
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("os.Stat %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("os.Remove %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/client"
+	"github.com/victorvbello/gomcp/mcp/shared"
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+func newTestSocketServer(t *testing.T, opts SocketServerOptions) *SocketServer {
+	t.Helper()
+	if opts.Address == "" {
+		opts.Address = filepath.Join(t.TempDir(), "mcp.sock")
+	}
+	opts.NewMcpServer = func(sessionID string) (*McpServer, error) {
+		var serverInfo types.Implementation
+		serverInfo.Name = "socket-test"
+		return NewMcpServer(serverInfo, ServerOptions{})
+	}
+	ss, err := NewSocketServer(opts)
+	if err != nil {
+		t.Fatalf("NewSocketServer: %v", err)
+	}
+	return ss
+}
+
+//Serve in a goroutine, the returned channel gets its result
+func serveSocketServer(t *testing.T, ss *SocketServer, ctx context.Context) chan error {
+	t.Helper()
+	if err := ss.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- ss.Serve(ctx) }()
+	return served
+}
+
+func waitServe(t *testing.T, served chan error) {
+	t.Helper()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Serve to return")
+	}
+}
+
+func TestSocketServerInitializeRoundTrip(t *testing.T) {
+	ss := newTestSocketServer(t, SocketServerOptions{})
+	served := serveSocketServer(t, ss, context.Background())
+	defer ss.Close()
+
+	received := make(chan types.JSONRPCMessage, 1)
+	ct := client.NewSocketClientTransport(client.SocketClientTransportOptions{Address: ss.Addr().String()})
+	ct.SetGlobalOnMessage(func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo) {
+		received <- message
+	})
+	if err := ct.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer ct.Close()
+
+	params := &types.InitializeRequestParams{ProtocolVersion: types.LATEST_PROTOCOL_VERSION}
+	params.ClientInfo.Name = "socket-client"
+	_, err := ct.Send(&types.JSONRPCRequest{
+		JSONRPC:          types.JSONRPC_VERSION,
+		ID:               1,
+		RequestInterface: types.NewInitializeRequest(params),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	select {
+	case message := <-received:
+		response, ok := message.(*types.JSONRPCResponse)
+		if !ok {
+			t.Fatalf("expected a response, got %#v", message)
+		}
+		result, ok := response.Result.(*types.InitializeResult)
+		if !ok {
+			t.Fatalf("expected an InitializeResult, got %#v", response.Result)
+		}
+		if response.ID != 1 || result.ProtocolVersion != types.LATEST_PROTOCOL_VERSION || result.ServerInfo.Name != "socket-test" {
+			t.Fatalf("unexpected initialize result %+v", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the initialize response")
+	}
+	if sessions := ss.SessionIDs(); len(sessions) != 1 {
+		t.Fatalf("expected 1 session, got %v", sessions)
+	}
+
+	if err := ss.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	waitServe(t, served)
+}
+
+func TestSocketServerFileMode(t *testing.T) {
+	ss := newTestSocketServer(t, SocketServerOptions{})
+	if err := ss.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ss.Close()
+	info, err := os.Stat(ss.address)
+	if err != nil {
+		t.Fatalf("os.Stat: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != DEFAULT_SOCKET_FILE_MODE {
+		t.Fatalf("expected mode %v, got %v", DEFAULT_SOCKET_FILE_MODE, mode)
+	}
+}
+
+func TestSocketServerRemoveExistingSocket(t *testing.T) {
+	removeExisting := true
+	dir := t.TempDir()
+
+	//A regular file is never removed
+	filePath := filepath.Join(dir, "data.sock")
+	if err := os.WriteFile(filePath, []byte("data"), 0600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	ss := newTestSocketServer(t, SocketServerOptions{Address: filePath, RemoveExistingSocket: &removeExisting})
+	if err := ss.Listen(); err == nil {
+		ss.Close()
+		t.Fatal("expected Listen to fail over a regular file")
+	}
+	if data, err := os.ReadFile(filePath); err != nil || string(data) != "data" {
+		t.Fatalf("expected the file to be kept, got %q %v", data, err)
+	}
+
+	//A socket left by a crashed process is replaced
+	stalePath := filepath.Join(dir, "stale.sock")
+	listener, err := net.ListenUnix(SOCKET_NETWORK_UNIX, &net.UnixAddr{Name: stalePath, Net: SOCKET_NETWORK_UNIX})
+	if err != nil {
+		t.Fatalf("net.ListenUnix: %v", err)
+	}
+	listener.SetUnlinkOnClose(false)
+	listener.Close()
+	ss = newTestSocketServer(t, SocketServerOptions{Address: stalePath, RemoveExistingSocket: &removeExisting})
+	if err := ss.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	ss.Close()
+}
+
+//Serve with a connected session, so Serve is known to be accepting when the test stops it
+func serveWithSession(t *testing.T) (*SocketServer, chan error) {
+	t.Helper()
+	initialized := make(chan struct{}, 1)
+	ss := newTestSocketServer(t, SocketServerOptions{
+		OnSessionInitialized: func(sessionID string) { initialized <- struct{}{} },
+	})
+	served := serveSocketServer(t, ss, context.Background())
+	conn, err := net.Dial(SOCKET_NETWORK_UNIX, ss.address)
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	select {
+	case <-initialized:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the connection to be accepted")
+	}
+	return ss, served
+}
+
+func TestSocketServerCloseEndsServe(t *testing.T) {
+	ss, served := serveWithSession(t)
+	if err := ss.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	waitServe(t, served)
+	if sessions := ss.SessionIDs(); len(sessions) != 0 {
+		t.Fatalf("expected the sessions to be closed, got %v", sessions)
+	}
+}
+
+func TestSocketServerShutdownEndsServe(t *testing.T) {
+	ss, served := serveWithSession(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ss.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	waitServe(t, served)
+}
+
+func TestSocketServerContextEndsServe(t *testing.T) {
+	ss := newTestSocketServer(t, SocketServerOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	served := serveSocketServer(t, ss, ctx)
+	cancel()
+	waitServe(t, served)
+}
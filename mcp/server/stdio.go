@@ -3,6 +3,7 @@ package server
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
@@ -12,57 +13,48 @@ import (
 	utils "github.com/victorvbello/gomcp/mcp/utils/logger"
 )
 
-const (
-	_MAX_STDIO_BUFFER_READ = 4096
-)
+type StdioServerTransportOptions struct {
+	//Lines longer than this are discarded and reported with OnError.
+	//Default is shared.DEFAULT_MAX_LINE_SIZE.
+	MaxLineSize int
+}
 
 //Server transport for stdio: this communicates with a MCP client by reading from the current process' stdin and writing to stdout.
 //
-//This transport is only available in Node.js environments.
+//The messages are read and dispatched in order by a single loop, the request handlers run concurrently and the
+//notification handlers in order apart from the loop.
 type StdioServerTransport struct {
-	mu                  sync.RWMutex
+	mu                  sync.Mutex
 	protocolVersion     string
 	globalOnClose       func()
 	globalOnError       func(err error)
 	globalOnMessage     func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo)
 	globalContext       context.Context
 	globalContextCancel context.CancelFunc
-	stdin               *bufio.Reader
+	stdin               io.Reader
 	stdout              *bufio.Writer
 	started             bool
+	closeOnce           sync.Once
 	readBuffer          shared.ReadBuffer
 	logger              utils.LogService
 }
 
 func NewStdioServerTransport(stdin io.Reader, stdout io.Writer) shared.Transport {
+	return NewStdioServerTransportWithOptions(stdin, stdout, StdioServerTransportOptions{})
+}
+
+func NewStdioServerTransportWithOptions(stdin io.Reader, stdout io.Writer, opts StdioServerTransportOptions) shared.Transport {
 	nst := &StdioServerTransport{
-		stdin:  bufio.NewReader(stdin),
+		stdin:  stdin,
 		stdout: bufio.NewWriter(stdout),
 		logger: utils.NewLoggerService(),
 	}
+	nst.readBuffer.MaxLineSize = opts.MaxLineSize
 	return nst
 }
 
-func (st *StdioServerTransport) processReadBuffer() {
-	for {
-		message, err := st.readBuffer.ReadMessage()
-		if err != nil {
-			st.OnError(fmt.Errorf("st.readBuffer.ReadMessage %v", err))
-		}
-		if message == nil {
-			break
-		}
-		st.OnMessage(message, nil)
-	}
-}
-
-func (st *StdioServerTransport) onData(chunk []byte) {
-	st.readBuffer.Append(chunk)
-	st.processReadBuffer()
-}
-
-func (st *StdioServerTransport) onError(err error) {
-	st.OnError(err)
+func (st *StdioServerTransport) onMessage(message types.JSONRPCMessage) {
+	st.OnMessage(message, &shared.MessageExtraInfo{ConcurrentHandling: true})
 }
 
 //Starts processing messages on the transport, including any connection steps that might need to be taken.
@@ -78,23 +70,18 @@ func (st *StdioServerTransport) Start() error {
 	st.globalContext, st.globalContextCancel = context.WithCancel(context.Background())
 
 	go func() {
-		buf := make([]byte, _MAX_STDIO_BUFFER_READ)
-		for {
-			select {
-			case <-st.globalContext.Done():
-				st.logger.Info(nil, "gracefully stop reading")
-				return //gracefully stop reading
-			default:
-				n, err := st.stdin.Read(buf)
-				if err != nil {
-					st.onError(fmt.Errorf("st.stdin.Read %v", err))
-					return
-				}
-				go func() {
-					st.onData(buf[:n])
-				}()
-			}
+		err := shared.ReadMessageStream(st.stdin, &st.readBuffer, st.onMessage, st.OnError)
+		select {
+		case <-st.globalContext.Done():
+			st.logger.Info(nil, "gracefully stop reading")
+			return //gracefully stop reading
+		default:
+		}
+		if !errors.Is(err, io.EOF) {
+			st.OnError(fmt.Errorf("st.stdin.Read %v", err))
 		}
+		//The client closed stdin, release the connection
+		st.Close()
 	}()
 
 	st.started = true
@@ -109,14 +96,14 @@ func (st *StdioServerTransport) Send(request types.JSONRPCMessage, options *shar
 	if err != nil {
 		return nil, fmt.Errorf("shared.StdioSerializeMessage %v", err)
 	}
-	st.mu.RLock()
-	stdout := st.stdout
-	st.mu.RUnlock()
-	_, err = stdout.Write([]byte(msgJSON))
+	//Lock the whole write so concurrent handlers never interleave lines
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, err = st.stdout.Write([]byte(msgJSON))
 	if err != nil {
 		return nil, fmt.Errorf("st.stdout.Write %v", err)
 	}
-	err = stdout.Flush()
+	err = st.stdout.Flush()
 	if err != nil {
 		return nil, fmt.Errorf("st.stdout.Flush %v", err)
 	}
@@ -125,16 +112,20 @@ func (st *StdioServerTransport) Send(request types.JSONRPCMessage, options *shar
 
 //Closes the connection.
 func (st *StdioServerTransport) Close() error {
-	st.globalContextCancel()
-	//Clear the buffer and notify closure
-	st.readBuffer.Clear()
-	err := st.OnClose()
-	if err != nil {
-		lErr := fmt.Errorf("OnClose Error %v", err)
-		st.OnError(lErr)
-		return lErr
-	}
-	return nil
+	var closeErr error
+	st.closeOnce.Do(func() {
+		if st.globalContextCancel != nil {
+			st.globalContextCancel()
+		}
+		//Clear the buffer and notify closure
+		st.readBuffer.Clear()
+		err := st.OnClose()
+		if err != nil {
+			closeErr = fmt.Errorf("OnClose Error %v", err)
+			st.OnError(closeErr)
+		}
+	})
+	return closeErr
 }
 
 //Callback for when the connection is closed for any reason.
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+//Client side of a stdio server, the lines are written to its stdin and read from its stdout
+type stdioTestClient struct {
+	stdin *io.PipeWriter
+	lines chan []byte
+}
+
+func newStdioTestClient(t *testing.T, mcps *McpServer) *stdioTestClient {
+	t.Helper()
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	transport := NewStdioServerTransport(stdinReader, stdoutWriter)
+	mcps.GetServer().Protocol.Connect(context.Background(), transport)
+	t.Cleanup(func() {
+		stdinWriter.Close()
+		stdoutReader.Close()
+	})
+	lines := make(chan []byte, 10)
+	go func() {
+		scanner := bufio.NewScanner(stdoutReader)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+	}()
+	return &stdioTestClient{stdin: stdinWriter, lines: lines}
+}
+
+func (c *stdioTestClient) write(t *testing.T, line string) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.stdin.Write([]byte(line + "\n"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the server is not reading its stdin")
+	}
+}
+
+//Return the next message written by the server
+func (c *stdioTestClient) read(t *testing.T) map[string]interface{} {
+	t.Helper()
+	select {
+	case line := <-c.lines:
+		var message map[string]interface{}
+		if err := json.Unmarshal(line, &message); err != nil {
+			t.Fatalf("json.Unmarshal %s: %v", line, err)
+		}
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a message from the server")
+	}
+	return nil
+}
+
+func TestInitializedHandlerRequestOverStdio(t *testing.T) {
+	mcps := newTestMcpServer(t, ServerOptions{})
+	roots := make(chan *types.ListRootsResult, 1)
+	mcps.SetOnInitialized(func() error {
+		//Waits for the response read by the same loop that delivered the notification
+		result, err := mcps.GetServer().ListRoots(context.Background(), nil, nil)
+		if err != nil {
+			return err
+		}
+		roots <- result
+		return nil
+	})
+	client := newStdioTestClient(t, mcps)
+
+	client.write(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{"roots":{}},"clientInfo":{"name":"test","version":"1"}}}`)
+	if response := client.read(t); response["id"] != float64(1) || response["result"] == nil {
+		t.Fatalf("expected the initialize result, got %v", response)
+	}
+	client.write(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	request := client.read(t)
+	if request["method"] != "roots/list" {
+		t.Fatalf("expected the roots/list request, got %v", request)
+	}
+	id, _ := json.Marshal(request["id"])
+	client.write(t, `{"jsonrpc":"2.0","id":`+string(id)+`,"result":{"roots":[{"uri":"file:///tmp","name":"tmp"}]}}`)
+	select {
+	case result := <-roots:
+		if len(result.Roots) != 1 || result.Roots[0].URI != "file:///tmp" {
+			t.Fatalf("unexpected roots %+v", result.Roots)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the initialized handler to get the roots")
+	}
+}
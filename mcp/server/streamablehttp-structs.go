@@ -5,9 +5,40 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/victorvbello/gomcp/mcp/shared"
 	"github.com/victorvbello/gomcp/mcp/types"
+	"github.com/victorvbello/gomcp/mcp/utils/metrics"
+)
+
+const (
+	DEFAULT_EVENT_STORE_EXPIRY_INTERVAL = time.Minute
+	DEFAULT_SSE_KEEPALIVE_INTERVAL      = 15 * time.Second
+	DEFAULT_SSE_RETRY_INTERVAL          = 3 * time.Second
+	DEFAULT_SSE_QUEUE_SIZE              = 256
+	DEFAULT_PENDING_REQUESTS_SIZE       = 32
+	DEFAULT_PENDING_REQUEST_TTL         = 30 * time.Second
+	SSE_STREAM_KIND_STANDALONE          = "standalone"
+	SSE_STREAM_KIND_REQUEST             = "request"
+	//How often Drain checks if the requests were answered and the SSE queues written
+	DRAIN_POLL_INTERVAL = 10 * time.Millisecond
+	//Error messages delivered to the callers of the pending requests that are not sent
+	PENDING_REQUEST_EXPIRED_MESSAGE  = "Connection closed: the standalone SSE stream did not reconnect before the request expired"
+	PENDING_REQUEST_NOT_SENT_MESSAGE = "Connection closed: the standalone SSE stream failed before the request was sent"
+)
+
+//What Send does when the outbound queue of a SSE stream is full
+type SSEOverflowPolicy int
+
+const (
+	//Send waits until the stream writes a queued event, or the stream ends
+	SSE_OVERFLOW_POLICY_BLOCK SSEOverflowPolicy = iota
+	//The oldest queued notification is dropped, if there are none a new notification is dropped
+	//and requests and responses are queued over the limit so they are never lost
+	SSE_OVERFLOW_POLICY_DROP_OLDEST_NOTIFICATION
+	//The stream is closed, the client can reconnect and replay the missed events when an EventStore is configured
+	SSE_OVERFLOW_POLICY_CLOSE_STREAM
 )
 
 type StreamableHTTPServerTransportOptions struct {
@@ -38,6 +69,11 @@ type StreamableHTTPServerTransportOptions struct {
 	//Event store for resumability support
 	//If provided, resumability will be enabled, allowing clients to reconnect and resume messages
 	EventStore shared.EventStore
+	//Events older than this are expired from the EventStore, it requires a store implementing shared.EventStoreCleaner.
+	//The expiry runs when new events are stored, at most once per DEFAULT_EVENT_STORE_EXPIRY_INTERVAL.
+	//If is 0 the events are kept until the client ends the session with a DELETE request,
+	//closing the transport, e.g. on a Shutdown, does not delete them.
+	EventStoreTTL time.Duration
 	//List of allowed host header values for DNS rebinding protection.
 	//If not specified, host validation is disabled.
 	AllowedHosts map[string]struct{}
@@ -47,10 +83,70 @@ type StreamableHTTPServerTransportOptions struct {
 	//Enable DNS rebinding protection (requires allowedHosts and/or allowedOrigins to be configured).
 	//Default is false for backwards compatibility.
 	EnableDNSRebindingProtection *bool
+	//OAuth discovery metadata for the transport endpoint.
+	//If provided, ServeHTTP also answers the /.well-known/oauth-protected-resource requests
+	//(and /.well-known/oauth-authorization-server when proxied).
+	//Invalid metadata makes Start return an error and the requests are answered with an error.
+	OAuthMetadata *OAuthMetadataOptions
+	//Interval of the SSE comments written on idle GET streams so proxies and load balancers do not cut them.
+	//Default is DEFAULT_SSE_KEEPALIVE_INTERVAL, a negative value disables the keepalive.
+	SSEKeepAliveInterval time.Duration
+	//Reconnection delay sent to the client in the SSE retry field when a GET stream is opened.
+	//Default is DEFAULT_SSE_RETRY_INTERVAL, a negative value omits the field.
+	SSERetryInterval time.Duration
+	//A callback for SSE stream close events
+	//This is called when a GET stream ends because the client disconnected, a write failed (dead connection)
+	//or the transport was closed.
+	//sessionID(string) The session ID, empty in stateless mode
+	//streamID(shared.StreamID) The closed stream
+	//err(error) The write error of a dead connection, nil otherwise
+	OnStreamClosed func(sessionID string, streamID shared.StreamID, err error)
+	//Maximum number of events waiting to be written on each SSE stream, so a slow client does not stall the senders.
+	//Default is DEFAULT_SSE_QUEUE_SIZE.
+	SSEQueueSize int
+	//What to do when the queue of a stream is full.
+	//Default is SSE_OVERFLOW_POLICY_BLOCK.
+	SSEOverflowPolicy *SSEOverflowPolicy
+	//Maximum number of server to client requests (e.g. sampling, roots) kept while the standalone GET stream
+	//is disconnected, they are sent when the client reconnects.
+	//Default is DEFAULT_PENDING_REQUESTS_SIZE.
+	PendingRequestsSize int
+	//Pending requests older than this are discarded instead of sent, their callers get an
+	//ERROR_CODE_CONNECTION_CLOSED error.
+	//Default is DEFAULT_PENDING_REQUEST_TTL.
+	PendingRequestTTL time.Duration
+	//Compression of the responses negotiated with the Accept-Encoding header.
+	//If is nil the responses are not compressed.
+	Compression *CompressionOptions
+	//Registry where the active sessions, the open SSE streams and the event store size are recorded.
+	//If is nil no metrics are recorded.
+	Metrics *metrics.Registry
+	//Rate limits shared with the McpServer of the session, the POST requests of a session over its
+	//RateLimiterOptions.PerSession budget are answered with 429 Too Many Requests and a Retry-After header,
+	//except the ones with only pings.
+	//If is nil the POST requests are not rate limited.
+	RateLimiter *RateLimiter
+}
+
+//Outbound queue metrics of an open SSE stream
+type SSEStreamStats struct {
+	StreamID shared.StreamID
+	//Events waiting to be written
+	QueueDepth int
+	//Highest QueueDepth reached
+	MaxQueueDepth int
+	//Events written to the client
+	Written uint64
+	//Events discarded by the overflow policy
+	Dropped uint64
 }
 
 type ResponseWriter struct {
 	writer http.ResponseWriter
+	//Set on the SSE streams, shared by all the copies of the writer
+	sse *sseStream
+	//Negotiated with the Accept-Encoding header, nil if the response is not compressed
+	compression *responseCompression
 }
 
 func (r *ResponseWriter) Writer() http.ResponseWriter {
@@ -70,6 +166,16 @@ func (r *ResponseWriter) WriteJSON(code int, data interface{}) error {
 		r.writer.Write([]byte(lErr.Error()))
 		return lErr
 	}
+	//The SSE streams have the headers already sent, they compress the whole stream
+	//If the compression fails the response is sent uncompressed, the invalid options are reported by Start
+	if r.compression != nil && r.sse == nil && len(b) >= r.compression.minSize {
+		compressed, err := r.compression.compress(b)
+		if err == nil {
+			r.writer.Header().Set("Content-Encoding", r.compression.encoding)
+			r.writer.Header().Del("Content-Length")
+			b = compressed
+		}
+	}
 	r.writer.WriteHeader(code)
 	if code, err := r.writer.Write(b); err != nil {
 		return fmt.Errorf("could not response - code: %d", code)
@@ -77,12 +183,263 @@ func (r *ResponseWriter) WriteJSON(code int, data interface{}) error {
 	return nil
 }
 
+//Server to client request waiting for the standalone SSE stream
+type pendingStandaloneRequest struct {
+	message  types.JSONRPCMessage
+	queuedAt time.Time
+}
+
+//An event waiting in the queue of a SSE stream
+type sseQueuedEvent struct {
+	data []byte
+	//Notifications can be dropped by SSE_OVERFLOW_POLICY_DROP_OLDEST_NOTIFICATION
+	droppable bool
+}
+
+//State of an open SSE stream, a bounded queue of events written by the goroutine serving the stream,
+//serializes the writes and signals when the stream ends
+type sseStream struct {
+	mu   sync.Mutex
+	done chan struct{}
+	once sync.Once
+	err  error
+	//Set when the stream is compressed, the data is written through it
+	compressor flushWriteCloser
+	//Closed when no more events will be queued, the writer drains the queue and returns
+	finished     chan struct{}
+	finishedOnce sync.Once
+	//Queue, guarded by queueMu
+	queueMu       sync.Mutex
+	queue         []sseQueuedEvent
+	queueSize     int
+	policy        SSEOverflowPolicy
+	maxQueueDepth int
+	written       uint64
+	dropped       uint64
+	//Signaled when an event is queued and when one is taken
+	ready chan struct{}
+	space chan struct{}
+}
+
+func newSSEStream(queueSize int, policy SSEOverflowPolicy) *sseStream {
+	return &sseStream{
+		done:      make(chan struct{}),
+		finished:  make(chan struct{}),
+		queueSize: queueSize,
+		policy:    policy,
+		ready:     make(chan struct{}, 1),
+		space:     make(chan struct{}, 1),
+	}
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+//Queues the event to be written by the stream goroutine, applying the overflow policy when the queue is full
+func (ss *sseStream) enqueue(data []byte, droppable bool) error {
+	ss.queueMu.Lock()
+full:
+	for len(ss.queue) >= ss.queueSize {
+		if err := ss.closedErr(); err != nil {
+			ss.queueMu.Unlock()
+			return err
+		}
+		switch ss.policy {
+		case SSE_OVERFLOW_POLICY_DROP_OLDEST_NOTIFICATION:
+			if i := ss.oldestDroppable(); i >= 0 {
+				ss.queue = append(ss.queue[:i], ss.queue[i+1:]...)
+				ss.dropped++
+				continue
+			}
+			if droppable {
+				ss.dropped++
+				ss.queueMu.Unlock()
+				return nil
+			}
+			//Only requests and responses are queued, they are never lost
+			break full
+		case SSE_OVERFLOW_POLICY_CLOSE_STREAM:
+			ss.dropped += uint64(len(ss.queue)) + 1
+			ss.queue = nil
+			ss.queueMu.Unlock()
+			err := fmt.Errorf("sse stream queue overflow, size %d", ss.queueSize)
+			ss.close(err)
+			return err
+		default:
+			ss.queueMu.Unlock()
+			select {
+			case <-ss.space:
+			case <-ss.done:
+			}
+			ss.queueMu.Lock()
+		}
+	}
+	if err := ss.closedErr(); err != nil {
+		ss.queueMu.Unlock()
+		return err
+	}
+	ss.queue = append(ss.queue, sseQueuedEvent{data: data, droppable: droppable})
+	if len(ss.queue) > ss.maxQueueDepth {
+		ss.maxQueueDepth = len(ss.queue)
+	}
+	ss.queueMu.Unlock()
+	signal(ss.ready)
+	return nil
+}
+
+//Return the position of the oldest queued notification, -1 if there are none
+func (ss *sseStream) oldestDroppable() int {
+	for i, event := range ss.queue {
+		if event.droppable {
+			return i
+		}
+	}
+	return -1
+}
+
+//Writes all the queued events, called from the goroutine serving the stream
+func (ss *sseStream) flush(w http.ResponseWriter) error {
+	for {
+		ss.queueMu.Lock()
+		if len(ss.queue) == 0 {
+			ss.queueMu.Unlock()
+			return nil
+		}
+		event := ss.queue[0]
+		ss.queue[0] = sseQueuedEvent{}
+		ss.queue = ss.queue[1:]
+		ss.queueMu.Unlock()
+		signal(ss.space)
+		if err := ss.write(w, event.data); err != nil {
+			return err
+		}
+		ss.queueMu.Lock()
+		ss.written++
+		ss.queueMu.Unlock()
+	}
+}
+
+//Serves the stream until finish is called or the stream ends, writing the queued events
+func (ss *sseStream) drain(w http.ResponseWriter) {
+	for {
+		select {
+		case <-ss.ready:
+			if ss.flush(w) != nil {
+				return
+			}
+		case <-ss.finished:
+			ss.flush(w)
+			return
+		case <-ss.done:
+			return
+		}
+	}
+}
+
+//No more events will be queued
+func (ss *sseStream) finish() {
+	ss.finishedOnce.Do(func() {
+		close(ss.finished)
+	})
+}
+
+func (ss *sseStream) stats(streamID shared.StreamID) SSEStreamStats {
+	ss.queueMu.Lock()
+	defer ss.queueMu.Unlock()
+	return SSEStreamStats{
+		StreamID:      streamID,
+		QueueDepth:    len(ss.queue),
+		MaxQueueDepth: ss.maxQueueDepth,
+		Written:       ss.written,
+		Dropped:       ss.dropped,
+	}
+}
+
+//Return the error of an ended stream, nil if is open
+func (ss *sseStream) closedErr() error {
+	select {
+	case <-ss.done:
+		if ss.err != nil {
+			return ss.err
+		}
+		return fmt.Errorf("sse stream closed")
+	default:
+		return nil
+	}
+}
+
+//Writes and flushes the data, a write error marks the stream as dead
+func (ss *sseStream) write(w http.ResponseWriter, data []byte) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if err := ss.closedErr(); err != nil {
+		return err
+	}
+	if ss.compressor != nil {
+		//Flushes the compressor on every write so the event is not held in its buffer
+		if _, err := ss.compressor.Write(data); err != nil {
+			ss.closeLocked(err)
+			return err
+		}
+		if err := ss.compressor.Flush(); err != nil {
+			ss.closeLocked(err)
+			return err
+		}
+	} else if _, err := w.Write(data); err != nil {
+		ss.closeLocked(err)
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+//Ends the stream and writes the compression trailer, must be called by the goroutine serving the request before it returns
+func (ss *sseStream) end(w http.ResponseWriter) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.closeLocked(nil)
+	if ss.compressor == nil {
+		return
+	}
+	if err := ss.compressor.Close(); err == nil {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	ss.compressor = nil
+}
+
+func (ss *sseStream) close(err error) {
+	ss.mu.Lock()
+	ss.closeLocked(err)
+	ss.mu.Unlock()
+}
+
+func (ss *sseStream) closeLocked(err error) {
+	ss.once.Do(func() {
+		ss.err = err
+		close(ss.done)
+	})
+}
+
 //muxMapStreamMapping
 type muxMapStreamMapping struct {
 	mu sync.RWMutex
 	m  map[shared.StreamID]ResponseWriter
 }
 
+func newMuxMapStreamMapping() *muxMapStreamMapping {
+	return &muxMapStreamMapping{
+		m: make(map[shared.StreamID]ResponseWriter),
+	}
+}
+
 func (xm *muxMapStreamMapping) Clear() {
 	xm.mu.Lock()
 	xm.m = make(map[shared.StreamID]ResponseWriter)
@@ -123,6 +480,12 @@ type muxMapRequestToStreamMapping struct {
 	m  map[types.RequestID]shared.StreamID
 }
 
+func newMuxMapRequestToStreamMapping() *muxMapRequestToStreamMapping {
+	return &muxMapRequestToStreamMapping{
+		m: make(map[types.RequestID]shared.StreamID),
+	}
+}
+
 func (xm *muxMapRequestToStreamMapping) Clear() {
 	xm.mu.Lock()
 	xm.m = make(map[types.RequestID]shared.StreamID)
@@ -163,6 +526,12 @@ type muxMapRequestResponseMap struct {
 	m  map[types.RequestID]types.JSONRPCMessage
 }
 
+func newMuxMapRequestResponseMap() *muxMapRequestResponseMap {
+	return &muxMapRequestResponseMap{
+		m: make(map[types.RequestID]types.JSONRPCMessage),
+	}
+}
+
 func (xm *muxMapRequestResponseMap) Clear() {
 	xm.mu.Lock()
 	xm.m = make(map[types.RequestID]types.JSONRPCMessage)
@@ -186,3 +555,37 @@ func (xm *muxMapRequestResponseMap) Delete(key types.RequestID) {
 	delete(xm.m, key)
 	xm.mu.Unlock()
 }
+
+//muxMapEventStreams, set of the streams with events in the EventStore
+type muxMapEventStreams struct {
+	mu sync.RWMutex
+	m  map[shared.StreamID]struct{}
+}
+
+func newMuxMapEventStreams() *muxMapEventStreams {
+	return &muxMapEventStreams{
+		m: make(map[shared.StreamID]struct{}),
+	}
+}
+
+func (xm *muxMapEventStreams) Clear() {
+	xm.mu.Lock()
+	xm.m = make(map[shared.StreamID]struct{})
+	xm.mu.Unlock()
+}
+
+func (xm *muxMapEventStreams) GetAll() []shared.StreamID {
+	xm.mu.RLock()
+	streamIDs := make([]shared.StreamID, 0, len(xm.m))
+	for key := range xm.m {
+		streamIDs = append(streamIDs, key)
+	}
+	xm.mu.RUnlock()
+	return streamIDs
+}
+
+func (xm *muxMapEventStreams) Set(key shared.StreamID) {
+	xm.mu.Lock()
+	xm.m[key] = struct{}{}
+	xm.mu.Unlock()
+}
@@ -1,15 +1,21 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/victorvbello/gomcp/mcp/shared"
 	"github.com/victorvbello/gomcp/mcp/types"
 	"github.com/victorvbello/gomcp/mcp/utils"
+	logger "github.com/victorvbello/gomcp/mcp/utils/logger"
+	"github.com/victorvbello/gomcp/mcp/utils/metrics"
 )
 
 const (
@@ -56,23 +62,86 @@ type StreamableHTTPServerTransport struct {
 	enableJSONResponse           bool
 	standaloneSseStreamID        shared.StreamID
 	eventStore                   shared.EventStore
+	eventStoreTTL                time.Duration
+	eventStoreExpiryMu           sync.Mutex
+	lastEventStoreExpiry         time.Time
+	eventStreams                 *muxMapEventStreams
 	onSessionInitialized         func(sessionID string)
 	onSessionClosed              func(sessionID string)
 	allowedHosts                 map[string]struct{}
 	allowedOrigins               map[string]struct{}
 	enableDNSRebindingProtection bool
+	oauthMetadataHandler         *OAuthMetadataHandler
+	sseKeepAliveInterval         time.Duration
+	sseRetryInterval             time.Duration
+	onStreamClosed               func(sessionID string, streamID shared.StreamID, err error)
+	sseQueueSize                 int
+	sseOverflowPolicy            SSEOverflowPolicy
+	standaloneMu                 sync.Mutex
+	standaloneOpened             bool
+	pendingRequests              []pendingStandaloneRequest
+	pendingRequestsSize          int
+	pendingRequestTTL            time.Duration
+	compression                  *CompressionOptions
+	rateLimiter                  *RateLimiter
+	activeSessions               *metrics.GaugeVec
+	sseStreams                   *metrics.GaugeVec
+	//1 while the session is counted in activeSessions
+	sessionActive int32
+	//POST requests being handled, including the writing of their SSE streams
+	activePosts int32
+	//Error of invalid options, Start returns it and the requests are answered with an error
+	optionsErr error
 	//The session ID generated for this connection.
 	SessionID string
 }
 
 func NewStreamableHTTPServerTransport(opts StreamableHTTPServerTransportOptions) shared.Transport {
 	nst := &StreamableHTTPServerTransport{
-		sessionIDGenerator:   opts.SessionIDGenerator,
-		eventStore:           opts.EventStore,
-		onSessionInitialized: opts.OnSessionInitialized,
-		onSessionClosed:      opts.OnSessionClosed,
-		allowedHosts:         opts.AllowedHosts,
-		allowedOrigins:       opts.AllowedOrigins,
+		sessionIDGenerator:     opts.SessionIDGenerator,
+		streamMapping:          newMuxMapStreamMapping(),
+		requestToStreamMapping: newMuxMapRequestToStreamMapping(),
+		requestResponseMap:     newMuxMapRequestResponseMap(),
+		eventStore:             opts.EventStore,
+		eventStoreTTL:          opts.EventStoreTTL,
+		eventStreams:           newMuxMapEventStreams(),
+		//Unique per transport so the sessions sharing an EventStore do not mix their standalone events
+		standaloneSseStreamID: shared.StreamID(uuid.New().String()),
+		onSessionInitialized:  opts.OnSessionInitialized,
+		onSessionClosed:       opts.OnSessionClosed,
+		allowedHosts:          opts.AllowedHosts,
+		allowedOrigins:        opts.AllowedOrigins,
+		sseKeepAliveInterval:  opts.SSEKeepAliveInterval,
+		sseRetryInterval:      opts.SSERetryInterval,
+		onStreamClosed:        opts.OnStreamClosed,
+		sseQueueSize:          opts.SSEQueueSize,
+		pendingRequestsSize:   opts.PendingRequestsSize,
+		pendingRequestTTL:     opts.PendingRequestTTL,
+		compression:           opts.Compression,
+		rateLimiter:           opts.RateLimiter,
+	}
+	if opts.Metrics != nil {
+		nst.activeSessions = opts.Metrics.NewGaugeVec(shared.METRIC_ACTIVE_SESSIONS, "Initialized sessions not closed yet.")
+		nst.sseStreams = opts.Metrics.NewGaugeVec(shared.METRIC_SSE_STREAMS, "Open SSE streams by kind.", "kind")
+		shared.RegisterEventStoreMetrics(opts.Metrics, opts.EventStore)
+	}
+	if nst.pendingRequestsSize <= 0 {
+		nst.pendingRequestsSize = DEFAULT_PENDING_REQUESTS_SIZE
+	}
+	if nst.pendingRequestTTL <= 0 {
+		nst.pendingRequestTTL = DEFAULT_PENDING_REQUEST_TTL
+	}
+	if opts.SSEOverflowPolicy != nil {
+		nst.sseOverflowPolicy = *opts.SSEOverflowPolicy
+	}
+	if nst.sseQueueSize <= 0 {
+		nst.sseQueueSize = DEFAULT_SSE_QUEUE_SIZE
+	}
+	if nst.sseKeepAliveInterval == 0 {
+		nst.sseKeepAliveInterval = DEFAULT_SSE_KEEPALIVE_INTERVAL
+	}
+	if nst.sseRetryInterval == 0 {
+		nst.sseRetryInterval = DEFAULT_SSE_RETRY_INTERVAL
 	}
 	if opts.EnableJSONResponse != nil {
 		nst.enableJSONResponse = *opts.EnableJSONResponse
@@ -80,6 +149,20 @@ func NewStreamableHTTPServerTransport(opts StreamableHTTPServerTransportOptions)
 	if opts.EnableDNSRebindingProtection != nil {
 		nst.enableDNSRebindingProtection = *opts.EnableDNSRebindingProtection
 	}
+	if opts.Compression != nil && opts.Compression.Level != nil {
+		if err := validCompressionLevel(*opts.Compression.Level); err != nil {
+			nst.optionsErr = fmt.Errorf("CompressionOptions.Level %w", err)
+			logger.NewLoggerService().Error(nil, fmt.Sprintf("NewStreamableHTTPServerTransport %v", nst.optionsErr))
+		}
+	}
+	if opts.OAuthMetadata != nil {
+		metadataHandler, err := NewOAuthMetadataHandler(*opts.OAuthMetadata)
+		if err != nil {
+			nst.optionsErr = fmt.Errorf("NewOAuthMetadataHandler %w", err)
+			logger.NewLoggerService().Error(nil, fmt.Sprintf("NewStreamableHTTPServerTransport %v", nst.optionsErr))
+		}
+		nst.oauthMetadataHandler = metadataHandler
+	}
 	return nst
 }
 
@@ -88,7 +171,12 @@ func NewStreamableHTTPServerTransport(opts StreamableHTTPServerTransportOptions)
 //This method should only be called after callbacks are installed, or else messages may be lost.
 //
 //NOTE: This method should not be called explicitly when using Client, Server, or Protocol classes, as they will implicitly call start().
+//
+//Return an error if the options given to NewStreamableHTTPServerTransport are invalid.
 func (s *StreamableHTTPServerTransport) Start() error {
+	if s.optionsErr != nil {
+		return fmt.Errorf("invalid transport options %w", s.optionsErr)
+	}
 	if s.started {
 		return fmt.Errorf("transport already started")
 	}
@@ -217,6 +305,22 @@ func (s *StreamableHTTPServerTransport) validateProtocolVersion(res ResponseWrit
 
 //Handles an incoming HTTP request, whether GET or POST
 func (s *StreamableHTTPServerTransport) HandleRequest(res ResponseWriter, req *http.Request) {
+	//A misconfigured transport does not serve, e.g. without its OAuth metadata the clients could not discover the authorization server
+	if s.optionsErr != nil {
+		err := res.WriteJSON(http.StatusInternalServerError, types.JSONRPCError{
+			JSONRPC: types.JSONRPC_VERSION,
+			Error:   &types.Error{Code: types.ERROR_CODE_INTERNAL_ERROR, Message: "Internal Server Error: invalid transport options"},
+		})
+		if err != nil {
+			s.OnError(fmt.Errorf("res.WriteJSON %v", err))
+		}
+		s.OnError(fmt.Errorf("invalid transport options %v", s.optionsErr))
+		return
+	}
+	if s.compression != nil {
+		res.compression = newResponseCompression(s.compression, req)
+		res.Writer().Header().Add("Vary", "Accept-Encoding")
+	}
 	// Validate request headers for DNS rebinding protection
 	validationError := s.validateRequestHeaders(req)
 	if validationError != nil {
@@ -244,74 +348,334 @@ func (s *StreamableHTTPServerTransport) HandleRequest(res ResponseWriter, req *h
 	}
 }
 
-//Replays events that would have been sent after the specified event ID
+//Implements http.Handler so the transport can be mounted directly or wrapped by middlewares like RequireBearerAuth
+func (s *StreamableHTTPServerTransport) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.oauthMetadataHandler != nil && s.oauthMetadataHandler.Handles(req.URL.Path) {
+		s.oauthMetadataHandler.ServeHTTP(w, req)
+		return
+	}
+	s.HandleRequest(ResponseWriter{writer: w}, req)
+}
+
+//Return the OAuth discovery handler built from StreamableHTTPServerTransportOptions.OAuthMetadata, nil if is not configured.
+//
+//The discovery endpoints must be public, when the transport is wrapped by RequireBearerAuth mount this handler
+//on /.well-known/ outside the middleware and pass its ResourceMetadataURL to BearerAuthMiddlewareOptions.
+func (s *StreamableHTTPServerTransport) OAuthMetadataHandler() *OAuthMetadataHandler {
+	return s.oauthMetadataHandler
+}
+
+//Replays events that would have been sent after the specified event ID,
+//then keeps the stream open to deliver the new events until the client disconnects.
+//A request stream ends once the responses of its requests are delivered, only the standalone stream stays open.
 //Only used when resumability is enabled
-func (s *StreamableHTTPServerTransport) replayEvents(lastEventID shared.EventID, res ResponseWriter) {
+func (s *StreamableHTTPServerTransport) replayEvents(lastEventID shared.EventID, res ResponseWriter, req *http.Request) {
 	if s.eventStore == nil {
 		return
 	}
 
+	res = s.startSSEStream(res)
+	defer res.sse.end(res.Writer())
+	replayFailed := false
+	streamID, err := s.eventStore.ReplayEventsAfter(lastEventID, func(eventID shared.EventID, msg types.JSONRPCMessage) {
+		if replayFailed {
+			return
+		}
+		//Written directly, the queue is drained only once the stream is mapped
+		data, err := s.sseEventData(msg, eventID)
+		if err == nil {
+			err = res.sse.write(res.Writer(), data)
+		}
+		if err != nil {
+			s.OnError(fmt.Errorf("failed replay event %s", eventID))
+			replayFailed = true
+		}
+	})
+	if err != nil {
+		s.OnError(fmt.Errorf("s.eventStore.ReplayEventsAfter %v", err))
+		return
+	}
+	if replayFailed {
+		return
+	}
+
+	//New events of the stream are sent through this connection
+	if streamID == s.standaloneSseStreamID {
+		s.openStandaloneStream(res)
+	} else {
+		s.streamMapping.Set(streamID, res)
+		//The replay already delivered the responses of the stream, a response sent
+		//before the mapping was stored and the client gets it on its next reconnection
+		if !s.hasPendingRequests(streamID) {
+			s.streamMapping.Delete(streamID)
+			return
+		}
+	}
+	s.keepSSEStreamOpen(streamID, res, req)
+}
+
+//Return true if some request sent through the stream still waits for its response
+func (s *StreamableHTTPServerTransport) hasPendingRequests(streamID shared.StreamID) bool {
+	for _, sID := range s.requestToStreamMapping.GetAll() {
+		if sID == streamID {
+			return true
+		}
+	}
+	return false
+}
+
+//Sends the SSE headers and the retry field, return the writer with the stream state attached
+func (s *StreamableHTTPServerTransport) startSSEStream(res ResponseWriter) ResponseWriter {
+	res = s.writeSSEHeaders(res)
+
+	var preamble string
+	//Tells the client how long to wait before reconnecting when the stream is cut
+	if s.sseRetryInterval > 0 {
+		preamble = fmt.Sprintf("retry: %d\n\n", s.sseRetryInterval.Milliseconds())
+	}
+	if err := res.sse.write(res.Writer(), []byte(preamble)); err != nil {
+		s.OnError(fmt.Errorf("res.sse.write retry %v", err))
+	}
+	return res
+}
+
+//Sends the SSE headers and attaches a new stream state with its outbound queue
+func (s *StreamableHTTPServerTransport) writeSSEHeaders(res ResponseWriter) ResponseWriter {
 	headers := map[string]string{
 		"Content-Type":  "text/event-stream",
 		"Cache-Control": "no-cache, no-transform",
 		"Connection":    "keep-alive",
 	}
 
+	//After initialization, always include the session ID if we have one
 	if s.SessionID != "" {
 		headers["mcp-session-id"] = s.SessionID
 	}
 
+	//We need to send headers immediately as messages will arrive much later,
+	//otherwise the client will just wait for the first message
 	for k, v := range headers {
 		res.Writer().Header().Set(k, v)
 	}
+	//The compressor is created before the headers are sent, if it fails the stream is sent uncompressed
+	var compressor flushWriteCloser
+	if res.compression != nil {
+		var err error
+		compressor, err = res.compression.newWriter(res.Writer())
+		if err != nil {
+			s.OnError(fmt.Errorf("res.compression.newWriter %v", err))
+			res.compression = nil
+		}
+	}
+	if compressor != nil {
+		res.Writer().Header().Set("Content-Encoding", res.compression.encoding)
+		res.Writer().Header().Del("Content-Length")
+	}
 	//Sends headers to the client
 	res.Writer().WriteHeader(http.StatusOK)
+	res.sse = newSSEStream(s.sseQueueSize, s.sseOverflowPolicy)
+	res.sse.compressor = compressor
+	return res
+}
+
+//Blocks until the client disconnects, a write fails, the transport is closed or the responses of the stream are sent,
+//writing a comment every SSEKeepAliveInterval so idle connections are not cut by intermediaries.
+//Then removes the stream from streamMapping
+func (s *StreamableHTTPServerTransport) keepSSEStreamOpen(streamID shared.StreamID, res ResponseWriter, req *http.Request) {
+	kind := SSE_STREAM_KIND_REQUEST
+	if streamID == s.standaloneSseStreamID {
+		kind = SSE_STREAM_KIND_STANDALONE
+	}
+	s.sseStreams.Inc(kind)
+	defer s.sseStreams.Dec(kind)
+	var keepAlive <-chan time.Time
+	if s.sseKeepAliveInterval > 0 {
+		ticker := time.NewTicker(s.sseKeepAliveInterval)
+		defer ticker.Stop()
+		keepAlive = ticker.C
+	}
+	var streamErr error
+loop:
+	for {
+		select {
+		case <-req.Context().Done():
+			res.sse.close(nil)
+			break loop
+		case <-res.sse.done:
+			streamErr = res.sse.err
+			break loop
+		case <-res.sse.ready:
+			if err := res.sse.flush(res.Writer()); err != nil {
+				streamErr = err
+				break loop
+			}
+		case <-res.sse.finished:
+			streamErr = res.sse.flush(res.Writer())
+			break loop
+		case <-keepAlive:
+			//SSE comment, ignored by the clients
+			if err := res.sse.write(res.Writer(), []byte(": keepalive\n\n")); err != nil {
+				streamErr = err
+				break loop
+			}
+		}
+	}
+	if current, ok := s.streamMapping.Get(streamID); ok && current.writer == res.writer {
+		s.streamMapping.Delete(streamID)
+	}
+	if streamErr != nil {
+		s.OnError(fmt.Errorf("sse stream %s closed %v", streamID, streamErr))
+	}
+	if s.onStreamClosed != nil {
+		s.onStreamClosed(s.SessionID, streamID, streamErr)
+	}
+}
+
+//Writes an event to the SSE stream with proper formatting
+//
+//On the streams with outbound queue the event is queued and written by the goroutine serving the stream
+func (s *StreamableHTTPServerTransport) writeSSEEvent(res ResponseWriter, msg types.JSONRPCMessage, eventID shared.EventID) bool {
+	eventData, err := s.sseEventData(msg, eventID)
+	if err != nil {
+		s.OnError(fmt.Errorf("s.sseEventData %v", err))
+		return false
+	}
+
+	if res.sse != nil {
+		droppable := msg.JSONRPCMessageType() == types.JSONRPC_MESSAGE_JSONRPC_NOTIFICATION_TYPE
+		if err := res.sse.enqueue(eventData, droppable); err != nil {
+			s.OnError(fmt.Errorf("res.sse.enqueue %v", err))
+			return false
+		}
+		return true
+	}
+	if code, err := res.Writer().Write(eventData); err != nil {
+		s.OnError(fmt.Errorf("res.Writer().Write code:%d  %v", code, err))
+		return false
+	}
 	if f, ok := res.Writer().(http.Flusher); ok {
-		//Flushes the buffered data (including headers)
 		f.Flush()
 	}
+	return true
+}
+
+//Maps the standalone SSE stream and sends the requests queued while it was disconnected
+func (s *StreamableHTTPServerTransport) openStandaloneStream(res ResponseWriter) {
+	s.standaloneMu.Lock()
+	s.standaloneOpened = true
+	s.streamMapping.Set(s.standaloneSseStreamID, res)
+	expired := s.takeExpiredRequestsLocked(time.Now())
+	pending := s.pendingRequests
+	s.pendingRequests = nil
+	s.standaloneMu.Unlock()
+	if len(pending) == 0 && len(expired) == 0 {
+		return
+	}
+	//In background, the queue of the stream is drained by the caller once it starts waiting
+	go func() {
+		s.failPendingRequests(expired, PENDING_REQUEST_EXPIRED_MESSAGE)
+		for i, request := range pending {
+			var eventID shared.EventID
+			if s.eventStore != nil {
+				var err error
+				eventID, err = s.storeEvent(s.standaloneSseStreamID, request.message)
+				if err != nil {
+					s.OnError(fmt.Errorf("s.storeEvent %v", err))
+				}
+			}
+			if !s.writeSSEEvent(res, request.message, eventID) {
+				//The stream broke, the client will not answer this request nor the ones after it
+				s.failPendingRequests(pending[i:], PENDING_REQUEST_NOT_SENT_MESSAGE)
+				return
+			}
+		}
+	}()
+}
+
+//Removes and returns the pending requests older than the TTL, must be called with standaloneMu held
+func (s *StreamableHTTPServerTransport) takeExpiredRequestsLocked(now time.Time) []pendingStandaloneRequest {
+	limit := now.Add(-s.pendingRequestTTL)
+	start := 0
+	for start < len(s.pendingRequests) && !s.pendingRequests[start].queuedAt.After(limit) {
+		start++
+	}
+	if start == 0 {
+		return nil
+	}
+	expired := append([]pendingStandaloneRequest(nil), s.pendingRequests[:start]...)
+	s.pendingRequests = s.pendingRequests[start:]
+	return expired
+}
 
-	waitResponse := make(chan bool)
+//Fails the pending requests past the TTL, scheduled each time a request is queued
+func (s *StreamableHTTPServerTransport) expirePendingRequests() {
+	s.standaloneMu.Lock()
+	expired := s.takeExpiredRequestsLocked(time.Now())
+	s.standaloneMu.Unlock()
+	s.failPendingRequests(expired, PENDING_REQUEST_EXPIRED_MESSAGE)
+}
 
-	streamID, err := s.eventStore.ReplayEventsAfter(lastEventID, func(eventID shared.EventID, msg types.JSONRPCMessage) {
-		if !s.writeSSEEvent(res, msg, eventID) {
-			s.OnError(fmt.Errorf("failed replay events"))
-			waitResponse <- true
-			return
+//Delivers a JSON-RPC error for each request as if the client answered it,
+//so the caller fails at once instead of waiting for its timeout
+func (s *StreamableHTTPServerTransport) failPendingRequests(requests []pendingStandaloneRequest, message string) {
+	for _, request := range requests {
+		jsonrpcRequest, ok := request.message.(*types.JSONRPCRequest)
+		if !ok {
+			continue
 		}
-	})
+		s.OnMessage(&types.JSONRPCError{
+			JSONRPC: types.JSONRPC_VERSION,
+			ID:      jsonrpcRequest.ID,
+			Error:   &types.Error{Code: types.ERROR_CODE_CONNECTION_CLOSED, Message: message},
+		}, nil)
+	}
+}
 
-	if err != nil {
-		s.OnError(fmt.Errorf("s.eventStore.ReplayEventsAfter %v", err))
-		return
+//Queues a request while the standalone SSE stream is disconnected.
+//
+//Return false if the stream is connected, and an error if the client never opened the stream or the queue is full
+func (s *StreamableHTTPServerTransport) queueStandaloneRequest(msg types.JSONRPCMessage) (bool, error) {
+	s.standaloneMu.Lock()
+	defer s.standaloneMu.Unlock()
+	if _, ok := s.streamMapping.Get(s.standaloneSseStreamID); ok {
+		return false, nil
+	}
+	if !s.standaloneOpened {
+		return false, fmt.Errorf("%w: no standalone SSE stream, the client must open a GET stream to receive server requests", shared.ErrNoRequestChannel)
+	}
+	now := time.Now()
+	if expired := s.takeExpiredRequestsLocked(now); len(expired) > 0 {
+		//Without the lock, the callers can send new requests when they fail
+		go s.failPendingRequests(expired, PENDING_REQUEST_EXPIRED_MESSAGE)
 	}
-	<-waitResponse
-	s.streamMapping.Set(streamID, res)
+	if len(s.pendingRequests) >= s.pendingRequestsSize {
+		return false, fmt.Errorf("standalone SSE stream disconnected and %d requests already pending", len(s.pendingRequests))
+	}
+	s.pendingRequests = append(s.pendingRequests, pendingStandaloneRequest{message: msg, queuedAt: now})
+	time.AfterFunc(s.pendingRequestTTL, s.expirePendingRequests)
+	return true, nil
 }
 
-//Writes an event to the SSE stream with proper formatting
-func (s *StreamableHTTPServerTransport) writeSSEEvent(res ResponseWriter, msg types.JSONRPCMessage, eventID shared.EventID) bool {
+//Formats the message as a SSE event
+func (s *StreamableHTTPServerTransport) sseEventData(msg types.JSONRPCMessage, eventID shared.EventID) ([]byte, error) {
 	eventData := "event: message\n"
 	//Include event ID if provided - this is important for resumability
 	if eventID != "" {
 		eventData += fmt.Sprintf("id: %s\n", eventID)
 	}
-	eventData += fmt.Sprintf("data: %v\n\n", msg)
-
-	res.Writer().Write([]byte(eventData))
-	if code, err := res.Writer().Write([]byte(eventData)); err != nil {
-		s.OnError(fmt.Errorf("res.Writer().Write code:%d  %v", code, err))
-		return false
+	data, err := types.JSONRPCMessageMarshalJSON(msg)
+	if err != nil {
+		return nil, fmt.Errorf("types.JSONRPCMessageMarshalJSON %v", err)
 	}
-
-	return true
+	eventData += fmt.Sprintf("data: %s\n\n", data)
+	return []byte(eventData), nil
 }
 
 //Handles GET requests for SSE stream
 func (s *StreamableHTTPServerTransport) handleGetRequest(res ResponseWriter, req *http.Request) {
 	//The client MUST include an Accept header, listing text/event-stream as a supported content type.
 	acceptHeader := req.Header.Get("Accept")
-	if !strings.Contains(acceptHeader, "text/event-stream") {
+	if !acceptsMediaType(acceptHeader, CONTENT_TYPE_EVENT_STREAM) {
 		err := res.WriteJSON(http.StatusNotAcceptable, types.JSONRPCError{
 			JSONRPC: types.JSONRPC_VERSION,
 			Error: &types.Error{
@@ -337,7 +701,7 @@ func (s *StreamableHTTPServerTransport) handleGetRequest(res ResponseWriter, req
 	if s.eventStore != nil {
 		lastEventID := shared.EventID(req.Header.Get(shared.TRANSPORT_HEADER_LAST_EVENT_ID))
 		if lastEventID != "" {
-			s.replayEvents(lastEventID, res)
+			s.replayEvents(lastEventID, res, req)
 			return
 		}
 	}
@@ -360,44 +724,30 @@ func (s *StreamableHTTPServerTransport) handleGetRequest(res ResponseWriter, req
 
 	//The server MUST either return Content-Type: text/event-stream in response to this HTTP GET,
 	//or else return HTTP 405 Method Not Allowed
-	headers := map[string]string{
-		"Content-Type":  "text/event-stream",
-		"Cache-Control": "no-cache, no-transform",
-		"Connection":    "keep-alive",
-	}
-
-	//After initialization, always include the session ID if we have one
-	if s.SessionID != "" {
-		headers["mcp-session-id"] = s.SessionID
-	}
-
-	//We need to send headers immediately as messages will arrive much later,
-	//otherwise the client will just wait for the first message
-	for k, v := range headers {
-		res.Writer().Header().Set(k, v)
-	}
-	//Sends headers to the client
-	res.Writer().WriteHeader(http.StatusOK)
-	if f, ok := res.Writer().(http.Flusher); ok {
-		//Flushes the buffered data (including headers)
-		f.Flush()
-	}
+	res = s.startSSEStream(res)
+	defer res.sse.end(res.Writer())
 
 	//Assign the response to the standalone SSE stream
-	s.streamMapping.Set(s.standaloneSseStreamID, res)
-	//Set up close handler for client disconnects
-	go func() {
-		<-req.Context().Done()
-		s.streamMapping.Delete(s.standaloneSseStreamID)
-	}()
+	s.openStandaloneStream(res)
+	//Keep the stream open until the client disconnects
+	s.keepSSEStreamOpen(s.standaloneSseStreamID, res, req)
 }
 
 //Handles POST requests containing JSON-RPC messages
 func (s *StreamableHTTPServerTransport) handlePostRequest(res ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&s.activePosts, 1)
+	defer atomic.AddInt32(&s.activePosts, -1)
 	//Validate the Accept header
 	acceptHeader := req.Header.Get("Accept")
 	//The client MUST include an Accept header, listing both application/json and text/event-stream as supported content types.
-	if !strings.Contains(acceptHeader, "application/json") || !strings.Contains(acceptHeader, "text/event-stream") {
+	//A client accepting neither, or not the one used by the transport for the responses, can not be answered
+	acceptsJSON := acceptsMediaType(acceptHeader, CONTENT_TYPE_JSON)
+	acceptsEventStream := acceptsMediaType(acceptHeader, CONTENT_TYPE_EVENT_STREAM)
+	responseAccepted := acceptsEventStream
+	if s.enableJSONResponse {
+		responseAccepted = acceptsJSON
+	}
+	if (!acceptsJSON && !acceptsEventStream) || !responseAccepted {
 		err := res.WriteJSON(http.StatusNotAcceptable, types.JSONRPCError{
 			JSONRPC: types.JSONRPC_VERSION,
 			Error: &types.Error{
@@ -433,7 +783,7 @@ func (s *StreamableHTTPServerTransport) handlePostRequest(res ResponseWriter, re
 	var messages []types.RawMessage
 
 	req.Body = http.MaxBytesReader(res.Writer(), req.Body, MAXIMUM_MESSAGE_SIZE)
-	if err := json.NewDecoder(req.Body).Decode(&messages); err != nil {
+	if err := decodePostMessages(req, &messages); err != nil {
 		err := res.WriteJSON(http.StatusBadRequest, types.JSONRPCError{
 			JSONRPC: types.JSONRPC_VERSION,
 			Error: &types.Error{
@@ -465,7 +815,7 @@ func (s *StreamableHTTPServerTransport) handlePostRequest(res ResponseWriter, re
 			}
 			return
 		}
-		if len(messages) > 0 {
+		if len(messages) > 1 {
 			err := res.WriteJSON(http.StatusBadRequest, types.JSONRPCError{
 				JSONRPC: types.JSONRPC_VERSION,
 				Error: &types.Error{
@@ -480,6 +830,9 @@ func (s *StreamableHTTPServerTransport) handlePostRequest(res ResponseWriter, re
 		}
 		s.SessionID = s.sessionIDGenerator()
 		s.initialized = true
+		if s.SessionID != "" && atomic.CompareAndSwapInt32(&s.sessionActive, 0, 1) {
+			s.activeSessions.Inc()
+		}
 
 		//If we have a session ID and an onSessionInitialized handler, call it immediately
 		//This is needed in cases where the server needs to keep track of multiple sessions
@@ -505,6 +858,27 @@ func (s *StreamableHTTPServerTransport) handlePostRequest(res ResponseWriter, re
 	//check if it contains requests
 	isJSONRPCRequest := types.MessagesHasSomeJSONRPCRequest(messages)
 
+	//The session already used its budget, its requests would be rejected one by one by the server.
+	//The pings are let through so the keepalive of the client does not fail
+	if retryAfter := s.rateLimiter.SessionRetryAfter(s.SessionID); retryAfter > 0 && types.MessagesHasSomeNonPingRequest(messages) {
+		res.Writer().Header().Set("Retry-After", retryAfterHeader(retryAfter))
+		err := res.WriteJSON(http.StatusTooManyRequests, types.JSONRPCError{
+			JSONRPC: types.JSONRPC_VERSION,
+			Error: &types.Error{
+				Code:    types.ERROR_CODE_RATE_LIMITED,
+				Message: "Too Many Requests: session rate limit exceeded",
+				Data: map[string]interface{}{
+					"scope":        RATE_LIMIT_SCOPE_SESSION,
+					"retryAfterMs": retryAfter.Milliseconds(),
+				},
+			},
+		})
+		if err != nil {
+			s.OnError(fmt.Errorf("res.WriteJSON Too Many Requests: session rate limit exceeded %v", err))
+		}
+		return
+	}
+
 	parseError := func(gError error) {
 		err := res.WriteJSON(http.StatusBadRequest, types.JSONRPCError{
 			JSONRPC: types.JSONRPC_VERSION,
@@ -530,7 +904,7 @@ func (s *StreamableHTTPServerTransport) handlePostRequest(res ResponseWriter, re
 				parseError(fmt.Errorf("utils.MessageToJSONRPCMessage %v", err))
 				return
 			}
-			s.OnMessage(m, &shared.MessageExtraInfo{AuthInfo: &authInfo, RequestInfo: &requestInfo})
+			s.OnMessage(m, &shared.MessageExtraInfo{AuthInfo: authInfo, RequestInfo: &requestInfo, Context: req.Context()})
 		}
 		return
 	} else {
@@ -538,21 +912,26 @@ func (s *StreamableHTTPServerTransport) handlePostRequest(res ResponseWriter, re
 		//but in some cases server will return JSON responses
 		streamID := shared.StreamID(uuid.New().String())
 		if s.enableJSONResponse {
-			headers := map[string]string{
-				"Content-Type":  "text/event-stream",
-				"Cache-Control": "no-cache",
-				"Connection":    "keep-alive",
-			}
 			//After initialization, always include the session ID if we have one
 			if s.SessionID != "" {
-				headers["mcp-session-id"] = s.SessionID
-			}
-
-			for k, v := range headers {
-				res.Writer().Header().Set(k, v)
+				res.Writer().Header().Set("mcp-session-id", s.SessionID)
 			}
-			//Sends headers to the client
-			res.Writer().WriteHeader(http.StatusOK)
+		} else {
+			//The events are written by a goroutine so a slow client does not stall the handlers,
+			//the request ends when all the queued events are written
+			res = s.writeSSEHeaders(res)
+			s.sseStreams.Inc(SSE_STREAM_KIND_REQUEST)
+			defer s.sseStreams.Dec(SSE_STREAM_KIND_REQUEST)
+			writerDone := make(chan struct{})
+			go func(sse *sseStream, w http.ResponseWriter) {
+				defer close(writerDone)
+				sse.drain(w)
+			}(res.sse, res.Writer())
+			defer func(sse *sseStream, w http.ResponseWriter) {
+				sse.finish()
+				<-writerDone
+				sse.end(w)
+			}(res.sse, res.Writer())
 		}
 		//Store the response for this request to send messages back through this connection
 		//We need to track by request ID to maintain the connection
@@ -570,10 +949,13 @@ func (s *StreamableHTTPServerTransport) handlePostRequest(res ResponseWriter, re
 		}
 
 		//Set up close handler for client disconnects
-		go func() {
+		go func(sse *sseStream) {
 			<-req.Context().Done()
 			s.streamMapping.Delete(streamID)
-		}()
+			if sse != nil {
+				sse.close(nil)
+			}
+		}(res.sse)
 
 		//handle each message
 		for _, msg := range messages {
@@ -582,7 +964,7 @@ func (s *StreamableHTTPServerTransport) handlePostRequest(res ResponseWriter, re
 				parseError(fmt.Errorf("msg.ToJSONRPCMessage %v", err))
 				return
 			}
-			s.OnMessage(jsonrpc, &shared.MessageExtraInfo{AuthInfo: &authInfo, RequestInfo: &requestInfo})
+			s.OnMessage(jsonrpc, &shared.MessageExtraInfo{AuthInfo: authInfo, RequestInfo: &requestInfo, Context: req.Context()})
 		}
 		//The server SHOULD NOT close the SSE stream before sending all JSON-RPC responses
 		//This will be handled by the send() method when responses are ready
@@ -590,6 +972,23 @@ func (s *StreamableHTTPServerTransport) handlePostRequest(res ResponseWriter, re
 
 }
 
+//Decodes the body, a single JSON-RPC message or a batch
+func decodePostMessages(req *http.Request, messages *[]types.RawMessage) error {
+	var body json.RawMessage
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return err
+	}
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		return json.Unmarshal(body, messages)
+	}
+	var msg types.RawMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return err
+	}
+	*messages = []types.RawMessage{msg}
+	return nil
+}
+
 //Handles DELETE requests to terminate sessions
 func (s *StreamableHTTPServerTransport) handleDeleteRequest(res ResponseWriter, req *http.Request) {
 	if !s.validateSession(res, req) {
@@ -598,7 +997,13 @@ func (s *StreamableHTTPServerTransport) handleDeleteRequest(res ResponseWriter,
 	if !s.validateProtocolVersion(res, req) {
 		return
 	}
-	s.onSessionClosed(s.SessionID)
+	if s.onSessionClosed != nil {
+		s.onSessionClosed(s.SessionID)
+	}
+	s.rateLimiter.ForgetSession(s.SessionID)
+	//The client ended the session, its events can not be replayed anymore.
+	//Close alone keeps them, e.g. on a Shutdown the clients can resume with a new process sharing the store
+	s.purgeEventStreams()
 	err := s.Close()
 	if err != nil {
 		err := res.WriteJSON(http.StatusInternalServerError, types.JSONRPCError{
@@ -635,13 +1040,14 @@ func (s *StreamableHTTPServerTransport) handleUnsupportedRequest(res ResponseWri
 //
 //If present, `relatedRequestId` is used to indicate to the transport which incoming request to associate this outgoing message with.
 func (s *StreamableHTTPServerTransport) Send(msg types.JSONRPCMessage, opts *shared.TransportSendOptions) (*types.JSONRPCResponse, error) {
-	requestID := opts.RelatedRequestID
+	var requestID types.RequestID
+	if opts != nil {
+		requestID = opts.RelatedRequestID
+	}
 	_, isJSONRPCResponse := msg.(*types.JSONRPCResponse)
 	_, isJSONRPCError := msg.(*types.JSONRPCError)
-	switch msgRes := msg.(type) {
-	case *types.JSONRPCResponse:
-	case *types.JSONRPCError:
-		//If the message is a response, use the request ID from the message
+	//If the message is a response, use the request ID from the message
+	if msgRes, ok := msg.(types.JSONRPCGeneralResponse); ok {
 		requestID = msgRes.GetRequestID()
 	}
 
@@ -653,21 +1059,32 @@ func (s *StreamableHTTPServerTransport) Send(msg types.JSONRPCMessage, opts *sha
 		if isJSONRPCResponse || isJSONRPCError {
 			return nil, fmt.Errorf("cannot send a response on a standalone SSE stream unless resuming a previous client request")
 		}
-		standaloneSSEResp, okStandaloneSSE := s.streamMapping.Get(s.standaloneSseStreamID)
-		if !okStandaloneSSE {
-			//The spec says the server MAY send messages on the stream, so it's ok to discard if no stream
-			return nil, nil
+		//Requests wait for the standalone stream, otherwise the caller would wait for a response until the timeout
+		if msg.JSONRPCMessageType() == types.JSONRPC_MESSAGE_JSONRPC_REQUEST_TYPE {
+			queued, err := s.queueStandaloneRequest(msg)
+			if err != nil {
+				return nil, fmt.Errorf("s.queueStandaloneRequest %w", err)
+			}
+			if queued {
+				return nil, nil
+			}
 		}
-		//Generate and store event ID if event store is provided
+		//Generate and store event ID if event store is provided,
+		//the event is stored even without a connected stream so a reconnecting client can replay it
 		var eventID shared.EventID
 		var err error
 		if s.eventStore != nil {
 			//Stores the event and gets the generated event ID
-			eventID, err = s.eventStore.StoreEvent(s.standaloneSseStreamID, msg)
+			eventID, err = s.storeEvent(s.standaloneSseStreamID, msg)
 			if err != nil {
-				return nil, fmt.Errorf("s.eventStore.StoreEvent %v", err)
+				return nil, fmt.Errorf("s.storeEvent %v", err)
 			}
 		}
+		standaloneSSEResp, okStandaloneSSE := s.streamMapping.Get(s.standaloneSseStreamID)
+		if !okStandaloneSSE {
+			//The spec says the server MAY send messages on the stream, so it's ok to discard if no stream
+			return nil, nil
+		}
 		//Send the message to the standalone SSE stream
 		if !s.writeSSEEvent(standaloneSSEResp, msg, eventID) {
 			return nil, fmt.Errorf("s.writeSSEEvent return false eventID: %v", eventID)
@@ -682,7 +1099,18 @@ func (s *StreamableHTTPServerTransport) Send(msg types.JSONRPCMessage, opts *sha
 	}
 	responseW, okResponseW := s.streamMapping.Get(streamID)
 	if !okResponseW {
-		return nil, fmt.Errorf("response writer not found for streamID: %v", streamID)
+		if s.eventStore == nil || s.enableJSONResponse {
+			return nil, fmt.Errorf("response writer not found for streamID: %v", streamID)
+		}
+		//The client disconnected, the event is replayed when it reconnects with the Last-Event-ID header
+		if _, err := s.storeEvent(streamID, msg); err != nil {
+			return nil, fmt.Errorf("s.storeEvent %v", err)
+		}
+		if isJSONRPCResponse || isJSONRPCError {
+			s.requestToStreamMapping.Delete(requestID)
+			s.requestResponseMap.Delete(requestID)
+		}
+		return nil, nil
 	}
 
 	if !s.enableJSONResponse {
@@ -691,9 +1119,9 @@ func (s *StreamableHTTPServerTransport) Send(msg types.JSONRPCMessage, opts *sha
 		var err error
 		if s.eventStore != nil {
 			//Stores the event and gets the generated event ID
-			eventID, err = s.eventStore.StoreEvent(streamID, msg)
+			eventID, err = s.storeEvent(streamID, msg)
 			if err != nil {
-				return nil, fmt.Errorf("s.eventStore.StoreEvent %v", err)
+				return nil, fmt.Errorf("s.storeEvent %v", err)
 			}
 		}
 		//Write the event to the response stream
@@ -702,63 +1130,174 @@ func (s *StreamableHTTPServerTransport) Send(msg types.JSONRPCMessage, opts *sha
 		}
 	}
 	if isJSONRPCResponse || isJSONRPCError {
-		var allRequestID []types.RequestID
-		var countRequest int
 		s.requestResponseMap.Set(requestID, msg)
-		allRequestToStream := s.requestToStreamMapping.GetAll()
-		for rID, sID := range allRequestToStream {
-			if sID != streamID {
-				continue
-			}
-			allRequestID = append(allRequestID, rID)
-			_, okRes := s.requestResponseMap.Get(rID)
-			if !okRes {
-				continue
-			}
-			countRequest++
+		s.sendReadyResponses(streamID, responseW)
+	}
+	return nil, nil
+}
+
+//Frees the stream mapping of a request that ends without a response (e.g. cancelled),
+//the responses of the other requests of the same POST are sent if they were only waiting for it
+func (s *StreamableHTTPServerTransport) ReleaseRequest(requestID types.RequestID) {
+	streamID, okStream := s.requestToStreamMapping.Get(requestID)
+	if !okStream {
+		return
+	}
+	s.requestToStreamMapping.Delete(requestID)
+	s.requestResponseMap.Delete(requestID)
+	responseW, okResponseW := s.streamMapping.Get(streamID)
+	if !okResponseW {
+		return
+	}
+	s.sendReadyResponses(streamID, responseW)
+}
+
+//Sends the responses of a stream once all its requests have one, then forgets the requests
+func (s *StreamableHTTPServerTransport) sendReadyResponses(streamID shared.StreamID, responseW ResponseWriter) {
+	var allRequestID []types.RequestID
+	var countRequest int
+	allRequestToStream := s.requestToStreamMapping.GetAll()
+	for rID, sID := range allRequestToStream {
+		if sID != streamID {
+			continue
+		}
+		allRequestID = append(allRequestID, rID)
+		_, okRes := s.requestResponseMap.Get(rID)
+		if !okRes {
+			continue
 		}
+		countRequest++
+	}
 
-		allResponsesReady := len(allRequestID) == countRequest
-		if !allResponsesReady {
-			return nil, nil
+	allResponsesReady := len(allRequestID) == countRequest
+	if !allResponsesReady || len(allRequestID) == 0 {
+		return
+	}
+	if s.enableJSONResponse {
+		//All responses ready, send as JSON
+		headers := map[string]string{
+			"Content-Type": "text/event-stream",
+		}
+		if s.SessionID != "" {
+			headers["mcp-session-id"] = s.SessionID
 		}
-		if s.enableJSONResponse {
-			//All responses ready, send as JSON
-			headers := map[string]string{
-				"Content-Type": "text/event-stream",
-			}
-			if s.SessionID != "" {
-				headers["mcp-session-id"] = s.SessionID
-			}
 
-			var respMsg []types.JSONRPCMessage
-			for _, reqID := range allRequestID {
-				res, ok := s.requestResponseMap.Get(reqID)
-				if !ok {
-					continue
-				}
-				respMsg = append(respMsg, res)
+		var respMsg []types.JSONRPCMessage
+		for _, reqID := range allRequestID {
+			res, ok := s.requestResponseMap.Get(reqID)
+			if !ok {
+				continue
 			}
+			respMsg = append(respMsg, res)
+		}
 
-			if len(respMsg) == 1 {
-				responseW.WriteJSON(http.StatusOK, respMsg[0])
-			} else {
-				responseW.WriteJSON(http.StatusOK, respMsg)
-			}
+		if len(respMsg) == 1 {
+			responseW.WriteJSON(http.StatusOK, respMsg[0])
 		} else {
-			//End the SSE stream
-			if f, ok := responseW.Writer().(http.Flusher); ok {
-				//Flushes the buffered data (including headers)
-				f.Flush()
-			}
+			responseW.WriteJSON(http.StatusOK, respMsg)
 		}
-		//Clean up
-		for _, rID := range allRequestID {
-			s.requestResponseMap.Delete(rID)
-			s.requestToStreamMapping.Delete(rID)
+	} else if responseW.sse == nil {
+		//End the SSE stream, the queued streams are flushed by their writer when the request ends
+		if f, ok := responseW.Writer().(http.Flusher); ok {
+			//Flushes the buffered data (including headers)
+			f.Flush()
 		}
+	} else {
+		//No more events for the stream, its writer flushes the queue and ends it
+		responseW.sse.finish()
 	}
-	return nil, nil
+	//Clean up
+	for _, rID := range allRequestID {
+		s.requestResponseMap.Delete(rID)
+		s.requestToStreamMapping.Delete(rID)
+	}
+}
+
+//Stores the event and keeps track of its stream to purge it when the client ends the session
+func (s *StreamableHTTPServerTransport) storeEvent(streamID shared.StreamID, msg types.JSONRPCMessage) (shared.EventID, error) {
+	eventID, err := s.eventStore.StoreEvent(streamID, msg)
+	if err != nil {
+		return "", err
+	}
+	s.eventStreams.Set(streamID)
+	s.expireEventStore()
+	return eventID, nil
+}
+
+//Expires the events older than EventStoreTTL, at most once per DEFAULT_EVENT_STORE_EXPIRY_INTERVAL
+func (s *StreamableHTTPServerTransport) expireEventStore() {
+	if s.eventStoreTTL <= 0 {
+		return
+	}
+	cleaner, ok := s.eventStore.(shared.EventStoreCleaner)
+	if !ok {
+		return
+	}
+	interval := DEFAULT_EVENT_STORE_EXPIRY_INTERVAL
+	if s.eventStoreTTL < interval {
+		interval = s.eventStoreTTL
+	}
+	now := time.Now()
+	s.eventStoreExpiryMu.Lock()
+	if now.Sub(s.lastEventStoreExpiry) < interval {
+		s.eventStoreExpiryMu.Unlock()
+		return
+	}
+	s.lastEventStoreExpiry = now
+	s.eventStoreExpiryMu.Unlock()
+	if err := cleaner.ExpireEvents(now.Add(-s.eventStoreTTL)); err != nil {
+		s.OnError(fmt.Errorf("cleaner.ExpireEvents %v", err))
+	}
+}
+
+//Deletes from the EventStore all the streams of the session
+func (s *StreamableHTTPServerTransport) purgeEventStreams() {
+	if s.eventStore == nil {
+		return
+	}
+	if _, err := shared.PurgeEventStreams(s.eventStore, s.eventStreams.GetAll()); err != nil {
+		s.OnError(fmt.Errorf("shared.PurgeEventStreams %v", err))
+	}
+	s.eventStreams.Clear()
+}
+
+//Return the outbound queue metrics of the open SSE streams
+func (s *StreamableHTTPServerTransport) SSEStreamStats() []SSEStreamStats {
+	var stats []SSEStreamStats
+	for streamID, res := range s.streamMapping.GetAll() {
+		if res.sse == nil {
+			continue
+		}
+		stats = append(stats, res.sse.stats(streamID))
+	}
+	return stats
+}
+
+//Waits until the POST requests being handled are answered and the events queued on the SSE streams are written,
+//return the error of ctx if it is done first
+func (s *StreamableHTTPServerTransport) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(DRAIN_POLL_INTERVAL)
+	defer ticker.Stop()
+	for !s.drained() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (s *StreamableHTTPServerTransport) drained() bool {
+	if atomic.LoadInt32(&s.activePosts) > 0 {
+		return false
+	}
+	for _, stats := range s.SSEStreamStats() {
+		if stats.QueueDepth > 0 {
+			return false
+		}
+	}
+	return true
 }
 
 //Closes the connection.
@@ -766,6 +1305,11 @@ func (s *StreamableHTTPServerTransport) Close() error {
 	//Close all SSE connections
 	sm := s.streamMapping.GetAll()
 	for _, r := range sm {
+		if r.sse != nil {
+			//Ends the GET streams waiting in keepSSEStreamOpen
+			r.sse.close(nil)
+			continue
+		}
 		if f, ok := r.Writer().(http.Flusher); ok {
 			//Flushes the buffered data (including headers)
 			f.Flush()
@@ -773,6 +1317,12 @@ func (s *StreamableHTTPServerTransport) Close() error {
 	}
 	//Clear any pending responses
 	s.streamMapping.Clear()
+	s.standaloneMu.Lock()
+	s.pendingRequests = nil
+	s.standaloneMu.Unlock()
+	if atomic.CompareAndSwapInt32(&s.sessionActive, 1, 0) {
+		s.activeSessions.Dec()
+	}
 
 	err := s.OnClose()
 	if err != nil {
@@ -0,0 +1,646 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/shared"
+	"github.com/victorvbello/gomcp/mcp/types"
+	"github.com/victorvbello/gomcp/mcp/utils/metrics"
+)
+
+func TestCloseKeepsStoredEvents(t *testing.T) {
+	store := shared.NewMemoryEventStore(shared.MemoryEventStoreOptions{})
+	s := NewStreamableHTTPServerTransport(StreamableHTTPServerTransportOptions{EventStore: store}).(*StreamableHTTPServerTransport)
+	notification := &types.JSONRPCNotification{
+		JSONRPC:               types.JSONRPC_VERSION,
+		NotificationInterface: types.NewToolListChangedNotification(nil),
+	}
+	if _, err := s.storeEvent("stream", notification); err != nil {
+		t.Fatalf("storeEvent: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if stats := store.EventStoreStats(); stats.Events != 1 {
+		t.Fatalf("expected the event to be kept by Close, got %+v", stats)
+	}
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/mcp", nil))
+	if stats := store.EventStoreStats(); stats.Events != 0 {
+		t.Fatalf("expected the event to be purged by DELETE, got %+v", stats)
+	}
+}
+
+//Collects the errors delivered to the callers of the pending requests
+func collectPendingErrors(s *StreamableHTTPServerTransport) chan *types.JSONRPCError {
+	errs := make(chan *types.JSONRPCError, 8)
+	s.SetGlobalOnMessage(func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo) {
+		if jsonrpcErr, ok := message.(*types.JSONRPCError); ok {
+			errs <- jsonrpcErr
+		}
+	})
+	return errs
+}
+
+func expectPendingError(t *testing.T, errs chan *types.JSONRPCError, id types.RequestID) {
+	t.Helper()
+	select {
+	case jsonrpcErr := <-errs:
+		if jsonrpcErr.ID != id || jsonrpcErr.Error.GetErrorCode() != types.ERROR_CODE_CONNECTION_CLOSED {
+			t.Fatalf("unexpected error for request %v: %+v", id, jsonrpcErr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("the caller of request %v was not failed", id)
+	}
+}
+
+func standaloneRequest(id types.RequestID) *types.JSONRPCRequest {
+	return &types.JSONRPCRequest{JSONRPC: types.JSONRPC_VERSION, ID: id, RequestInterface: types.NewListRootsRequest(nil)}
+}
+
+func TestExpiredPendingRequestFailsCaller(t *testing.T) {
+	s := NewStreamableHTTPServerTransport(StreamableHTTPServerTransportOptions{
+		PendingRequestTTL: 20 * time.Millisecond,
+	}).(*StreamableHTTPServerTransport)
+	errs := collectPendingErrors(s)
+	s.standaloneOpened = true
+
+	if _, err := s.Send(standaloneRequest(1), nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	expectPendingError(t, errs, 1)
+}
+
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingResponseWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestUnsentPendingRequestsFailCallers(t *testing.T) {
+	s := NewStreamableHTTPServerTransport(StreamableHTTPServerTransportOptions{}).(*StreamableHTTPServerTransport)
+	errs := collectPendingErrors(s)
+	s.standaloneOpened = true
+	for _, id := range []types.RequestID{1, 2} {
+		if _, err := s.Send(standaloneRequest(id), nil); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	s.openStandaloneStream(ResponseWriter{writer: failingResponseWriter{httptest.NewRecorder()}})
+	expectPendingError(t, errs, 1)
+	expectPendingError(t, errs, 2)
+}
+
+type testSSEEvent struct {
+	id   string
+	data string
+}
+
+//Reads the events of a SSE response in background, the channel is closed when the stream ends
+func readSSEEvents(body io.Reader) chan testSSEEvent {
+	events := make(chan testSSEEvent, 16)
+	go func() {
+		defer close(events)
+		reader := bufio.NewReader(body)
+		var event testSSEEvent
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				event.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			case line == "" && event.data != "":
+				events <- event
+				event = testSSEEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func nextSSEEvent(t *testing.T, events chan testSSEEvent, contains string) testSSEEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatalf("stream ended waiting for %s", contains)
+		}
+		if !strings.Contains(event.data, contains) {
+			t.Fatalf("expected an event with %s, got %s", contains, event.data)
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no event with %s", contains)
+	}
+	return testSSEEvent{}
+}
+
+func progressNotification(progress int) *types.JSONRPCNotification {
+	params := &types.ProgressNotificationParams{ProgressToken: "token"}
+	params.Progress.Progress = progress
+	return &types.JSONRPCNotification{
+		JSONRPC:               types.JSONRPC_VERSION,
+		NotificationInterface: types.NewProgressNotification(params),
+	}
+}
+
+//Resumable transport served by httptest, the requests it receives are handled until the test ends
+func newResumableTestServer(t *testing.T) (*StreamableHTTPServerTransport, *httptest.Server, chan types.RequestID) {
+	t.Helper()
+	s := NewStreamableHTTPServerTransport(StreamableHTTPServerTransportOptions{
+		EventStore: shared.NewMemoryEventStore(shared.MemoryEventStoreOptions{}),
+	}).(*StreamableHTTPServerTransport)
+	requests := make(chan types.RequestID, 1)
+	handlerDone := make(chan struct{})
+	//The request is handled while OnMessage runs, as the protocol does for the HTTP requests
+	s.SetGlobalOnMessage(func(message types.JSONRPCMessage, extra *shared.MessageExtraInfo) {
+		if request, ok := message.(*types.JSONRPCRequest); ok {
+			requests <- request.ID
+			<-handlerDone
+		}
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(handlerDone) })
+	return s, srv, requests
+}
+
+//Posts a request, receives its first progress event and cuts the stream,
+//return the request ID and the ID of the received event
+func cutRequestStream(t *testing.T, s *StreamableHTTPServerTransport, srv *httptest.Server, requests chan types.RequestID) (types.RequestID, string) {
+	t.Helper()
+	ctx, cut := context.WithCancel(context.Background())
+	defer cut()
+	body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"slow"}}`
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, strings.NewReader(body))
+	req.Header.Set("Content-Type", CONTENT_TYPE_JSON)
+	req.Header.Set("Accept", CONTENT_TYPE_JSON+", "+CONTENT_TYPE_EVENT_STREAM)
+	//The headers are flushed with the first event
+	responses := make(chan *http.Response, 1)
+	go func() {
+		res, err := srv.Client().Do(req)
+		if err != nil {
+			t.Errorf("POST: %v", err)
+			close(responses)
+			return
+		}
+		responses <- res
+	}()
+	requestID := <-requests
+
+	if _, err := s.Send(progressNotification(1), &shared.TransportSendOptions{RelatedRequestID: requestID}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	res, ok := <-responses
+	if !ok {
+		t.FailNow()
+	}
+	first := nextSSEEvent(t, readSSEEvents(res.Body), `"progress":1`)
+	if first.id == "" {
+		t.Fatal("expected the event to have an id")
+	}
+
+	//The stream is cut, the events sent until the client reconnects are kept by the store
+	streamID, _ := s.requestToStreamMapping.Get(requestID)
+	cut()
+	res.Body.Close()
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if _, ok := s.streamMapping.Get(streamID); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the server did not notice the disconnection")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return requestID, first.id
+}
+
+func resumeSSEStream(t *testing.T, srv *httptest.Server, lastEventID string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept", CONTENT_TYPE_EVENT_STREAM)
+	req.Header.Set(shared.TRANSPORT_HEADER_LAST_EVENT_ID, lastEventID)
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func expectSSEStreamEnd(t *testing.T, events chan testSSEEvent) {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Fatalf("expected the stream to end, got %s", event.data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the stream to end")
+	}
+}
+
+func TestReconnectReplaysMissedAndLiveEvents(t *testing.T) {
+	s, srv, requests := newResumableTestServer(t)
+	requestID, lastEventID := cutRequestStream(t, s, srv, requests)
+	streamID, _ := s.requestToStreamMapping.Get(requestID)
+	related := &shared.TransportSendOptions{RelatedRequestID: requestID}
+
+	if _, err := s.Send(progressNotification(2), related); err != nil {
+		t.Fatalf("Send while disconnected: %v", err)
+	}
+
+	events := readSSEEvents(resumeSSEStream(t, srv, lastEventID).Body)
+	nextSSEEvent(t, events, `"progress":2`)
+
+	//Live events of the request go to the new connection
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if _, ok := s.streamMapping.Get(streamID); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the replayed stream was not mapped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := s.Send(progressNotification(3), related); err != nil {
+		t.Fatalf("Send after reconnect: %v", err)
+	}
+	nextSSEEvent(t, events, `"progress":3`)
+	response := &types.JSONRPCResponse{JSONRPC: types.JSONRPC_VERSION, ID: requestID, Result: &types.EmptyResult{}}
+	if _, err := s.Send(response, nil); err != nil {
+		t.Fatalf("Send response: %v", err)
+	}
+	nextSSEEvent(t, events, fmt.Sprintf(`"id":%d`, requestID))
+	//The request is answered, the stream ends
+	expectSSEStreamEnd(t, events)
+}
+
+func TestReplayEndsAnsweredRequestStream(t *testing.T) {
+	s, srv, requests := newResumableTestServer(t)
+	requestID, lastEventID := cutRequestStream(t, s, srv, requests)
+	streamID, _ := s.requestToStreamMapping.Get(requestID)
+
+	//Answered while the client is away, the replay delivers the response
+	response := &types.JSONRPCResponse{JSONRPC: types.JSONRPC_VERSION, ID: requestID, Result: &types.EmptyResult{}}
+	if _, err := s.Send(response, nil); err != nil {
+		t.Fatalf("Send response: %v", err)
+	}
+	events := readSSEEvents(resumeSSEStream(t, srv, lastEventID).Body)
+	nextSSEEvent(t, events, fmt.Sprintf(`"id":%d`, requestID))
+	expectSSEStreamEnd(t, events)
+	if _, ok := s.streamMapping.Get(streamID); ok {
+		t.Fatal("expected the replayed stream not to be mapped")
+	}
+}
+
+func TestDeletePurgesSessionStreams(t *testing.T) {
+	store := shared.NewMemoryEventStore(shared.MemoryEventStoreOptions{})
+	newSession := func(sessionID string) *StreamableHTTPServerTransport {
+		s := NewStreamableHTTPServerTransport(StreamableHTTPServerTransportOptions{
+			EventStore:         store,
+			SessionIDGenerator: func() string { return sessionID },
+		}).(*StreamableHTTPServerTransport)
+		s.SessionID = sessionID
+		s.initialized = true
+		if err := s.Start(); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		return s
+	}
+	ended, other := newSession("ended"), newSession("other")
+	defer other.Close()
+	notification := &types.JSONRPCNotification{
+		JSONRPC:               types.JSONRPC_VERSION,
+		NotificationInterface: types.NewToolListChangedNotification(nil),
+	}
+	var endedIDs []shared.EventID
+	for _, streamID := range []shared.StreamID{"ended-1", "ended-2", "ended-3"} {
+		eventID, err := ended.storeEvent(streamID, notification)
+		if err != nil {
+			t.Fatalf("storeEvent: %v", err)
+		}
+		endedIDs = append(endedIDs, eventID)
+		if _, err := ended.storeEvent(streamID, notification); err != nil {
+			t.Fatalf("storeEvent: %v", err)
+		}
+	}
+	if _, err := other.storeEvent("other-1", notification); err != nil {
+		t.Fatalf("storeEvent: %v", err)
+	}
+
+	srv := httptest.NewServer(ended)
+	defer srv.Close()
+	//The open standalone stream of the session ends with it
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept", CONTENT_TYPE_EVENT_STREAM)
+	req.Header.Set(shared.TRANSPORT_HEADER_SESSION_ID, "ended")
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the standalone stream, got %d", res.StatusCode)
+	}
+	events := readSSEEvents(res.Body)
+
+	req, _ = http.NewRequest(http.MethodDelete, srv.URL, nil)
+	req.Header.Set(shared.TRANSPORT_HEADER_SESSION_ID, "ended")
+	deleted, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("DELETE: %v", err)
+	}
+	deleted.Body.Close()
+	if deleted.StatusCode != http.StatusOK {
+		t.Fatalf("expected DELETE to succeed, got %d", deleted.StatusCode)
+	}
+	expectSSEStreamEnd(t, events)
+
+	if stats := store.EventStoreStats(); stats.Streams != 1 || stats.Events != 1 {
+		t.Fatalf("expected only the stream of the other session, got %+v", stats)
+	}
+	//The second event of each stream is gone
+	for _, eventID := range endedIDs {
+		store.ReplayEventsAfter(eventID, func(replayed shared.EventID, msg types.JSONRPCMessage) {
+			t.Fatalf("unexpected replay of %s after %s", replayed, eventID)
+		})
+	}
+}
+
+//ResponseWriter whose writes fail once it is broken, as when the client is gone but the request is not canceled
+type breakableResponseWriter struct {
+	mu     sync.Mutex
+	header http.Header
+	body   strings.Builder
+	broken bool
+}
+
+func (w *breakableResponseWriter) Header() http.Header        { return w.header }
+func (w *breakableResponseWriter) WriteHeader(statusCode int) {}
+func (w *breakableResponseWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.broken {
+		return 0, errors.New("broken pipe")
+	}
+	return w.body.Write(b)
+}
+
+func (w *breakableResponseWriter) written() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.body.String()
+}
+
+func (w *breakableResponseWriter) breakWrites() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.broken = true
+}
+
+func TestKeepAliveDetectsDeadStream(t *testing.T) {
+	closed := make(chan error, 1)
+	s := NewStreamableHTTPServerTransport(StreamableHTTPServerTransportOptions{
+		SSEKeepAliveInterval: 10 * time.Millisecond,
+		OnStreamClosed: func(sessionID string, streamID shared.StreamID, err error) {
+			closed <- err
+		},
+	}).(*StreamableHTTPServerTransport)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	w := &breakableResponseWriter{header: make(http.Header)}
+	req := httptest.NewRequest(http.MethodGet, "/mcp", nil)
+	req.Header.Set("Accept", CONTENT_TYPE_EVENT_STREAM)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.ServeHTTP(w, req)
+	}()
+
+	for deadline := time.Now().Add(2 * time.Second); !strings.Contains(w.written(), ": keepalive"); {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a keepalive comment")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	//Nothing else is sent, only the next keepalive notices the dead connection
+	w.breakWrites()
+	select {
+	case err := <-closed:
+		if err == nil {
+			t.Fatal("expected the write error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the dead stream was not detected")
+	}
+	select {
+	case <-served:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the GET request to end")
+	}
+	if _, ok := s.streamMapping.Get(s.standaloneSseStreamID); ok {
+		t.Fatal("expected the dead stream to be unmapped")
+	}
+}
+
+//Writes the queued events of the stream and return their data in order
+func flushedEvents(t *testing.T, ss *sseStream) []string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	if err := ss.flush(rec); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	return strings.Fields(rec.Body.String())
+}
+
+func expectEvents(t *testing.T, events []string, expected ...string) {
+	t.Helper()
+	if strings.Join(events, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected %v, got %v", expected, events)
+	}
+}
+
+func TestSSEOverflowPolicyBlock(t *testing.T) {
+	ss := newSSEStream(2, SSE_OVERFLOW_POLICY_BLOCK)
+	ss.enqueue([]byte("n1 "), true)
+	ss.enqueue([]byte("n2 "), true)
+	queued := make(chan error, 1)
+	go func() { queued <- ss.enqueue([]byte("r1 "), false) }()
+	select {
+	case <-queued:
+		t.Fatal("expected Send to wait for room in the queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	expectEvents(t, flushedEvents(t, ss), "n1", "n2")
+	if err := <-queued; err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	expectEvents(t, flushedEvents(t, ss), "r1")
+
+	//The waiting Send ends with the stream
+	ss.enqueue([]byte("n3 "), true)
+	ss.enqueue([]byte("n4 "), true)
+	go func() { queued <- ss.enqueue([]byte("r2 "), false) }()
+	ss.close(errors.New("client gone"))
+	select {
+	case err := <-queued:
+		if err == nil {
+			t.Fatal("expected the error of the closed stream")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Send to stop waiting")
+	}
+	if stats := ss.stats("stream"); stats.Dropped != 0 {
+		t.Fatalf("expected no dropped events, got %+v", stats)
+	}
+}
+
+func TestSSEOverflowPolicyDropOldestNotification(t *testing.T) {
+	ss := newSSEStream(2, SSE_OVERFLOW_POLICY_DROP_OLDEST_NOTIFICATION)
+	ss.enqueue([]byte("n1 "), true)
+	ss.enqueue([]byte("r1 "), false)
+	//The oldest notification makes room
+	ss.enqueue([]byte("n2 "), true)
+	ss.enqueue([]byte("r2 "), false)
+	//Without queued notifications the new one is dropped, the request is queued over the limit
+	if err := ss.enqueue([]byte("n3 "), true); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if err := ss.enqueue([]byte("r3 "), false); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	expectEvents(t, flushedEvents(t, ss), "r1", "r2", "r3")
+	if stats := ss.stats("stream"); stats.Dropped != 3 || stats.MaxQueueDepth != 3 || stats.Written != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSSEOverflowPolicyCloseStream(t *testing.T) {
+	ss := newSSEStream(2, SSE_OVERFLOW_POLICY_CLOSE_STREAM)
+	ss.enqueue([]byte("n1 "), true)
+	ss.enqueue([]byte("r1 "), false)
+	if err := ss.enqueue([]byte("n2 "), true); err == nil {
+		t.Fatal("expected the overflow to close the stream")
+	}
+	select {
+	case <-ss.done:
+	default:
+		t.Fatal("expected the stream to be closed")
+	}
+	if err := ss.enqueue([]byte("r2 "), false); err == nil {
+		t.Fatal("expected the closed stream to reject events")
+	}
+	if stats := ss.stats("stream"); stats.Dropped != 3 || stats.QueueDepth != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSSEStreamsMetric(t *testing.T) {
+	registry := metrics.NewRegistry()
+	s := NewStreamableHTTPServerTransport(StreamableHTTPServerTransportOptions{Metrics: registry}).(*StreamableHTTPServerTransport)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	streams := registry.NewGaugeVec(shared.METRIC_SSE_STREAMS, "", "kind")
+	ctx, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+	req := httptest.NewRequest(http.MethodGet, "/mcp", nil).WithContext(ctx)
+	req.Header.Set("Accept", CONTENT_TYPE_EVENT_STREAM)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.ServeHTTP(&breakableResponseWriter{header: make(http.Header)}, req)
+	}()
+
+	for deadline := time.Now().Add(2 * time.Second); streams.Value(SSE_STREAM_KIND_STANDALONE) != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the standalone stream to be counted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	disconnect()
+	<-served
+	if value := streams.Value(SSE_STREAM_KIND_STANDALONE); value != 0 {
+		t.Fatalf("expected the closed stream to be uncounted, got %v", value)
+	}
+}
+
+func TestClientDisconnectCancelsHandler(t *testing.T) {
+	var serverInfo types.Implementation
+	serverInfo.Name = "test"
+	mcps, err := NewMcpServer(serverInfo, ServerOptions{})
+	if err != nil {
+		t.Fatalf("NewMcpServer: %v", err)
+	}
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	_, err = mcps.RegisterTool(RegisterToolOpts{Name: "slow", Callback: func(args map[string]interface{}, extra *shared.RequestHandlerExtra) (*types.CallToolResult, error) {
+		close(started)
+		select {
+		case <-extra.Context.Done():
+			cancelled <- extra.Context.Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- nil
+		}
+		return &types.CallToolResult{}, nil
+	}})
+	if err != nil {
+		t.Fatalf("RegisterTool: %v", err)
+	}
+	s := NewStreamableHTTPServerTransport(StreamableHTTPServerTransportOptions{})
+	mcps.GetServer().Protocol.Connect(context.Background(), s)
+	srv := httptest.NewServer(s.(*StreamableHTTPServerTransport))
+	defer srv.Close()
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+	body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"slow"}}`
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, strings.NewReader(body))
+	req.Header.Set("Content-Type", CONTENT_TYPE_JSON)
+	req.Header.Set("Accept", CONTENT_TYPE_JSON+", "+CONTENT_TYPE_EVENT_STREAM)
+	go func() {
+		res, err := srv.Client().Do(req)
+		if err == nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+	}()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the tool to be called")
+	}
+	disconnect()
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the handler context to be cancelled, got %v", err)
+		}
+	case <-time.After(6 * time.Second):
+		t.Fatal("expected the tool to return")
+	}
+}
@@ -0,0 +1,218 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+	"github.com/victorvbello/gomcp/mcp/utils/metrics"
+)
+
+const (
+	//Time a request waits for a free slot when ConcurrencyLimits.QueueTimeout is not set
+	DEFAULT_CONCURRENCY_QUEUE_TIMEOUT = 30 * time.Second
+	CONCURRENCY_SCOPE_SESSION         = "session"
+	CONCURRENCY_SCOPE_METHOD          = "method"
+	CONCURRENCY_SCOPE_TOOL            = "tool"
+	METRIC_CONCURRENCY_IN_FLIGHT      = "mcp_concurrency_in_flight"
+	METRIC_CONCURRENCY_QUEUED         = "mcp_concurrency_queued"
+	CONCURRENCY_REJECT_REASON_LIMIT   = "limit reached"
+	CONCURRENCY_REJECT_REASON_QUEUE   = "queue full"
+	CONCURRENCY_REJECT_REASON_TIMEOUT = "queue timeout"
+)
+
+//Current state of a concurrency limit
+type ConcurrencyStats struct {
+	//CONCURRENCY_SCOPE_SESSION, CONCURRENCY_SCOPE_METHOD or CONCURRENCY_SCOPE_TOOL
+	Scope string `json:"scope"`
+	//Method or tool name, empty for the session limit
+	Key         string `json:"key,omitempty"`
+	MaxInFlight int    `json:"maxInFlight"`
+	MaxQueue    int    `json:"maxQueue"`
+	InFlight    int    `json:"inFlight"`
+	Queued      int    `json:"queued"`
+}
+
+//Limits the requests handled at the same time, the requests over the limit wait in a bounded queue or are rejected.
+//
+//The methods of a nil ConcurrencyLimiter do nothing, so a missing limit can be used as is
+type ConcurrencyLimiter struct {
+	mu           sync.Mutex
+	scope        string
+	key          string
+	maxQueue     int
+	queueTimeout time.Duration
+	slots        chan struct{}
+	queued       int
+	gauges       *concurrencyGauges
+}
+
+//Return a limiter of maxInFlight concurrent requests, nil if maxInFlight is not positive.
+//
+//Up to maxQueue requests wait for a free slot for queueTimeout at most, 0 uses DEFAULT_CONCURRENCY_QUEUE_TIMEOUT
+//and a negative value waits until the context of the request is done
+func NewConcurrencyLimiter(scope string, key string, maxInFlight int, maxQueue int, queueTimeout time.Duration) *ConcurrencyLimiter {
+	if maxInFlight <= 0 {
+		return nil
+	}
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
+	if queueTimeout == 0 {
+		queueTimeout = DEFAULT_CONCURRENCY_QUEUE_TIMEOUT
+	}
+	return &ConcurrencyLimiter{
+		scope:        scope,
+		key:          key,
+		maxQueue:     maxQueue,
+		queueTimeout: queueTimeout,
+		slots:        make(chan struct{}, maxInFlight),
+	}
+}
+
+//Return a limiter by key for each positive limit of the map
+func NewConcurrencyLimiters(scope string, limits map[string]int, maxQueue int, queueTimeout time.Duration) map[string]*ConcurrencyLimiter {
+	limiters := make(map[string]*ConcurrencyLimiter)
+	for key, maxInFlight := range limits {
+		if limiter := NewConcurrencyLimiter(scope, key, maxInFlight, maxQueue, queueTimeout); limiter != nil {
+			limiters[key] = limiter
+		}
+	}
+	return limiters
+}
+
+//Takes a slot, waiting in the queue if there is none free.
+//
+//Return the function that frees the slot, or an McpError with code ERROR_CODE_CONCURRENCY_LIMIT_EXCEEDED when the request is rejected,
+//or the error of ctx if it is done while waiting
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context) (func(), error) {
+	if cl == nil {
+		return func() {}, nil
+	}
+	select {
+	case cl.slots <- struct{}{}:
+		return cl.acquired(), nil
+	default:
+	}
+	if cl.maxQueue == 0 {
+		return nil, cl.rejectError(CONCURRENCY_REJECT_REASON_LIMIT)
+	}
+	cl.mu.Lock()
+	if cl.queued >= cl.maxQueue {
+		cl.mu.Unlock()
+		return nil, cl.rejectError(CONCURRENCY_REJECT_REASON_QUEUE)
+	}
+	cl.queued++
+	cl.mu.Unlock()
+	cl.gauges.queued(cl, 1)
+	defer func() {
+		cl.mu.Lock()
+		cl.queued--
+		cl.mu.Unlock()
+		cl.gauges.queued(cl, -1)
+	}()
+
+	var timeout <-chan time.Time
+	if cl.queueTimeout > 0 {
+		timer := time.NewTimer(cl.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case cl.slots <- struct{}{}:
+		return cl.acquired(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout:
+		return nil, cl.rejectError(CONCURRENCY_REJECT_REASON_TIMEOUT)
+	}
+}
+
+//Return the release of a taken slot, calls after the first one do nothing
+func (cl *ConcurrencyLimiter) acquired() func() {
+	cl.gauges.inFlight(cl, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-cl.slots
+			cl.gauges.inFlight(cl, -1)
+		})
+	}
+}
+
+func (cl *ConcurrencyLimiter) rejectError(reason string) error {
+	subject := cl.scope
+	if cl.key != "" {
+		subject = fmt.Sprintf("%s %s", cl.scope, cl.key)
+	}
+	return types.NewMcpError(types.ERROR_CODE_CONCURRENCY_LIMIT_EXCEEDED, fmt.Sprintf("too many concurrent requests for %s, %s", subject, reason), map[string]interface{}{
+		"scope":       cl.scope,
+		"key":         cl.key,
+		"reason":      reason,
+		"maxInFlight": cap(cl.slots),
+		"maxQueue":    cl.maxQueue,
+	})
+}
+
+func (cl *ConcurrencyLimiter) Stats() ConcurrencyStats {
+	if cl == nil {
+		return ConcurrencyStats{}
+	}
+	cl.mu.Lock()
+	queued := cl.queued
+	cl.mu.Unlock()
+	return ConcurrencyStats{
+		Scope:       cl.scope,
+		Key:         cl.key,
+		MaxInFlight: cap(cl.slots),
+		MaxQueue:    cl.maxQueue,
+		InFlight:    len(cl.slots),
+		Queued:      queued,
+	}
+}
+
+//Records the in-flight and queued requests of the limiter in the registry, nothing is recorded if the registry is nil
+func (cl *ConcurrencyLimiter) RegisterMetrics(registry *metrics.Registry) {
+	if cl == nil || registry == nil {
+		return
+	}
+	cl.gauges = newConcurrencyGauges(registry)
+}
+
+//Return the stats of the limiters sorted by key
+func ConcurrencyLimitersStats(limiters map[string]*ConcurrencyLimiter) []ConcurrencyStats {
+	stats := make([]ConcurrencyStats, 0, len(limiters))
+	for _, limiter := range limiters {
+		stats = append(stats, limiter.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+	return stats
+}
+
+//Gauges shared by all the limiters of a registry, the sessions with the same scope and key are added
+type concurrencyGauges struct {
+	inFlightGauge *metrics.GaugeVec
+	queuedGauge   *metrics.GaugeVec
+}
+
+func newConcurrencyGauges(registry *metrics.Registry) *concurrencyGauges {
+	return &concurrencyGauges{
+		inFlightGauge: registry.NewGaugeVec(METRIC_CONCURRENCY_IN_FLIGHT, "Requests holding a slot of a concurrency limit by scope and key.", "scope", "key"),
+		queuedGauge:   registry.NewGaugeVec(METRIC_CONCURRENCY_QUEUED, "Requests waiting for a slot of a concurrency limit by scope and key.", "scope", "key"),
+	}
+}
+
+func (cg *concurrencyGauges) inFlight(cl *ConcurrencyLimiter, delta float64) {
+	if cg != nil {
+		cg.inFlightGauge.Add(delta, cl.scope, cl.key)
+	}
+}
+
+func (cg *concurrencyGauges) queued(cl *ConcurrencyLimiter, delta float64) {
+	if cg != nil {
+		cg.queuedGauge.Add(delta, cl.scope, cl.key)
+	}
+}
@@ -0,0 +1,223 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+//Expects a rejection of the limiter for the reason
+func expectConcurrencyRejected(t *testing.T, err error, reason string) {
+	t.Helper()
+	var mcpErr *types.McpError
+	if !errors.As(err, &mcpErr) || mcpErr.GetErrorCode() != types.ERROR_CODE_CONCURRENCY_LIMIT_EXCEEDED {
+		t.Fatalf("expected a concurrency limit error, got %v", err)
+	}
+	data, _ := mcpErr.GetErrorData().(map[string]interface{})
+	if data["reason"] != reason {
+		t.Fatalf("expected the reason %q, got %v", reason, data)
+	}
+}
+
+//Waits until the limiter has the queued requests
+func waitQueued(t *testing.T, cl *ConcurrencyLimiter, queued int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for cl.Stats().Queued != queued {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued requests, got %+v", queued, cl.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type testAcquireResult struct {
+	release func()
+	err     error
+}
+
+func startAcquire(ctx context.Context, cl *ConcurrencyLimiter) chan testAcquireResult {
+	results := make(chan testAcquireResult, 1)
+	go func() {
+		release, err := cl.Acquire(ctx)
+		results <- testAcquireResult{release, err}
+	}()
+	return results
+}
+
+func waitAcquire(t *testing.T, results chan testAcquireResult) testAcquireResult {
+	t.Helper()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Acquire to return")
+	}
+	return testAcquireResult{}
+}
+
+func TestConcurrencyLimiterQueuedGetsReleasedSlot(t *testing.T) {
+	cl := NewConcurrencyLimiter(CONCURRENCY_SCOPE_SESSION, "", 1, 1, -1)
+	release, err := cl.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	results := startAcquire(context.Background(), cl)
+	waitQueued(t, cl, 1)
+	if stats := cl.Stats(); stats.InFlight != 1 || stats.MaxInFlight != 1 || stats.MaxQueue != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	select {
+	case result := <-results:
+		t.Fatalf("expected the request to wait for the slot, got %v", result.err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	release()
+	//Releasing twice does not free the slot of the queued request
+	release()
+	queued := waitAcquire(t, results)
+	if queued.err != nil {
+		t.Fatalf("expected the queued request to get the slot, got %v", queued.err)
+	}
+	if stats := cl.Stats(); stats.InFlight != 1 || stats.Queued != 0 {
+		t.Fatalf("expected the queued request in flight, got %+v", stats)
+	}
+	queued.release()
+	if stats := cl.Stats(); stats.InFlight != 0 {
+		t.Fatalf("expected no requests in flight, got %+v", stats)
+	}
+}
+
+func TestConcurrencyLimiterRejects(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxQueue     int
+		queueTimeout time.Duration
+		//Requests waiting before the rejected one
+		queued int
+		reason string
+	}{
+		{"no queue", 0, -1, 0, CONCURRENCY_REJECT_REASON_LIMIT},
+		{"queue full", 2, -1, 2, CONCURRENCY_REJECT_REASON_QUEUE},
+		{"queue timeout", 1, 20 * time.Millisecond, 0, CONCURRENCY_REJECT_REASON_TIMEOUT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewConcurrencyLimiter(CONCURRENCY_SCOPE_METHOD, "tools/call", 1, tt.maxQueue, tt.queueTimeout)
+			release, err := cl.Acquire(context.Background())
+			if err != nil {
+				t.Fatalf("Acquire: %v", err)
+			}
+			defer release()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			for i := 0; i < tt.queued; i++ {
+				startAcquire(ctx, cl)
+			}
+			waitQueued(t, cl, tt.queued)
+
+			start := time.Now()
+			_, err = cl.Acquire(context.Background())
+			expectConcurrencyRejected(t, err, tt.reason)
+			if tt.queueTimeout > 0 && time.Since(start) < tt.queueTimeout {
+				t.Fatalf("expected the rejection after the queue timeout, got it after %v", time.Since(start))
+			}
+			if stats := cl.Stats(); stats.InFlight != 1 || stats.Queued != tt.queued {
+				t.Fatalf("expected the rejected request not to be kept, got %+v", stats)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimiterQueuedContextDone(t *testing.T) {
+	cl := NewConcurrencyLimiter(CONCURRENCY_SCOPE_SESSION, "", 1, 1, -1)
+	release, err := cl.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(context.Background())
+	results := startAcquire(ctx, cl)
+	waitQueued(t, cl, 1)
+	cancel()
+	if result := waitAcquire(t, results); !errors.Is(result.err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", result.err)
+	}
+	if stats := cl.Stats(); stats.Queued != 0 {
+		t.Fatalf("expected the cancelled request out of the queue, got %+v", stats)
+	}
+}
+
+//Sends requests handled concurrently to a protocol whose session allows one request in flight
+func TestProtocolConcurrencyLimitSaturated(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxQueue int
+		reason   string
+	}{
+		{"queue", 1, CONCURRENCY_REJECT_REASON_QUEUE},
+		{"reject", 0, CONCURRENCY_REJECT_REASON_LIMIT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner := &testProtocolOwner{errs: make(chan error, 100)}
+			p := NewProtocol(&ProtocolOptions{
+				ConcurrencyLimits: &ConcurrencyLimits{MaxInFlight: 1, MaxQueue: tt.maxQueue, QueueTimeout: -1},
+			}, owner)
+			transport := &testTransport{sent: make(chan types.JSONRPCMessage, 100)}
+			p.Connect(context.Background(), transport)
+			unblock := make(chan struct{})
+			p.SetRequestHandler(types.NewListToolsRequest(nil), func(request types.RequestInterface, extra *RequestHandlerExtra) (types.ResultInterface, error) {
+				<-unblock
+				return &types.EmptyResult{}, nil
+			})
+			send := func(id types.RequestID) {
+				transport.OnMessage(&types.JSONRPCRequest{
+					JSONRPC:          types.JSONRPC_VERSION,
+					ID:               id,
+					RequestInterface: types.NewListToolsRequest(nil),
+				}, &MessageExtraInfo{ConcurrentHandling: true})
+			}
+
+			send(1)
+			queued := tt.maxQueue
+			for i := 0; i < queued; i++ {
+				send(types.RequestID(2 + i))
+			}
+			waitQueued(t, p.sessionLimiter, queued)
+			rejectedID := types.RequestID(2 + queued)
+			send(rejectedID)
+			rejected, ok := nextSent(t, transport).(*types.JSONRPCError)
+			if !ok || rejected.ID != rejectedID || rejected.Error.GetErrorCode() != types.ERROR_CODE_CONCURRENCY_LIMIT_EXCEEDED {
+				t.Fatalf("expected the request %d rejected, got %#v", rejectedID, rejected)
+			}
+			if data, _ := rejected.Error.GetErrorData().(map[string]interface{}); data["reason"] != tt.reason {
+				t.Fatalf("expected the reason %q, got %v", tt.reason, rejected.Error.GetErrorData())
+			}
+			stats := p.ConcurrencyStats()
+			if len(stats) != 1 || stats[0].InFlight != 1 || stats[0].Queued != queued {
+				t.Fatalf("unexpected stats %+v", stats)
+			}
+
+			//The running and the queued requests are answered
+			close(unblock)
+			answered := make(map[types.RequestID]bool)
+			for i := 0; i < 1+queued; i++ {
+				response, ok := nextSent(t, transport).(*types.JSONRPCResponse)
+				if !ok {
+					t.Fatal("expected a response")
+				}
+				answered[response.ID] = true
+			}
+			for id := types.RequestID(1); id < rejectedID; id++ {
+				if !answered[id] {
+					t.Fatalf("expected the request %d answered, got %v", id, answered)
+				}
+			}
+		})
+	}
+}
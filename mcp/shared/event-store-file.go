@@ -0,0 +1,615 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+	utils "github.com/victorvbello/gomcp/mcp/utils/logger"
+)
+
+type FsyncPolicy int
+
+const (
+	//fsync after every stored event, no event is lost on a crash
+	FSYNC_POLICY_ALWAYS FsyncPolicy = iota
+	//fsync every FsyncInterval, the events of the last interval can be lost on a crash
+	FSYNC_POLICY_INTERVAL
+	//Never fsync, the operating system decides when the data reaches the disk
+	FSYNC_POLICY_NEVER
+)
+
+const (
+	DEFAULT_FILE_EVENT_STORE_MAX_SEGMENT_BYTES = 16 * 1024 * 1024
+	DEFAULT_FILE_EVENT_STORE_FSYNC_INTERVAL    = time.Second
+	DEFAULT_FILE_EVENT_STORE_RETENTION         = 24 * time.Hour
+	FILE_EVENT_STORE_SEGMENT_EXT               = ".log"
+)
+
+type FileEventStoreOptions struct {
+	//Directory of the segment files, it is created if does not exist.
+	Dir string
+	//A new segment is started when the active one reaches this size.
+	//Default is DEFAULT_FILE_EVENT_STORE_MAX_SEGMENT_BYTES.
+	MaxSegmentBytes int64
+	//Default is FSYNC_POLICY_ALWAYS.
+	FsyncPolicy *FsyncPolicy
+	//Used with FSYNC_POLICY_INTERVAL.
+	//Default is DEFAULT_FILE_EVENT_STORE_FSYNC_INTERVAL.
+	FsyncInterval time.Duration
+	//Events older than this are not replayed, segments with only expired events are deleted.
+	//Default is DEFAULT_FILE_EVENT_STORE_RETENTION.
+	Retention time.Duration
+	//If greater than 0 the oldest segments are deleted while the total size is above it.
+	MaxTotalBytes int64
+	//How often the expired segments are deleted in background, if is 0 it only happens when a segment is rotated
+	//or Compact is called.
+	CompactInterval time.Duration
+	//Return the current time, useful for tests.
+	//
+	//If is nil time.Now is used.
+	Now func() time.Time
+}
+
+//One line of a segment file, an event or a cleanup record without message
+type fileEventRecord struct {
+	Seq      uint64          `json:"seq"`
+	StreamID StreamID        `json:"stream,omitempty"`
+	StoredAt int64           `json:"ts"`
+	Message  json.RawMessage `json:"message,omitempty"`
+	//The events of StreamID stored before this record are deleted
+	Deleted bool `json:"deleted,omitempty"`
+	//The events of all the streams stored before this time (unix nano) are deleted
+	ExpireBefore int64 `json:"expireBefore,omitempty"`
+}
+
+//Location of an event in the segments
+type fileEventIndexEntry struct {
+	seq      uint64
+	segment  uint64
+	offset   int64
+	length   int64
+	storedAt time.Time
+}
+
+type fileEventSegment struct {
+	//Sequence of the first event, also the file name
+	firstSeq uint64
+	size     int64
+	//Time of the newest event
+	lastStoredAt time.Time
+}
+
+//EventStore that appends the events to segmented log files, it survives process restarts.
+//
+//Each segment is a file named by the sequence of its first event with one JSON line per event,
+//an in-memory index by stream is rebuilt from the segments on open so ReplayEventsAfter reads only the needed lines.
+//Event IDs have the form <streamID>_<sequence>, the sequence is monotonic across restarts.
+//
+//The store must be closed with Close to stop the background work and flush the data.
+type FileEventStore struct {
+	mu              sync.Mutex
+	dir             string
+	maxSegmentBytes int64
+	fsyncPolicy     FsyncPolicy
+	retention       time.Duration
+	maxTotalBytes   int64
+	now             func() time.Time
+	lastSeq         uint64
+	segments        []*fileEventSegment
+	active          *os.File
+	dirty           bool
+	index           map[StreamID][]fileEventIndexEntry
+	closed          bool
+	stop            chan struct{}
+	wg              sync.WaitGroup
+	logger          utils.LogService
+}
+
+func NewFileEventStore(opts FileEventStoreOptions) (*FileEventStore, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("dir is required")
+	}
+	nes := &FileEventStore{
+		dir:             opts.Dir,
+		maxSegmentBytes: opts.MaxSegmentBytes,
+		fsyncPolicy:     FSYNC_POLICY_ALWAYS,
+		retention:       opts.Retention,
+		maxTotalBytes:   opts.MaxTotalBytes,
+		now:             opts.Now,
+		index:           make(map[StreamID][]fileEventIndexEntry),
+		stop:            make(chan struct{}),
+		logger:          utils.NewLoggerService(),
+	}
+	if opts.FsyncPolicy != nil {
+		nes.fsyncPolicy = *opts.FsyncPolicy
+	}
+	if nes.maxSegmentBytes <= 0 {
+		nes.maxSegmentBytes = DEFAULT_FILE_EVENT_STORE_MAX_SEGMENT_BYTES
+	}
+	if nes.retention <= 0 {
+		nes.retention = DEFAULT_FILE_EVENT_STORE_RETENTION
+	}
+	if nes.now == nil {
+		nes.now = time.Now
+	}
+	if err := os.MkdirAll(nes.dir, 0700); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll %v", err)
+	}
+	if err := nes.load(); err != nil {
+		return nil, fmt.Errorf("nes.load %v", err)
+	}
+
+	fsyncInterval := opts.FsyncInterval
+	if fsyncInterval <= 0 {
+		fsyncInterval = DEFAULT_FILE_EVENT_STORE_FSYNC_INTERVAL
+	}
+	if nes.fsyncPolicy == FSYNC_POLICY_INTERVAL {
+		nes.runEvery(fsyncInterval, func() {
+			nes.mu.Lock()
+			defer nes.mu.Unlock()
+			if err := nes.syncLocked(); err != nil {
+				nes.logger.Error(nil, fmt.Sprintf("FileEventStore.syncLocked %v", err))
+			}
+		})
+	}
+	if opts.CompactInterval > 0 {
+		nes.runEvery(opts.CompactInterval, func() {
+			if err := nes.Compact(); err != nil {
+				nes.logger.Error(nil, fmt.Sprintf("FileEventStore.Compact %v", err))
+			}
+		})
+	}
+	return nes, nil
+}
+
+func (es *FileEventStore) runEvery(interval time.Duration, fn func()) {
+	es.wg.Add(1)
+	go func() {
+		defer es.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-es.stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
+
+func (es *FileEventStore) segmentPath(firstSeq uint64) string {
+	return filepath.Join(es.dir, fmt.Sprintf("%020d%s", firstSeq, FILE_EVENT_STORE_SEGMENT_EXT))
+}
+
+//Rebuilds the index from the segment files and opens the last one for append
+func (es *FileEventStore) load() error {
+	entries, err := os.ReadDir(es.dir)
+	if err != nil {
+		return fmt.Errorf("os.ReadDir %v", err)
+	}
+	var firstSeqs []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, FILE_EVENT_STORE_SEGMENT_EXT) {
+			continue
+		}
+		firstSeq, err := strconv.ParseUint(strings.TrimSuffix(name, FILE_EVENT_STORE_SEGMENT_EXT), 10, 64)
+		if err != nil {
+			continue
+		}
+		firstSeqs = append(firstSeqs, firstSeq)
+	}
+	sort.Slice(firstSeqs, func(i, j int) bool { return firstSeqs[i] < firstSeqs[j] })
+
+	for i, firstSeq := range firstSeqs {
+		isLast := i == len(firstSeqs)-1
+		segment, err := es.loadSegment(firstSeq, isLast)
+		if err != nil {
+			return fmt.Errorf("es.loadSegment %d %v", firstSeq, err)
+		}
+		es.segments = append(es.segments, segment)
+	}
+	if len(es.segments) == 0 {
+		return es.rotateLocked()
+	}
+	last := es.segments[len(es.segments)-1]
+	es.active, err = os.OpenFile(es.segmentPath(last.firstSeq), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile %v", err)
+	}
+	return nil
+}
+
+//Indexes the events of a segment, a partial or corrupt line at the end of the last segment (a crash during a write) is truncated
+func (es *FileEventStore) loadSegment(firstSeq uint64, isLast bool) (*fileEventSegment, error) {
+	path := es.segmentPath(firstSeq)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open %v", err)
+	}
+	defer f.Close()
+
+	segment := &fileEventSegment{firstSeq: firstSeq}
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				if !isLast {
+					return nil, fmt.Errorf("segment %s has a partial line at %d", path, offset)
+				}
+				es.logger.Warning(nil, fmt.Sprintf("FileEventStore truncating partial event in %s at %d", path, offset))
+				if err := os.Truncate(path, offset); err != nil {
+					return nil, fmt.Errorf("os.Truncate %v", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reader.ReadBytes %v", err)
+		}
+		var record fileEventRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			if _, peekErr := reader.Peek(1); !isLast || peekErr != io.EOF {
+				return nil, fmt.Errorf("json.Unmarshal %s at %d %v", path, offset, err)
+			}
+			es.logger.Warning(nil, fmt.Sprintf("FileEventStore truncating corrupt event in %s at %d", path, offset))
+			if err := os.Truncate(path, offset); err != nil {
+				return nil, fmt.Errorf("os.Truncate %v", err)
+			}
+			break
+		}
+		storedAt := time.Unix(0, record.StoredAt)
+		switch {
+		case record.Deleted:
+			delete(es.index, record.StreamID)
+		case record.ExpireBefore > 0:
+			es.expireIndexLocked(time.Unix(0, record.ExpireBefore))
+		default:
+			es.index[record.StreamID] = append(es.index[record.StreamID], fileEventIndexEntry{
+				seq:      record.Seq,
+				segment:  firstSeq,
+				offset:   offset,
+				length:   int64(len(line)),
+				storedAt: storedAt,
+			})
+		}
+		if record.Seq > es.lastSeq {
+			es.lastSeq = record.Seq
+		}
+		segment.lastStoredAt = storedAt
+		offset += int64(len(line))
+	}
+	segment.size = offset
+	return segment, nil
+}
+
+func (es *FileEventStore) syncLocked() error {
+	if !es.dirty || es.active == nil {
+		return nil
+	}
+	if err := es.active.Sync(); err != nil {
+		return fmt.Errorf("active.Sync %v", err)
+	}
+	es.dirty = false
+	return nil
+}
+
+//Closes the active segment and starts a new one, must be called with the lock held
+func (es *FileEventStore) rotateLocked() error {
+	if es.active != nil {
+		if es.fsyncPolicy != FSYNC_POLICY_NEVER {
+			if err := es.active.Sync(); err != nil {
+				return fmt.Errorf("active.Sync %v", err)
+			}
+		}
+		if err := es.active.Close(); err != nil {
+			return fmt.Errorf("active.Close %v", err)
+		}
+		es.active = nil
+		es.dirty = false
+	}
+	firstSeq := es.lastSeq + 1
+	f, err := os.OpenFile(es.segmentPath(firstSeq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile %v", err)
+	}
+	es.active = f
+	es.segments = append(es.segments, &fileEventSegment{firstSeq: firstSeq})
+	return nil
+}
+
+//Appends the record to the active segment, rotating it first if is full, must be called with the lock held.
+//
+//Set the sequence and time of the record and return the segment and offset where it was written
+func (es *FileEventStore) appendRecordLocked(record *fileEventRecord) (*fileEventSegment, int64, int64, error) {
+	if es.closed {
+		return nil, 0, 0, fmt.Errorf("event store is closed")
+	}
+	active := es.segments[len(es.segments)-1]
+	if active.size >= es.maxSegmentBytes {
+		if err := es.rotateLocked(); err != nil {
+			return nil, 0, 0, fmt.Errorf("es.rotateLocked %v", err)
+		}
+		active = es.segments[len(es.segments)-1]
+		if err := es.compactLocked(); err != nil {
+			es.logger.Error(nil, fmt.Sprintf("FileEventStore.compactLocked %v", err))
+		}
+	}
+
+	now := es.now()
+	record.Seq = es.lastSeq + 1
+	record.StoredAt = now.UnixNano()
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("json.Marshal %v", err)
+	}
+	line = append(line, '\n')
+	//A single write per record, a crash leaves at most one partial line that is truncated on load
+	if _, err := es.active.Write(line); err != nil {
+		return nil, 0, 0, fmt.Errorf("active.Write %v", err)
+	}
+	es.dirty = true
+	if es.fsyncPolicy == FSYNC_POLICY_ALWAYS {
+		if err := es.syncLocked(); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	offset := active.size
+	es.lastSeq = record.Seq
+	active.size += int64(len(line))
+	active.lastStoredAt = now
+	return active, offset, int64(len(line)), nil
+}
+
+//Stores an event for later retrieval
+func (es *FileEventStore) StoreEvent(streamID StreamID, message types.JSONRPCMessage) (EventID, error) {
+	data, err := types.JSONRPCMessageMarshalJSON(message)
+	if err != nil {
+		return "", fmt.Errorf("types.JSONRPCMessageMarshalJSON %v", err)
+	}
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	record := fileEventRecord{StreamID: streamID, Message: data}
+	segment, offset, length, err := es.appendRecordLocked(&record)
+	if err != nil {
+		return "", err
+	}
+	es.index[streamID] = append(es.index[streamID], fileEventIndexEntry{
+		seq:      record.Seq,
+		segment:  segment.firstSeq,
+		offset:   offset,
+		length:   length,
+		storedAt: time.Unix(0, record.StoredAt),
+	})
+	return fileEventID(streamID, record.Seq), nil
+}
+
+//Deletes all the events of the stream.
+//
+//A deletion record is appended so the stream stays deleted after a restart, the data is removed from disk by the compaction.
+func (es *FileEventStore) DeleteStream(streamID StreamID) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if _, ok := es.index[streamID]; !ok {
+		return nil
+	}
+	if _, _, _, err := es.appendRecordLocked(&fileEventRecord{StreamID: streamID, Deleted: true}); err != nil {
+		return err
+	}
+	delete(es.index, streamID)
+	return nil
+}
+
+//Deletes the events of all the streams stored before the time, the segments with only expired events are removed
+func (es *FileEventStore) ExpireEvents(before time.Time) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if _, _, _, err := es.appendRecordLocked(&fileEventRecord{ExpireBefore: before.UnixNano()}); err != nil {
+		return err
+	}
+	es.expireIndexLocked(before)
+	return es.compactBeforeLocked(before)
+}
+
+//Removes the index entries stored before the time, must be called with the lock held
+func (es *FileEventStore) expireIndexLocked(before time.Time) {
+	for streamID, entries := range es.index {
+		start := sort.Search(len(entries), func(i int) bool { return !entries[i].storedAt.Before(before) })
+		if start == len(entries) {
+			delete(es.index, streamID)
+			continue
+		}
+		if start > 0 {
+			es.index[streamID] = append([]fileEventIndexEntry(nil), entries[start:]...)
+		}
+	}
+}
+
+func fileEventID(streamID StreamID, seq uint64) EventID {
+	return EventID(fmt.Sprintf("%s_%d", streamID, seq))
+}
+
+func parseFileEventID(eventID EventID) (StreamID, uint64, error) {
+	raw := string(eventID)
+	sep := strings.LastIndex(raw, "_")
+	if sep < 0 {
+		return "", 0, fmt.Errorf("invalid event id %q", eventID)
+	}
+	seq, err := strconv.ParseUint(raw[sep+1:], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid event id %q sequence %v", eventID, err)
+	}
+	return StreamID(raw[:sep]), seq, nil
+}
+
+//Sends, in order, the events of the stream stored after lastEventID and returns the stream ID
+func (es *FileEventStore) ReplayEventsAfter(lastEventID EventID, send ReplayEventsAfterSend) (StreamID, error) {
+	streamID, lastSeq, err := parseFileEventID(lastEventID)
+	if err != nil {
+		return "", err
+	}
+
+	es.mu.Lock()
+	if es.closed {
+		es.mu.Unlock()
+		return "", fmt.Errorf("event store is closed")
+	}
+	entries := es.index[streamID]
+	start := sort.Search(len(entries), func(i int) bool { return entries[i].seq > lastSeq })
+	limit := es.now().Add(-es.retention)
+	var pending []fileEventIndexEntry
+	for _, entry := range entries[start:] {
+		if entry.storedAt.Before(limit) {
+			continue
+		}
+		pending = append(pending, entry)
+	}
+	es.mu.Unlock()
+
+	//Read without the lock, segments are append only and the ones being read are not deleted
+	//until they are past the retention window
+	files := make(map[uint64]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, entry := range pending {
+		f, ok := files[entry.segment]
+		if !ok {
+			f, err = os.Open(es.segmentPath(entry.segment))
+			if os.IsNotExist(err) {
+				//Compacted meanwhile
+				continue
+			}
+			if err != nil {
+				return streamID, fmt.Errorf("os.Open %v", err)
+			}
+			files[entry.segment] = f
+		}
+		line := make([]byte, entry.length)
+		if _, err := f.ReadAt(line, entry.offset); err != nil {
+			return streamID, fmt.Errorf("f.ReadAt segment %d offset %d %v", entry.segment, entry.offset, err)
+		}
+		var record fileEventRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &record); err != nil {
+			return streamID, fmt.Errorf("json.Unmarshal %v", err)
+		}
+		message, err := types.NewJSONRPCRawMessage(record.Message)
+		if err != nil {
+			return streamID, fmt.Errorf("types.NewJSONRPCRawMessage %v", err)
+		}
+		send(fileEventID(streamID, record.Seq), message)
+	}
+	return streamID, nil
+}
+
+//Deletes the segments past the retention window, and the oldest ones while the total size is above MaxTotalBytes.
+//The active segment is never deleted.
+func (es *FileEventStore) Compact() error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.closed {
+		return nil
+	}
+	return es.compactLocked()
+}
+
+func (es *FileEventStore) compactLocked() error {
+	return es.compactBeforeLocked(es.now().Add(-es.retention))
+}
+
+//Deletes the sealed segments whose newest event is before the limit and the oldest ones above MaxTotalBytes
+func (es *FileEventStore) compactBeforeLocked(limit time.Time) error {
+	var totalBytes int64
+	for _, segment := range es.segments {
+		totalBytes += segment.size
+	}
+	var deleted []uint64
+	for len(es.segments) > 1 {
+		oldest := es.segments[0]
+		expired := oldest.lastStoredAt.Before(limit)
+		overSize := es.maxTotalBytes > 0 && totalBytes > es.maxTotalBytes
+		if !expired && !overSize {
+			break
+		}
+		if err := os.Remove(es.segmentPath(oldest.firstSeq)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("os.Remove %v", err)
+		}
+		totalBytes -= oldest.size
+		deleted = append(deleted, oldest.firstSeq)
+		es.segments = es.segments[1:]
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+	//Remove the index entries of the deleted segments, they are the oldest of each stream
+	firstKept := es.segments[0].firstSeq
+	for streamID, entries := range es.index {
+		start := sort.Search(len(entries), func(i int) bool { return entries[i].segment >= firstKept })
+		if start == len(entries) {
+			delete(es.index, streamID)
+			continue
+		}
+		es.index[streamID] = append([]fileEventIndexEntry(nil), entries[start:]...)
+	}
+	return nil
+}
+
+//Return the number of indexed streams and events and the size of the segments
+func (es *FileEventStore) EventStoreStats() EventStoreStats {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	stats := EventStoreStats{Streams: len(es.index)}
+	for _, entries := range es.index {
+		stats.Events += len(entries)
+	}
+	for _, segment := range es.segments {
+		stats.Bytes += segment.size
+	}
+	return stats
+}
+
+//Stops the background work, syncs and closes the active segment
+func (es *FileEventStore) Close() error {
+	es.mu.Lock()
+	if es.closed {
+		es.mu.Unlock()
+		return nil
+	}
+	es.closed = true
+	close(es.stop)
+	es.mu.Unlock()
+	es.wg.Wait()
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.active == nil {
+		return nil
+	}
+	if err := es.active.Sync(); err != nil {
+		return fmt.Errorf("active.Sync %v", err)
+	}
+	err := es.active.Close()
+	es.active = nil
+	if err != nil {
+		return fmt.Errorf("active.Close %v", err)
+	}
+	return nil
+}
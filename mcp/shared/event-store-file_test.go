@@ -0,0 +1,187 @@
+package shared
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+func openTestFileEventStore(t *testing.T, opts FileEventStoreOptions) *FileEventStore {
+	t.Helper()
+	es, err := NewFileEventStore(opts)
+	if err != nil {
+		t.Fatalf("NewFileEventStore: %v", err)
+	}
+	t.Cleanup(func() { es.Close() })
+	return es
+}
+
+func storeTestEvent(t *testing.T, es *FileEventStore, streamID StreamID, n int) EventID {
+	t.Helper()
+	eventID, err := es.StoreEvent(streamID, testEvent(n))
+	if err != nil {
+		t.Fatalf("StoreEvent: %v", err)
+	}
+	return eventID
+}
+
+//Return the IDs of the requests replayed after the event, the file store replays the original JSON
+func fileReplayedIDs(t *testing.T, es *FileEventStore, after EventID) []types.RequestID {
+	t.Helper()
+	var ids []types.RequestID
+	_, err := es.ReplayEventsAfter(after, func(eventID EventID, message types.JSONRPCMessage) {
+		var request struct {
+			ID types.RequestID `json:"id"`
+		}
+		if err := json.Unmarshal(message.(*types.JSONRPCRawMessage).Data, &request); err != nil {
+			t.Fatalf("json.Unmarshal: %v", err)
+		}
+		ids = append(ids, request.ID)
+	})
+	if err != nil {
+		t.Fatalf("ReplayEventsAfter: %v", err)
+	}
+	return ids
+}
+
+func expectIDs(t *testing.T, ids []types.RequestID, expected ...types.RequestID) {
+	t.Helper()
+	if len(ids) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, ids)
+		}
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+FILE_EVENT_STORE_SEGMENT_EXT))
+	if err != nil {
+		t.Fatalf("filepath.Glob: %v", err)
+	}
+	return files
+}
+
+func TestFileEventStoreReplayAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	es := openTestFileEventStore(t, FileEventStoreOptions{Dir: dir})
+	first := storeTestEvent(t, es, "stream", 0)
+	storeTestEvent(t, es, "stream", 1)
+	storeTestEvent(t, es, "other", 2)
+	last := storeTestEvent(t, es, "stream", 3)
+	if err := es.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	es = openTestFileEventStore(t, FileEventStoreOptions{Dir: dir})
+	expectIDs(t, fileReplayedIDs(t, es, first), 1, 3)
+	//The sequence continues after the restart
+	next := storeTestEvent(t, es, "stream", 4)
+	_, lastSeq, _ := parseFileEventID(last)
+	if _, nextSeq, _ := parseFileEventID(next); nextSeq <= lastSeq {
+		t.Fatalf("expected the sequence to continue after %d, got %d", lastSeq, nextSeq)
+	}
+	expectIDs(t, fileReplayedIDs(t, es, last), 4)
+}
+
+func TestFileEventStoreReplayAcrossSegments(t *testing.T) {
+	dir := t.TempDir()
+	//Each event fills a segment
+	es := openTestFileEventStore(t, FileEventStoreOptions{Dir: dir, MaxSegmentBytes: 1})
+	first := storeTestEvent(t, es, "stream", 0)
+	for i := 1; i <= 4; i++ {
+		storeTestEvent(t, es, "stream", i)
+	}
+	if files := segmentFiles(t, dir); len(files) != 5 {
+		t.Fatalf("expected 5 segments, got %v", files)
+	}
+	expectIDs(t, fileReplayedIDs(t, es, first), 1, 2, 3, 4)
+
+	if err := es.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	es = openTestFileEventStore(t, FileEventStoreOptions{Dir: dir, MaxSegmentBytes: 1})
+	expectIDs(t, fileReplayedIDs(t, es, first), 1, 2, 3, 4)
+}
+
+func TestFileEventStoreCompactKeepsLiveEvents(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	opts := FileEventStoreOptions{Dir: dir, MaxSegmentBytes: 1, Retention: time.Minute, Now: func() time.Time { return now }}
+	es := openTestFileEventStore(t, opts)
+	expired := storeTestEvent(t, es, "expired", 0)
+	storeTestEvent(t, es, "expired", 1)
+	now = now.Add(2 * time.Minute)
+	live := storeTestEvent(t, es, "live", 2)
+	storeTestEvent(t, es, "live", 3)
+
+	if err := es.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if files := segmentFiles(t, dir); len(files) != 2 {
+		t.Fatalf("expected only the segments of the live events, got %v", files)
+	}
+	if stats := es.EventStoreStats(); stats.Streams != 1 || stats.Events != 2 {
+		t.Fatalf("expected only the live stream, got %+v", stats)
+	}
+	expectIDs(t, fileReplayedIDs(t, es, expired))
+	liveStream, _, _ := parseFileEventID(live)
+	expectIDs(t, fileReplayedIDs(t, es, fileEventID(liveStream, 0)), 2, 3)
+
+	//Nothing comes back after a restart
+	if err := es.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	es = openTestFileEventStore(t, opts)
+	expectIDs(t, fileReplayedIDs(t, es, expired))
+	expectIDs(t, fileReplayedIDs(t, es, fileEventID(liveStream, 0)), 2, 3)
+}
+
+func TestFileEventStoreTruncatesTrailingRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		record string
+	}{
+		{"partial", `{"seq":3,"stream":"str`},
+		{"corrupt", "\x00\x00\x00\x00\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			es := openTestFileEventStore(t, FileEventStoreOptions{Dir: dir})
+			first := storeTestEvent(t, es, "stream", 0)
+			storeTestEvent(t, es, "stream", 1)
+			if err := es.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			files := segmentFiles(t, dir)
+			if len(files) != 1 {
+				t.Fatalf("expected 1 segment, got %v", files)
+			}
+			//A crash during the write of the next event
+			f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				t.Fatalf("os.OpenFile: %v", err)
+			}
+			f.WriteString(tt.record)
+			f.Close()
+
+			es = openTestFileEventStore(t, FileEventStoreOptions{Dir: dir})
+			expectIDs(t, fileReplayedIDs(t, es, first), 1)
+			//New events are appended after the last valid one
+			storeTestEvent(t, es, "stream", 2)
+			if err := es.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			es = openTestFileEventStore(t, FileEventStoreOptions{Dir: dir})
+			expectIDs(t, fileReplayedIDs(t, es, first), 1, 2)
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package shared
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+const (
+	DEFAULT_MEMORY_EVENT_STORE_MAX_EVENTS_PER_STREAM = 1000
+	DEFAULT_MEMORY_EVENT_STORE_MAX_STREAMS           = 1000
+	//Initial capacity of the buffer of a stream, doubled as the events are stored up to MaxEventsPerStream
+	MEMORY_EVENT_STREAM_INITIAL_CAPACITY = 16
+)
+
+type MemoryEventStoreOptions struct {
+	//Maximum number of events kept per stream, when the buffer is full the oldest event is dropped.
+	//Default is DEFAULT_MEMORY_EVENT_STORE_MAX_EVENTS_PER_STREAM.
+	MaxEventsPerStream int
+	//Maximum number of streams kept, when a new stream would exceed it the stream with the oldest last event is dropped.
+	//Default is DEFAULT_MEMORY_EVENT_STORE_MAX_STREAMS.
+	MaxStreams int
+	//Events older than this are dropped, streams without events are removed.
+	//If is 0 events are kept until they are pushed out by MaxEventsPerStream.
+	MaxEventAge time.Duration
+	//Return the current time, useful for tests.
+	//
+	//If is nil time.Now is used.
+	Now func() time.Time
+}
+
+type memoryEvent struct {
+	seq      uint64
+	message  types.JSONRPCMessage
+	storedAt time.Time
+}
+
+//Ring buffer of the events of one stream, it grows as the events are stored up to maxEvents
+type memoryEventStream struct {
+	events    []memoryEvent
+	start     int
+	count     int
+	maxEvents int
+}
+
+func (ms *memoryEventStream) push(event memoryEvent) {
+	if ms.count == len(ms.events) && len(ms.events) < ms.maxEvents {
+		ms.grow()
+	}
+	if ms.count < len(ms.events) {
+		ms.events[(ms.start+ms.count)%len(ms.events)] = event
+		ms.count++
+		return
+	}
+	//Full, overwrite the oldest
+	ms.events[ms.start] = event
+	ms.start = (ms.start + 1) % len(ms.events)
+}
+
+//Doubles the buffer, up to maxEvents, keeping the events in order from the start
+func (ms *memoryEventStream) grow() {
+	size := len(ms.events) * 2
+	if size < MEMORY_EVENT_STREAM_INITIAL_CAPACITY {
+		size = MEMORY_EVENT_STREAM_INITIAL_CAPACITY
+	}
+	if size > ms.maxEvents {
+		size = ms.maxEvents
+	}
+	events := make([]memoryEvent, size)
+	for i := 0; i < ms.count; i++ {
+		events[i] = ms.at(i)
+	}
+	ms.events = events
+	ms.start = 0
+}
+
+//Return the time the last event was stored, zero if the stream is empty
+func (ms *memoryEventStream) lastStoredAt() time.Time {
+	if ms.count == 0 {
+		return time.Time{}
+	}
+	return ms.at(ms.count - 1).storedAt
+}
+
+func (ms *memoryEventStream) at(i int) memoryEvent {
+	return ms.events[(ms.start+i)%len(ms.events)]
+}
+
+//Drops the events stored before the limit
+func (ms *memoryEventStream) dropBefore(limit time.Time) {
+	for ms.count > 0 && ms.at(0).storedAt.Before(limit) {
+		ms.events[ms.start] = memoryEvent{}
+		ms.start = (ms.start + 1) % len(ms.events)
+		ms.count--
+	}
+}
+
+//In-memory EventStore with a bounded ring buffer per stream and a bounded number of streams.
+//
+//Event IDs have the form <streamID>_<storeID>-<sequence>, the sequence is monotonic across all the streams of the store
+//so ReplayEventsAfter can locate the stream and the position from the ID alone. The storeID is random per instance,
+//IDs issued by another instance (e.g. before a restart) are rejected instead of replaying unrelated events.
+//
+//Replay is best effort, events dropped by the retention limits are not sent.
+type MemoryEventStore struct {
+	mu                 sync.Mutex
+	storeID            string
+	lastSeq            uint64
+	streams            map[StreamID]*memoryEventStream
+	maxEventsPerStream int
+	maxStreams         int
+	maxEventAge        time.Duration
+	now                func() time.Time
+}
+
+func NewMemoryEventStore(opts MemoryEventStoreOptions) *MemoryEventStore {
+	b := make([]byte, 4)
+	rand.Read(b)
+	nes := &MemoryEventStore{
+		storeID:            hex.EncodeToString(b),
+		streams:            make(map[StreamID]*memoryEventStream),
+		maxEventsPerStream: opts.MaxEventsPerStream,
+		maxStreams:         opts.MaxStreams,
+		maxEventAge:        opts.MaxEventAge,
+		now:                opts.Now,
+	}
+	if nes.maxEventsPerStream <= 0 {
+		nes.maxEventsPerStream = DEFAULT_MEMORY_EVENT_STORE_MAX_EVENTS_PER_STREAM
+	}
+	if nes.maxStreams <= 0 {
+		nes.maxStreams = DEFAULT_MEMORY_EVENT_STORE_MAX_STREAMS
+	}
+	if nes.now == nil {
+		nes.now = time.Now
+	}
+	return nes
+}
+
+func (es *MemoryEventStore) eventID(streamID StreamID, seq uint64) EventID {
+	return EventID(fmt.Sprintf("%s_%s-%d", streamID, es.storeID, seq))
+}
+
+//Return the stream and sequence encoded in the event ID
+func (es *MemoryEventStore) parseEventID(eventID EventID) (StreamID, uint64, error) {
+	raw := string(eventID)
+	sep := strings.LastIndex(raw, "_")
+	if sep < 0 {
+		return "", 0, fmt.Errorf("invalid event id %q", eventID)
+	}
+	parts := strings.SplitN(raw[sep+1:], "-", 2)
+	if len(parts) != 2 || parts[0] != es.storeID {
+		return "", 0, fmt.Errorf("event id %q was not issued by this store", eventID)
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid event id %q sequence %v", eventID, err)
+	}
+	return StreamID(raw[:sep]), seq, nil
+}
+
+//Drops the expired events of all the streams, must be called with the lock held
+func (es *MemoryEventStore) pruneExpired(now time.Time) {
+	if es.maxEventAge <= 0 {
+		return
+	}
+	limit := now.Add(-es.maxEventAge)
+	for streamID, stream := range es.streams {
+		stream.dropBefore(limit)
+		if stream.count == 0 {
+			delete(es.streams, streamID)
+		}
+	}
+}
+
+//Drops the stream with the oldest last event, must be called with the lock held
+func (es *MemoryEventStore) dropOldestStream() {
+	var oldestID StreamID
+	var oldest time.Time
+	first := true
+	for streamID, stream := range es.streams {
+		if last := stream.lastStoredAt(); first || last.Before(oldest) {
+			oldestID, oldest, first = streamID, last, false
+		}
+	}
+	delete(es.streams, oldestID)
+}
+
+//Stores an event for later retrieval
+func (es *MemoryEventStore) StoreEvent(streamID StreamID, message types.JSONRPCMessage) (EventID, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	now := es.now()
+	es.pruneExpired(now)
+
+	stream, ok := es.streams[streamID]
+	if !ok {
+		if len(es.streams) >= es.maxStreams {
+			es.dropOldestStream()
+		}
+		stream = &memoryEventStream{maxEvents: es.maxEventsPerStream}
+		es.streams[streamID] = stream
+	}
+	es.lastSeq++
+	stream.push(memoryEvent{seq: es.lastSeq, message: message, storedAt: now})
+	return es.eventID(streamID, es.lastSeq), nil
+}
+
+//Sends, in order, the events of the stream stored after lastEventID and returns the stream ID
+func (es *MemoryEventStore) ReplayEventsAfter(lastEventID EventID, send ReplayEventsAfterSend) (StreamID, error) {
+	streamID, lastSeq, err := es.parseEventID(lastEventID)
+	if err != nil {
+		return "", err
+	}
+
+	es.mu.Lock()
+	es.pruneExpired(es.now())
+	var pending []memoryEvent
+	if stream, ok := es.streams[streamID]; ok {
+		for i := 0; i < stream.count; i++ {
+			if event := stream.at(i); event.seq > lastSeq {
+				pending = append(pending, event)
+			}
+		}
+	}
+	es.mu.Unlock()
+
+	//send is called without the lock, it can be slow or store new events
+	for _, event := range pending {
+		send(es.eventID(streamID, event.seq), event.message)
+	}
+	return streamID, nil
+}
+
+//Deletes all the events of the stream
+func (es *MemoryEventStore) DeleteStream(streamID StreamID) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	delete(es.streams, streamID)
+	return nil
+}
+
+//Deletes the events of all the streams stored before the time
+func (es *MemoryEventStore) ExpireEvents(before time.Time) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for streamID, stream := range es.streams {
+		stream.dropBefore(before)
+		if stream.count == 0 {
+			delete(es.streams, streamID)
+		}
+	}
+	return nil
+}
+
+//Return the number of streams and events kept
+func (es *MemoryEventStore) EventStoreStats() EventStoreStats {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	stats := EventStoreStats{Streams: len(es.streams)}
+	for _, stream := range es.streams {
+		stats.Events += stream.count
+	}
+	return stats
+}
@@ -0,0 +1,99 @@
+package shared
+
+import (
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+func testEvent(n int) types.JSONRPCMessage {
+	return &types.JSONRPCRequest{JSONRPC: types.JSONRPC_VERSION, ID: types.RequestID(n), RequestInterface: types.NewPingRequest()}
+}
+
+//Return the IDs of the requests replayed after the event
+func replayedIDs(t *testing.T, es *MemoryEventStore, after EventID) []types.RequestID {
+	t.Helper()
+	var ids []types.RequestID
+	_, err := es.ReplayEventsAfter(after, func(eventID EventID, message types.JSONRPCMessage) {
+		ids = append(ids, message.(*types.JSONRPCRequest).ID)
+	})
+	if err != nil {
+		t.Fatalf("ReplayEventsAfter: %v", err)
+	}
+	return ids
+}
+
+func TestMemoryEventStoreEvictsByCount(t *testing.T) {
+	es := NewMemoryEventStore(MemoryEventStoreOptions{MaxEventsPerStream: 3})
+	first, _ := es.StoreEvent("stream", testEvent(0))
+	for i := 1; i <= 5; i++ {
+		es.StoreEvent("stream", testEvent(i))
+	}
+	ids := replayedIDs(t, es, first)
+	if len(ids) != 3 || ids[0] != 3 || ids[2] != 5 {
+		t.Fatalf("expected the last 3 events, got %v", ids)
+	}
+	if len(es.streams["stream"].events) != 3 {
+		t.Fatalf("expected the buffer to stop growing at MaxEventsPerStream, got %d", len(es.streams["stream"].events))
+	}
+}
+
+func TestMemoryEventStoreEvictsByAge(t *testing.T) {
+	now := time.Now()
+	es := NewMemoryEventStore(MemoryEventStoreOptions{MaxEventAge: time.Minute, Now: func() time.Time { return now }})
+	first, _ := es.StoreEvent("stream", testEvent(0))
+	es.StoreEvent("stream", testEvent(1))
+	now = now.Add(45 * time.Second)
+	es.StoreEvent("stream", testEvent(2))
+	es.StoreEvent("idle", testEvent(3))
+
+	now = now.Add(30 * time.Second)
+	ids := replayedIDs(t, es, first)
+	if len(ids) != 1 || ids[0] != 2 {
+		t.Fatalf("expected only the event younger than MaxEventAge, got %v", ids)
+	}
+
+	now = now.Add(time.Minute)
+	es.StoreEvent("other", testEvent(4))
+	if stats := es.EventStoreStats(); stats.Streams != 1 || stats.Events != 1 {
+		t.Fatalf("expected the streams without events to be removed, got %+v", stats)
+	}
+}
+
+func TestMemoryEventStoreGrowsLazily(t *testing.T) {
+	es := NewMemoryEventStore(MemoryEventStoreOptions{})
+	first, _ := es.StoreEvent("stream", testEvent(0))
+	if size := len(es.streams["stream"].events); size != MEMORY_EVENT_STREAM_INITIAL_CAPACITY {
+		t.Fatalf("expected an initial buffer of %d events, got %d", MEMORY_EVENT_STREAM_INITIAL_CAPACITY, size)
+	}
+	for i := 1; i <= MEMORY_EVENT_STREAM_INITIAL_CAPACITY*2; i++ {
+		es.StoreEvent("stream", testEvent(i))
+	}
+	ids := replayedIDs(t, es, first)
+	if len(ids) != MEMORY_EVENT_STREAM_INITIAL_CAPACITY*2 {
+		t.Fatalf("expected %d events, got %d", MEMORY_EVENT_STREAM_INITIAL_CAPACITY*2, len(ids))
+	}
+	for i, id := range ids {
+		if id != types.RequestID(i+1) {
+			t.Fatalf("expected the events in order, got %v", ids)
+		}
+	}
+}
+
+func TestMemoryEventStoreBoundsStreams(t *testing.T) {
+	now := time.Now()
+	es := NewMemoryEventStore(MemoryEventStoreOptions{MaxStreams: 2, Now: func() time.Time { return now }})
+	es.StoreEvent("a", testEvent(0))
+	now = now.Add(time.Second)
+	es.StoreEvent("b", testEvent(1))
+	now = now.Add(time.Second)
+	//a is written after b, b becomes the stream with the oldest last event
+	es.StoreEvent("a", testEvent(2))
+	now = now.Add(time.Second)
+	es.StoreEvent("c", testEvent(3))
+
+	if _, ok := es.streams["b"]; ok || len(es.streams) != 2 {
+		t.Fatalf("expected the stream b to be dropped, got %v", es.streams)
+	}
+}
@@ -1,6 +1,12 @@
 package shared
 
-import "github.com/victorvbello/gomcp/mcp/types"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
 
 type StreamID string
 type EventID string
@@ -15,3 +21,47 @@ type EventStore interface {
 	StoreEvent(streamId StreamID, message types.JSONRPCMessage) (EventID, error)
 	ReplayEventsAfter(lastEventID EventID, send ReplayEventsAfterSend) (StreamID, error)
 }
+
+//Optional interface of an EventStore that can remove events, the transports check it with a type assertion
+//so stores implementing only EventStore keep working.
+type EventStoreCleaner interface {
+	//Deletes all the events of the stream, replaying after any of its event IDs sends nothing
+	//@param streamId ID of the stream to delete
+	DeleteStream(streamId StreamID) error
+	//Deletes the events of all the streams stored before the time
+	//@param before Events stored before this time are deleted
+	ExpireEvents(before time.Time) error
+}
+
+//Size of an event store
+type EventStoreStats struct {
+	Streams int
+	Events  int
+	Bytes   int64
+}
+
+//Optional interface of an EventStore that reports its size, used by the metrics
+type EventStoreStatsProvider interface {
+	EventStoreStats() EventStoreStats
+}
+
+//Deletes the streams from the store if it implements EventStoreCleaner,
+//used when a session ends to purge all its streams.
+//
+//Return false if the store does not support cleanup
+func PurgeEventStreams(store EventStore, streamIDs []StreamID) (bool, error) {
+	cleaner, ok := store.(EventStoreCleaner)
+	if !ok {
+		return false, nil
+	}
+	var errs []string
+	for _, streamID := range streamIDs {
+		if err := cleaner.DeleteStream(streamID); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", streamID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return true, fmt.Errorf("cleaner.DeleteStream %s", strings.Join(errs, ", "))
+	}
+	return true, nil
+}
@@ -16,7 +16,11 @@ func MakeAuthInfoRequest(req *http.Request, authInfo types.AuthInfo) context.Con
 	return ctx
 }
 
-func GetAuthInfoRequest(r *http.Request) types.AuthInfo {
-	value := r.Context().Value(AUTH_INFO_REQUEST_KEY_NAME)
-	return value.(types.AuthInfo)
+//Return the AuthInfo stored by MakeAuthInfoRequest, nil if the request is not authenticated
+func GetAuthInfoRequest(r *http.Request) *types.AuthInfo {
+	value, ok := r.Context().Value(AUTH_INFO_REQUEST_KEY_NAME).(types.AuthInfo)
+	if !ok {
+		return nil
+	}
+	return &value
 }
@@ -0,0 +1,82 @@
+package shared
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/utils/metrics"
+)
+
+const (
+	METRIC_REQUESTS_TOTAL             = "mcp_requests_total"
+	METRIC_REQUEST_ERRORS_TOTAL       = "mcp_request_errors_total"
+	METRIC_REQUEST_DURATION_SECONDS   = "mcp_request_duration_seconds"
+	METRIC_REQUESTS_IN_FLIGHT         = "mcp_requests_in_flight"
+	METRIC_TOOL_CALLS_TOTAL           = "mcp_tool_calls_total"
+	METRIC_ACTIVE_SESSIONS            = "mcp_active_sessions"
+	METRIC_SSE_STREAMS                = "mcp_sse_streams"
+	METRIC_EVENT_STORE_STREAMS        = "mcp_event_store_streams"
+	METRIC_EVENT_STORE_EVENTS         = "mcp_event_store_events"
+	METRIC_EVENT_STORE_BYTES          = "mcp_event_store_bytes"
+	METRIC_LABEL_METHOD_UNKNOWN       = "unknown"
+	METRIC_REQUEST_RESULT_CODE_NO_ERR = 0
+)
+
+//Instruments of the incoming requests
+type protocolMetrics struct {
+	requests *metrics.CounterVec
+	errors   *metrics.CounterVec
+	duration *metrics.HistogramVec
+	inFlight *metrics.GaugeVec
+}
+
+//Return nil when the registry is nil, the methods of a nil protocolMetrics do nothing
+func newProtocolMetrics(registry *metrics.Registry) *protocolMetrics {
+	if registry == nil {
+		return nil
+	}
+	return &protocolMetrics{
+		requests: registry.NewCounterVec(METRIC_REQUESTS_TOTAL, "Requests received by method.", "method"),
+		errors:   registry.NewCounterVec(METRIC_REQUEST_ERRORS_TOTAL, "Requests answered with an error by method and JSON-RPC error code.", "method", "code"),
+		duration: registry.NewHistogramVec(METRIC_REQUEST_DURATION_SECONDS, "Time to handle the requests by method.", nil, "method"),
+		inFlight: registry.NewGaugeVec(METRIC_REQUESTS_IN_FLIGHT, "Requests being handled."),
+	}
+}
+
+func (pm *protocolMetrics) requestStarted() time.Time {
+	if pm != nil {
+		pm.inFlight.Inc()
+	}
+	return time.Now()
+}
+
+//Records a handled request, code is METRIC_REQUEST_RESULT_CODE_NO_ERR on success
+func (pm *protocolMetrics) requestFinished(method string, start time.Time, code int) {
+	if pm == nil {
+		return
+	}
+	pm.inFlight.Dec()
+	pm.requests.Inc(method)
+	pm.duration.Observe(time.Since(start).Seconds(), method)
+	if code != METRIC_REQUEST_RESULT_CODE_NO_ERR {
+		pm.errors.Inc(method, strconv.Itoa(code))
+	}
+}
+
+//Registers the size gauges of an event store implementing EventStoreStatsProvider,
+//only the first store registered in the registry is reported
+func RegisterEventStoreMetrics(registry *metrics.Registry, store EventStore) {
+	provider, ok := store.(EventStoreStatsProvider)
+	if registry == nil || !ok {
+		return
+	}
+	registry.NewGaugeFunc(METRIC_EVENT_STORE_STREAMS, "Streams with events in the event store.", func() float64 {
+		return float64(provider.EventStoreStats().Streams)
+	})
+	registry.NewGaugeFunc(METRIC_EVENT_STORE_EVENTS, "Events kept in the event store.", func() float64 {
+		return float64(provider.EventStoreStats().Events)
+	})
+	registry.NewGaugeFunc(METRIC_EVENT_STORE_BYTES, "Bytes used by the event store, 0 if the store does not track it.", func() float64 {
+		return float64(provider.EventStoreStats().Bytes)
+	})
+}
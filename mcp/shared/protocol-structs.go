@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/victorvbello/gomcp/mcp/types"
+	"github.com/victorvbello/gomcp/mcp/utils/metrics"
+	"github.com/victorvbello/gomcp/mcp/utils/tracing"
 )
 
 const (
@@ -51,30 +53,33 @@ type RequestOptions struct {
 	TransportSendOptions
 	//If set, requests progress notifications from the remote end (if supported). When progress notifications are received, this callback will be invoked.
 	Onprogress types.ProgressCallback
-	//This only can be a WithCancel context
-	Context context.Context
-	//This is required, if not set by default create new context
-	ContextCancelFunc context.CancelFunc
-	//A timeout (in milliseconds) for this request. If exceeded, an McpError with code `RequestTimeout` will be raised from request().
+	//Time to wait for the response without progress. If exceeded, an McpError with code `RequestTimeout` is returned by Request.
 	//
-	//If not specified, `DEFAULT_REQUEST_TIMEOUT_MSEC` will be used as the timeout.
+	//If not specified, DEFAULT_REQUEST_TIMEOUT is used, a negative value disables it and only the context deadline applies.
 	Timeout time.Duration
 	//If true, receiving a progress notification will reset the request timeout.
 	//This is useful for long-running operations that send periodic progress updates.
 	//Default: false
 	ResetTimeoutOnProgress *bool
-	//Maximum total time (in milliseconds) to wait for a response.
-	//If exceeded, an McpError with code `RequestTimeout` will be raised, regardless of progress notifications.
+	//Maximum total time to wait for a response.
+	//If exceeded, an McpError with code `RequestTimeout` is returned, regardless of progress notifications.
 	//If not specified, there is no maximum total timeout.
 	MaxTotalTimeout time.Duration
-}
-
-//Check if context was canceled
-func (ro *RequestOptions) Canceled() bool {
-	if ro.Context == nil {
-		return false
-	}
-	return ro.Context.Err() == context.Canceled
+	//Decodes the result of the response, for results that are not of the type registered for the method.
+	//
+	//If not specified, the type or decoder registered with types.RegisterResultType or types.RegisterResultDecoder is used.
+	ResultDecoder types.ResultDecoder
+	//If set, the request is sent again when an attempt fails with a transient error, only if the request is idempotent.
+	//
+	//Each attempt is a new request with its own timeouts, the context given to Request bounds all of them.
+	RetryPolicy *RetryPolicy
+	//Marks the request as idempotent so RetryPolicy applies to it.
+	//The requests of the methods in methods.IDEMPOTENT_REQUEST_METHODS are always idempotent, a tools/call is idempotent
+	//if the tool was listed by a tools/list request of the connection with annotations that hint that it is read-only or idempotent.
+	Idempotent bool
+	//Context of the request being handled when the request is sent through RequestHandlerExtra.SendRequest,
+	//its span is the parent of the span of the request if the context given to Request has none
+	parentContext context.Context
 }
 
 type RequestHandlerExtra struct {
@@ -98,7 +103,7 @@ type RequestHandlerExtra struct {
 	//Sends a request that relates to the current request being handled.
 	//
 	//This is used by certain transports to correctly associate related messages.
-	SendRequest func(request types.RequestInterface, opts *RequestOptions) (types.ResultInterface, error)
+	SendRequest func(ctx context.Context, request types.RequestInterface, opts *RequestOptions) (types.ResultInterface, error)
 }
 
 //Check if context was canceled
@@ -113,44 +118,93 @@ type ProtocolOptions struct {
 	//
 	//Currently this defaults to false, for backwards compatibility with SDK versions that did not advertise capabilities correctly. In future, this will default to true.
 	EnforceStrictCapabilities *bool
+	//Registry where the incoming requests are recorded (counts, errors, latencies, in-flight).
+	//If is nil no metrics are recorded.
+	Metrics *metrics.Registry
+	//Creates the spans of the requests and notifications sent and received.
+	//The W3C trace context is propagated in the _meta of the messages even if is nil.
+	Tracer tracing.Tracer
+	//Sends pings to the remote side and closes the connection when it stops answering.
+	//If is nil no pings are sent.
+	KeepAlive *KeepAliveOptions
+	//Limits the incoming requests handled at the same time.
+	//If is nil the requests are not limited.
+	ConcurrencyLimits *ConcurrencyLimits
+}
+
+//Maximum incoming requests handled at the same time, 0 means no limit.
+//
+//A request over a limit waits in the queue of the limit for a free slot, it is answered with an error with code
+//ERROR_CODE_CONCURRENCY_LIMIT_EXCEEDED when the queue is full or the wait times out.
+type ConcurrencyLimits struct {
+	//Requests of the session (a connection for the transports without sessions), ping is not counted
+	//so the keepalive of the remote side keeps working.
+	MaxInFlight int
+	//Requests by method, e.g. "tools/call".
+	MaxInFlightPerMethod map[string]int
+	//Calls by tool name, applied by McpServer.
+	MaxInFlightPerTool map[string]int
+	//Requests waiting for a slot of each limit, 0 rejects the requests over the limit immediately.
+	MaxQueue int
+	//Maximum time waiting for a slot.
+	//Default is DEFAULT_CONCURRENCY_QUEUE_TIMEOUT, a negative value waits until the request is cancelled.
+	QueueTimeout time.Duration
+}
+
+type KeepAliveOptions struct {
+	//Time between pings.
+	//Default is DEFAULT_KEEPALIVE_INTERVAL.
+	Interval time.Duration
+	//Time to wait for each pong.
+	//Default is the Interval.
+	Timeout time.Duration
+	//Consecutive unanswered pings after which the remote side is declared dead.
+	//Default is DEFAULT_KEEPALIVE_MAX_FAILURES.
+	MaxFailures int
+	//Time the remote side can go without sending a message while the pings can not be sent to it, e.g. a streamable
+	//HTTP session without a GET stream, after which it is declared dead and the transport closed.
+	//Default is DEFAULT_KEEPALIVE_IDLE_TIMEOUT, a negative value disables it.
+	IdleTimeout time.Duration
+	//Called when the remote side is declared dead, after the transport is closed and the pending requests failed.
+	OnPeerDead func(err error)
 }
 
 //Options that can be given per notification.
 type NotificationOptions struct {
 	//May be used to indicate to the transport which incoming request to associate this outgoing notification with.
 	RelatedRequestID types.RequestID
+	//Context whose span is the parent of the span of the notification
+	parentContext context.Context
+}
+
+//Response or error received for a request
+type requestResult struct {
+	r types.ResultInterface
+	e error
 }
 
-//Information about a request's timeout state
+//Information about a request's timeout state, the timers are owned by the Request waiting the response
 type timeoutConfig struct {
-	StartTime              time.Time
 	Timeout                time.Duration
-	MaxTotalTimeout        time.Duration
 	ResetTimeoutOnProgress bool
-	OnTimeout              func()
-	ctx                    context.Context
-	contextCancel          context.CancelFunc
-}
-
-//Start timeout using context with cancel
-func (t *timeoutConfig) Start() {
-	t.ctx, t.contextCancel = context.WithCancel(context.Background())
-	go func() {
-		select {
-		case <-t.ctx.Done():
-			return
-		case <-time.After(t.Timeout):
-			t.OnTimeout()
-		}
-	}()
+	//Signals the Request to restart its timeout
+	reset chan struct{}
 }
 
-//Call the cancel func of context
-func (t *timeoutConfig) Clear() {
-	if t.contextCancel == nil {
-		return
+func newTimeoutConfig(timeout time.Duration, resetTimeoutOnProgress bool) *timeoutConfig {
+	return &timeoutConfig{
+		Timeout:                timeout,
+		ResetTimeoutOnProgress: resetTimeoutOnProgress,
+		reset:                  make(chan struct{}, 1),
+	}
+}
+
+//Asks the Request to restart its timeout, pending resets are merged
+func (t *timeoutConfig) Reset() {
+	select {
+	case t.reset <- struct{}{}:
+	default:
 	}
-	t.contextCancel()
 }
 
 //muxRequestMessageID
@@ -167,13 +221,127 @@ func (xi *muxRequestMessageID) Get() int {
 }
 
 func (xi *muxRequestMessageID) Increase() int {
-	xi.mu.RLock()
+	xi.mu.Lock()
 	xi.i += 1
 	val := xi.i
-	xi.mu.RUnlock()
+	xi.mu.Unlock()
 	return val
 }
 
+//muxInFlightRequests, counts the requests being handled until the protocol shuts down
+type muxInFlightRequests struct {
+	mu       sync.Mutex
+	count    int
+	shutdown bool
+	//Closed when the protocol shuts down and no request is being handled
+	idle chan struct{}
+}
+
+//Counts a new request, return false when the protocol is shutting down
+func (xi *muxInFlightRequests) Add() bool {
+	xi.mu.Lock()
+	defer xi.mu.Unlock()
+	if xi.shutdown {
+		return false
+	}
+	xi.count++
+	return true
+}
+
+func (xi *muxInFlightRequests) Done() {
+	xi.mu.Lock()
+	defer xi.mu.Unlock()
+	xi.count--
+	if xi.shutdown && xi.count == 0 {
+		close(xi.idle)
+	}
+}
+
+//Stops counting new requests, return the channel closed once the requests being handled finish
+func (xi *muxInFlightRequests) Shutdown() <-chan struct{} {
+	xi.mu.Lock()
+	defer xi.mu.Unlock()
+	if !xi.shutdown {
+		xi.shutdown = true
+		xi.idle = make(chan struct{})
+		if xi.count == 0 {
+			close(xi.idle)
+		}
+	}
+	return xi.idle
+}
+
+//Counts the new requests again, used when the protocol connects to a new transport
+func (xi *muxInFlightRequests) Reset() {
+	xi.mu.Lock()
+	xi.shutdown = false
+	xi.mu.Unlock()
+}
+
+//muxNotificationQueue, runs the notification handlers of a connection one at a time in the order they were received
+//on its own goroutine, so the reader of the transport never waits on a handler, e.g. one sending a request
+type muxNotificationQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	closed  bool
+	//Signaled when a handler is pushed or the queue is closed
+	wake chan struct{}
+}
+
+//Return a queue whose handlers run until it is closed
+func newMuxNotificationQueue() *muxNotificationQueue {
+	xq := &muxNotificationQueue{wake: make(chan struct{}, 1)}
+	go xq.run()
+	return xq
+}
+
+//Adds a handler after the pending ones, ignored once the queue is closed
+func (xq *muxNotificationQueue) Push(handler func()) {
+	xq.mu.Lock()
+	if xq.closed {
+		xq.mu.Unlock()
+		return
+	}
+	xq.pending = append(xq.pending, handler)
+	xq.mu.Unlock()
+	xq.signal()
+}
+
+//Stops the queue once the pending handlers have run
+func (xq *muxNotificationQueue) Close() {
+	xq.mu.Lock()
+	xq.closed = true
+	xq.mu.Unlock()
+	xq.signal()
+}
+
+func (xq *muxNotificationQueue) signal() {
+	select {
+	case xq.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (xq *muxNotificationQueue) run() {
+	for {
+		xq.mu.Lock()
+		if len(xq.pending) == 0 {
+			closed := xq.closed
+			xq.mu.Unlock()
+			if closed {
+				return
+			}
+			<-xq.wake
+			continue
+		}
+		handler := xq.pending[0]
+		xq.pending[0] = nil
+		xq.pending = xq.pending[1:]
+		xq.mu.Unlock()
+		handler()
+	}
+}
+
 //muxMapRequestHandlerCancel
 type muxMapRequestHandlerCancel struct {
 	mu sync.RWMutex
@@ -401,3 +569,34 @@ func (xm *muxMapTimeoutConfig) Delete(key int) {
 	delete(xm.m, key)
 	xm.mu.Unlock()
 }
+
+//muxMapIdempotentTools
+type muxMapIdempotentTools struct {
+	mu sync.RWMutex
+	m  map[string]bool
+}
+
+func newMuxMapIdempotentTools() *muxMapIdempotentTools {
+	return &muxMapIdempotentTools{
+		m: make(map[string]bool),
+	}
+}
+
+func (xm *muxMapIdempotentTools) Clear() {
+	xm.mu.Lock()
+	xm.m = make(map[string]bool)
+	xm.mu.Unlock()
+}
+
+func (xm *muxMapIdempotentTools) Get(key string) bool {
+	xm.mu.RLock()
+	val := xm.m[key]
+	xm.mu.RUnlock()
+	return val
+}
+
+func (xm *muxMapIdempotentTools) Set(key string, value bool) {
+	xm.mu.Lock()
+	xm.m[key] = value
+	xm.mu.Unlock()
+}
@@ -2,33 +2,56 @@ package shared
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/victorvbello/gomcp/mcp/methods"
 	"github.com/victorvbello/gomcp/mcp/types"
 	utils "github.com/victorvbello/gomcp/mcp/utils/logger"
+	"github.com/victorvbello/gomcp/mcp/utils/tracing"
 )
 
 const (
-	//The default request timeout, in miliseconds.
-	DEFAULT_REQUEST_TIMEOUT_MSEC = 60 * time.Millisecond
+	//The default time to wait for the response of a request.
+	DEFAULT_REQUEST_TIMEOUT = 60 * time.Second
+	//Deprecated: use DEFAULT_REQUEST_TIMEOUT, this was 60ms by mistake.
+	DEFAULT_REQUEST_TIMEOUT_MSEC   = DEFAULT_REQUEST_TIMEOUT
+	DEFAULT_KEEPALIVE_INTERVAL     = 30 * time.Second
+	DEFAULT_KEEPALIVE_MAX_FAILURES = 3
+	DEFAULT_KEEPALIVE_IDLE_TIMEOUT = 10 * time.Minute
 )
 
 var ErrCancelFunNotFound = fmt.Errorf("cancel function not found")
 
 type Protocol struct {
+	//Unix nanoseconds of the last message received, first field so it is 64-bit aligned for the atomic access
+	lastMessageAt        int64
 	logger               utils.LogService
 	owner                ProtocolInterface
 	transport            Transport
 	requestMessageID     *muxRequestMessageID
 	requestHandlerCancel *muxMapRequestHandlerCancel
+	inFlightRequests     *muxInFlightRequests
 	requestHandlers      *muxMapRequestHandlers
 	responseHandlers     *muxMapResponseHandlers
 	notificationHandlers *muxMapNotificationHandlers
 	progressHandlers     *muxMapProgressHandlers
 	timeoutInfo          *muxMapTimeoutConfig
 	options              *ProtocolOptions
+	metrics              *protocolMetrics
+	tracer               tracing.Tracer
+	sessionLimiter       *ConcurrencyLimiter
+	methodLimiters       map[string]*ConcurrencyLimiter
+	//Done when the connection closes, parent of the contexts of the handlers
+	connectionCtx    context.Context
+	connectionCancel context.CancelFunc
+	//Notification handlers of the connection
+	notifications *muxNotificationQueue
+	//Idempotency of the tools of the remote side, from the annotations of the tools/list results
+	idempotentTools *muxMapIdempotentTools
 }
 
 func NewProtocol(opts *ProtocolOptions, pi ProtocolInterface) *Protocol {
@@ -36,22 +59,34 @@ func NewProtocol(opts *ProtocolOptions, pi ProtocolInterface) *Protocol {
 		owner:                pi,
 		requestMessageID:     new(muxRequestMessageID),
 		requestHandlerCancel: newMuxMapRequestHandlerCancel(),
+		inFlightRequests:     new(muxInFlightRequests),
 		requestHandlers:      newMuxMapRequestHandlers(),
 		responseHandlers:     newMuxMapResponseHandlers(),
 		notificationHandlers: newMuxMapNotificationHandlers(),
 		progressHandlers:     newMuxMapProgressHandlers(),
 		timeoutInfo:          newMuxMapTimeoutConfig(),
+		idempotentTools:      newMuxMapIdempotentTools(),
 		logger:               utils.NewLoggerService(),
 		options:              opts,
 	}
+	if opts != nil {
+		newProtocol.metrics = newProtocolMetrics(opts.Metrics)
+		newProtocol.tracer = opts.Tracer
+		if limits := opts.ConcurrencyLimits; limits != nil {
+			newProtocol.sessionLimiter = NewConcurrencyLimiter(CONCURRENCY_SCOPE_SESSION, "", limits.MaxInFlight, limits.MaxQueue, limits.QueueTimeout)
+			newProtocol.sessionLimiter.RegisterMetrics(opts.Metrics)
+			newProtocol.methodLimiters = NewConcurrencyLimiters(CONCURRENCY_SCOPE_METHOD, limits.MaxInFlightPerMethod, limits.MaxQueue, limits.QueueTimeout)
+			for _, limiter := range newProtocol.methodLimiters {
+				limiter.RegisterMetrics(opts.Metrics)
+			}
+		}
+	}
 
 	newProtocol.logger = utils.NewLoggerService()
 	newProtocol.SetNotificationHandler(types.NewCancelledNotification(nil), func(ctx context.Context, notification types.NotificationInterface) error {
 		notify := notification.(*types.CancelledNotification)
 		newProtocol.logger.Info(utils.LogFields{"reason": notify.Params.Reason}, "cancelled notification")
-		if notify.Params.RequestID == 0 {
-			return nil
-		}
+		//0 is a valid request ID, the requests without handler are ignored
 		CancelFunc, ok := newProtocol.requestHandlerCancel.Get(notify.Params.RequestID)
 		if !ok {
 			newProtocol.logger.Error(utils.LogFields{"requestID": notify.Params.RequestID}, ErrCancelFunNotFound.Error())
@@ -79,40 +114,31 @@ func (p *Protocol) setupTimeout(messageID int, timeout *timeoutConfig) {
 	p.timeoutInfo.Set(messageID, timeout)
 }
 
-//Reset the timeout by validating the MaxTotalTimeout
-func (p *Protocol) resetTimeout(messageID int) (bool, types.ErrorInterface) {
-	timeout, ok := p.timeoutInfo.Get(messageID)
-	if !ok {
-		return false, nil
-	}
-	totalElapsed := time.Until(timeout.StartTime) * -1
-	if timeout.MaxTotalTimeout > 0 && totalElapsed >= timeout.MaxTotalTimeout {
-		return false, types.NewMcpError(
-			types.ERROR_CODE_REQUEST_TIMEOUT,
-			"Maximum total timeout exceeded", map[string]interface{}{
-				"maxTotalTimeout": timeout.MaxTotalTimeout,
-				"totalElapsed":    totalElapsed})
-	}
-	timeout.Clear()
-	//Recreate timeout whit same timeout
-	timeout.Start()
-	return true, nil
-}
-
 func (p *Protocol) cleanupTimeout(messageID int) {
-	timeout, ok := p.timeoutInfo.Get(messageID)
-	if !ok {
-		return
-	}
-	timeout.Clear()
 	p.timeoutInfo.Delete(messageID)
 }
 
 //Attaches to the given transport, starts it, and starts listening for messages.
 //
 //The Protocol object assumes ownership of the Transport, replacing any callbacks that have already been set, and expects that it is the only user of the Transport instance going forward.
+//
+//The handlers of the requests and notifications get a context derived from ctx, cancelled when the connection closes.
+//
+//The notification handlers run one at a time in the order the notifications are received, apart from the reader of the
+//transport, so a handler can send requests and wait for their responses. The progress and cancelled notifications
+//are handled as soon as they are read, before the response of their request.
 func (p *Protocol) Connect(ctx context.Context, transport Transport) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, p.connectionCancel = context.WithCancel(ctx)
+	p.connectionCtx = ctx
 	p.transport = transport
+	p.inFlightRequests.Reset()
+	p.idempotentTools.Clear()
+	notifications := newMuxNotificationQueue()
+	p.notifications = notifications
+	atomic.StoreInt64(&p.lastMessageAt, time.Now().UnixNano())
 	p.transport.SetGlobalOnClose(func() {
 		p.onClose(ctx)
 	})
@@ -120,13 +146,24 @@ func (p *Protocol) Connect(ctx context.Context, transport Transport) {
 		p.onError(err)
 	})
 	p.transport.SetGlobalOnMessage(func(message types.JSONRPCMessage, extra *MessageExtraInfo) {
+		atomic.StoreInt64(&p.lastMessageAt, time.Now().UnixNano())
 		switch msg := message.(type) {
 		case types.JSONRPCGeneralResponse:
 			p.onResponse(ctx, msg)
 		case *types.JSONRPCRequest:
 			p.onRequest(msg, extra)
 		case *types.JSONRPCNotification:
-			p.onNotification(ctx, msg)
+			if method := msg.GetNotification().Method; method == methods.METHOD_NOTIFICATION_PROGRESS || method == methods.METHOD_NOTIFICATION_CANCELLED {
+				p.onNotification(ctx, msg, extra)
+				return
+			}
+			//The tools may have changed, their idempotency is known again once they are listed
+			if msg.GetNotification().Method == methods.METHOD_NOTIFICATION_TOOLS_LIST_CHANGED {
+				p.idempotentTools.Clear()
+			}
+			notifications.Push(func() {
+				p.onNotification(ctx, msg, extra)
+			})
 		default:
 			p.onError(fmt.Errorf("unknown message type %T", msg))
 		}
@@ -136,9 +173,102 @@ func (p *Protocol) Connect(ctx context.Context, transport Transport) {
 	if err != nil {
 		p.onError(fmt.Errorf("transport.Start %v", err))
 	}
+	if p.options != nil && p.options.KeepAlive != nil {
+		go p.keepAlive(ctx, *p.options.KeepAlive)
+	}
+}
+
+//Pings the remote side until ctx is done, after MaxFailures consecutive unanswered pings the remote side is declared dead
+func (p *Protocol) keepAlive(ctx context.Context, opts KeepAliveOptions) {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DEFAULT_KEEPALIVE_INTERVAL
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = interval
+	}
+	maxFailures := opts.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = DEFAULT_KEEPALIVE_MAX_FAILURES
+	}
+	idleTimeout := opts.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = DEFAULT_KEEPALIVE_IDLE_TIMEOUT
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var failures int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		_, err := p.Request(ctx, types.NewPingRequest(), &RequestOptions{Timeout: timeout})
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil || pingAnswered(err) {
+			failures = 0
+			continue
+		}
+		if errors.Is(err, ErrNoRequestChannel) {
+			//The remote side can not receive pings yet, it is not a sign of a dead peer while it keeps sending messages,
+			//e.g. a streamable HTTP client without a GET stream
+			lastMessageAt := time.Unix(0, atomic.LoadInt64(&p.lastMessageAt))
+			if idleTimeout > 0 && time.Since(lastMessageAt) >= idleTimeout {
+				p.peerDead(fmt.Errorf("remote side sent no message for %v and can not receive pings: %w", idleTimeout, err), opts.OnPeerDead)
+				return
+			}
+			continue
+		}
+		failures++
+		p.logger.Warning(utils.LogFields{"failures": failures, "maxFailures": maxFailures}, fmt.Sprintf("keepalive ping failed %v", err))
+		if failures >= maxFailures {
+			p.peerDead(fmt.Errorf("remote side did not answer %d pings, last error: %w", failures, err), opts.OnPeerDead)
+			return
+		}
+	}
+}
+
+//Return true if the ping got an error response, the remote side is alive even if it does not support ping
+func pingAnswered(err error) bool {
+	var mcpErr *types.McpError
+	if !errors.As(err, &mcpErr) {
+		return false
+	}
+	code := mcpErr.GetErrorCode()
+	return code != types.ERROR_CODE_REQUEST_TIMEOUT && code != types.ERROR_CODE_CONNECTION_CLOSED
+}
+
+//Closes the connection with a dead remote side, the pending requests fail with ERROR_CODE_CONNECTION_CLOSED
+func (p *Protocol) peerDead(err error, onPeerDead func(err error)) {
+	p.onError(err)
+	transport := p.transport
+	if transport != nil {
+		if cErr := transport.Close(); cErr != nil {
+			p.onError(fmt.Errorf("transport.Close %v", cErr))
+		}
+		//The transports must call OnClose on Close, this covers the ones that do not
+		if p.transport == transport {
+			p.onClose(p.connectionContext())
+		}
+	}
+	if onPeerDead != nil {
+		onPeerDead(err)
+	}
 }
 
 func (p *Protocol) onClose(ctx context.Context) {
+	//Cancels the in-flight handlers, their responses can not be sent anymore
+	if p.connectionCancel != nil {
+		p.connectionCancel()
+	}
+	//The notifications already received are still handled, with the context of the connection done
+	if p.notifications != nil {
+		p.notifications.Close()
+	}
 	responseHandlers := p.responseHandlers.GetAll()
 	p.responseHandlers.Clear()
 	p.progressHandlers.Clear()
@@ -156,9 +286,30 @@ func (p *Protocol) onError(err error) {
 	p.owner.OnError(err)
 }
 
-func (p *Protocol) onNotification(ctx context.Context, notification *types.JSONRPCNotification) {
+//Return the context of the connection, context.Background if the protocol is not connected
+func (p *Protocol) connectionContext() context.Context {
+	if p.connectionCtx == nil {
+		return context.Background()
+	}
+	return p.connectionCtx
+}
+
+func (p *Protocol) onNotification(ctx context.Context, notification *types.JSONRPCNotification, extra *MessageExtraInfo) {
+	method := notification.NotificationInterface.GetNotification().Method
+	notificationMeta, err := types.GetNotificationMeta(notification.NotificationInterface)
+	if err != nil {
+		p.onError(fmt.Errorf("types.GetNotificationMeta %v", err))
+	}
+	if tc, ok := remoteTraceContext(notificationMeta, extra); ok {
+		ctx = tracing.ContextWithRemoteTraceContext(ctx, tc)
+	}
+	ctx, span := tracing.StartSpan(ctx, p.tracer, method, tracing.SPAN_KIND_CONSUMER, map[string]interface{}{
+		TRACE_ATTRIBUTE_METHOD: method,
+	})
+	defer span.End()
+
 	handlerType := "notificationHandlers"
-	handler, ok := p.notificationHandlers.Get(notification.NotificationInterface.GetNotification().Method)
+	handler, ok := p.notificationHandlers.Get(method)
 	if !ok {
 		handlerType = "fallbackNotificationHandler"
 		handler = p.owner.FallbackNotificationHandler()
@@ -166,21 +317,86 @@ func (p *Protocol) onNotification(ctx context.Context, notification *types.JSONR
 	if handler == nil {
 		return
 	}
-	err := handler(ctx, notification.NotificationInterface)
+	err = handler(ctx, notification.NotificationInterface)
 	if err != nil {
+		span.RecordError(err)
 		p.onError(fmt.Errorf("uncaught error in notification handler[%s] %v %v", handlerType, err, notification.GetNotification()))
 	}
 }
 
 func (p *Protocol) onRequest(request *types.JSONRPCRequest, extra *MessageExtraInfo) {
+	if extra != nil && extra.ConcurrentHandling && extra.registered == nil {
+		p.onRequestConcurrently(request, extra)
+		return
+	}
+	if extra != nil && extra.registered != nil {
+		//Also for the requests answered before being registered, e.g. unknown methods
+		defer extra.registered()
+	}
+	//Kept for the whole request, onClose unsets p.transport
+	transport := p.transport
+	if transport == nil {
+		return
+	}
+	method := request.GetRequest().Method
+	if p.inFlightRequests.Add() {
+		defer p.inFlightRequests.Done()
+	} else if method != methods.METHOD_REQUEST_PING {
+		//Shutting down, the pings are still answered so the remote side waits for the requests being handled
+		_, err := transport.Send(&types.JSONRPCError{
+			JSONRPC: types.JSONRPC_VERSION,
+			ID:      request.ID,
+			Error: &types.Error{
+				Code:    types.ERROR_CODE_CONNECTION_CLOSED,
+				Message: "Connection closing: the server is shutting down",
+			},
+		}, nil)
+		if err != nil {
+			p.onError(fmt.Errorf("failed to send an error response %v", err))
+		}
+		return
+	}
+	safeExtra := &MessageExtraInfo{}
+	if extra != nil {
+		safeExtra = extra
+	}
+	start := p.metrics.requestStarted()
+	//Only the registered methods are used as label, the clients can send anything
+	metricMethod := method
+	resultCode := METRIC_REQUEST_RESULT_CODE_NO_ERR
+	var handlerErr error
+
+	requestMeta, metaErr := types.GetRequestMeta(request.RequestInterface)
+	spanCtx := p.connectionContext()
+	if tc, ok := remoteTraceContext(requestMeta, extra); ok {
+		spanCtx = tracing.ContextWithRemoteTraceContext(spanCtx, tc)
+	}
+	spanCtx, span := tracing.StartSpan(spanCtx, p.tracer, method, tracing.SPAN_KIND_SERVER, map[string]interface{}{
+		TRACE_ATTRIBUTE_METHOD:     method,
+		TRACE_ATTRIBUTE_REQUEST_ID: int(request.ID),
+	})
+	defer func() {
+		p.metrics.requestFinished(metricMethod, start, resultCode)
+		if resultCode != METRIC_REQUEST_RESULT_CODE_NO_ERR {
+			span.SetAttribute(TRACE_ATTRIBUTE_JSONRPC_ERROR, resultCode)
+			if handlerErr == nil {
+				handlerErr = fmt.Errorf("JSON-RPC error %d", resultCode)
+			}
+			span.RecordError(handlerErr)
+		}
+		span.End()
+	}()
+
 	handlerType := "requestHandlers"
-	handler, ok := p.requestHandlers.Get(request.GetRequest().Method)
+	handler, ok := p.requestHandlers.Get(method)
 	if !ok {
 		handlerType = "fallbackRequestHandler"
 		handler = p.owner.FallbackRequestHandler()
+		metricMethod = METRIC_LABEL_METHOD_UNKNOWN
 	}
 	if handler == nil {
-		_, err := p.transport.Send(&types.JSONRPCError{
+		resultCode = types.ERROR_CODE_METHOD_NOT_FOUND
+		_, err := transport.Send(&types.JSONRPCError{
 			JSONRPC: types.JSONRPC_VERSION,
 			ID:      request.ID,
 			Error: &types.Error{
@@ -190,21 +406,35 @@ func (p *Protocol) onRequest(request *types.JSONRPCRequest, extra *MessageExtraI
 		}, nil)
 		if err != nil {
 			p.onError(fmt.Errorf("failed to send an error response %v", err))
-			return
 		}
+		return
 	}
-	ctx, cancelFunc := context.WithCancel(context.Background())
+	//Cancelled by notifications/cancelled, the connection close or the end of the transport request (e.g. client disconnect)
+	ctx, cancelFunc := context.WithCancel(spanCtx)
 	p.requestHandlerCancel.Set(request.ID, cancelFunc)
+	if safeExtra.registered != nil {
+		safeExtra.registered()
+	}
 	defer func() {
 		p.requestHandlerCancel.Delete(request.ID)
+		cancelFunc()
 	}()
-	safeRequestParams := &types.BaseRequestParams{}
-	if request.GetRequest().Params != nil {
-		safeRequestParams = request.GetRequest().Params
+	if safeExtra.Context != nil {
+		go func(transportCtx context.Context) {
+			select {
+			case <-transportCtx.Done():
+				cancelFunc()
+			case <-ctx.Done():
+			}
+		}(safeExtra.Context)
+	}
+	RhExMeta, err := types.NewMetadataRequestFromMetadata(requestMeta)
+	if metaErr != nil {
+		err = metaErr
 	}
-	RhExMeta, err := types.NewMetadataRequestFromMetadata(safeRequestParams.Meta)
 	if err != nil {
-		_, err := p.transport.Send(&types.JSONRPCError{
+		resultCode = types.ERROR_CODE_INTERNAL_ERROR
+		_, err := transport.Send(&types.JSONRPCError{
 			JSONRPC: types.JSONRPC_VERSION,
 			ID:      request.ID,
 			Error: &types.Error{
@@ -214,48 +444,77 @@ func (p *Protocol) onRequest(request *types.JSONRPCRequest, extra *MessageExtraI
 		}, nil)
 		if err != nil {
 			p.onError(fmt.Errorf("failed to send an error response %v", err))
-			return
 		}
+		return
 	}
-	safeExtra := &MessageExtraInfo{}
-	if extra != nil {
-		safeExtra = extra
-	}
-	extraRequestHandle := &RequestHandlerExtra{
+	sessionID := transport.GetSessionID()
+	span.SetAttribute(TRACE_ATTRIBUTE_SESSION_ID, sessionID)
+	//Declared before so the callbacks read the Context of the handler, that can carry a child span (e.g. the tool span)
+	var extraRequestHandle *RequestHandlerExtra
+	extraRequestHandle = &RequestHandlerExtra{
 		Context:     ctx,
-		SessionID:   p.transport.GetSessionID(),
+		SessionID:   sessionID,
 		Meta:        RhExMeta,
 		AuthInfo:    safeExtra.AuthInfo,
 		RequestID:   request.ID,
 		RequestInfo: safeExtra.RequestInfo,
 		SendNotification: func(ctx context.Context, notification types.NotificationInterface) {
-			p.Notification(notification, &NotificationOptions{RelatedRequestID: request.ID})
+			p.Notification(notification, &NotificationOptions{
+				RelatedRequestID: request.ID,
+				parentContext:    traceParentContext(ctx, extraRequestHandle.Context),
+			})
 		},
-		SendRequest: func(req types.RequestInterface, opts *RequestOptions) (types.ResultInterface, error) {
+		SendRequest: func(ctx context.Context, req types.RequestInterface, opts *RequestOptions) (types.ResultInterface, error) {
+			if opts == nil {
+				opts = &RequestOptions{}
+			}
 			opts.RelatedRequestID = request.ID
-			return p.Request(req, opts)
+			opts.parentContext = extraRequestHandle.Context
+			return p.Request(ctx, req, opts)
 		},
 	}
-	result, err := handler(request.RequestInterface, extraRequestHandle)
+	var result types.ResultInterface
+	release, err := p.acquireConcurrency(ctx, method)
+	if err == nil {
+		result, err = handler(request.RequestInterface, extraRequestHandle)
+		release()
+	}
 	if err := ctx.Err(); err != nil {
-		p.logger.Info(nil, fmt.Sprintf("context for method %s was closed %v", request.GetRequest().Method, err))
+		//The cancelled requests are not answered, the transport only frees what it keeps for the response
+		p.logger.Info(nil, fmt.Sprintf("context for method %s was closed %v", method, err))
+		span.RecordError(err)
+		if releaser, ok := transport.(RequestReleaser); ok {
+			releaser.ReleaseRequest(request.ID)
+		}
 		return
 	}
 	if err != nil {
-		_, err := p.transport.Send(&types.JSONRPCError{
+		handlerErr = err
+		jsonErr := &types.Error{
+			Code:    types.ERROR_CODE_INTERNAL_ERROR,
+			Message: fmt.Sprintf("handler request error %s, [%v], %v ", handlerType, request, err),
+		}
+		//Keep the code and data of the MCP errors returned by the handler
+		var mcpErr *types.McpError
+		if errors.As(err, &mcpErr) {
+			jsonErr = &types.Error{
+				Code:    mcpErr.GetErrorCode(),
+				Message: mcpErr.GetErrorMessage(),
+				Data:    mcpErr.GetErrorData(),
+			}
+		}
+		resultCode = jsonErr.Code
+		_, err := transport.Send(&types.JSONRPCError{
 			JSONRPC: types.JSONRPC_VERSION,
 			ID:      request.ID,
-			Error: &types.Error{
-				Code:    types.ERROR_CODE_INTERNAL_ERROR,
-				Message: fmt.Sprintf("handler request error %s, [%v], %v ", handlerType, request, err),
-			},
+			Error:   jsonErr,
 		}, nil)
 		if err != nil {
 			p.onError(fmt.Errorf("failed to send an error response %v", err))
-			return
 		}
+		return
 	}
-	_, err = p.transport.Send(&types.JSONRPCResponse{
+	_, err = transport.Send(&types.JSONRPCResponse{
 		JSONRPC: types.JSONRPC_VERSION,
 		ID:      request.ID,
 		Result:  result,
@@ -266,23 +525,63 @@ func (p *Protocol) onRequest(request *types.JSONRPCRequest, extra *MessageExtraI
 	}
 }
 
+//Handles the request in its own goroutine, returning once its cancel function is registered
+func (p *Protocol) onRequestConcurrently(request *types.JSONRPCRequest, extra *MessageExtraInfo) {
+	registered := make(chan struct{})
+	var once sync.Once
+	concurrentExtra := *extra
+	concurrentExtra.registered = func() {
+		once.Do(func() { close(registered) })
+	}
+	go p.onRequest(request, &concurrentExtra)
+	<-registered
+}
+
+//Takes a slot of the session and method limits, waiting in their queues if needed
+func (p *Protocol) acquireConcurrency(ctx context.Context, method string) (func(), error) {
+	var limiters []*ConcurrencyLimiter
+	if method != methods.METHOD_REQUEST_PING {
+		limiters = append(limiters, p.sessionLimiter)
+	}
+	limiters = append(limiters, p.methodLimiters[method])
+	releases := make([]func(), 0, len(limiters))
+	releaseAll := func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+	for _, limiter := range limiters {
+		release, err := limiter.Acquire(ctx)
+		if err != nil {
+			releaseAll()
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	return releaseAll, nil
+}
+
+//Return the state of the session and method concurrency limits, empty if there are no limits
+func (p *Protocol) ConcurrencyStats() []ConcurrencyStats {
+	stats := ConcurrencyLimitersStats(p.methodLimiters)
+	if p.sessionLimiter != nil {
+		stats = append([]ConcurrencyStats{p.sessionLimiter.Stats()}, stats...)
+	}
+	return stats
+}
+
 func (p *Protocol) onProgress(ctx context.Context, progressNotify *types.ProgressNotification) {
-	messageID := progressNotify.Params.ProgressToken.(int)
+	messageID, okToken := progressTokenMessageID(progressNotify.Params.ProgressToken)
 	progressHandler, okProgressHandle := p.progressHandlers.Get(messageID)
-	if !okProgressHandle {
+	if !okToken || !okProgressHandle {
 		p.onError(fmt.Errorf("received a progress notification for an unknown token, progressHandlers not found: %v", progressNotify))
 		return
 	}
 
-	responseHandler, okResponseHandler := p.responseHandlers.Get(messageID)
+	//The MaxTotalTimeout is enforced by the Request, it is not affected by the reset
 	timeout, okTimeoutInfo := p.timeoutInfo.Get(messageID)
-	if okTimeoutInfo && okResponseHandler && timeout.ResetTimeoutOnProgress {
-		_, err := p.resetTimeout(messageID)
-		if err != nil {
-			jsonError := &types.JSONRPCError{Error: err}
-			responseHandler(ctx, jsonError)
-			return
-		}
+	if okTimeoutInfo && timeout.ResetTimeoutOnProgress {
+		timeout.Reset()
 	}
 
 	err := progressHandler(progressNotify.Params.Progress)
@@ -291,6 +590,19 @@ func (p *Protocol) onProgress(ctx context.Context, progressNotify *types.Progres
 	}
 }
 
+//Return the message ID used as progress token by Request, the tokens decoded from JSON are float64
+func progressTokenMessageID(token types.ProgressToken) (int, bool) {
+	switch v := token.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), v == float64(int(v))
+	case types.RequestID:
+		return int(v), true
+	}
+	return 0, false
+}
+
 func (p *Protocol) onResponse(ctx context.Context, response types.JSONRPCGeneralResponse) {
 	messageID := int(response.GetRequestID())
 	responseHandler, okResponseHandler := p.responseHandlers.Get(messageID)
@@ -333,161 +645,292 @@ func (p *Protocol) Close() {
 	}
 }
 
+//Closes the connection gracefully: the new requests are answered with an error, the requests being handled
+//can finish and the messages queued by the transport are written, then the transport is closed.
+//
+//If ctx is done first the transport is closed anyway, cancelling the handlers still running, and the error of ctx is returned
+func (p *Protocol) Shutdown(ctx context.Context) error {
+	transport := p.transport
+	if transport == nil {
+		return nil
+	}
+	var gErr error
+	select {
+	case <-p.inFlightRequests.Shutdown():
+		if drainer, ok := transport.(TransportDrainer); ok {
+			if err := drainer.Drain(ctx); err != nil {
+				gErr = fmt.Errorf("transport.Drain %w", err)
+			}
+		}
+	case <-ctx.Done():
+		gErr = ctx.Err()
+	}
+	err := transport.Close()
+	if err != nil {
+		p.onError(fmt.Errorf("transport.Close %v", err))
+	}
+	return gErr
+}
+
 //Sends a request and wait for a response.
 //
+//Request returns exactly once: with the response, when ctx is done, when the timeout or MaxTotalTimeout expires or when the connection closes.
+//If ctx is done or a timeout expires the remote side is notified that the request was cancelled.
+//An expired ctx deadline or timeout returns an McpError with code `RequestTimeout`, a cancelled ctx an error wrapping context.Canceled.
+//
+//With RequestOptions.RetryPolicy an idempotent request that fails with a transient error is sent again,
+//the error of the last attempt is returned.
+//
 //Do not use this method to emit notifications! Use notification() instead.
-func (p *Protocol) Request(request types.RequestInterface, opts *RequestOptions) (gResp types.ResultInterface, gErr error) {
+func (p *Protocol) Request(ctx context.Context, request types.RequestInterface, opts *RequestOptions) (types.ResultInterface, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	safeOpts := opts
 	if safeOpts == nil {
 		safeOpts = &RequestOptions{}
 	}
-	if p.transport == nil {
+	method := request.GetRequest().Method
+	if safeOpts.RetryPolicy == nil || !p.isIdempotentRequest(request, safeOpts) {
+		return p.request(ctx, request, safeOpts, 1)
+	}
+	policy := safeOpts.RetryPolicy.withDefaults()
+	for attempt := 1; ; attempt++ {
+		result, err := p.request(ctx, request, safeOpts, attempt)
+		if err == nil {
+			if attempt > 1 {
+				p.logger.Info(utils.LogFields{"method": method, "attempts": attempt}, fmt.Sprintf("request %s succeeded after retrying", method))
+			}
+			return result, nil
+		}
+		//The requests whose context is done are not retried, their error is already the final one
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil || !policy.retryable(err) {
+			if attempt > 1 {
+				err = fmt.Errorf("request %s failed after %d attempts %w", method, attempt, err)
+			}
+			return nil, err
+		}
+		delay := policy.backoff(attempt, err)
+		p.logger.Warning(utils.LogFields{
+			"method":      method,
+			"attempt":     attempt,
+			"maxAttempts": policy.MaxAttempts,
+			"delay":       delay.String(),
+		}, fmt.Sprintf("request %s failed, retrying %v", method, err))
+		if err := waitRetry(ctx, delay); err != nil {
+			return nil, p.requestContextError(method, err)
+		}
+	}
+}
+
+//Sends an attempt of a request and wait for its response
+func (p *Protocol) request(ctx context.Context, request types.RequestInterface, safeOpts *RequestOptions, attempt int) (gResp types.ResultInterface, gErr error) {
+	transport := p.transport
+	if transport == nil {
 		gErr = fmt.Errorf("transport not connected")
 		return
 	}
-	if p.options.EnforceStrictCapabilities != nil && *p.options.EnforceStrictCapabilities {
-		p.owner.AssertCapabilityForMethod(request)
+	method := request.GetRequest().Method
+	if p.options != nil && p.options.EnforceStrictCapabilities != nil && *p.options.EnforceStrictCapabilities {
+		if err := p.owner.AssertCapabilityForMethod(request); err != nil {
+			gErr = fmt.Errorf("p.owner.AssertCapabilityForMethod %v", err)
+			return
+		}
 	}
-	if safeOpts.Canceled() {
-		gErr = fmt.Errorf("the request was canceled by an external close function")
+	if err := ctx.Err(); err != nil {
+		gErr = p.requestContextError(method, err)
 		return
 	}
+	_, span := tracing.StartSpan(traceParentContext(ctx, safeOpts.parentContext), p.tracer, method, tracing.SPAN_KIND_CLIENT, map[string]interface{}{
+		TRACE_ATTRIBUTE_METHOD:  method,
+		TRACE_ATTRIBUTE_ATTEMPT: attempt,
+	})
+	defer func() {
+		span.RecordError(gErr)
+		span.End()
+	}()
 
 	messageID := p.requestMessageID.Increase()
+	span.SetAttribute(TRACE_ATTRIBUTE_REQUEST_ID, messageID)
 	jsonrpcRequest := &types.JSONRPCRequest{
 		JSONRPC:          types.JSONRPC_VERSION,
 		ID:               types.RequestID(messageID),
 		RequestInterface: request,
 	}
+	requestMeta := traceMeta(span)
 	if safeOpts.Onprogress != nil {
 		p.progressHandlers.Set(messageID, safeOpts.Onprogress)
-		jsonrpcRequest.RequestInterface = &types.Request{
-			Method: request.GetRequest().Method,
-			Params: &types.BaseRequestParams{
-				Meta: types.Meta{
-					"progressToken": types.ProgressToken(messageID),
-				},
-			}}
-	}
-	type requestReturnChan struct {
-		r types.ResultInterface
-		e error
-	}
-	returnChan := make(chan requestReturnChan)
-	defer func() {
-		select {
-		case resultReturn := <-returnChan:
-			gResp = resultReturn.r
-			if gErr != nil {
-				gErr = resultReturn.e
-			}
-		}
-	}()
-	cancelFlow := func(reason types.ErrorInterface) {
-		var once sync.Once
-		once.Do(func() {
-			p.responseHandlers.Delete(messageID)
-			p.progressHandlers.Delete(messageID)
-			p.cleanupTimeout(messageID)
-
-			_, err := p.transport.Send(types.NewCancelledNotification(&types.CancelledNotificationParams{
-				RequestID: types.RequestID(messageID),
-				Reason:    reason.ToError().Error(),
-			}), &TransportSendOptions{
-				RelatedRequestID:  safeOpts.RelatedRequestID,
-				ResumptionToken:   safeOpts.ResumptionToken,
-				OnResumptionToken: safeOpts.OnResumptionToken,
-			})
-			if err != nil {
-				p.onError(fmt.Errorf("failed to send cancellation: %v", err))
-			}
-			go func() {
-				returnChan <- requestReturnChan{
-					e: reason.ToError(),
-				}
-			}()
-		})
-	}
-
-	if safeOpts.Context != nil {
-		//If RequestOptions has a cancel context
-		if _, ok := safeOpts.Context.Deadline(); ok {
-			go func() {
-				//When the cancel function was called
-				<-safeOpts.Context.Done()
-				cancelFlow(&types.Error{Message: "context was canceled from outside"})
-			}()
+		if requestMeta == nil {
+			requestMeta = make(types.Meta)
 		}
+		requestMeta["progressToken"] = types.ProgressToken(messageID)
+	}
+	if len(requestMeta) > 0 {
+		//The params of the request are kept, only the _meta entries are added
+		jsonrpcRequest.RequestInterface = types.NewRequestWithMeta(request, requestMeta)
 	}
 
-	p.responseHandlers.Set(messageID, func(ctx context.Context, response types.JSONRPCGeneralResponse) error {
-		if safeOpts.Canceled() {
-			return nil
+	//Buffered and written without blocking: the response and the connection close can race, the first one wins
+	resultChan := make(chan requestResult, 1)
+	deliver := func(result requestResult) {
+		select {
+		case resultChan <- result:
+		default:
 		}
+	}
+	p.responseHandlers.Set(messageID, func(ctx context.Context, response types.JSONRPCGeneralResponse) error {
 		if err, ok := response.(*types.JSONRPCError); ok {
 			lErr := err.Error.ToError()
-			go func() {
-				returnChan <- requestReturnChan{
-					e: lErr,
-				}
-			}()
+			deliver(requestResult{e: lErr})
 			return lErr
 		}
 		if res, ok := response.(*types.JSONRPCResponse); ok {
-			go func() {
-				returnChan <- requestReturnChan{
-					r: res.Result,
+			result, err := decodeResponseResult(method, res, safeOpts.ResultDecoder)
+			if list, ok := result.(*types.ListToolsResult); ok {
+				for i := range list.Tools {
+					p.idempotentTools.Set(list.Tools[i].Name, list.Tools[i].IsIdempotent())
 				}
-			}()
-			return nil
+			}
+			deliver(requestResult{r: result, e: err})
+			return err
 		}
 		err := fmt.Errorf("invalid response type")
-		go func() {
-			returnChan <- requestReturnChan{
-				e: err,
-			}
-		}()
+		deliver(requestResult{e: err})
 		return err
 	})
 
 	timeout := safeOpts.Timeout
 	if timeout == 0 {
-		timeout = DEFAULT_REQUEST_TIMEOUT_MSEC
+		timeout = DEFAULT_REQUEST_TIMEOUT
 	}
-
-	timeoutHandler := func() {
-		cancelFlow(types.NewMcpError(
-			types.ERROR_CODE_REQUEST_TIMEOUT,
-			"request timed out "+request.GetRequest().Method, map[string]interface{}{"timeout": timeout}))
-
-	}
-
 	var resetTimeoutOnProgress bool
 	if safeOpts.ResetTimeoutOnProgress != nil {
 		resetTimeoutOnProgress = *safeOpts.ResetTimeoutOnProgress
 	}
-	p.setupTimeout(messageID, &timeoutConfig{
-		Timeout:                time.Duration(timeout),
-		MaxTotalTimeout:        safeOpts.MaxTotalTimeout,
-		OnTimeout:              timeoutHandler,
-		ResetTimeoutOnProgress: resetTimeoutOnProgress,
+	timeoutInfo := newTimeoutConfig(timeout, resetTimeoutOnProgress)
+	p.setupTimeout(messageID, timeoutInfo)
+	cleanup := func() {
+		p.responseHandlers.Delete(messageID)
+		p.progressHandlers.Delete(messageID)
+		p.cleanupTimeout(messageID)
+	}
+
+	_, err := transport.Send(jsonrpcRequest, &TransportSendOptions{
+		RelatedRequestID:  safeOpts.RelatedRequestID,
+		ResumptionToken:   safeOpts.ResumptionToken,
+		OnResumptionToken: safeOpts.OnResumptionToken,
 	})
-	_, err := p.transport.Send(jsonrpcRequest,
-		&TransportSendOptions{
-			RelatedRequestID:  safeOpts.RelatedRequestID,
-			ResumptionToken:   safeOpts.ResumptionToken,
-			OnResumptionToken: safeOpts.OnResumptionToken,
-		})
 	if err != nil {
-		p.cleanupTimeout(messageID)
-		go func() {
-			returnChan <- requestReturnChan{
-				e: err,
+		cleanup()
+		gErr = fmt.Errorf("transport.Send %w", &TransportSendError{Err: err})
+		return
+	}
+
+	var timer *time.Timer
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		timer = time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+	var maxTotalTimeoutC <-chan time.Time
+	if safeOpts.MaxTotalTimeout > 0 {
+		maxTotalTimer := time.NewTimer(safeOpts.MaxTotalTimeout)
+		defer maxTotalTimer.Stop()
+		maxTotalTimeoutC = maxTotalTimer.C
+	}
+	for {
+		select {
+		case result := <-resultChan:
+			cleanup()
+			gResp, gErr = result.r, result.e
+			return
+		case <-ctx.Done():
+			gErr = p.requestContextError(method, ctx.Err())
+			cleanup()
+			p.sendCancelled(messageID, safeOpts, gErr)
+			return
+		case <-timeoutC:
+			gErr = types.NewMcpError(types.ERROR_CODE_REQUEST_TIMEOUT, "request timed out "+method, map[string]interface{}{
+				"timeout": timeout.String(),
+			}).ToError()
+			cleanup()
+			p.sendCancelled(messageID, safeOpts, gErr)
+			return
+		case <-maxTotalTimeoutC:
+			gErr = types.NewMcpError(types.ERROR_CODE_REQUEST_TIMEOUT, "maximum total timeout exceeded "+method, map[string]interface{}{
+				"maxTotalTimeout": safeOpts.MaxTotalTimeout.String(),
+			}).ToError()
+			cleanup()
+			p.sendCancelled(messageID, safeOpts, gErr)
+			return
+		case <-timeoutInfo.reset:
+			if timer == nil {
+				continue
 			}
-		}()
+			if !timer.Stop() {
+				//The timer fired but its value was not received yet, drain it so Reset starts clean
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(timeout)
+		}
+	}
+}
+
+//Return the result of the response decoded into the type registered for the method of the request,
+//or with decoder if given. Responses that were not received as JSON keep their result
+func decodeResponseResult(method string, response *types.JSONRPCResponse, decoder types.ResultDecoder) (types.ResultInterface, error) {
+	raw := response.RawResult()
+	if raw == nil {
+		return response.Result, nil
+	}
+	if decoder == nil {
+		result, err := types.DecodeResult(method, raw)
+		if err != nil {
+			return nil, fmt.Errorf("types.DecodeResult %w", err)
+		}
+		return result, nil
+	}
+	result, err := decoder(raw)
+	if err != nil {
+		return nil, fmt.Errorf("decode result of %s %w", method, err)
+	}
+	if result == nil {
+		return nil, fmt.Errorf("decode result of %s: the decoder returned no result", method)
+	}
+	return result, nil
+}
+
+//Return the error of a request whose context is done, an expired deadline is reported as a timeout like the request timeout
+func (p *Protocol) requestContextError(method string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return types.NewMcpError(types.ERROR_CODE_REQUEST_TIMEOUT, "request deadline exceeded "+method, map[string]interface{}{
+			"reason": err.Error(),
+		}).ToError()
+	}
+	return fmt.Errorf("request %s cancelled: %w", method, err)
+}
+
+//Tells the remote side to stop processing a request whose result will not be used
+func (p *Protocol) sendCancelled(messageID int, opts *RequestOptions, reason error) {
+	transport := p.transport
+	if transport == nil {
 		return
 	}
-	return
+	_, err := transport.Send(types.NewCancelledNotification(&types.CancelledNotificationParams{
+		RequestID: types.RequestID(messageID),
+		Reason:    reason.Error(),
+	}), &TransportSendOptions{
+		RelatedRequestID:  opts.RelatedRequestID,
+		ResumptionToken:   opts.ResumptionToken,
+		OnResumptionToken: opts.OnResumptionToken,
+	})
+	if err != nil {
+		p.onError(fmt.Errorf("failed to send cancellation: %v", err))
+	}
 }
 
 //Emits a notification, which is a one-way message that does not expect a response.
@@ -503,13 +946,22 @@ func (p *Protocol) Notification(notification types.NotificationInterface, opts *
 	if err != nil {
 		return fmt.Errorf("assertNotificationCapability error: %v", err)
 	}
+	method := notification.GetNotification().Method
+	_, span := tracing.StartSpan(traceParentContext(safeOpts.parentContext), p.tracer, method, tracing.SPAN_KIND_PRODUCER, map[string]interface{}{
+		TRACE_ATTRIBUTE_METHOD: method,
+	})
+	defer span.End()
 	jsonrpcNotification := &types.JSONRPCNotification{
 		JSONRPC:               types.JSONRPC_VERSION,
 		NotificationInterface: notification,
 	}
+	if meta := traceMeta(span); meta != nil {
+		jsonrpcNotification.NotificationInterface = types.NewNotificationWithMeta(notification, meta)
+	}
 
 	_, err = p.transport.Send(jsonrpcNotification, &TransportSendOptions{RelatedRequestID: safeOpts.RelatedRequestID})
 	if err != nil {
+		span.RecordError(err)
 		return fmt.Errorf("transport.Send error: %v", err)
 	}
 	return nil
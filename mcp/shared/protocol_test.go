@@ -0,0 +1,402 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+//ProtocolInterface without capabilities checks, the errors are kept in a channel
+type testProtocolOwner struct {
+	errs chan error
+}
+
+func (o *testProtocolOwner) ProtocolInterfaceType() int                                  { return 0 }
+func (o *testProtocolOwner) OnClose() error                                              { return nil }
+func (o *testProtocolOwner) SetOnErrorCallBack(func(err error))                          {}
+func (o *testProtocolOwner) FallbackRequestHandler() RequestHandler                      { return nil }
+func (o *testProtocolOwner) FallbackNotificationHandler() NotificationHandler            { return nil }
+func (o *testProtocolOwner) AssertCapabilityForMethod(req types.RequestInterface) error  { return nil }
+func (o *testProtocolOwner) AssertRequestHandlerCapability(types.RequestInterface) error { return nil }
+func (o *testProtocolOwner) AssertNotificationCapability(types.NotificationInterface) error {
+	return nil
+}
+func (o *testProtocolOwner) OnError(err error) error {
+	select {
+	case o.errs <- err:
+	default:
+	}
+	return nil
+}
+
+//Transport that records the sent messages, the test delivers the received ones with OnMessage
+type testTransport struct {
+	sent chan types.JSONRPCMessage
+	//If set, called for each sent message, an error fails the send
+	onSend          func(msg types.JSONRPCMessage) error
+	globalOnMessage func(message types.JSONRPCMessage, extra *MessageExtraInfo)
+	globalOnClose   func()
+}
+
+func (t *testTransport) Start() error { return nil }
+func (t *testTransport) Send(msg types.JSONRPCMessage, opts *TransportSendOptions) (*types.JSONRPCResponse, error) {
+	if t.onSend != nil {
+		if err := t.onSend(msg); err != nil {
+			return nil, err
+		}
+	}
+	t.sent <- msg
+	return nil, nil
+}
+func (t *testTransport) Close() error { return t.OnClose() }
+func (t *testTransport) OnClose() error {
+	if t.globalOnClose != nil {
+		t.globalOnClose()
+	}
+	return nil
+}
+func (t *testTransport) OnError(err error) {}
+func (t *testTransport) OnMessage(message types.JSONRPCMessage, extra *MessageExtraInfo) {
+	t.globalOnMessage(message, extra)
+}
+func (t *testTransport) SetProtocolVersion(version string)  {}
+func (t *testTransport) GetSessionID() string               { return "" }
+func (t *testTransport) SetGlobalOnClose(f func())          { t.globalOnClose = f }
+func (t *testTransport) SetGlobalOnError(f func(err error)) {}
+func (t *testTransport) SetGlobalOnMessage(f func(message types.JSONRPCMessage, extra *MessageExtraInfo)) {
+	t.globalOnMessage = f
+}
+
+func newTestProtocol(t *testing.T) (*Protocol, *testTransport, *testProtocolOwner) {
+	t.Helper()
+	owner := &testProtocolOwner{errs: make(chan error, 100)}
+	p := NewProtocol(&ProtocolOptions{}, owner)
+	transport := &testTransport{sent: make(chan types.JSONRPCMessage, 100)}
+	p.Connect(context.Background(), transport)
+	return p, transport, owner
+}
+
+func nextSent(t *testing.T, transport *testTransport) types.JSONRPCMessage {
+	t.Helper()
+	select {
+	case msg := <-transport.sent:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a sent message")
+	}
+	return nil
+}
+
+func expectNothingSent(t *testing.T, transport *testTransport, wait time.Duration) {
+	t.Helper()
+	select {
+	case msg := <-transport.sent:
+		t.Fatalf("unexpected message %#v", msg)
+	case <-time.After(wait):
+	}
+}
+
+//Return the ID of the sent request
+func sentRequestID(t *testing.T, transport *testTransport) types.RequestID {
+	t.Helper()
+	request, ok := nextSent(t, transport).(*types.JSONRPCRequest)
+	if !ok {
+		t.Fatal("expected a request")
+	}
+	return request.ID
+}
+
+func expectCancelled(t *testing.T, transport *testTransport, id types.RequestID) {
+	t.Helper()
+	cancelled, ok := nextSent(t, transport).(*types.CancelledNotification)
+	if !ok {
+		t.Fatal("expected a cancelled notification")
+	}
+	if cancelled.Params.RequestID != id {
+		t.Fatalf("expected the cancellation of %d, got %d", id, cancelled.Params.RequestID)
+	}
+}
+
+func expectErrorCode(t *testing.T, err error, code int) {
+	t.Helper()
+	var mcpErr *types.McpError
+	if !errors.As(err, &mcpErr) || mcpErr.GetErrorCode() != code {
+		t.Fatalf("expected an error with code %d, got %v", code, err)
+	}
+}
+
+type testRequestResult struct {
+	result types.ResultInterface
+	err    error
+}
+
+//Runs the request in background, the channel gets its result
+func startRequest(ctx context.Context, p *Protocol, request types.RequestInterface, opts *RequestOptions) chan testRequestResult {
+	results := make(chan testRequestResult, 1)
+	go func() {
+		result, err := p.Request(ctx, request, opts)
+		results <- testRequestResult{result, err}
+	}()
+	return results
+}
+
+func waitRequest(t *testing.T, results chan testRequestResult) testRequestResult {
+	t.Helper()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the request to return")
+	}
+	return testRequestResult{}
+}
+
+func sendProgress(transport *testTransport, id types.RequestID, progress int) {
+	params := &types.ProgressNotificationParams{ProgressToken: int(id)}
+	params.Progress.Progress = progress
+	transport.OnMessage(&types.JSONRPCNotification{
+		JSONRPC:               types.JSONRPC_VERSION,
+		NotificationInterface: types.NewProgressNotification(params),
+	}, nil)
+}
+
+func TestRequestTimeoutAfterProgressReset(t *testing.T) {
+	p, transport, _ := newTestProtocol(t)
+	resetOnProgress := true
+	var progressCount int
+	var mu sync.Mutex
+	start := time.Now()
+	results := startRequest(context.Background(), p, types.NewPingRequest(), &RequestOptions{
+		Timeout:                60 * time.Millisecond,
+		ResetTimeoutOnProgress: &resetOnProgress,
+		Onprogress: func(progress types.Progress) error {
+			mu.Lock()
+			progressCount++
+			mu.Unlock()
+			return nil
+		},
+	})
+	id := sentRequestID(t, transport)
+
+	//Each progress restarts the timeout, together they last longer than it
+	for i := 1; i <= 4; i++ {
+		time.Sleep(30 * time.Millisecond)
+		sendProgress(transport, id, i)
+		select {
+		case result := <-results:
+			t.Fatalf("expected the progress to keep the request alive, got %v", result.err)
+		default:
+		}
+	}
+	result := waitRequest(t, results)
+	expectErrorCode(t, result.err, types.ERROR_CODE_REQUEST_TIMEOUT)
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected the timeout after the last progress, returned after %v", elapsed)
+	}
+	mu.Lock()
+	if progressCount != 4 {
+		t.Fatalf("expected 4 progress callbacks, got %d", progressCount)
+	}
+	mu.Unlock()
+	expectCancelled(t, transport, id)
+}
+
+func TestRequestMaxTotalTimeoutWinsOverResets(t *testing.T) {
+	p, transport, _ := newTestProtocol(t)
+	resetOnProgress := true
+	start := time.Now()
+	results := startRequest(context.Background(), p, types.NewPingRequest(), &RequestOptions{
+		Timeout:                50 * time.Millisecond,
+		ResetTimeoutOnProgress: &resetOnProgress,
+		MaxTotalTimeout:        150 * time.Millisecond,
+		Onprogress:             func(progress types.Progress) error { return nil },
+	})
+	id := sentRequestID(t, transport)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 1; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				sendProgress(transport, id, i)
+			}
+		}
+	}()
+	result := waitRequest(t, results)
+	expectErrorCode(t, result.err, types.ERROR_CODE_REQUEST_TIMEOUT)
+	if !strings.Contains(result.err.Error(), "maximum total timeout") {
+		t.Fatalf("expected the maximum total timeout, got %v", result.err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("expected the request to last MaxTotalTimeout, returned after %v", elapsed)
+	}
+	expectCancelled(t, transport, id)
+}
+
+func TestRequestParentCancelSendsOneCancelled(t *testing.T) {
+	p, transport, _ := newTestProtocol(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	results := startRequest(ctx, p, types.NewPingRequest(), &RequestOptions{Timeout: 50 * time.Millisecond})
+	id := sentRequestID(t, transport)
+	cancel()
+
+	result := waitRequest(t, results)
+	if !errors.Is(result.err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", result.err)
+	}
+	expectCancelled(t, transport, id)
+	//The request timeout of the finished request sends nothing else
+	expectNothingSent(t, transport, 100*time.Millisecond)
+}
+
+func TestRequestLateResponse(t *testing.T) {
+	p, transport, owner := newTestProtocol(t)
+	results := startRequest(context.Background(), p, types.NewPingRequest(), &RequestOptions{Timeout: 20 * time.Millisecond})
+	id := sentRequestID(t, transport)
+	result := waitRequest(t, results)
+	expectErrorCode(t, result.err, types.ERROR_CODE_REQUEST_TIMEOUT)
+	expectCancelled(t, transport, id)
+
+	//Answered after the timeout, the response is reported and dropped
+	transport.OnMessage(&types.JSONRPCResponse{JSONRPC: types.JSONRPC_VERSION, ID: id, Result: &types.EmptyResult{}}, nil)
+	select {
+	case err := <-owner.errs:
+		if !strings.Contains(err.Error(), "unknown message ID") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the late response to be reported")
+	}
+
+	//The response and the cancellation race, the request returns once and is cancelled at most once
+	cancelledCount := make(map[types.RequestID]int)
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		results := startRequest(ctx, p, types.NewPingRequest(), nil)
+		var id types.RequestID
+		for id == 0 {
+			switch msg := nextSent(t, transport).(type) {
+			case *types.JSONRPCRequest:
+				id = msg.ID
+			case *types.CancelledNotification:
+				cancelledCount[msg.Params.RequestID]++
+			}
+		}
+		go cancel()
+		transport.OnMessage(&types.JSONRPCResponse{JSONRPC: types.JSONRPC_VERSION, ID: id, Result: &types.EmptyResult{}}, nil)
+		waitRequest(t, results)
+		cancel()
+	}
+	for id, count := range cancelledCount {
+		if count > 1 {
+			t.Fatalf("expected the request %d to be cancelled once, got %d", id, count)
+		}
+	}
+}
+
+func TestNotificationHandlersRunInOrderApartFromReader(t *testing.T) {
+	p, transport, _ := newTestProtocol(t)
+	unblock := make(chan struct{})
+	handled := make(chan string, 3)
+	p.SetNotificationHandler(&types.RawNotification{Method: "test/event"}, func(ctx context.Context, notification types.NotificationInterface) error {
+		var params struct {
+			Name string `json:"name"`
+		}
+		notification.(*types.RawNotification).DecodeParams(&params)
+		if params.Name == "first" {
+			<-unblock
+		}
+		handled <- params.Name
+		return nil
+	})
+	delivered := make(chan struct{})
+	go func() {
+		for _, name := range []string{"first", "second", "third"} {
+			notification, _ := types.NewRawNotification("test/event", map[string]string{"name": name})
+			transport.OnMessage(&types.JSONRPCNotification{JSONRPC: types.JSONRPC_VERSION, NotificationInterface: notification}, nil)
+		}
+		close(delivered)
+	}()
+	//The blocked handler does not stop the reader
+	select {
+	case <-delivered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the notifications to be delivered while the first handler runs")
+	}
+	select {
+	case name := <-handled:
+		t.Fatalf("expected the notifications to wait for the first handler, got %s", name)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(unblock)
+	for _, expected := range []string{"first", "second", "third"} {
+		select {
+		case name := <-handled:
+			if name != expected {
+				t.Fatalf("expected %s, got %s", expected, name)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %s to be handled", expected)
+		}
+	}
+}
+
+//Delivers the response as received from the transport, with its result to be decoded
+func sendResponse(t *testing.T, transport *testTransport, id types.RequestID, result string) {
+	t.Helper()
+	response := new(types.JSONRPCResponse)
+	if err := json.Unmarshal([]byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"result":%s}`, id, result)), response); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	transport.OnMessage(response, nil)
+}
+
+func TestRequestResultDecoderOverridesRegistry(t *testing.T) {
+	p, transport, _ := newTestProtocol(t)
+	result := `{"content":[{"type":"text","text":"hi"}]}`
+	call := types.NewCallToolRequest(&types.CallToolRequestParams{Name: "echo"})
+
+	//Without a decoder the result has the type registered for the method
+	results := startRequest(context.Background(), p, call, nil)
+	sendResponse(t, transport, sentRequestID(t, transport), result)
+	decoded := waitRequest(t, results)
+	if decoded.err != nil {
+		t.Fatalf("Request: %v", decoded.err)
+	}
+	if _, ok := decoded.result.(*types.CallToolResult); !ok {
+		t.Fatalf("expected the registered result type, got %T", decoded.result)
+	}
+
+	opts := &RequestOptions{ResultDecoder: types.NewResultDecoder(func() types.ResultInterface { return new(types.RawResult) })}
+	results = startRequest(context.Background(), p, call, opts)
+	sendResponse(t, transport, sentRequestID(t, transport), result)
+	decoded = waitRequest(t, results)
+	if decoded.err != nil {
+		t.Fatalf("Request: %v", decoded.err)
+	}
+	raw, ok := decoded.result.(*types.RawResult)
+	if !ok || !strings.Contains(string(raw.Data), `"text":"hi"`) {
+		t.Fatalf("expected the result of the decoder, got %#v", decoded.result)
+	}
+
+	//The error of the decoder is the error of the request
+	opts = &RequestOptions{ResultDecoder: func(data json.RawMessage) (types.ResultInterface, error) {
+		return nil, errors.New("unexpected result")
+	}}
+	results = startRequest(context.Background(), p, call, opts)
+	sendResponse(t, transport, sentRequestID(t, transport), result)
+	if decoded = waitRequest(t, results); decoded.err == nil || !strings.Contains(decoded.err.Error(), "unexpected result") {
+		t.Fatalf("expected the error of the decoder, got %v", decoded.err)
+	}
+}
@@ -0,0 +1,179 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/methods"
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+const (
+	//Attempts of a request when RetryPolicy.MaxAttempts is not set, including the first one
+	DEFAULT_RETRY_MAX_ATTEMPTS = 3
+	//Delay before the first retry when RetryPolicy.InitialBackoff is not set
+	DEFAULT_RETRY_INITIAL_BACKOFF = 200 * time.Millisecond
+	//Maximum delay between attempts when RetryPolicy.MaxBackoff is not set
+	DEFAULT_RETRY_MAX_BACKOFF = 5 * time.Second
+	//Fraction of the delay that is randomized when RetryPolicy.Jitter is not set
+	DEFAULT_RETRY_JITTER = 0.2
+)
+
+//JSON-RPC error codes retried when RetryPolicy.RetryableCodes is not set
+var DEFAULT_RETRYABLE_ERROR_CODES = []int{
+	types.ERROR_CODE_REQUEST_TIMEOUT,
+	types.ERROR_CODE_CONCURRENCY_LIMIT_EXCEEDED,
+	types.ERROR_CODE_RATE_LIMITED,
+}
+
+//Sends a request again when it fails with a transient error: the transport could not send it or the remote side
+//answered with one of the retryable error codes. A request is never retried once its context is done.
+//
+//Only the idempotent requests are retried: the methods in methods.IDEMPOTENT_REQUEST_METHODS, the requests
+//marked with RequestOptions.Idempotent and the tools/call of a tool whose annotations in the tools/list results
+//hint that it is read-only or idempotent, see types.Tool.IsIdempotent
+type RetryPolicy struct {
+	//Attempts of the request, including the first one.
+	//Default is DEFAULT_RETRY_MAX_ATTEMPTS.
+	MaxAttempts int
+	//Delay before the first retry, doubled after each attempt.
+	//Default is DEFAULT_RETRY_INITIAL_BACKOFF.
+	InitialBackoff time.Duration
+	//Maximum delay between attempts.
+	//Default is DEFAULT_RETRY_MAX_BACKOFF.
+	MaxBackoff time.Duration
+	//Fraction of the delay that is randomized, between 0 and 1, so the senders do not retry at the same time.
+	//Default is DEFAULT_RETRY_JITTER, a negative value disables it.
+	Jitter float64
+	//JSON-RPC error codes of the responses that are retried.
+	//Default is DEFAULT_RETRYABLE_ERROR_CODES.
+	RetryableCodes []int
+}
+
+//Return the policy with the defaults of the fields not set
+func (rp RetryPolicy) withDefaults() RetryPolicy {
+	if rp.MaxAttempts <= 0 {
+		rp.MaxAttempts = DEFAULT_RETRY_MAX_ATTEMPTS
+	}
+	if rp.InitialBackoff <= 0 {
+		rp.InitialBackoff = DEFAULT_RETRY_INITIAL_BACKOFF
+	}
+	if rp.MaxBackoff <= 0 {
+		rp.MaxBackoff = DEFAULT_RETRY_MAX_BACKOFF
+	}
+	if rp.Jitter == 0 {
+		rp.Jitter = DEFAULT_RETRY_JITTER
+	}
+	if rp.Jitter < 0 {
+		rp.Jitter = 0
+	}
+	if rp.Jitter > 1 {
+		rp.Jitter = 1
+	}
+	if rp.RetryableCodes == nil {
+		rp.RetryableCodes = DEFAULT_RETRYABLE_ERROR_CODES
+	}
+	return rp
+}
+
+//Return true if the error of an attempt is worth another attempt
+func (rp RetryPolicy) retryable(err error) bool {
+	var sendErr *TransportSendError
+	if errors.As(err, &sendErr) {
+		return true
+	}
+	var mcpErr *types.McpError
+	if !errors.As(err, &mcpErr) {
+		return false
+	}
+	for _, code := range rp.RetryableCodes {
+		if mcpErr.GetErrorCode() == code {
+			return true
+		}
+	}
+	return false
+}
+
+//Delay before the attempt after the given one, at least the wait asked by a rate limited error
+func (rp RetryPolicy) backoff(attempt int, err error) time.Duration {
+	delay := float64(rp.InitialBackoff) * math.Pow(2, float64(attempt-1))
+	delay = math.Min(delay, float64(rp.MaxBackoff))
+	delay -= delay * rp.Jitter * retryRand.Float64()
+	backoff := time.Duration(delay)
+	if retryAfter := errorRetryAfter(err); retryAfter > backoff {
+		backoff = retryAfter
+	}
+	return backoff
+}
+
+//Return the wait in the retryAfterMs of the data of an McpError, 0 if it has none
+func errorRetryAfter(err error) time.Duration {
+	var mcpErr *types.McpError
+	if !errors.As(err, &mcpErr) {
+		return 0
+	}
+	data, ok := mcpErr.GetErrorData().(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	//float64 when the error was received as JSON, int64 when it was built by this side
+	switch retryAfterMs := data["retryAfterMs"].(type) {
+	case float64:
+		return time.Duration(retryAfterMs * float64(time.Millisecond))
+	case int64:
+		return time.Duration(retryAfterMs) * time.Millisecond
+	}
+	return 0
+}
+
+//Return true if the request can be retried, a tools/call is idempotent if the tool was listed with annotations
+//that hint that it is read-only or idempotent
+func (p *Protocol) isIdempotentRequest(request types.RequestInterface, opts *RequestOptions) bool {
+	if opts.Idempotent || methods.MethodIn(methods.IDEMPOTENT_REQUEST_METHODS, request.GetRequest().Method) {
+		return true
+	}
+	call, ok := request.(*types.CallToolRequest)
+	return ok && p.idempotentTools.Get(call.Params.Name)
+}
+
+//Waits the delay, return the error of ctx if it is done first
+func waitRetry(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//Error of the transport sending a request, the request did not reach the remote side
+type TransportSendError struct {
+	Err error
+}
+
+func (e *TransportSendError) Error() string { return e.Err.Error() }
+func (e *TransportSendError) Unwrap() error { return e.Err }
+
+//Source of the jitter, seeded so the senders of different processes do not retry at the same time
+var retryRand = newLockedRand(time.Now().UnixNano())
+
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{r: rand.New(rand.NewSource(seed))}
+}
+
+func (lr *lockedRand) Float64() float64 {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	return lr.r.Float64()
+}
@@ -0,0 +1,275 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+)
+
+//Remote side that fails the first attempts of each method, then answers with its result
+type flakyRemote struct {
+	mu        sync.Mutex
+	transport *testTransport
+	//Attempts received by method
+	attempts map[string]int
+	//Failed attempts by method before answering
+	failures map[string]int
+	//Code of the error answered to the failed attempts, 0 fails the send in the transport
+	code int
+	//Data of the error answered to the failed attempts
+	data interface{}
+	//Result by method, an empty result if not set
+	results map[string]types.ResultInterface
+}
+
+func newFlakyRemote(transport *testTransport, code int) *flakyRemote {
+	r := &flakyRemote{
+		transport: transport,
+		attempts:  make(map[string]int),
+		failures:  make(map[string]int),
+		code:      code,
+		results:   make(map[string]types.ResultInterface),
+	}
+	transport.onSend = r.onSend
+	return r
+}
+
+func (r *flakyRemote) onSend(msg types.JSONRPCMessage) error {
+	request, ok := msg.(*types.JSONRPCRequest)
+	if !ok {
+		return nil
+	}
+	method := request.GetRequest().Method
+	r.mu.Lock()
+	r.attempts[method]++
+	failed := r.attempts[method] <= r.failures[method]
+	result := r.results[method]
+	r.mu.Unlock()
+	if failed && r.code == 0 {
+		return errors.New("broken pipe")
+	}
+	var response types.JSONRPCMessage
+	if failed {
+		response = &types.JSONRPCError{JSONRPC: types.JSONRPC_VERSION, ID: request.ID, Error: types.NewMcpError(r.code, "failed", r.data)}
+	} else {
+		if result == nil {
+			result = &types.EmptyResult{}
+		}
+		response = &types.JSONRPCResponse{JSONRPC: types.JSONRPC_VERSION, ID: request.ID, Result: result}
+	}
+	go r.transport.OnMessage(response, nil)
+	return nil
+}
+
+func (r *flakyRemote) Fail(method string, n int) {
+	r.mu.Lock()
+	r.failures[method] = n
+	r.attempts[method] = 0
+	r.mu.Unlock()
+}
+
+func (r *flakyRemote) Attempts(method string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.attempts[method]
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	rateLimited := func(retryAfterMs interface{}) error {
+		return types.NewMcpError(types.ERROR_CODE_RATE_LIMITED, "rate limited", map[string]interface{}{"retryAfterMs": retryAfterMs})
+	}
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		err     error
+		delay   time.Duration
+	}{
+		{"default initial backoff", RetryPolicy{Jitter: -1}, 1, nil, DEFAULT_RETRY_INITIAL_BACKOFF},
+		{"first retry", RetryPolicy{InitialBackoff: 10 * time.Millisecond, Jitter: -1}, 1, nil, 10 * time.Millisecond},
+		{"doubled", RetryPolicy{InitialBackoff: 10 * time.Millisecond, Jitter: -1}, 2, nil, 20 * time.Millisecond},
+		{"doubled twice", RetryPolicy{InitialBackoff: 10 * time.Millisecond, Jitter: -1}, 3, nil, 40 * time.Millisecond},
+		{"capped", RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 25 * time.Millisecond, Jitter: -1}, 3, nil, 25 * time.Millisecond},
+		{"default cap", RetryPolicy{InitialBackoff: time.Second, Jitter: -1}, 10, nil, DEFAULT_RETRY_MAX_BACKOFF},
+		{"retry after received", RetryPolicy{InitialBackoff: 10 * time.Millisecond, Jitter: -1}, 1, rateLimited(float64(1500)), 1500 * time.Millisecond},
+		{"retry after built", RetryPolicy{InitialBackoff: 10 * time.Millisecond, Jitter: -1}, 1, rateLimited(int64(30)), 30 * time.Millisecond},
+		{"retry after shorter", RetryPolicy{InitialBackoff: 10 * time.Millisecond, Jitter: -1}, 2, rateLimited(int64(5)), 20 * time.Millisecond},
+		{"retry after wrapped", RetryPolicy{InitialBackoff: 10 * time.Millisecond, Jitter: -1}, 1, fmt.Errorf("request %w", rateLimited(int64(30))), 30 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if delay := tt.policy.withDefaults().backoff(tt.attempt, tt.err); delay != tt.delay {
+				t.Fatalf("expected %v, got %v", tt.delay, delay)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		jitter float64
+		//Shortest delay of an attempt whose delay without jitter is 100ms
+		min time.Duration
+	}{
+		{"default", 0, 80 * time.Millisecond},
+		{"half", 0.5, 50 * time.Millisecond},
+		{"capped to the delay", 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, Jitter: tt.jitter}.withDefaults()
+			delays := make(map[time.Duration]struct{})
+			for i := 0; i < 100; i++ {
+				delay := policy.backoff(1, nil)
+				if delay < tt.min || delay > 100*time.Millisecond {
+					t.Fatalf("expected a delay between %v and 100ms, got %v", tt.min, delay)
+				}
+				delays[delay] = struct{}{}
+			}
+			if len(delays) < 2 {
+				t.Fatalf("expected the delays to be randomized, got %v", delays)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyRetryableCodes(t *testing.T) {
+	codeErr := func(code int) error { return types.NewMcpError(code, "failed", nil) }
+	custom := []int{types.ERROR_CODE_INTERNAL_ERROR}
+	tests := []struct {
+		name      string
+		codes     []int
+		err       error
+		retryable bool
+	}{
+		{"not sent", nil, fmt.Errorf("transport.Send %w", &TransportSendError{Err: errors.New("broken pipe")}), true},
+		{"request timeout", nil, codeErr(types.ERROR_CODE_REQUEST_TIMEOUT), true},
+		{"concurrency limit", nil, codeErr(types.ERROR_CODE_CONCURRENCY_LIMIT_EXCEEDED), true},
+		{"rate limited", nil, codeErr(types.ERROR_CODE_RATE_LIMITED), true},
+		{"invalid params", nil, codeErr(types.ERROR_CODE_INVALID_PARAMS), false},
+		{"internal error", nil, codeErr(types.ERROR_CODE_INTERNAL_ERROR), false},
+		{"other error", nil, errors.New("failed"), false},
+		{"custom code", custom, codeErr(types.ERROR_CODE_INTERNAL_ERROR), true},
+		{"default code not in custom codes", custom, codeErr(types.ERROR_CODE_RATE_LIMITED), false},
+		{"not sent with custom codes", custom, &TransportSendError{Err: errors.New("broken pipe")}, true},
+		{"no codes", []int{}, codeErr(types.ERROR_CODE_REQUEST_TIMEOUT), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := RetryPolicy{RetryableCodes: tt.codes}.withDefaults()
+			if retryable := policy.retryable(tt.err); retryable != tt.retryable {
+				t.Fatalf("expected retryable %v, got %v", tt.retryable, retryable)
+			}
+		})
+	}
+}
+
+func TestRequestRetries(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: time.Millisecond, Jitter: -1}
+	call := types.NewCallToolRequest(&types.CallToolRequestParams{Name: "write"})
+	tests := []struct {
+		name    string
+		request types.RequestInterface
+		opts    *RequestOptions
+		//Code of the error of the failed attempts, 0 fails the send
+		code     int
+		failures int
+		attempts int
+		//Code of the error returned, 0 for a send error and -1 for success
+		errCode int
+	}{
+		{"recovers from send errors", types.NewPingRequest(), &RequestOptions{RetryPolicy: policy}, 0, 2, 3, -1},
+		{"recovers from retryable codes", types.NewPingRequest(), &RequestOptions{RetryPolicy: policy}, types.ERROR_CODE_CONCURRENCY_LIMIT_EXCEEDED, 1, 2, -1},
+		{"default max attempts", types.NewPingRequest(), &RequestOptions{RetryPolicy: policy}, types.ERROR_CODE_RATE_LIMITED, 5, DEFAULT_RETRY_MAX_ATTEMPTS, types.ERROR_CODE_RATE_LIMITED},
+		{"max attempts", types.NewPingRequest(), &RequestOptions{RetryPolicy: &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, Jitter: -1}}, 0, 10, 5, 0},
+		{"code not retryable", types.NewPingRequest(), &RequestOptions{RetryPolicy: policy}, types.ERROR_CODE_INVALID_PARAMS, 1, 1, types.ERROR_CODE_INVALID_PARAMS},
+		{"no policy", types.NewPingRequest(), nil, 0, 1, 1, 0},
+		{"not idempotent tool call", call, &RequestOptions{RetryPolicy: policy}, 0, 1, 1, 0},
+		{"not idempotent tool call answered with retryable code", call, &RequestOptions{RetryPolicy: policy}, types.ERROR_CODE_RATE_LIMITED, 1, 1, types.ERROR_CODE_RATE_LIMITED},
+		{"tool call marked idempotent", call, &RequestOptions{RetryPolicy: policy, Idempotent: true}, 0, 1, 2, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, transport, _ := newTestProtocol(t)
+			remote := newFlakyRemote(transport, tt.code)
+			method := tt.request.GetRequest().Method
+			remote.Fail(method, tt.failures)
+			_, err := p.Request(context.Background(), tt.request, tt.opts)
+			if attempts := remote.Attempts(method); attempts != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, attempts)
+			}
+			switch tt.errCode {
+			case -1:
+				if err != nil {
+					t.Fatalf("expected the request to succeed, got %v", err)
+				}
+			case 0:
+				var sendErr *TransportSendError
+				if !errors.As(err, &sendErr) {
+					t.Fatalf("expected a send error, got %v", err)
+				}
+			default:
+				expectErrorCode(t, err, tt.errCode)
+			}
+		})
+	}
+}
+
+func TestRequestRetriesToolCallsFromAnnotations(t *testing.T) {
+	p, transport, _ := newTestProtocol(t)
+	remote := newFlakyRemote(transport, 0)
+	hint := func(v bool) *bool { return &v }
+	tool := func(name string, annotations *types.ToolAnnotations) types.Tool {
+		tool := types.Tool{Annotations: annotations}
+		tool.Name = name
+		return tool
+	}
+	remote.results[types.NewListToolsRequest(nil).Method] = &types.ListToolsResult{Tools: []types.Tool{
+		tool("lookup", &types.ToolAnnotations{IdempotentHint: hint(true)}),
+		tool("read", &types.ToolAnnotations{ReadOnlyHint: hint(true)}),
+		tool("append", &types.ToolAnnotations{IdempotentHint: hint(false)}),
+		tool("write", nil),
+	}}
+	opts := &RequestOptions{RetryPolicy: &RetryPolicy{InitialBackoff: time.Millisecond, Jitter: -1}}
+	//Sends a tools/call of the tool that fails once, return the attempts
+	callOnce := func(name string) int {
+		call := types.NewCallToolRequest(&types.CallToolRequestParams{Name: name})
+		remote.Fail(call.Method, 1)
+		p.Request(context.Background(), call, opts)
+		return remote.Attempts(call.Method)
+	}
+
+	//Before the tools are listed no tool call is idempotent
+	if attempts := callOnce("lookup"); attempts != 1 {
+		t.Fatalf("expected the call of a tool not listed to be sent once, got %d", attempts)
+	}
+	if _, err := p.Request(context.Background(), types.NewListToolsRequest(nil), nil); err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	for name, attempts := range map[string]int{"lookup": 2, "read": 2, "append": 1, "write": 1, "unknown": 1} {
+		if got := callOnce(name); got != attempts {
+			t.Fatalf("expected %d attempts of the call of %s, got %d", attempts, name, got)
+		}
+	}
+
+	//The tools changed, they are not idempotent until listed again
+	transport.OnMessage(&types.JSONRPCNotification{
+		JSONRPC:               types.JSONRPC_VERSION,
+		NotificationInterface: types.NewToolListChangedNotification(nil),
+	}, nil)
+	if attempts := callOnce("lookup"); attempts != 1 {
+		t.Fatalf("expected the call of a changed tool to be sent once, got %d", attempts)
+	}
+	if _, err := p.Request(context.Background(), types.NewListToolsRequest(nil), nil); err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if attempts := callOnce("lookup"); attempts != 2 {
+		t.Fatalf("expected the call of the listed tool to be retried, got %d", attempts)
+	}
+}
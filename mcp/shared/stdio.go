@@ -1,23 +1,38 @@
 package shared
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"strings"
+	"sync"
 
 	"github.com/victorvbello/gomcp/mcp/types"
 )
 
+const (
+	//Default maximum size of a message line, the same as the maximum body of the HTTP transport
+	DEFAULT_MAX_LINE_SIZE = 4 * 1024 * 1024
+	//Bytes read from the stream at once by ReadMessageStream
+	STREAM_READ_CHUNK_SIZE = 32 * 1024
+)
+
+//Buffer of newline-delimited JSON-RPC messages, the chunks read from a stream are appended and the
+//complete lines decoded in order. It is safe for concurrent use.
 type ReadBuffer struct {
+	mu     sync.Mutex
 	buffer bytes.Buffer
+	//Lines longer than this are discarded, ReadMessage returns an error for each of them.
+	//Default is DEFAULT_MAX_LINE_SIZE.
+	MaxLineSize int
+	//Set while the rest of a too long line is discarded
+	skipping bool
 }
 
-//Append adds new data to the buffer.
+//Append adds new data to the buffer, the chunk is copied so the caller can reuse it.
 func (rb *ReadBuffer) Append(chunk []byte) (int, error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
 	b, err := rb.buffer.Write(chunk)
 	if err != nil {
 		return 0, fmt.Errorf("rb.buffer.Write %v", err)
@@ -25,29 +40,17 @@ func (rb *ReadBuffer) Append(chunk []byte) (int, error) {
 	return b, nil
 }
 
-//ReadMessage reads the next JSON-RPC message from the buffer if a full line is available.
+//ReadMessage reads the next JSON-RPC message from the buffer if a full line is available, the empty lines are skipped.
+//
+//Return nil without error when there is no full line yet. An invalid or too long line returns an error
+//and is consumed, so the next call continues with the following line.
 func (rb *ReadBuffer) ReadMessage() (types.JSONRPCMessage, error) {
-	//Use a buffered reader over the current buffer contents
-	reader := bufio.NewReader(&rb.buffer)
-	//Peek to see if we have a complete line (without consuming it)
-	line, err := reader.ReadString('\n')
-	if err != nil {
-		if errors.Is(err, io.EOF) {
-			return nil, nil //No full line yet
-		}
-		return nil, fmt.Errorf("reader.ReadString %v", err)
-	}
-	//Remove optional trailing \r
-	line = strings.TrimRight(line, "\r\n")
-	if line == "" {
-		//Return if empty
-		return nil, nil
+	line, err := rb.readLine()
+	if err != nil || line == nil {
+		return nil, err
 	}
-
-	//Consume the line from the buffer
-	//Note: bufio.ReadString also advances the underlying reader, so the buffer is now clean
 	var msg types.RawMessage
-	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+	if err := json.Unmarshal(line, &msg); err != nil {
 		return nil, fmt.Errorf("json.Unmarshal %v", err)
 	}
 	finalMsg, err := msg.ToJSONRPCMessage()
@@ -57,9 +60,83 @@ func (rb *ReadBuffer) ReadMessage() (types.JSONRPCMessage, error) {
 	return finalMsg, nil
 }
 
+//Return a copy of the next non empty line without the line break, nil if there is no full line yet
+func (rb *ReadBuffer) readLine() ([]byte, error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	maxLineSize := rb.MaxLineSize
+	if maxLineSize <= 0 {
+		maxLineSize = DEFAULT_MAX_LINE_SIZE
+	}
+	for {
+		data := rb.buffer.Bytes()
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			if rb.skipping {
+				rb.buffer.Reset()
+				return nil, nil
+			}
+			if len(data) > maxLineSize {
+				rb.buffer.Reset()
+				rb.skipping = true
+				return nil, fmt.Errorf("line exceeds the maximum size of %d bytes", maxLineSize)
+			}
+			return nil, nil
+		}
+		if rb.skipping {
+			//End of the too long line, already reported
+			rb.buffer.Next(i + 1)
+			rb.skipping = false
+			continue
+		}
+		if i > maxLineSize {
+			rb.buffer.Next(i + 1)
+			return nil, fmt.Errorf("line exceeds the maximum size of %d bytes", maxLineSize)
+		}
+		//Copied, the bytes of the buffer are reused by the next Append
+		line := append([]byte(nil), bytes.TrimRight(data[:i], "\r")...)
+		rb.buffer.Next(i + 1)
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		return line, nil
+	}
+}
+
 //Clear resets the internal buffer.
 func (rb *ReadBuffer) Clear() {
+	rb.mu.Lock()
 	rb.buffer.Reset()
+	rb.skipping = false
+	rb.mu.Unlock()
+}
+
+//Reads the stream until it fails, the messages are decoded and given to onMessage in the order they were read,
+//from this single goroutine. The invalid lines are reported to onError and skipped.
+//
+//Return the error of the read, io.EOF when the stream ended
+func ReadMessageStream(reader io.Reader, rb *ReadBuffer, onMessage func(message types.JSONRPCMessage), onError func(err error)) error {
+	buf := make([]byte, STREAM_READ_CHUNK_SIZE)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			rb.Append(buf[:n])
+			for {
+				message, mErr := rb.ReadMessage()
+				if mErr != nil {
+					onError(fmt.Errorf("rb.ReadMessage %v", mErr))
+					continue
+				}
+				if message == nil {
+					break
+				}
+				onMessage(message)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
 
 func StdioSerializeMessage(msg types.JSONRPCMessage) (string, error) {
@@ -0,0 +1,52 @@
+package shared
+
+import (
+	"context"
+
+	"github.com/victorvbello/gomcp/mcp/types"
+	"github.com/victorvbello/gomcp/mcp/utils/tracing"
+)
+
+const (
+	TRACE_ATTRIBUTE_METHOD        = "mcp.method.name"
+	TRACE_ATTRIBUTE_REQUEST_ID    = "mcp.request.id"
+	TRACE_ATTRIBUTE_ATTEMPT       = "mcp.request.attempt"
+	TRACE_ATTRIBUTE_SESSION_ID    = "mcp.session.id"
+	TRACE_ATTRIBUTE_TOOL_NAME     = "mcp.tool.name"
+	TRACE_ATTRIBUTE_TOOL_IS_ERROR = "mcp.tool.is_error"
+	TRACE_ATTRIBUTE_JSONRPC_ERROR = "rpc.jsonrpc.error_code"
+	//Spans of the tool callbacks are named "tool <name>"
+	TRACE_SPAN_NAME_TOOL_PREFIX = "tool "
+)
+
+//Return the context received with a message: the _meta entries or else the HTTP headers of the request that carried it
+func remoteTraceContext(meta types.Meta, extra *MessageExtraInfo) (tracing.TraceContext, bool) {
+	if tc, ok := tracing.ExtractMeta(meta); ok {
+		return tc, true
+	}
+	if extra != nil && extra.RequestInfo != nil {
+		return tracing.ExtractHTTPHeaders(extra.RequestInfo.Headers)
+	}
+	return tracing.TraceContext{}, false
+}
+
+//Return the first context with a span or remote trace context, context.Background if none has it
+func traceParentContext(candidates ...context.Context) context.Context {
+	for _, ctx := range candidates {
+		if _, ok := tracing.ParentFromContext(ctx); ok {
+			return ctx
+		}
+	}
+	return context.Background()
+}
+
+//Return the _meta entries that propagate the span to the remote side, nil if the span has no valid context
+func traceMeta(span tracing.Span) types.Meta {
+	tc := span.TraceContext()
+	if !tc.IsValid() {
+		return nil
+	}
+	meta := make(types.Meta)
+	tracing.InjectMeta(meta, tc)
+	return meta
+}
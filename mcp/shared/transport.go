@@ -1,6 +1,8 @@
 package shared
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/victorvbello/gomcp/mcp/types"
@@ -11,6 +13,11 @@ const (
 	TRANSPORT_HEADER_LAST_EVENT_ID = "last-event-id"
 )
 
+//Returned (wrapped) by Transport.Send when there is no channel to deliver the requests initiated by this side,
+//e.g. an HTTP client that never opened the GET stream. The keepalive does not count these pings as failures,
+//the remote side is declared dead when it sends no message for KeepAliveOptions.IdleTimeout.
+var ErrNoRequestChannel = fmt.Errorf("no channel to send requests to the remote side")
+
 type TransportSendOptions struct {
 	//If present, `relatedRequestId` is used to indicate to the transport which incoming request to associate this outgoing message with.
 	RelatedRequestID types.RequestID
@@ -34,6 +41,15 @@ type MessageExtraInfo struct {
 	RequestInfo *RequestInfo
 	//The authentication information.
 	AuthInfo *types.AuthInfo
+	//Context of the transport request that carried the message (e.g. the HTTP request), done when the client disconnects.
+	//The handlers of the requests in the message are cancelled with it.
+	Context context.Context
+	//Set by the transports that read the messages of a stream in a single loop (stdio, sockets):
+	//OnMessage returns once the request is registered, so a later cancellation finds it, and its handler runs in its own goroutine
+	//so the following messages are dispatched in order without waiting for it.
+	ConcurrentHandling bool
+	//Called by onRequest once the request is registered, set for the requests with ConcurrentHandling
+	registered func()
 }
 
 type Transport interface {
@@ -78,3 +94,15 @@ type Transport interface {
 	//Set this if globalOnMessage is needed, this must be executed into OnMessage Func first
 	SetGlobalOnMessage(func(message types.JSONRPCMessage, extra *MessageExtraInfo))
 }
+
+//Implemented by the transports that keep state for each request until its response is sent
+type RequestReleaser interface {
+	//Frees the state of a request that ends without a response, e.g. a cancelled request
+	ReleaseRequest(requestID types.RequestID)
+}
+
+//Implemented by the transports that queue the messages sent, used by Protocol.Shutdown before closing them
+type TransportDrainer interface {
+	//Waits until the queued messages are written to the remote side, return the error of ctx if it is done first
+	Drain(ctx context.Context) error
+}
@@ -4,6 +4,136 @@ package types
 type AuthInfo struct {
 	//The access token.
 	Token string
+	//The client ID associated with this token.
+	ClientID string
+	//Scopes associated with this token.
+	Scopes []string
 	//When the token expires (in seconds since epoch).
 	ExpiresAt int
+	//Additional data associated with the token, for JWT access tokens these are the decoded claims.
+	Extra map[string]interface{}
+}
+
+//Return true if the token has all the given scopes
+func (ai *AuthInfo) HasScopes(scopes ...string) bool {
+	granted := make(map[string]struct{}, len(ai.Scopes))
+	for _, s := range ai.Scopes {
+		granted[s] = struct{}{}
+	}
+	for _, s := range scopes {
+		if _, ok := granted[s]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+//OAuth 2.0 Protected Resource Metadata, https://datatracker.ietf.org/doc/html/rfc9728
+type OAuthProtectedResourceMetadata struct {
+	//The protected resource's resource identifier, a URL that uses the https scheme.
+	Resource string `json:"resource"`
+	//Issuer identifiers of the authorization servers that can be used with this protected resource.
+	AuthorizationServers []string `json:"authorization_servers,omitempty"`
+	//URL of the protected resource's JSON Web Key Set document.
+	JwksURI string `json:"jwks_uri,omitempty"`
+	//Scope values used in authorization requests to request access to this protected resource.
+	ScopesSupported []string `json:"scopes_supported,omitempty"`
+	//Supported methods of sending an OAuth 2.0 bearer token to the protected resource: header, body or query.
+	BearerMethodsSupported []string `json:"bearer_methods_supported,omitempty"`
+	//JWS signing algorithms supported by the protected resource for signing resource responses.
+	ResourceSigningAlgValuesSupported []string `json:"resource_signing_alg_values_supported,omitempty"`
+	//Human-readable name of the protected resource intended for display to the end user.
+	ResourceName string `json:"resource_name,omitempty"`
+	//URL of a page containing human-readable information that developers might want or need to know when using the protected resource.
+	ResourceDocumentation string `json:"resource_documentation,omitempty"`
+	//URL of a page containing human-readable information about the protected resource's requirements on how the client can use the data.
+	ResourcePolicyURI string `json:"resource_policy_uri,omitempty"`
+	//URL of a page containing human-readable information about the protected resource's terms of service.
+	ResourceTosURI string `json:"resource_tos_uri,omitempty"`
+}
+
+//OAuth 2.0 Authorization Server Metadata, https://datatracker.ietf.org/doc/html/rfc8414
+type OAuthMetadata struct {
+	//The authorization server's issuer identifier, a URL that uses the https scheme and has no query or fragment components.
+	Issuer string `json:"issuer"`
+	//URL of the authorization server's authorization endpoint.
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	//URL of the authorization server's token endpoint.
+	TokenEndpoint string `json:"token_endpoint"`
+	//URL of the authorization server's OAuth 2.0 Dynamic Client Registration endpoint.
+	RegistrationEndpoint string `json:"registration_endpoint,omitempty"`
+	//URL of the authorization server's JWK Set document.
+	JwksURI string `json:"jwks_uri,omitempty"`
+	//Scope values that this authorization server supports.
+	ScopesSupported []string `json:"scopes_supported,omitempty"`
+	//The "response_type" values that this authorization server supports.
+	ResponseTypesSupported []string `json:"response_types_supported"`
+	//The "response_mode" values that this authorization server supports.
+	ResponseModesSupported []string `json:"response_modes_supported,omitempty"`
+	//The OAuth 2.0 grant type values that this authorization server supports.
+	GrantTypesSupported []string `json:"grant_types_supported,omitempty"`
+	//Client authentication methods supported by the token endpoint.
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	//URL of a page containing human-readable information that developers might want or need to know when using the authorization server.
+	ServiceDocumentation string `json:"service_documentation,omitempty"`
+	//URL of the authorization server's OAuth 2.0 revocation endpoint.
+	RevocationEndpoint string `json:"revocation_endpoint,omitempty"`
+	//Client authentication methods supported by the revocation endpoint.
+	RevocationEndpointAuthMethodsSupported []string `json:"revocation_endpoint_auth_methods_supported,omitempty"`
+	//URL of the authorization server's OAuth 2.0 introspection endpoint.
+	IntrospectionEndpoint string `json:"introspection_endpoint,omitempty"`
+	//PKCE code challenge methods supported by this authorization server.
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+//OAuth 2.0 Dynamic Client Registration metadata, https://datatracker.ietf.org/doc/html/rfc7591#section-2
+type OAuthClientMetadata struct {
+	//Array of redirection URI strings for use in redirect-based flows.
+	RedirectURIs []string `json:"redirect_uris"`
+	//Requested authentication method for the token endpoint: none, client_secret_post or client_secret_basic.
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method,omitempty"`
+	//Array of OAuth 2.0 grant type strings that the client can use at the token endpoint.
+	GrantTypes []string `json:"grant_types,omitempty"`
+	//Array of the OAuth 2.0 response type strings that the client can use at the authorization endpoint.
+	ResponseTypes []string `json:"response_types,omitempty"`
+	//Human-readable string name of the client to be presented to the end-user during authorization.
+	ClientName string `json:"client_name,omitempty"`
+	//URL string of a web page providing information about the client.
+	ClientURI string `json:"client_uri,omitempty"`
+	//URL string that references a logo for the client.
+	LogoURI string `json:"logo_uri,omitempty"`
+	//Space-separated list of scope values that the client can use when requesting access tokens.
+	Scope string `json:"scope,omitempty"`
+	//Array of strings representing ways to contact people responsible for this client.
+	Contacts []string `json:"contacts,omitempty"`
+	//URL string that points to a human-readable terms of service document for the client.
+	TosURI string `json:"tos_uri,omitempty"`
+	//URL string that points to a human-readable privacy policy document.
+	PolicyURI string `json:"policy_uri,omitempty"`
+	//Identifier for the software that comprises a client.
+	SoftwareID string `json:"software_id,omitempty"`
+	//Version identifier string for the client software.
+	SoftwareVersion string `json:"software_version,omitempty"`
+}
+
+//OAuth 2.0 Dynamic Client Registration response, https://datatracker.ietf.org/doc/html/rfc7591#section-3.2.1
+type OAuthClientInformation struct {
+	OAuthClientMetadata
+	//OAuth 2.0 client identifier string.
+	ClientID string `json:"client_id"`
+	//OAuth 2.0 client secret string, empty for public clients.
+	ClientSecret string `json:"client_secret,omitempty"`
+	//Time at which the client identifier was issued, seconds since the Unix epoch.
+	ClientIDIssuedAt int64 `json:"client_id_issued_at,omitempty"`
+	//Time at which the client secret will expire or 0 if it will not expire.
+	ClientSecretExpiresAt int64 `json:"client_secret_expires_at,omitempty"`
+}
+
+//OAuth 2.0 token endpoint response, https://datatracker.ietf.org/doc/html/rfc6749#section-5.1
+type OAuthTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 }
@@ -1,5 +1,10 @@
 package types
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 const (
 	TEXT_CONTENT_TYPE              = "text"
 	IMAGE_CONTENT_TYPE             = "image"
@@ -84,9 +89,65 @@ type EmbeddedResource struct {
 
 func (e *EmbeddedResource) TypeOfContent() string { return EMBEDDED_RESOURCE_CONTENT_TYPE }
 
+func (e *EmbeddedResource) UnmarshalJSON(data []byte) error {
+	var meta struct {
+		BaseContent
+		Resource json.RawMessage `json:"resource"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("error unmarshaling global meta: %v", err)
+	}
+	resource, err := decodeResourceContents(meta.Resource)
+	if err != nil {
+		return err
+	}
+	e.BaseContent = meta.BaseContent
+	e.Resource = resource
+	return nil
+}
+
 func NewEmbeddedResource(Resource ResourceContents) *EmbeddedResource {
 	c := new(EmbeddedResource)
 	c.Type = "resource"
 	c.Resource = Resource
 	return c
 }
+
+//Decode a content by its type, the contents without a known type are decoded as TextContent
+func decodeContent(data json.RawMessage) (Content, error) {
+	var base BaseContent
+	if err := json.Unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("error unmarshaling content type: %v", err)
+	}
+	var c Content
+	switch base.Type {
+	case IMAGE_CONTENT_TYPE:
+		c = new(ImageContent)
+	case AUDIO_CONTENT_TYPE:
+		c = new(AudioContent)
+	case EMBEDDED_RESOURCE_CONTENT_TYPE:
+		c = new(EmbeddedResource)
+	default:
+		c = new(TextContent)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("error unmarshaling %s content: %v", base.Type, err)
+	}
+	return c, nil
+}
+
+//Decode a list of contents, see decodeContent
+func decodeContents(data []json.RawMessage) ([]Content, error) {
+	if data == nil {
+		return nil, nil
+	}
+	contents := make([]Content, 0, len(data))
+	for _, item := range data {
+		c, err := decodeContent(item)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, c)
+	}
+	return contents, nil
+}
@@ -26,6 +26,12 @@ const (
 	ERROR_CODE_SESSION_ID_NOT_FOUND = -32002
 	//-32003
 	ERROR_CODE_METHOD_NOT_ALLOWED = -32003
+	//-32004, the caller is not authorized to use the tool, resource or prompt
+	ERROR_CODE_FORBIDDEN = -32004
+	//-32005, the request was rejected because too many requests are being handled, the request can be retried later
+	ERROR_CODE_CONCURRENCY_LIMIT_EXCEEDED = -32005
+	//-32006, the caller exceeded a rate limit, the data of the error has the milliseconds to wait in retryAfterMs
+	ERROR_CODE_RATE_LIMITED = -32006
 )
 
 const (
@@ -66,8 +72,13 @@ func (e *McpError) TypeOfError() int          { return MCP_ERROR_ERROR_INTERFACE
 func (e *McpError) GetErrorCode() int         { return e.Err.Code }
 func (e *McpError) GetErrorMessage() string   { return e.Err.Message }
 func (e *McpError) GetErrorData() interface{} { return e.Err.Data }
+func (e *McpError) Error() string {
+	return fmt.Sprintf("code: %d, message: %s, data: %v", e.Err.Code, e.Err.Message, e.Err.Data)
+}
+
+//Return the McpError as error, the code and data can be recovered with errors.As
 func (e *McpError) ToError() error {
-	return fmt.Errorf("code: %d, message: %s, data: %v", e.Err.Code, e.Err.Message, e.Err.Data)
+	return e
 }
 
 func NewMcpError(code int, msg string, data interface{}) *McpError {
@@ -1,5 +1,10 @@
 package types
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 const (
 	LATEST_PROTOCOL_VERSION             = "2025-03-26"
 	DEFAULT_NEGOTIATED_PROTOCOL_VERSION = "2025-03-26"
@@ -28,6 +33,53 @@ func (m Meta) GetMeta() map[string]interface{} { return m }
 // SetMeta sets the metadata on a value.
 func (m *Meta) SetMeta(x map[string]interface{}) { *m = x }
 
+//Return the _meta of the params of a marshaled request or notification, nil if it has none
+func paramsMeta(v interface{}) (Meta, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal %v", err)
+	}
+	var message struct {
+		Params struct {
+			Meta Meta `json:"_meta"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(b, &message); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal %v", err)
+	}
+	return message.Params.Meta, nil
+}
+
+//Marshals a request or notification adding the meta entries to the _meta of its params,
+//the entries already in the _meta are replaced
+func marshalWithParamsMeta(v interface{}, meta Meta) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal %v", err)
+	}
+	if len(meta) == 0 {
+		return b, nil
+	}
+	var message map[string]interface{}
+	if err := json.Unmarshal(b, &message); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal %v", err)
+	}
+	params, _ := message["params"].(map[string]interface{})
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	paramsMeta, _ := params["_meta"].(map[string]interface{})
+	if paramsMeta == nil {
+		paramsMeta = make(map[string]interface{})
+	}
+	for key, value := range meta {
+		paramsMeta[key] = value
+	}
+	params["_meta"] = paramsMeta
+	message["params"] = params
+	return json.Marshal(message)
+}
+
 //Base metadata interface for common properties across resources, tools, prompts, and implementations.
 type BaseMetadata struct {
 	// Intended for programmatic or logical use, but used as a display name in past specs or fallback
@@ -3,8 +3,6 @@ package types
 import (
 	"encoding/json"
 	"fmt"
-
-	"github.com/victorvbello/gomcp/mcp/methods"
 )
 
 const (
@@ -41,10 +39,50 @@ type JSONRPCMessage interface {
 	JSONRPCMessageType() int
 }
 
+//A JSON-RPC message kept as the original JSON, e.g. loaded from a persistent event store, it is marshaled unchanged
+type JSONRPCRawMessage struct {
+	//One of the JSONRPC_MESSAGE_* types
+	Type int
+	Data json.RawMessage
+}
+
+func (jm *JSONRPCRawMessage) JSONRPCMessageType() int      { return jm.Type }
+func (jm *JSONRPCRawMessage) MarshalJSON() ([]byte, error) { return jm.Data, nil }
+
+//Builds a JSONRPCRawMessage, the type is detected from the message members
+func NewJSONRPCRawMessage(data []byte) (*JSONRPCRawMessage, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal %v", err)
+	}
+	_, hasMethod := members["method"]
+	_, hasID := members["id"]
+	_, hasResult := members["result"]
+	_, hasError := members["error"]
+	raw := &JSONRPCRawMessage{Data: append(json.RawMessage(nil), data...)}
+	switch {
+	case hasMethod && hasID:
+		raw.Type = JSONRPC_MESSAGE_JSONRPC_REQUEST_TYPE
+	case hasMethod:
+		raw.Type = JSONRPC_MESSAGE_JSONRPC_NOTIFICATION_TYPE
+	case hasResult:
+		raw.Type = JSONRPC_MESSAGE_JSONRPC_RESPONSE_TYPE
+	case hasError:
+		raw.Type = JSONRPC_MESSAGE_JSONRPC_ERROR_TYPE
+	default:
+		return nil, fmt.Errorf("unknown JSON-RPC message")
+	}
+	return raw, nil
+}
+
 func JSONRPCMessageMarshalJSON(msg JSONRPCMessage) ([]byte, error) {
 	var result []byte
 	var err error
 
+	if raw, ok := msg.(*JSONRPCRawMessage); ok {
+		return raw.Data, nil
+	}
+
 	jmTyp := msg.JSONRPCMessageType()
 	switch jmTyp {
 	case JSONRPC_MESSAGE_JSONRPC_REQUEST_TYPE:
@@ -128,59 +166,19 @@ func (jr *JSONRPCRequest) JSONRPCBatchRequestType() int {
 }
 
 func (jr *JSONRPCRequest) MarshalJSON() ([]byte, error) {
-	//bridge struct to marshal known fields
-	aux := struct {
-		JSONRPC string    `json:"jsonrpc"`
-		ID      RequestID `json:"id"`
-	}{
-		JSONRPC: jr.JSONRPC,
-		ID:      jr.ID,
-	}
-	knownFields, err := json.Marshal(&aux)
-	if err != nil {
-		return nil, fmt.Errorf("marshal known fields: %w", err)
-	}
-	//Marshal knownFields to map
+	//The method and params come from the RequestInterface, jsonrpc and id are added to them
 	baseMap := make(map[string]interface{})
-	if err := json.Unmarshal(knownFields, &baseMap); err != nil {
-		return nil, fmt.Errorf("unmarshal known fields to map: %w", err)
-	}
-	//Marshal RequestInterface
-	var reqInB []byte
-	switch jr.RequestInterface.TypeOfRequestInterface() {
-	case PING_REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	case INITIALIZE_REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	case REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	case CREATE_MESSAGE_REQUEST_REQUEST_INTERFACE_TYPE:
-		reqInB, err = json.Marshal(jr.RequestInterface.(*CreateMessageRequest))
+	if jr.RequestInterface != nil {
+		reqInB, err := json.Marshal(jr.RequestInterface)
 		if err != nil {
-			return nil, fmt.Errorf("marshal METHOD_SAMPLING_CREATE_MESSAGE fields: %w", err)
+			return nil, fmt.Errorf("marshal request fields: %w", err)
+		}
+		if err := json.Unmarshal(reqInB, &baseMap); err != nil {
+			return nil, fmt.Errorf("unmarshal request fields: %w", err)
 		}
-	case LIST_ROOTS_REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	case SET_LEVEL_REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	case GET_PROMPT_REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	case LIST_PROMPTS_REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	case LIST_RESOURCES_REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	case LIST_RESOURCE_TEMPLATES_REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	case READ_RESOURCE_REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	case CALL_TOOL_REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	case LIST_TOOLS_REQUEST_REQUEST_INTERFACE_TYPE:
-		break
-	}
-	if err := json.Unmarshal(reqInB, &baseMap); err != nil {
-		return nil, fmt.Errorf("unmarshal base fields: %w", err)
 	}
+	baseMap["jsonrpc"] = jr.JSONRPC
+	baseMap["id"] = jr.ID
 	return json.Marshal(baseMap)
 }
 func (jr *JSONRPCRequest) UnmarshalJSON(data []byte) error {
@@ -195,40 +193,8 @@ func (jr *JSONRPCRequest) UnmarshalJSON(data []byte) error {
 	jr.JSONRPC = meta.JSONRPC
 	jr.ID = meta.ID
 
-	var r RequestInterface
-	switch meta.Method {
-	case methods.METHOD_REQUEST_INITIALIZE:
-		r = new(InitializeRequest)
-	case methods.METHOD_REQUEST_PING:
-		r = new(PingRequest)
-	case methods.METHOD_REQUEST_LIST_RESOURCES:
-		r = new(ListResourcesRequest)
-	case methods.METHOD_REQUEST_TEMPLATES_LIST_RESOURCES:
-		r = new(ListResourceTemplatesRequest)
-	case methods.METHOD_REQUEST_READ_RESOURCES:
-		r = new(ReadResourceRequest)
-	case methods.METHOD_REQUEST_SUBSCRIBE_RESOURCES:
-		r = new(SubscribeRequest)
-	case methods.METHOD_REQUEST_UNSUBSCRIBE_RESOURCES:
-		r = new(UnsubscribeRequest)
-	case methods.METHOD_REQUEST_LIST_PROMPTS:
-		r = new(ListPromptsRequest)
-	case methods.METHOD_REQUEST_GET_PROMPTS:
-		r = new(GetPromptRequest)
-	case methods.METHOD_REQUEST_LIST_TOOLS:
-		r = new(ListToolsRequest)
-	case methods.METHOD_REQUEST_CALL_TOOLS:
-		r = new(CallToolRequest)
-	case methods.METHOD_REQUEST_SET_LEVEL_LOGGING:
-		r = new(SetLevelRequest)
-	case methods.METHOD_SAMPLING_CREATE_MESSAGE:
-		r = new(CreateMessageRequest)
-	case methods.METHOD_AUTOCOMPLETE_COMPLETE:
-		r = new(CompleteRequest)
-	case methods.METHOD_LIST_ROOTS:
-		r = new(ListRootsRequest)
-	}
-	if err := json.Unmarshal(data, &r); err != nil {
+	r := newRequestForMethod(meta.Method)
+	if err := json.Unmarshal(data, r); err != nil {
 		return fmt.Errorf("error unmarshaling method: %s, err: %v", meta.Method, err)
 	}
 	jr.RequestInterface = r
@@ -247,35 +213,33 @@ func (jn *JSONRPCNotification) JSONRPCMessageType() int {
 func (jn *JSONRPCNotification) JSONRPCBatchRequestType() int {
 	return JSONRPC_BATCH_REQUEST_JSONRPC_NOTIFICATION_TYPE
 }
+func (jn *JSONRPCNotification) MarshalJSON() ([]byte, error) {
+	//The method and params come from the NotificationInterface, jsonrpc is added to them
+	baseMap := make(map[string]interface{})
+	if jn.NotificationInterface != nil {
+		notInB, err := json.Marshal(jn.NotificationInterface)
+		if err != nil {
+			return nil, fmt.Errorf("marshal notification fields: %w", err)
+		}
+		if err := json.Unmarshal(notInB, &baseMap); err != nil {
+			return nil, fmt.Errorf("unmarshal notification fields: %w", err)
+		}
+	}
+	baseMap["jsonrpc"] = jn.JSONRPC
+	return json.Marshal(baseMap)
+}
 func (jn *JSONRPCNotification) UnmarshalJSON(data []byte) error {
 	var meta struct {
-		Method string `json:"method"`
+		JSONRPC string `json:"jsonrpc"`
+		Method  string `json:"method"`
 	}
 	if err := json.Unmarshal(data, &meta); err != nil {
 		return fmt.Errorf("error unmarshaling global meta: %v", err)
 	}
-	var n NotificationInterface
-	switch meta.Method {
-	case methods.METHOD_NOTIFICATION_CANCELLED:
-		n = new(CancelledNotification)
-	case methods.METHOD_NOTIFICATION_INITIALIZED:
-		n = new(InitializedNotification)
-	case methods.METHOD_NOTIFICATION_PROGRESS:
-		n = new(ProgressNotification)
-	case methods.METHOD_NOTIFICATION_RESOURCES_LIST_CHANGED:
-		n = new(ResourceListChangedNotification)
-	case methods.METHOD_NOTIFICATION_RESOURCES_UPDATED:
-		n = new(ResourceUpdatedNotification)
-	case methods.METHOD_NOTIFICATION_PROMPTS_LIST_CHANGED:
-		n = new(PromptListChangedNotification)
-	case methods.METHOD_NOTIFICATION_TOOLS_LIST_CHANGED:
-		n = new(ToolListChangedNotification)
-	case methods.METHOD_NOTIFICATION_MESSAGE:
-		n = new(LoggingMessageNotification)
-	case methods.METHOD_NOTIFICATION_ROOTS_LIST_CHANGED:
-		n = new(RootsListChangedNotification)
-	}
-	if err := json.Unmarshal(data, &n); err != nil {
+	jn.JSONRPC = meta.JSONRPC
+
+	n := newNotificationForMethod(meta.Method)
+	if err := json.Unmarshal(data, n); err != nil {
 		return fmt.Errorf("error unmarshaling method: %s, err: %v", meta.Method, err)
 	}
 	jn.NotificationInterface = n
@@ -287,6 +251,8 @@ type JSONRPCResponse struct {
 	JSONRPC string          `json:"jsonrpc"`
 	ID      RequestID       `json:"id"`
 	Result  ResultInterface `json:"result"`
+	//Raw JSON of the result as received, used to decode it by the method of the request
+	rawResult json.RawMessage
 }
 
 func (jr *JSONRPCResponse) JSONRPCMessageType() int { return JSONRPC_MESSAGE_JSONRPC_RESPONSE_TYPE }
@@ -298,6 +264,9 @@ func (jr *JSONRPCResponse) JSONRPCGeneralResponseType() int {
 }
 func (jr *JSONRPCResponse) GetRequestID() RequestID { return jr.ID }
 
+//Return the raw JSON of the result of a received response, nil if the response was not unmarshaled
+func (jr *JSONRPCResponse) RawResult() json.RawMessage { return jr.rawResult }
+
 func (jr *JSONRPCResponse) MarshalJSON() ([]byte, error) {
 	//bridge struct to marshal known fields
 	aux := struct {
@@ -395,7 +364,8 @@ func (jr *JSONRPCResponse) MarshalJSON() ([]byte, error) {
 		}
 		baseMap["result"] = *msLTR
 	default:
-		return nil, fmt.Errorf("ResultInterface invalid type for %d", jmTyp)
+		//Results of custom methods, e.g. RawResult
+		baseMap["result"] = jr.Result
 	}
 	return json.Marshal(baseMap)
 }
@@ -411,9 +381,21 @@ func (jr *JSONRPCResponse) UnmarshalJSON(data []byte) error {
 	}
 	jr.JSONRPC = meta.JSONRPC
 	jr.ID = meta.ID
+	jr.rawResult = meta.Result
+	//The type guessed by the fields can be wrong, the Request that waits for the response decodes the raw result
+	//by its method, so a result that does not fit the guessed type is left nil instead of dropping the response
+	r, err := decodeResultByFields(meta.Result)
+	if err == nil {
+		jr.Result = r
+	}
+	return nil
+}
+
+//Decode a result without knowing the method of the request, the type is guessed by the fields of the result
+func decodeResultByFields(data json.RawMessage) (ResultInterface, error) {
 	resultDataMap := make(map[string]interface{})
-	if err := json.Unmarshal(meta.Result, &resultDataMap); err != nil {
-		return fmt.Errorf("error unmarshaling global data in map: %v", err)
+	if err := json.Unmarshal(data, &resultDataMap); err != nil {
+		return nil, fmt.Errorf("error unmarshaling global data in map: %v", err)
 	}
 
 	var r ResultInterface
@@ -442,11 +424,10 @@ func (jr *JSONRPCResponse) UnmarshalJSON(data []byte) error {
 		r = new(EmptyResult)
 	}
 
-	if err := json.Unmarshal(meta.Result, &r); err != nil {
-		return fmt.Errorf("error unmarshaling err: %v", err)
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("error unmarshaling err: %v", err)
 	}
-	jr.Result = r
-	return nil
+	return r, nil
 }
 
 type JSONRPCError struct {
@@ -465,6 +446,24 @@ func (je *JSONRPCError) JSONRPCGeneralResponseType() int {
 }
 func (je *JSONRPCError) GetRequestID() RequestID { return je.ID }
 
+func (je *JSONRPCError) UnmarshalJSON(data []byte) error {
+	var meta struct {
+		JSONRPC string    `json:"jsonrpc"`
+		ID      RequestID `json:"id"`
+		Error   *Error    `json:"error"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("error unmarshaling global meta: %v", err)
+	}
+	je.JSONRPC = meta.JSONRPC
+	je.ID = meta.ID
+	//The received errors are decoded as Error, ErrorInterface can not be unmarshaled
+	if meta.Error != nil {
+		je.Error = meta.Error
+	}
+	return nil
+}
+
 //A JSON-RPC batch request, as described in https://www.jsonrpc.org/specification#batch.
 type JSONRPCBatchRequest []JSONRPCBatchRequestInterface
 
@@ -0,0 +1,125 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSONRPCErrorUnmarshal(t *testing.T) {
+	var msg RawMessage
+	data := `{"jsonrpc":"2.0","id":7,"error":{"code":-32601,"message":"Method not found","data":"tools/unknown"}}`
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	decoded, err := msg.ToJSONRPCMessage()
+	if err != nil {
+		t.Fatalf("ToJSONRPCMessage: %v", err)
+	}
+	jErr, ok := decoded.(*JSONRPCError)
+	if !ok {
+		t.Fatalf("expected *JSONRPCError, got %T", decoded)
+	}
+	if jErr.ID != 7 || jErr.JSONRPC != JSONRPC_VERSION {
+		t.Fatalf("unexpected members %+v", jErr)
+	}
+	if jErr.Error == nil {
+		t.Fatal("expected the error member to be decoded")
+	}
+	if code := jErr.Error.GetErrorCode(); code != ERROR_CODE_METHOD_NOT_FOUND {
+		t.Fatalf("expected code %d, got %d", ERROR_CODE_METHOD_NOT_FOUND, code)
+	}
+	if message := jErr.Error.GetErrorMessage(); message != "Method not found" {
+		t.Fatalf("unexpected message %q", message)
+	}
+	if data := jErr.Error.GetErrorData(); data != "tools/unknown" {
+		t.Fatalf("unexpected data %v", data)
+	}
+
+	//Sent again unchanged, e.g. by a proxy
+	encoded, err := JSONRPCMessageMarshalJSON(jErr)
+	if err != nil {
+		t.Fatalf("JSONRPCMessageMarshalJSON: %v", err)
+	}
+	if string(encoded) != data {
+		t.Fatalf("expected %s, got %s", data, encoded)
+	}
+}
+
+func TestJSONRPCMessageMarshalRoundTrip(t *testing.T) {
+	progress := &ProgressNotificationParams{ProgressToken: "token"}
+	progress.Progress.Progress = 2
+	progress.Total = 5
+	tests := []struct {
+		name string
+		msg  JSONRPCMessage
+		data string
+		//Type decoded for the method
+		decoded interface{}
+	}{
+		{
+			"tools/call",
+			&JSONRPCRequest{JSONRPC: JSONRPC_VERSION, ID: 3, RequestInterface: NewCallToolRequest(&CallToolRequestParams{
+				Name:      "echo",
+				Arguments: map[string]interface{}{"text": "hi"},
+			})},
+			`{"id":3,"jsonrpc":"2.0","method":"tools/call","params":{"arguments":{"text":"hi"},"name":"echo"}}`,
+			&CallToolRequest{},
+		},
+		{
+			"resources/read",
+			&JSONRPCRequest{JSONRPC: JSONRPC_VERSION, ID: 4, RequestInterface: NewReadResourceRequest(&ReadResourceRequestParams{URI: "file:///a.txt"})},
+			`{"id":4,"jsonrpc":"2.0","method":"resources/read","params":{"uri":"file:///a.txt"}}`,
+			&ReadResourceRequest{},
+		},
+		{
+			"notifications/progress",
+			&JSONRPCNotification{JSONRPC: JSONRPC_VERSION, NotificationInterface: NewProgressNotification(progress)},
+			`{"jsonrpc":"2.0","method":"notifications/progress","params":{"progress":2,"progressToken":"token","total":5}}`,
+			&ProgressNotification{},
+		},
+		{
+			"notifications/cancelled",
+			&JSONRPCNotification{JSONRPC: JSONRPC_VERSION, NotificationInterface: NewCancelledNotification(&CancelledNotificationParams{RequestID: 3, Reason: "timeout"})},
+			`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"reason":"timeout","requestId":3}}`,
+			&CancelledNotification{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := JSONRPCMessageMarshalJSON(tt.msg)
+			if err != nil {
+				t.Fatalf("JSONRPCMessageMarshalJSON: %v", err)
+			}
+			if string(encoded) != tt.data {
+				t.Fatalf("expected %s, got %s", tt.data, encoded)
+			}
+
+			var msg RawMessage
+			if err := json.Unmarshal(encoded, &msg); err != nil {
+				t.Fatalf("json.Unmarshal: %v", err)
+			}
+			decoded, err := msg.ToJSONRPCMessage()
+			if err != nil {
+				t.Fatalf("ToJSONRPCMessage: %v", err)
+			}
+			var inner interface{}
+			switch m := decoded.(type) {
+			case *JSONRPCRequest:
+				inner = m.RequestInterface
+			case *JSONRPCNotification:
+				inner = m.NotificationInterface
+			}
+			if reflect.TypeOf(inner) != reflect.TypeOf(tt.decoded) {
+				t.Fatalf("expected %T, got %T", tt.decoded, inner)
+			}
+			again, err := JSONRPCMessageMarshalJSON(decoded)
+			if err != nil {
+				t.Fatalf("JSONRPCMessageMarshalJSON decoded: %v", err)
+			}
+			if string(again) != tt.data {
+				t.Fatalf("expected %s after the round trip, got %s", tt.data, again)
+			}
+		})
+	}
+}
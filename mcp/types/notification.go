@@ -1,6 +1,9 @@
 package types
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/victorvbello/gomcp/mcp/methods"
 )
 
@@ -31,6 +34,7 @@ const (
 	RESOURCE_LIST_CHANGED_NOTIFICATION_NOTIFICATION_INTERFACE_TYPE
 	TOOL_LIST_CHANGED_NOTIFICATION_NOTIFICATION_INTERFACE_TYPE
 	PROMPT_LIST_CHANGED_NOTIFICATION_NOTIFICATION_INTERFACE_TYPE
+	RAW_NOTIFICATION_NOTIFICATION_INTERFACE_TYPE
 )
 
 type Notification struct {
@@ -55,6 +59,79 @@ type NotificationInterface interface {
 	GetNotification() Notification
 }
 
+//Return the _meta sent in the params of the notification, nil if it has none
+func GetNotificationMeta(notification NotificationInterface) (Meta, error) {
+	if notification == nil {
+		return nil, nil
+	}
+	return paramsMeta(notification)
+}
+
+//Notification sent with extra entries in the _meta of its params, the rest of the params of the wrapped notification are kept
+type NotificationWithMeta struct {
+	NotificationInterface
+	Meta Meta
+}
+
+//Wraps the notification to send it with the meta entries, if it is already wrapped the entries are merged
+func NewNotificationWithMeta(notification NotificationInterface, meta Meta) *NotificationWithMeta {
+	merged := make(Meta)
+	if wrapped, ok := notification.(*NotificationWithMeta); ok {
+		notification = wrapped.NotificationInterface
+		for key, value := range wrapped.Meta {
+			merged[key] = value
+		}
+	}
+	for key, value := range meta {
+		merged[key] = value
+	}
+	return &NotificationWithMeta{NotificationInterface: notification, Meta: merged}
+}
+
+func (nm *NotificationWithMeta) MarshalJSON() ([]byte, error) {
+	return marshalWithParamsMeta(nm.NotificationInterface, nm.Meta)
+}
+
+//Notification of a method without a registered type, the params are kept as received.
+//
+//Also used to send notifications of custom methods and to set their handlers with Protocol.SetNotificationHandler
+type RawNotification struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+func (rn *RawNotification) TypeOfNotification() int {
+	return RAW_NOTIFICATION_NOTIFICATION_INTERFACE_TYPE
+}
+func (rn *RawNotification) GetNotification() Notification {
+	return Notification{Method: rn.Method}
+}
+
+//Unmarshals the params of the notification into v, nothing is done if the notification has no params
+func (rn *RawNotification) DecodeParams(v interface{}) error {
+	if len(rn.Params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(rn.Params, v); err != nil {
+		return fmt.Errorf("json.Unmarshal %v", err)
+	}
+	return nil
+}
+
+//Return a notification of the method with params marshaled as its params, params can be nil
+func NewRawNotification(method string, params interface{}) (*RawNotification, error) {
+	rn := &RawNotification{Method: method}
+	if params == nil {
+		return rn, nil
+	}
+	paramsB, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal %v", err)
+	}
+	rn.Params = paramsB
+	return rn, nil
+}
+
 //This notification can be sent by either side to indicate that it is cancelling a previously-issued request.
 //
 //The request SHOULD still be in-flight, but due to communication latency, it is always possible that this notification MAY arrive after the request has already finished.
@@ -1,6 +1,9 @@
 package types
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/victorvbello/gomcp/mcp/methods"
 )
 
@@ -40,6 +43,23 @@ type PromptMessage struct {
 	Content Content `json:"content"`
 }
 
+func (pm *PromptMessage) UnmarshalJSON(data []byte) error {
+	var meta struct {
+		Role    Role            `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("error unmarshaling global meta: %v", err)
+	}
+	c, err := decodeContent(meta.Content)
+	if err != nil {
+		return err
+	}
+	pm.Role = meta.Role
+	pm.Content = c
+	return nil
+}
+
 //An optional notification from the server to the client, informing it that the list of prompts it offers has changed. This may be issued by servers without any previous subscription from the client.
 //
 //Only method: METHOD_NOTIFICATION_PROMPTS_LIST_CHANGED
@@ -0,0 +1,214 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/victorvbello/gomcp/mcp/methods"
+)
+
+//Return a new request of a method, used to decode the requests received
+type RequestFactory func() RequestInterface
+
+//Return a new notification of a method, used to decode the notifications received
+type NotificationFactory func() NotificationInterface
+
+var requestFactories = newMuxMapRequestFactory(map[string]RequestFactory{
+	methods.METHOD_REQUEST_INITIALIZE:               func() RequestInterface { return new(InitializeRequest) },
+	methods.METHOD_REQUEST_PING:                     func() RequestInterface { return new(PingRequest) },
+	methods.METHOD_REQUEST_LIST_RESOURCES:           func() RequestInterface { return new(ListResourcesRequest) },
+	methods.METHOD_REQUEST_TEMPLATES_LIST_RESOURCES: func() RequestInterface { return new(ListResourceTemplatesRequest) },
+	methods.METHOD_REQUEST_READ_RESOURCES:           func() RequestInterface { return new(ReadResourceRequest) },
+	methods.METHOD_REQUEST_SUBSCRIBE_RESOURCES:      func() RequestInterface { return new(SubscribeRequest) },
+	methods.METHOD_REQUEST_UNSUBSCRIBE_RESOURCES:    func() RequestInterface { return new(UnsubscribeRequest) },
+	methods.METHOD_REQUEST_LIST_PROMPTS:             func() RequestInterface { return new(ListPromptsRequest) },
+	methods.METHOD_REQUEST_GET_PROMPTS:              func() RequestInterface { return new(GetPromptRequest) },
+	methods.METHOD_REQUEST_LIST_TOOLS:               func() RequestInterface { return new(ListToolsRequest) },
+	methods.METHOD_REQUEST_CALL_TOOLS:               func() RequestInterface { return new(CallToolRequest) },
+	methods.METHOD_REQUEST_SET_LEVEL_LOGGING:        func() RequestInterface { return new(SetLevelRequest) },
+	methods.METHOD_SAMPLING_CREATE_MESSAGE:          func() RequestInterface { return new(CreateMessageRequest) },
+	methods.METHOD_AUTOCOMPLETE_COMPLETE:            func() RequestInterface { return new(CompleteRequest) },
+	methods.METHOD_LIST_ROOTS:                       func() RequestInterface { return new(ListRootsRequest) },
+})
+
+var notificationFactories = newMuxMapNotificationFactory(map[string]NotificationFactory{
+	methods.METHOD_NOTIFICATION_CANCELLED:              func() NotificationInterface { return new(CancelledNotification) },
+	methods.METHOD_NOTIFICATION_INITIALIZED:            func() NotificationInterface { return new(InitializedNotification) },
+	methods.METHOD_NOTIFICATION_PROGRESS:               func() NotificationInterface { return new(ProgressNotification) },
+	methods.METHOD_NOTIFICATION_RESOURCES_LIST_CHANGED: func() NotificationInterface { return new(ResourceListChangedNotification) },
+	methods.METHOD_NOTIFICATION_RESOURCES_UPDATED:      func() NotificationInterface { return new(ResourceUpdatedNotification) },
+	methods.METHOD_NOTIFICATION_PROMPTS_LIST_CHANGED:   func() NotificationInterface { return new(PromptListChangedNotification) },
+	methods.METHOD_NOTIFICATION_TOOLS_LIST_CHANGED:     func() NotificationInterface { return new(ToolListChangedNotification) },
+	methods.METHOD_NOTIFICATION_MESSAGE:                func() NotificationInterface { return new(LoggingMessageNotification) },
+	methods.METHOD_NOTIFICATION_ROOTS_LIST_CHANGED:     func() NotificationInterface { return new(RootsListChangedNotification) },
+})
+
+//Registers the type of the requests of a custom method, e.g. `acme/reindex`, newRequest must return a new pointer each time.
+//The requests of methods without a type are decoded as *RawRequest.
+//
+//Replaces the type registered before for the method, including the built-in ones.
+//The type of the result is registered with RegisterResultType
+func RegisterRequestType(method string, newRequest RequestFactory) {
+	requestFactories.Set(method, newRequest)
+}
+
+//Registers the type of the notifications of a custom method, newNotification must return a new pointer each time.
+//The notifications of methods without a type are decoded as *RawNotification.
+//
+//Replaces the type registered before for the method, including the built-in ones
+func RegisterNotificationType(method string, newNotification NotificationFactory) {
+	notificationFactories.Set(method, newNotification)
+}
+
+//Return a new request of the type registered for the method, *RawRequest if there is none
+func newRequestForMethod(method string) RequestInterface {
+	if newRequest, ok := requestFactories.Get(method); ok {
+		return newRequest()
+	}
+	return new(RawRequest)
+}
+
+//Return a new notification of the type registered for the method, *RawNotification if there is none
+func newNotificationForMethod(method string) NotificationInterface {
+	if newNotification, ok := notificationFactories.Get(method); ok {
+		return newNotification()
+	}
+	return new(RawNotification)
+}
+
+//Decode the result of a response, data is the raw JSON of the `result` member
+type ResultDecoder func(data json.RawMessage) (ResultInterface, error)
+
+//Return a ResultDecoder that unmarshals the result into the value returned by newResult, which must be a pointer
+func NewResultDecoder(newResult func() ResultInterface) ResultDecoder {
+	return func(data json.RawMessage) (ResultInterface, error) {
+		r := newResult()
+		if len(data) == 0 {
+			return r, nil
+		}
+		if err := json.Unmarshal(data, r); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal %T %w", r, err)
+		}
+		return r, nil
+	}
+}
+
+var resultDecoders = newMuxMapResultDecoder(map[string]ResultDecoder{
+	methods.METHOD_REQUEST_INITIALIZE:               NewResultDecoder(func() ResultInterface { return new(InitializeResult) }),
+	methods.METHOD_REQUEST_PING:                     NewResultDecoder(func() ResultInterface { return new(EmptyResult) }),
+	methods.METHOD_REQUEST_LIST_RESOURCES:           NewResultDecoder(func() ResultInterface { return new(ListResourcesResult) }),
+	methods.METHOD_REQUEST_TEMPLATES_LIST_RESOURCES: NewResultDecoder(func() ResultInterface { return new(ListResourceTemplatesResult) }),
+	methods.METHOD_REQUEST_READ_RESOURCES:           NewResultDecoder(func() ResultInterface { return new(ReadResourceResult) }),
+	methods.METHOD_REQUEST_SUBSCRIBE_RESOURCES:      NewResultDecoder(func() ResultInterface { return new(EmptyResult) }),
+	methods.METHOD_REQUEST_UNSUBSCRIBE_RESOURCES:    NewResultDecoder(func() ResultInterface { return new(EmptyResult) }),
+	methods.METHOD_REQUEST_LIST_PROMPTS:             NewResultDecoder(func() ResultInterface { return new(ListPromptsResult) }),
+	methods.METHOD_REQUEST_GET_PROMPTS:              NewResultDecoder(func() ResultInterface { return new(GetPromptResult) }),
+	methods.METHOD_REQUEST_LIST_TOOLS:               NewResultDecoder(func() ResultInterface { return new(ListToolsResult) }),
+	methods.METHOD_REQUEST_CALL_TOOLS:               NewResultDecoder(func() ResultInterface { return new(CallToolResult) }),
+	methods.METHOD_REQUEST_SET_LEVEL_LOGGING:        NewResultDecoder(func() ResultInterface { return new(EmptyResult) }),
+	methods.METHOD_SAMPLING_CREATE_MESSAGE:          NewResultDecoder(func() ResultInterface { return new(CreateMessageResult) }),
+	methods.METHOD_AUTOCOMPLETE_COMPLETE:            NewResultDecoder(func() ResultInterface { return new(CompleteResult) }),
+	methods.METHOD_LIST_ROOTS:                       NewResultDecoder(func() ResultInterface { return new(ListRootsResult) }),
+})
+
+//Registers the type of the result of the requests of a method, newResult must return a new pointer each time.
+//
+//Replaces the type or decoder registered before for the method, including the built-in ones
+func RegisterResultType(method string, newResult func() ResultInterface) {
+	resultDecoders.Set(method, NewResultDecoder(newResult))
+}
+
+//Registers the decoder of the result of the requests of a method, used by custom methods whose results
+//can not be decoded by unmarshaling into a single type.
+//
+//Replaces the type or decoder registered before for the method, including the built-in ones
+func RegisterResultDecoder(method string, decoder ResultDecoder) {
+	resultDecoders.Set(method, decoder)
+}
+
+//Decode the result of a response to a request of the method with the type or decoder registered for it,
+//methods without one are decoded as *RawResult
+func DecodeResult(method string, data json.RawMessage) (ResultInterface, error) {
+	decoder, ok := resultDecoders.Get(method)
+	if !ok {
+		decoder = NewResultDecoder(func() ResultInterface { return new(RawResult) })
+	}
+	r, err := decoder(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode result of %s %w", method, err)
+	}
+	if r == nil {
+		return nil, fmt.Errorf("decode result of %s: the decoder returned no result", method)
+	}
+	return r, nil
+}
+
+//muxMapResultDecoder
+type muxMapResultDecoder struct {
+	mu sync.RWMutex
+	m  map[string]ResultDecoder
+}
+
+func newMuxMapResultDecoder(m map[string]ResultDecoder) *muxMapResultDecoder {
+	return &muxMapResultDecoder{m: m}
+}
+
+func (xm *muxMapResultDecoder) Get(key string) (ResultDecoder, bool) {
+	xm.mu.RLock()
+	val, ok := xm.m[key]
+	xm.mu.RUnlock()
+	return val, ok
+}
+
+func (xm *muxMapResultDecoder) Set(key string, val ResultDecoder) {
+	xm.mu.Lock()
+	xm.m[key] = val
+	xm.mu.Unlock()
+}
+
+//muxMapRequestFactory
+type muxMapRequestFactory struct {
+	mu sync.RWMutex
+	m  map[string]RequestFactory
+}
+
+func newMuxMapRequestFactory(m map[string]RequestFactory) *muxMapRequestFactory {
+	return &muxMapRequestFactory{m: m}
+}
+
+func (xm *muxMapRequestFactory) Get(key string) (RequestFactory, bool) {
+	xm.mu.RLock()
+	val, ok := xm.m[key]
+	xm.mu.RUnlock()
+	return val, ok
+}
+
+func (xm *muxMapRequestFactory) Set(key string, val RequestFactory) {
+	xm.mu.Lock()
+	xm.m[key] = val
+	xm.mu.Unlock()
+}
+
+//muxMapNotificationFactory
+type muxMapNotificationFactory struct {
+	mu sync.RWMutex
+	m  map[string]NotificationFactory
+}
+
+func newMuxMapNotificationFactory(m map[string]NotificationFactory) *muxMapNotificationFactory {
+	return &muxMapNotificationFactory{m: m}
+}
+
+func (xm *muxMapNotificationFactory) Get(key string) (NotificationFactory, bool) {
+	xm.mu.RLock()
+	val, ok := xm.m[key]
+	xm.mu.RUnlock()
+	return val, ok
+}
+
+func (xm *muxMapNotificationFactory) Set(key string, val NotificationFactory) {
+	xm.mu.Lock()
+	xm.m[key] = val
+	xm.mu.Unlock()
+}
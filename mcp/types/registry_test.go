@@ -0,0 +1,323 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/victorvbello/gomcp/mcp/methods"
+)
+
+//Params of the built-in requests and notifications, the methods that are not here are sent without params
+var builtinParams = map[string]string{
+	methods.METHOD_REQUEST_INITIALIZE:               `{"capabilities":{"roots":{"listChanged":true}},"clientInfo":{"name":"client","version":"1.0"},"protocolVersion":"2025-03-26"}`,
+	methods.METHOD_REQUEST_LIST_RESOURCES:           `{"cursor":"next"}`,
+	methods.METHOD_REQUEST_TEMPLATES_LIST_RESOURCES: `{"cursor":"next"}`,
+	methods.METHOD_REQUEST_READ_RESOURCES:           `{"uri":"file:///a.txt"}`,
+	methods.METHOD_REQUEST_SUBSCRIBE_RESOURCES:      `{"uri":"file:///a.txt"}`,
+	methods.METHOD_REQUEST_UNSUBSCRIBE_RESOURCES:    `{"uri":"file:///a.txt"}`,
+	methods.METHOD_REQUEST_LIST_PROMPTS:             `{"cursor":"next"}`,
+	methods.METHOD_REQUEST_GET_PROMPTS:              `{"arguments":{"topic":"go"},"name":"review"}`,
+	methods.METHOD_REQUEST_LIST_TOOLS:               `{"cursor":"next"}`,
+	methods.METHOD_REQUEST_CALL_TOOLS:               `{"arguments":{"text":"hi"},"name":"echo"}`,
+	methods.METHOD_REQUEST_SET_LEVEL_LOGGING:        `{"level":"debug"}`,
+	methods.METHOD_SAMPLING_CREATE_MESSAGE:          `{"maxTokens":100,"messages":[{"content":{"text":"hi","type":"text"},"role":"user"}]}`,
+	methods.METHOD_AUTOCOMPLETE_COMPLETE:            `{"argument":{"name":"topic","value":"g"},"ref":{"name":"review","type":"ref/prompt"}}`,
+	methods.METHOD_NOTIFICATION_CANCELLED:           `{"reason":"user","requestId":3}`,
+	methods.METHOD_NOTIFICATION_PROGRESS:            `{"progress":2,"progressToken":"token","total":5}`,
+	methods.METHOD_NOTIFICATION_MESSAGE:             `{"data":"started","level":"info","logger":"server"}`,
+	methods.METHOD_NOTIFICATION_RESOURCES_UPDATED:   `{"uri":"file:///a.txt"}`,
+}
+
+//Results of the built-in requests, the methods that are not here have an empty result
+var builtinResults = map[string]string{
+	methods.METHOD_REQUEST_INITIALIZE:               `{"capabilities":{"tools":{"listChanged":true}},"protocolVersion":"2025-03-26","serverInfo":{"name":"server","version":"1.0"}}`,
+	methods.METHOD_REQUEST_LIST_RESOURCES:           `{"nextCursor":"next","resources":[{"name":"a","uri":"file:///a.txt"}]}`,
+	methods.METHOD_REQUEST_TEMPLATES_LIST_RESOURCES: `{"resourceTemplates":[{"name":"file","uriTemplate":"file:///{path}"}]}`,
+	methods.METHOD_REQUEST_READ_RESOURCES:           `{"contents":[{"mimeType":"text/plain","text":"hello","uri":"file:///a.txt"},{"blob":"aGk=","uri":"file:///a.bin"}]}`,
+	methods.METHOD_REQUEST_LIST_PROMPTS:             `{"prompts":[{"name":"review"}]}`,
+	methods.METHOD_REQUEST_GET_PROMPTS:              `{"description":"review","messages":[{"content":{"text":"review go","type":"text"},"role":"user"},{"content":{"resource":{"text":"package main","uri":"file:///main.go"},"type":"resource"},"role":"user"}]}`,
+	methods.METHOD_REQUEST_LIST_TOOLS:               `{"tools":[{"annotations":{"idempotentHint":true},"inputSchema":{"properties":{"text":{"description":"","type":"string"}},"required":["text"],"type":"object"},"name":"echo","outputSchema":{"properties":{},"required":[],"type":"object"}}]}`,
+	methods.METHOD_REQUEST_CALL_TOOLS:               `{"content":[{"text":"hi","type":"text"},{"data":"aGk=","mimeType":"image/png","type":"image"},{"resource":{"blob":"aGk=","uri":"file:///a.bin"},"type":"resource"}],"isError":false,"structuredContent":{"text":"hi"}}`,
+	methods.METHOD_SAMPLING_CREATE_MESSAGE:          `{"content":{"data":"aGk=","mimeType":"image/png","type":"image"},"model":"model","role":"assistant"}`,
+	methods.METHOD_AUTOCOMPLETE_COMPLETE:            `{"completion":{"values":["go"]}}`,
+	methods.METHOD_LIST_ROOTS:                       `{"roots":[{"name":"tmp","uri":"file:///tmp"}]}`,
+}
+
+//Decodes the message and return the inner request, notification or result
+func decodeTestMessage(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	var raw RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("json.Unmarshal %s: %v", data, err)
+	}
+	msg, err := raw.ToJSONRPCMessage()
+	if err != nil {
+		t.Fatalf("ToJSONRPCMessage %s: %v", data, err)
+	}
+	switch msg := msg.(type) {
+	case *JSONRPCRequest:
+		return msg.RequestInterface
+	case *JSONRPCNotification:
+		return msg.NotificationInterface
+	}
+	t.Fatalf("unexpected message %T", msg)
+	return nil
+}
+
+//Decodes the message, expects the type, and checks that it is decoded the same after being marshaled again
+func expectMessageRoundTrip(t *testing.T, data string, expected interface{}) interface{} {
+	t.Helper()
+	decoded := decodeTestMessage(t, []byte(data))
+	if reflect.TypeOf(decoded) != reflect.TypeOf(expected) {
+		t.Fatalf("expected %T, got %T", expected, decoded)
+	}
+	var msg JSONRPCMessage
+	switch decoded := decoded.(type) {
+	case RequestInterface:
+		msg = &JSONRPCRequest{JSONRPC: JSONRPC_VERSION, ID: 1, RequestInterface: decoded}
+	case NotificationInterface:
+		msg = &JSONRPCNotification{JSONRPC: JSONRPC_VERSION, NotificationInterface: decoded}
+	}
+	encoded, err := JSONRPCMessageMarshalJSON(msg)
+	if err != nil {
+		t.Fatalf("JSONRPCMessageMarshalJSON: %v", err)
+	}
+	if again := decodeTestMessage(t, encoded); !reflect.DeepEqual(again, decoded) {
+		t.Fatalf("expected %s to be decoded as %+v, got %+v", encoded, decoded, again)
+	}
+	return decoded
+}
+
+//Decodes the result, expects the type, and checks that it is decoded the same after being marshaled again
+func expectResultRoundTrip(t *testing.T, method string, data string, expected ResultInterface) ResultInterface {
+	t.Helper()
+	decoded, err := DecodeResult(method, json.RawMessage(data))
+	if err != nil {
+		t.Fatalf("DecodeResult: %v", err)
+	}
+	if reflect.TypeOf(decoded) != reflect.TypeOf(expected) {
+		t.Fatalf("expected %T, got %T", expected, decoded)
+	}
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	again, err := DecodeResult(method, encoded)
+	if err != nil {
+		t.Fatalf("DecodeResult: %v", err)
+	}
+	if !reflect.DeepEqual(again, decoded) {
+		t.Fatalf("expected %s to be decoded as %+v, got %+v", encoded, decoded, again)
+	}
+	return decoded
+}
+
+//Return the message of the method, with the params if there are
+func testMessage(id string, method string, params string) string {
+	data := `{"jsonrpc":"2.0",` + id + `"method":"` + method + `"`
+	if params != "" {
+		data += `,"params":` + params
+	}
+	return data + "}"
+}
+
+//Return a new result of the type registered for the method
+func resultForMethod(t *testing.T, method string) ResultInterface {
+	t.Helper()
+	decoder, ok := resultDecoders.Get(method)
+	if !ok {
+		t.Fatalf("expected a result type for %s", method)
+	}
+	result, err := decoder(nil)
+	if err != nil {
+		t.Fatalf("decoder: %v", err)
+	}
+	return result
+}
+
+func TestBuiltinResultsRoundTrip(t *testing.T) {
+	for method := range methods.REQUEST_METHODS {
+		t.Run(method, func(t *testing.T) {
+			result := builtinResults[method]
+			if result == "" {
+				result = "{}"
+			}
+			expectResultRoundTrip(t, method, result, resultForMethod(t, method))
+		})
+	}
+}
+
+func TestBuiltinMessagesRoundTrip(t *testing.T) {
+	for method := range methods.REQUEST_METHODS {
+		t.Run(method, func(t *testing.T) {
+			expectMessageRoundTrip(t, testMessage(`"id":1,`, method, builtinParams[method]), newRequestForMethod(method))
+		})
+	}
+	for method := range methods.NOTIFICATION_METHODS {
+		t.Run(method, func(t *testing.T) {
+			expectMessageRoundTrip(t, testMessage("", method, builtinParams[method]), newNotificationForMethod(method))
+		})
+	}
+}
+
+func TestResultContentsDecodedByType(t *testing.T) {
+	call := expectResultRoundTrip(t, methods.METHOD_REQUEST_CALL_TOOLS, builtinResults[methods.METHOD_REQUEST_CALL_TOOLS], &CallToolResult{}).(*CallToolResult)
+	if len(call.Content) != 3 {
+		t.Fatalf("expected 3 contents, got %+v", call.Content)
+	}
+	if text, ok := call.Content[0].(*TextContent); !ok || text.Text != "hi" {
+		t.Fatalf("expected the text content, got %#v", call.Content[0])
+	}
+	if image, ok := call.Content[1].(*ImageContent); !ok || image.MIMEType != "image/png" {
+		t.Fatalf("expected the image content, got %#v", call.Content[1])
+	}
+	embedded, ok := call.Content[2].(*EmbeddedResource)
+	if !ok {
+		t.Fatalf("expected the embedded resource, got %#v", call.Content[2])
+	}
+	if blob, ok := embedded.Resource.(BlobResourceContents); !ok || blob.Blob != "aGk=" {
+		t.Fatalf("expected the blob of the resource, got %#v", embedded.Resource)
+	}
+	if call.IsError == nil || *call.IsError || call.StructuredContent["text"] != "hi" {
+		t.Fatalf("unexpected result fields %+v", call)
+	}
+
+	read := expectResultRoundTrip(t, methods.METHOD_REQUEST_READ_RESOURCES, builtinResults[methods.METHOD_REQUEST_READ_RESOURCES], &ReadResourceResult{}).(*ReadResourceResult)
+	if len(read.Contents) != 2 {
+		t.Fatalf("expected 2 contents, got %+v", read.Contents)
+	}
+	if text, ok := read.Contents[0].(TextResourceContents); !ok || text.Text != "hello" || text.MIMEType != "text/plain" {
+		t.Fatalf("expected the text of the resource, got %#v", read.Contents[0])
+	}
+	if _, ok := read.Contents[1].(BlobResourceContents); !ok {
+		t.Fatalf("expected the blob of the resource, got %#v", read.Contents[1])
+	}
+
+	prompt := expectResultRoundTrip(t, methods.METHOD_REQUEST_GET_PROMPTS, builtinResults[methods.METHOD_REQUEST_GET_PROMPTS], &GetPromptResult{}).(*GetPromptResult)
+	if len(prompt.Messages) != 2 {
+		t.Fatalf("expected 2 messages, got %+v", prompt.Messages)
+	}
+	if embedded, ok := prompt.Messages[1].Content.(*EmbeddedResource); !ok || embedded.Resource.(TextResourceContents).Text != "package main" {
+		t.Fatalf("expected the embedded resource, got %#v", prompt.Messages[1].Content)
+	}
+
+	sampled := expectResultRoundTrip(t, methods.METHOD_SAMPLING_CREATE_MESSAGE, builtinResults[methods.METHOD_SAMPLING_CREATE_MESSAGE], &CreateMessageResult{}).(*CreateMessageResult)
+	if _, ok := sampled.Content.(*ImageContent); !ok || sampled.Model != "model" {
+		t.Fatalf("expected the sampled image, got %+v", sampled)
+	}
+
+	templates := builtinResults[methods.METHOD_REQUEST_TEMPLATES_LIST_RESOURCES]
+	list := expectResultRoundTrip(t, methods.METHOD_REQUEST_TEMPLATES_LIST_RESOURCES, templates, &ListResourceTemplatesResult{}).(*ListResourceTemplatesResult)
+	if encoded, _ := json.Marshal(list.ResourceTemplates[0]); !strings.Contains(string(encoded), `"uriTemplate":"file:///{path}"`) {
+		t.Fatalf("expected the template as a string, got %s", encoded)
+	}
+}
+
+type testCountResult struct {
+	Documents int `json:"documents"`
+}
+
+func (r *testCountResult) TypeOfResultInterface() int { return 0 }
+
+func TestRegisteredResultType(t *testing.T) {
+	RegisterResultType("test/count", func() ResultInterface { return new(testCountResult) })
+	result := expectResultRoundTrip(t, "test/count", `{"documents":12}`, &testCountResult{})
+	if documents := result.(*testCountResult).Documents; documents != 12 {
+		t.Fatalf("expected 12 documents, got %d", documents)
+	}
+
+	//Without a registered type the result is kept as received
+	data := `{"nested":{"list":[1,2]},"text":"hi"}`
+	raw := expectResultRoundTrip(t, "test/unknown", data, &RawResult{})
+	if received := raw.(*RawResult).Data; string(received) != data {
+		t.Fatalf("expected the result as received, got %s", received)
+	}
+}
+
+type testReindexParams struct {
+	Index string `json:"index"`
+}
+
+type testReindexRequest struct {
+	Method string            `json:"method"`
+	Params testReindexParams `json:"params"`
+}
+
+func (r *testReindexRequest) TypeOfRequestInterface() int { return 0 }
+func (r *testReindexRequest) GetRequest() Request         { return Request{Method: r.Method} }
+
+type testReindexedNotification struct {
+	Method string            `json:"method"`
+	Params testReindexParams `json:"params"`
+}
+
+func (n *testReindexedNotification) TypeOfNotification() int { return 0 }
+func (n *testReindexedNotification) GetNotification() Notification {
+	return Notification{Method: n.Method}
+}
+
+func TestRegisteredCustomMethodRoundTrip(t *testing.T) {
+	RegisterRequestType("test/reindex", func() RequestInterface { return new(testReindexRequest) })
+	RegisterResultType("test/reindex", func() ResultInterface { return new(testCountResult) })
+	RegisterNotificationType("test/reindexed", func() NotificationInterface { return new(testReindexedNotification) })
+
+	request := expectMessageRoundTrip(t, `{"jsonrpc":"2.0","id":1,"method":"test/reindex","params":{"index":"docs"}}`, &testReindexRequest{})
+	if params := request.(*testReindexRequest).Params; params.Index != "docs" {
+		t.Fatalf("unexpected params %+v", params)
+	}
+	result := expectResultRoundTrip(t, "test/reindex", `{"documents":12}`, &testCountResult{})
+	if documents := result.(*testCountResult).Documents; documents != 12 {
+		t.Fatalf("expected 12 documents, got %d", documents)
+	}
+	notification := expectMessageRoundTrip(t, `{"jsonrpc":"2.0","method":"test/reindexed","params":{"index":"docs"}}`, &testReindexedNotification{})
+	if params := notification.(*testReindexedNotification).Params; params.Index != "docs" {
+		t.Fatalf("unexpected params %+v", params)
+	}
+}
+
+func TestUnregisteredMethodRoundTrip(t *testing.T) {
+	params := `{"nested":{"list":[1,2]},"text":"hi"}`
+	request := expectMessageRoundTrip(t, testMessage(`"id":1,`, "test/unknown", params), &RawRequest{})
+	if raw := request.(*RawRequest); raw.Method != "test/unknown" || string(raw.Params) != params {
+		t.Fatalf("expected the params as received, got %s %s", raw.Method, raw.Params)
+	}
+	var decodedParams struct {
+		Text string `json:"text"`
+	}
+	if err := request.(*RawRequest).DecodeParams(&decodedParams); err != nil || decodedParams.Text != "hi" {
+		t.Fatalf("expected the params to be decoded, got %+v %v", decodedParams, err)
+	}
+	notification := expectMessageRoundTrip(t, testMessage("", "test/unknown", params), &RawNotification{})
+	if raw := notification.(*RawNotification); raw.Method != "test/unknown" || string(raw.Params) != params {
+		t.Fatalf("expected the params as received, got %s %s", raw.Method, raw.Params)
+	}
+}
+
+func TestRegisteredResultDecoder(t *testing.T) {
+	//The result is a document count or a failure report
+	RegisterResultDecoder("test/recount", func(data json.RawMessage) (ResultInterface, error) {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		if _, ok := fields["failure"]; ok {
+			return nil, errors.New("count failed")
+		}
+		return NewResultDecoder(func() ResultInterface { return new(testCountResult) })(data)
+	})
+	result := expectResultRoundTrip(t, "test/recount", `{"documents":3}`, &testCountResult{})
+	if documents := result.(*testCountResult).Documents; documents != 3 {
+		t.Fatalf("expected 3 documents, got %d", documents)
+	}
+	if _, err := DecodeResult("test/recount", json.RawMessage(`{"failure":"disk"}`)); err == nil {
+		t.Fatal("expected the error of the decoder")
+	}
+
+	//The decoder replaces the type registered before for the method
+	RegisterResultType("test/recounted", func() ResultInterface { return new(RawResult) })
+	RegisterResultDecoder("test/recounted", NewResultDecoder(func() ResultInterface { return new(testCountResult) }))
+	expectResultRoundTrip(t, "test/recounted", `{"documents":3}`, &testCountResult{})
+}
@@ -43,6 +43,7 @@ const (
 	READ_RESOURCE_REQUEST_REQUEST_INTERFACE_TYPE
 	CALL_TOOL_REQUEST_REQUEST_INTERFACE_TYPE
 	LIST_TOOLS_REQUEST_REQUEST_INTERFACE_TYPE
+	RAW_REQUEST_REQUEST_INTERFACE_TYPE
 )
 
 //A uniquely identifying ID for a request in JSON-RPC, number.
@@ -72,6 +73,77 @@ func NewMetadataRequestFromMetadata(meta map[string]interface{}) (*MetadataReque
 	return &result, nil
 }
 
+//Return the _meta sent in the params of the request, nil if it has none.
+//
+//The typed requests shadow Request.Params with their own params, read the _meta through this function
+func GetRequestMeta(req RequestInterface) (Meta, error) {
+	if req == nil {
+		return nil, nil
+	}
+	return paramsMeta(req)
+}
+
+//Request sent with extra entries in the _meta of its params, the rest of the params of the wrapped request are kept
+type RequestWithMeta struct {
+	RequestInterface
+	Meta Meta
+}
+
+//Wraps the request to send it with the meta entries, if it is already wrapped the entries are merged
+func NewRequestWithMeta(req RequestInterface, meta Meta) *RequestWithMeta {
+	merged := make(Meta)
+	if wrapped, ok := req.(*RequestWithMeta); ok {
+		req = wrapped.RequestInterface
+		for key, value := range wrapped.Meta {
+			merged[key] = value
+		}
+	}
+	for key, value := range meta {
+		merged[key] = value
+	}
+	return &RequestWithMeta{RequestInterface: req, Meta: merged}
+}
+
+func (rm *RequestWithMeta) MarshalJSON() ([]byte, error) {
+	return marshalWithParamsMeta(rm.RequestInterface, rm.Meta)
+}
+
+//Request of a method without a registered type, the params are kept as received.
+//
+//Also used to send requests of custom methods and to set their handlers with Protocol.SetRequestHandler
+type RawRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+func (rr *RawRequest) TypeOfRequestInterface() int { return RAW_REQUEST_REQUEST_INTERFACE_TYPE }
+func (rr *RawRequest) GetRequest() Request         { return Request{Method: rr.Method} }
+
+//Unmarshals the params of the request into v, nothing is done if the request has no params
+func (rr *RawRequest) DecodeParams(v interface{}) error {
+	if len(rr.Params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(rr.Params, v); err != nil {
+		return fmt.Errorf("json.Unmarshal %v", err)
+	}
+	return nil
+}
+
+//Return a request of the method with params marshaled as its params, params can be nil
+func NewRawRequest(method string, params interface{}) (*RawRequest, error) {
+	rr := &RawRequest{Method: method}
+	if params == nil {
+		return rr, nil
+	}
+	paramsB, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal %v", err)
+	}
+	rr.Params = paramsB
+	return rr, nil
+}
+
 type BaseRequestParams struct {
 	//Attach additional metadata to their notifications.
 	Meta `json:"_meta,omitempty"`
@@ -1,6 +1,9 @@
 package types
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/victorvbello/gomcp/mcp/methods"
 	"github.com/victorvbello/gomcp/mcp/utils"
 )
@@ -75,6 +78,26 @@ type BlobResourceContents struct {
 
 func (BlobResourceContents) TypeOfResource() int { return BLOB_RESOURCE_CONTENTS_TYPE }
 
+//Decode the contents of a resource, BlobResourceContents if it has a blob and TextResourceContents otherwise
+func decodeResourceContents(data json.RawMessage) (ResourceContents, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("error unmarshaling resource contents in map: %v", err)
+	}
+	if _, ok := fields["blob"]; ok {
+		var blob BlobResourceContents
+		if err := json.Unmarshal(data, &blob); err != nil {
+			return nil, fmt.Errorf("error unmarshaling blob resource contents: %v", err)
+		}
+		return blob, nil
+	}
+	var text TextResourceContents
+	if err := json.Unmarshal(data, &text); err != nil {
+		return nil, fmt.Errorf("error unmarshaling text resource contents: %v", err)
+	}
+	return text, nil
+}
+
 //An optional notification from the server to the client, informing it that the list of resources it can read from has changed. This may be issued by servers without any previous subscription from the client.
 //
 //Only method: METHOD_NOTIFICATION_RESOURCES_LIST_CHANGED
@@ -247,6 +270,30 @@ type ReadResourceResult struct {
 	Contents []ResourceContents `json:"contents"`
 }
 
+func (rrr *ReadResourceResult) UnmarshalJSON(data []byte) error {
+	var meta struct {
+		Result
+		Contents []json.RawMessage `json:"contents"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("error unmarshaling global meta: %v", err)
+	}
+	var contents []ResourceContents
+	if meta.Contents != nil {
+		contents = make([]ResourceContents, 0, len(meta.Contents))
+	}
+	for _, item := range meta.Contents {
+		resource, err := decodeResourceContents(item)
+		if err != nil {
+			return err
+		}
+		contents = append(contents, resource)
+	}
+	rrr.Result = meta.Result
+	rrr.Contents = contents
+	return nil
+}
+
 func (rrr *ReadResourceResult) TypeOfServerResult() int {
 	return READ_RESOURCE_RESULT_SERVER_RESULT_TYPE
 }
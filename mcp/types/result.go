@@ -1,5 +1,10 @@
 package types
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 const (
 	EMPTY_RESULT_CLIENT_RESULT_TYPE = iota + 60
 	CREATE_MESSAGE_RESULT_CLIENT_RESULT_TYPE
@@ -33,6 +38,7 @@ const (
 	READ_RESOURCE_RESULT_RESULT_INTERFACE_TYPE
 	CALL_TOOL_RESULT_RESULT_INTERFACE_TYPE
 	LIST_TOOLS_RESULT_RESULT_INTERFACE_TYPE
+	RAW_RESULT_RESULT_INTERFACE_TYPE
 )
 
 //A response that indicates success but carries no data.
@@ -148,6 +154,47 @@ type PaginatedResult struct {
 
 func (pr *PaginatedResult) TypeOfResultInterface() int { return PAGINATED_RESULT_RESULT_INTERFACE_TYPE }
 
+//Result of a method without a registered type or decoder, kept as received.
+//
+//Also used by the handlers of custom methods to answer with any JSON
+type RawResult struct {
+	Data json.RawMessage
+}
+
+func (rr *RawResult) TypeOfResultInterface() int { return RAW_RESULT_RESULT_INTERFACE_TYPE }
+
+func (rr *RawResult) MarshalJSON() ([]byte, error) {
+	if len(rr.Data) == 0 {
+		return []byte("{}"), nil
+	}
+	return rr.Data, nil
+}
+
+func (rr *RawResult) UnmarshalJSON(data []byte) error {
+	rr.Data = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+//Unmarshals the result into v
+func (rr *RawResult) DecodeResult(v interface{}) error {
+	if len(rr.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(rr.Data, v); err != nil {
+		return fmt.Errorf("json.Unmarshal %v", err)
+	}
+	return nil
+}
+
+//Return a result with data marshaled as its JSON
+func NewRawResult(data interface{}) (*RawResult, error) {
+	dataB, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal %v", err)
+	}
+	return &RawResult{Data: dataB}, nil
+}
+
 type ResultInterface interface {
 	TypeOfResultInterface() int
 }
@@ -42,6 +42,25 @@ type CreateMessageResult struct {
 	StopReason string `json:"stopReason,omitempty"`
 }
 
+//The UnmarshalJSON of SamplingMessage is promoted and would skip the fields of the result
+func (cmr *CreateMessageResult) UnmarshalJSON(data []byte) error {
+	var meta struct {
+		Result
+		Model      string `json:"model"`
+		StopReason string `json:"stopReason,omitempty"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("error unmarshaling global meta: %v", err)
+	}
+	if err := cmr.SamplingMessage.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	cmr.Result = meta.Result
+	cmr.Model = meta.Model
+	cmr.StopReason = meta.StopReason
+	return nil
+}
+
 func (cmr *CreateMessageResult) TypeOfClientResult() int {
 	return CREATE_MESSAGE_RESULT_CLIENT_RESULT_TYPE
 }
@@ -83,33 +102,11 @@ func (sm *SamplingMessage) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &meta); err != nil {
 		return fmt.Errorf("error unmarshaling global meta: %v", err)
 	}
-	sm.Role = meta.Role
-	contentDataMap := make(map[string]interface{})
-	if err := json.Unmarshal(meta.Content, &contentDataMap); err != nil {
-		return fmt.Errorf("error unmarshaling global data in map: %v", err)
-	}
-
-	var c Content
-	var contentFactories = map[string]func() Content{
-		"text":     func() Content { return new(TextContent) },
-		"image":    func() Content { return new(ImageContent) },
-		"audio":    func() Content { return new(AudioContent) },
-		"resource": func() Content { return new(EmbeddedResource) },
-	}
-
-	for key, builder := range contentFactories {
-		if _, ok := contentDataMap[key]; ok {
-			c = builder()
-			break
-		}
-	}
-	if c == nil {
-		c = new(TextContent)
-	}
-
-	if err := json.Unmarshal(meta.Content, &c); err != nil {
-		return fmt.Errorf("error unmarshaling err: %v", err)
+	c, err := decodeContent(meta.Content)
+	if err != nil {
+		return err
 	}
+	sm.Role = meta.Role
 	sm.Content = c
 	return nil
 }
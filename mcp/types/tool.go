@@ -2,6 +2,7 @@ package types
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/victorvbello/gomcp/mcp/methods"
 )
@@ -24,6 +25,19 @@ type Tool struct {
 	//for notes on _meta usage.
 	Meta `json:"_meta,omitempty"`
 }
+//Return true if calling the tool again with the same arguments has no additional effect:
+//its annotations hint that it is read-only or idempotent
+func (t *Tool) IsIdempotent() bool {
+	if t == nil || t.Annotations == nil {
+		return false
+	}
+	annotations := t.Annotations
+	if annotations.ReadOnlyHint != nil && *annotations.ReadOnlyHint {
+		return true
+	}
+	return annotations.IdempotentHint != nil && *annotations.IdempotentHint
+}
+
 type ToolInputSchemaProperties struct {
 	Type        string `json:"type"`
 	Description string `json:"description"`
@@ -189,6 +203,27 @@ type CallToolResult struct {
 	IsError *bool `json:"isError,omitempty"`
 }
 
+func (ctr *CallToolResult) UnmarshalJSON(data []byte) error {
+	var meta struct {
+		Result
+		Content           []json.RawMessage      `json:"content"`
+		StructuredContent map[string]interface{} `json:"structuredContent,omitempty"`
+		IsError           *bool                  `json:"isError,omitempty"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("error unmarshaling global meta: %v", err)
+	}
+	contents, err := decodeContents(meta.Content)
+	if err != nil {
+		return err
+	}
+	ctr.Result = meta.Result
+	ctr.Content = contents
+	ctr.StructuredContent = meta.StructuredContent
+	ctr.IsError = meta.IsError
+	return nil
+}
+
 func (ctr *CallToolResult) TypeOfServerResult() int    { return CALL_TOOL_RESULT_SERVER_RESULT_TYPE }
 func (ctr *CallToolResult) TypeOfResultInterface() int { return CALL_TOOL_RESULT_RESULT_INTERFACE_TYPE }
 
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	JWT_ALG_HS256 = "HS256"
+	JWT_ALG_HS384 = "HS384"
+	JWT_ALG_HS512 = "HS512"
+	JWT_ALG_RS256 = "RS256"
+	JWT_ALG_RS384 = "RS384"
+	JWT_ALG_RS512 = "RS512"
+)
+
+var jwtAlgHashes = map[string]crypto.Hash{
+	JWT_ALG_HS256: crypto.SHA256,
+	JWT_ALG_HS384: crypto.SHA384,
+	JWT_ALG_HS512: crypto.SHA512,
+	JWT_ALG_RS256: crypto.SHA256,
+	JWT_ALG_RS384: crypto.SHA384,
+	JWT_ALG_RS512: crypto.SHA512,
+}
+
+type JWTHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+type JWTClaims map[string]interface{}
+
+//Return the claim as string, empty if is not present or is not a string
+func (c JWTClaims) GetString(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+//Return the claim as a list of strings, a single string value is returned as a one item list
+func (c JWTClaims) GetStrings(key string) []string {
+	switch value := c[key].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var result []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+//Return a numeric claim (NumericDate for exp, nbf, iat), false if is not present
+func (c JWTClaims) GetInt64(key string) (int64, bool) {
+	switch value := c[key].(type) {
+	case float64:
+		return int64(value), true
+	case json.Number:
+		n, err := value.Int64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+//A decoded JSON Web Token in compact serialization, the signature is not verified until VerifySignature is called.
+type JWT struct {
+	Header       JWTHeader
+	Claims       JWTClaims
+	signingInput string
+	signature    []byte
+}
+
+func jwtDecodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+//Decodes a compact JWT, header.payload.signature
+func ParseJWT(token string) (*JWT, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token must have 3 segments, got %d", len(parts))
+	}
+	headerB, err := jwtDecodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("header decode %v", err)
+	}
+	claimsB, err := jwtDecodeSegment(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("claims decode %v", err)
+	}
+	signature, err := jwtDecodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("signature decode %v", err)
+	}
+	result := &JWT{
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}
+	if err := json.Unmarshal(headerB, &result.Header); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal header %v", err)
+	}
+	if err := json.Unmarshal(claimsB, &result.Claims); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal claims %v", err)
+	}
+	return result, nil
+}
+
+//Verifies the token signature.
+//
+//key must be a []byte secret for HS* algorithms or a *rsa.PublicKey for RS* algorithms,
+//a key of the wrong kind is rejected to avoid algorithm confusion.
+func (t *JWT) VerifySignature(key interface{}) error {
+	hash, ok := jwtAlgHashes[t.Header.Alg]
+	if !ok {
+		return fmt.Errorf("unsupported alg %s", t.Header.Alg)
+	}
+	switch k := key.(type) {
+	case []byte:
+		if !strings.HasPrefix(t.Header.Alg, "HS") {
+			return fmt.Errorf("alg %s can not be verified with a HMAC secret", t.Header.Alg)
+		}
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(t.signingInput))
+		if !hmac.Equal(mac.Sum(nil), t.signature) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(t.Header.Alg, "RS") {
+			return fmt.Errorf("alg %s can not be verified with a RSA key", t.Header.Alg)
+		}
+		h := hash.New()
+		h.Write([]byte(t.signingInput))
+		if err := rsa.VerifyPKCS1v15(k, hash, h.Sum(nil), t.signature); err != nil {
+			return fmt.Errorf("invalid signature %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T", key)
+}
+
+//Signs the claims and returns the compact JWT, header.Alg selects the algorithm.
+//
+//key must be a []byte secret for HS* algorithms or a *rsa.PrivateKey for RS* algorithms.
+func SignJWT(header JWTHeader, claims JWTClaims, key interface{}) (string, error) {
+	hash, ok := jwtAlgHashes[header.Alg]
+	if !ok {
+		return "", fmt.Errorf("unsupported alg %s", header.Alg)
+	}
+	if header.Typ == "" {
+		header.Typ = "JWT"
+	}
+	headerB, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal header %v", err)
+	}
+	claimsB, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal claims %v", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerB) + "." + base64.RawURLEncoding.EncodeToString(claimsB)
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		if !strings.HasPrefix(header.Alg, "HS") {
+			return "", fmt.Errorf("alg %s can not be signed with a HMAC secret", header.Alg)
+		}
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if !strings.HasPrefix(header.Alg, "RS") {
+			return "", fmt.Errorf("alg %s can not be signed with a RSA key", header.Alg)
+		}
+		h := hash.New()
+		h.Write([]byte(signingInput))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, h.Sum(nil))
+		if err != nil {
+			return "", fmt.Errorf("rsa.SignPKCS1v15 %v", err)
+		}
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
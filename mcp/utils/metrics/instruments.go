@@ -0,0 +1,199 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	METRIC_TYPE_COUNTER   = "counter"
+	METRIC_TYPE_GAUGE     = "gauge"
+	METRIC_TYPE_HISTOGRAM = "histogram"
+)
+
+//Buckets in seconds, suited for request latencies
+var DEFAULT_BUCKETS = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+//Float that can be updated concurrently
+type atomicFloat struct {
+	bits uint64
+}
+
+func (af *atomicFloat) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&af.bits)
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&af.bits, old, next) {
+			return
+		}
+	}
+}
+
+func (af *atomicFloat) Set(v float64) {
+	atomic.StoreUint64(&af.bits, math.Float64bits(v))
+}
+
+func (af *atomicFloat) Load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&af.bits))
+}
+
+//Series of a metric by label values
+type series struct {
+	mu     sync.RWMutex
+	labels []string
+	m      map[string]interface{}
+	create func() interface{}
+}
+
+func newSeries(labels []string, create func() interface{}) *series {
+	return &series{labels: labels, m: make(map[string]interface{}), create: create}
+}
+
+//Return the value of the label values, creating it when missing
+func (s *series) get(labelValues []string) interface{} {
+	if len(labelValues) != len(s.labels) {
+		//Wrong usage, keep the metric consistent instead of panicking in the request path
+		fixed := make([]string, len(s.labels))
+		copy(fixed, labelValues)
+		labelValues = fixed
+	}
+	key := strings.Join(labelValues, "\xff")
+	s.mu.RLock()
+	value, ok := s.m[key]
+	s.mu.RUnlock()
+	if ok {
+		return value
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value, ok := s.m[key]; ok {
+		return value
+	}
+	value = s.create()
+	s.m[key] = value
+	return value
+}
+
+//Return the label values and values sorted by label values
+func (s *series) each(fn func(labelValues []string, value interface{})) {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.m))
+	for key := range s.m {
+		keys = append(keys, key)
+	}
+	values := make(map[string]interface{}, len(s.m))
+	for key, value := range s.m {
+		values[key] = value
+	}
+	s.mu.RUnlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		var labelValues []string
+		if len(s.labels) > 0 {
+			labelValues = strings.Split(key, "\xff")
+		}
+		fn(labelValues, values[key])
+	}
+}
+
+//Monotonic counter by label values
+type CounterVec struct {
+	series *series
+}
+
+//Adds 1 to the counter of the label values
+func (cv *CounterVec) Inc(labelValues ...string) {
+	cv.Add(1, labelValues...)
+}
+
+//Adds v, that must be positive, to the counter of the label values
+func (cv *CounterVec) Add(v float64, labelValues ...string) {
+	if cv == nil || v < 0 {
+		return
+	}
+	cv.series.get(labelValues).(*atomicFloat).Add(v)
+}
+
+//Return the current value of the label values
+func (cv *CounterVec) Value(labelValues ...string) float64 {
+	if cv == nil {
+		return 0
+	}
+	return cv.series.get(labelValues).(*atomicFloat).Load()
+}
+
+//Value that goes up and down by label values
+type GaugeVec struct {
+	series *series
+}
+
+func (gv *GaugeVec) Set(v float64, labelValues ...string) {
+	if gv == nil {
+		return
+	}
+	gv.series.get(labelValues).(*atomicFloat).Set(v)
+}
+
+func (gv *GaugeVec) Add(v float64, labelValues ...string) {
+	if gv == nil {
+		return
+	}
+	gv.series.get(labelValues).(*atomicFloat).Add(v)
+}
+
+func (gv *GaugeVec) Inc(labelValues ...string) {
+	gv.Add(1, labelValues...)
+}
+
+func (gv *GaugeVec) Dec(labelValues ...string) {
+	gv.Add(-1, labelValues...)
+}
+
+//Return the current value of the label values
+func (gv *GaugeVec) Value(labelValues ...string) float64 {
+	if gv == nil {
+		return 0
+	}
+	return gv.series.get(labelValues).(*atomicFloat).Load()
+}
+
+//Observations of a histogram series
+type histogram struct {
+	upperBounds []float64
+	//Not cumulative, one per bucket plus +Inf
+	counts []uint64
+	sum    atomicFloat
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	h.sum.Add(v)
+	atomic.AddUint64(&h.count, 1)
+}
+
+//Distribution of observations in buckets by label values
+type HistogramVec struct {
+	series  *series
+	buckets []float64
+}
+
+//Adds an observation to the histogram of the label values
+func (hv *HistogramVec) Observe(v float64, labelValues ...string) {
+	if hv == nil {
+		return
+	}
+	hv.series.get(labelValues).(*histogram).observe(v)
+}
+
+//Return the number of observations of the label values
+func (hv *HistogramVec) Count(labelValues ...string) uint64 {
+	if hv == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&hv.series.get(labelValues).(*histogram).count)
+}
@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	PROMETHEUS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+//A metric and all its series
+type family struct {
+	name       string
+	help       string
+	metricType string
+	labels     []string
+	series     *series
+	buckets    []float64
+	//Gauges computed on every scrape
+	fn func() float64
+	//The CounterVec, GaugeVec or HistogramVec
+	instrument interface{}
+}
+
+//Set of metrics exposed in the Prometheus text format, without external dependencies.
+//
+//The New* methods return the metric already registered with the name so independent components
+//(e.g. a transport per session) share the same series.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+//Return the family with the name or registers a new one, nil if the name is used by a metric of another type
+func (r *Registry) family(name string, help string, metricType string, labels []string, build func(f *family)) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.metricType != metricType || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			return nil
+		}
+		return f
+	}
+	f := &family{name: name, help: help, metricType: metricType, labels: labels}
+	build(f)
+	r.families[name] = f
+	return f
+}
+
+//Return the counter registered with the name, registering it if is missing.
+//
+//Return nil, whose methods do nothing, if the name is used by another metric type or labels
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	f := r.family(name, help, METRIC_TYPE_COUNTER, labels, func(f *family) {
+		f.series = newSeries(labels, func() interface{} { return new(atomicFloat) })
+		f.instrument = &CounterVec{series: f.series}
+	})
+	if f == nil {
+		return nil
+	}
+	return f.instrument.(*CounterVec)
+}
+
+//Return the gauge registered with the name, registering it if is missing.
+//
+//Return nil, whose methods do nothing, if the name is used by another metric type or labels
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	f := r.family(name, help, METRIC_TYPE_GAUGE, labels, func(f *family) {
+		f.series = newSeries(labels, func() interface{} { return new(atomicFloat) })
+		f.instrument = &GaugeVec{series: f.series}
+	})
+	if f == nil || f.instrument == nil {
+		return nil
+	}
+	return f.instrument.(*GaugeVec)
+}
+
+//Return the histogram registered with the name, registering it if is missing.
+//
+//If buckets is empty DEFAULT_BUCKETS is used.
+//Return nil, whose methods do nothing, if the name is used by another metric type or labels
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DEFAULT_BUCKETS
+	}
+	f := r.family(name, help, METRIC_TYPE_HISTOGRAM, labels, func(f *family) {
+		upperBounds := append([]float64(nil), buckets...)
+		sort.Float64s(upperBounds)
+		f.buckets = upperBounds
+		f.series = newSeries(labels, func() interface{} {
+			return &histogram{upperBounds: upperBounds, counts: make([]uint64, len(upperBounds)+1)}
+		})
+		f.instrument = &HistogramVec{series: f.series, buckets: upperBounds}
+	})
+	if f == nil {
+		return nil
+	}
+	return f.instrument.(*HistogramVec)
+}
+
+//Registers a gauge without labels whose value is computed by fn on every scrape.
+//
+//Return false if the name is already registered, the existing metric is kept
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		return false
+	}
+	r.families[name] = &family{name: name, help: help, metricType: METRIC_TYPE_GAUGE, fn: fn}
+	return true
+}
+
+//Writes all the metrics in the Prometheus text exposition format, sorted by name
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		fmt.Fprintf(cw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", f.name, f.metricType)
+		if f.fn != nil {
+			fmt.Fprintf(cw, "%s %s\n", f.name, formatFloat(f.fn()))
+			continue
+		}
+		f.series.each(func(labelValues []string, value interface{}) {
+			switch v := value.(type) {
+			case *atomicFloat:
+				fmt.Fprintf(cw, "%s%s %s\n", f.name, formatLabels(f.labels, labelValues, "", ""), formatFloat(v.Load()))
+			case *histogram:
+				var cumulative uint64
+				for i, upperBound := range f.buckets {
+					cumulative += atomic.LoadUint64(&v.counts[i])
+					fmt.Fprintf(cw, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, labelValues, "le", formatFloat(upperBound)), cumulative)
+				}
+				cumulative += atomic.LoadUint64(&v.counts[len(f.buckets)])
+				fmt.Fprintf(cw, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, labelValues, "le", "+Inf"), cumulative)
+				fmt.Fprintf(cw, "%s_sum%s %s\n", f.name, formatLabels(f.labels, labelValues, "", ""), formatFloat(v.sum.Load()))
+				fmt.Fprintf(cw, "%s_count%s %d\n", f.name, formatLabels(f.labels, labelValues, "", ""), cumulative)
+			}
+		})
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, fmt.Errorf("w.Flush %v", err)
+	}
+	return cw.n, nil
+}
+
+//Serves the metrics to the Prometheus scrapes
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", PROMETHEUS_CONTENT_TYPE)
+	w.Header().Set("Cache-Control", "no-store")
+	r.WriteTo(w)
+}
+
+//Keeps the first error and the written bytes
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//Return {name="value",...}, with the extra label when extraName is not empty
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var pairs []string
+	for i, name := range names {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(value)))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, escapeLabelValue(extraValue)))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+//Token bucket: holds up to burst tokens and refills rate tokens per second, each allowed event takes one token
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+//Return a full bucket, if burst is not positive the rate rounded up is used, with a minimum of 1
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return NewTokenBucketWithClock(rate, burst, nil)
+}
+
+//Return a full bucket refilled by the time returned by now, useful for tests.
+//
+//If now is nil time.Now is used
+func NewTokenBucketWithClock(rate float64, burst int, now func() time.Time) *TokenBucket {
+	if now == nil {
+		now = time.Now
+	}
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	tb := &TokenBucket{
+		rate:  rate,
+		burst: float64(burst),
+		now:   now,
+	}
+	tb.tokens = tb.burst
+	tb.last = tb.now()
+	return tb
+}
+
+//Takes a token, return false and the time until the next token if the bucket is empty
+func (tb *TokenBucket) Allow() (bool, time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill()
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return true, 0
+	}
+	return false, tb.wait()
+}
+
+//Puts back a token taken by Allow, used when the event is rejected by another bucket
+func (tb *TokenBucket) Return() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill()
+	tb.tokens = math.Min(tb.burst, tb.tokens+1)
+}
+
+//Return the time until a token is available without taking it, 0 if there is one
+func (tb *TokenBucket) RetryAfter() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill()
+	if tb.tokens >= 1 {
+		return 0
+	}
+	return tb.wait()
+}
+
+//Return true if the bucket is full, a full bucket is the same as a new one
+func (tb *TokenBucket) Full() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill()
+	return tb.tokens >= tb.burst
+}
+
+func (tb *TokenBucket) refill() {
+	now := tb.now()
+	elapsed := now.Sub(tb.last).Seconds()
+	tb.last = now
+	if elapsed > 0 {
+		tb.tokens = math.Min(tb.burst, tb.tokens+elapsed*tb.rate)
+	}
+}
+
+//Time until the bucket has a whole token, must be called with the bucket locked
+func (tb *TokenBucket) wait() time.Duration {
+	if tb.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+}
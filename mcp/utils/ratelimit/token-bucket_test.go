@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+//Clock moved by the test
+type testClock struct {
+	now time.Time
+}
+
+func newTestClock() *testClock { return &testClock{now: time.Unix(0, 0)} }
+
+func (c *testClock) Now() time.Time          { return c.now }
+func (c *testClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+//Calls Allow n times, return the allowed calls
+func allowN(tb *TokenBucket, n int) (allowed int) {
+	for i := 0; i < n; i++ {
+		if ok, _ := tb.Allow(); ok {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		//Time waited after emptying the bucket
+		elapsed time.Duration
+		//Tokens available after the wait
+		allowed int
+	}{
+		{"nothing elapsed", 2, 4, 0, 0},
+		{"less than a token", 2, 4, 499 * time.Millisecond, 0},
+		{"one token", 2, 4, 500 * time.Millisecond, 1},
+		{"partial tokens add up", 2, 4, 1250 * time.Millisecond, 2},
+		{"capped by the burst", 2, 4, time.Hour, 4},
+		{"default burst", 2.5, 0, time.Hour, 3},
+		{"minimum burst", 0.5, 0, time.Hour, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newTestClock()
+			tb := NewTokenBucketWithClock(tt.rate, tt.burst, clock.Now)
+			//A new bucket is full
+			if !tb.Full() {
+				t.Fatal("expected a new bucket to be full")
+			}
+			allowN(tb, 100)
+			clock.Advance(tt.elapsed)
+			if allowed := allowN(tb, 100); allowed != tt.allowed {
+				t.Fatalf("expected %d tokens, got %d", tt.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	clock := newTestClock()
+	tb := NewTokenBucketWithClock(4, 1, clock.Now)
+	if retryAfter := tb.RetryAfter(); retryAfter != 0 {
+		t.Fatalf("expected no wait with a token, got %v", retryAfter)
+	}
+	if ok, wait := tb.Allow(); !ok || wait != 0 {
+		t.Fatalf("expected the token, got %v %v", ok, wait)
+	}
+	ok, wait := tb.Allow()
+	if ok || wait != 250*time.Millisecond {
+		t.Fatalf("expected to wait 250ms, got %v %v", ok, wait)
+	}
+	clock.Advance(100 * time.Millisecond)
+	//RetryAfter does not take the token
+	for i := 0; i < 2; i++ {
+		if retryAfter := tb.RetryAfter(); retryAfter != 150*time.Millisecond {
+			t.Fatalf("expected to wait 150ms, got %v", retryAfter)
+		}
+	}
+	clock.Advance(150 * time.Millisecond)
+	if retryAfter := tb.RetryAfter(); retryAfter != 0 {
+		t.Fatalf("expected no wait after the refill, got %v", retryAfter)
+	}
+
+	//Without refill the wait never ends
+	empty := NewTokenBucketWithClock(0, 1, clock.Now)
+	empty.Allow()
+	if _, wait := empty.Allow(); wait != time.Duration(math.MaxInt64) {
+		t.Fatalf("expected an endless wait, got %v", wait)
+	}
+}
+
+func TestTokenBucketReturn(t *testing.T) {
+	clock := newTestClock()
+	tb := NewTokenBucketWithClock(1, 2, clock.Now)
+	allowN(tb, 2)
+	tb.Return()
+	if allowed := allowN(tb, 2); allowed != 1 {
+		t.Fatalf("expected the returned token to be taken again, got %d", allowed)
+	}
+	//The returned tokens do not go over the burst
+	clock.Advance(time.Hour)
+	tb.Return()
+	if allowed := allowN(tb, 3); allowed != 2 {
+		t.Fatalf("expected the burst of 2 tokens, got %d", allowed)
+	}
+}
@@ -0,0 +1,152 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+//Span kept by the RecordingTracer
+type RecordedSpan struct {
+	Name         string
+	Kind         SpanKind
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Attributes   map[string]interface{}
+	Err          error
+	StartTime    time.Time
+	EndTime      time.Time
+	Ended        bool
+}
+
+//Tracer that keeps the spans in memory, useful in tests to check the spans and their relations
+type RecordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+func (rt *RecordingTracer) StartSpan(ctx context.Context, name string, kind SpanKind, parent TraceContext, attributes map[string]interface{}) Span {
+	rs := &recordingSpan{
+		data: RecordedSpan{
+			Name:       name,
+			Kind:       kind,
+			SpanID:     newID(SPAN_ID_HEX_SIZE / 2),
+			Attributes: make(map[string]interface{}),
+			StartTime:  time.Now(),
+		},
+		flags: TRACE_FLAG_SAMPLED,
+	}
+	if parent.IsValid() {
+		rs.data.TraceID = parent.TraceID
+		rs.data.ParentSpanID = parent.SpanID
+		rs.flags = parent.Flags
+		rs.traceState = parent.TraceState
+	} else {
+		rs.data.TraceID = newID(TRACE_ID_HEX_SIZE / 2)
+	}
+	for key, value := range attributes {
+		rs.data.Attributes[key] = value
+	}
+	rt.mu.Lock()
+	rt.spans = append(rt.spans, rs)
+	rt.mu.Unlock()
+	return rs
+}
+
+//Return a copy of the spans in start order, ended or not
+func (rt *RecordingTracer) Spans() []RecordedSpan {
+	rt.mu.Lock()
+	spans := append([]*recordingSpan(nil), rt.spans...)
+	rt.mu.Unlock()
+	result := make([]RecordedSpan, 0, len(spans))
+	for _, rs := range spans {
+		result = append(result, rs.snapshot())
+	}
+	return result
+}
+
+//Removes the recorded spans
+func (rt *RecordingTracer) Reset() {
+	rt.mu.Lock()
+	rt.spans = nil
+	rt.mu.Unlock()
+}
+
+type recordingSpan struct {
+	mu         sync.Mutex
+	data       RecordedSpan
+	flags      byte
+	traceState string
+}
+
+func (rs *recordingSpan) SetAttribute(key string, value interface{}) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.data.Ended {
+		return
+	}
+	rs.data.Attributes[key] = value
+}
+
+func (rs *recordingSpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.data.Ended {
+		return
+	}
+	rs.data.Err = err
+}
+
+func (rs *recordingSpan) End() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.data.Ended {
+		return
+	}
+	rs.data.Ended = true
+	rs.data.EndTime = time.Now()
+}
+
+func (rs *recordingSpan) TraceContext() TraceContext {
+	return TraceContext{
+		TraceID:    rs.data.TraceID,
+		SpanID:     rs.data.SpanID,
+		Flags:      rs.flags,
+		TraceState: rs.traceState,
+	}
+}
+
+func (rs *recordingSpan) snapshot() RecordedSpan {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	data := rs.data
+	data.Attributes = make(map[string]interface{}, len(rs.data.Attributes))
+	for key, value := range rs.data.Attributes {
+		data.Attributes[key] = value
+	}
+	return data
+}
+
+//Return a random ID of size bytes in hex
+func newID(size int) string {
+	b := make([]byte, size)
+	for {
+		rand.Read(b)
+		//All zeros is an invalid ID
+		for _, v := range b {
+			if v != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	//Header and _meta key of the W3C trace parent, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+	TRACEPARENT_KEY = "traceparent"
+	//Header and _meta key of the W3C vendor specific trace state
+	TRACESTATE_KEY      = "tracestate"
+	TRACEPARENT_VERSION = "00"
+	//Trace flag set when the caller recorded the trace
+	TRACE_FLAG_SAMPLED byte = 0x01
+	TRACE_ID_HEX_SIZE       = 32
+	SPAN_ID_HEX_SIZE        = 16
+)
+
+//Identifies a span across processes, as defined by W3C trace-context
+type TraceContext struct {
+	//32 lowercase hex characters, not all zeros
+	TraceID string
+	//16 lowercase hex characters, not all zeros
+	SpanID string
+	Flags  byte
+	//Opaque vendor data, propagated as is
+	TraceState string
+}
+
+//Return true if the trace and span IDs are well formed
+func (tc TraceContext) IsValid() bool {
+	return isValidID(tc.TraceID, TRACE_ID_HEX_SIZE) && isValidID(tc.SpanID, SPAN_ID_HEX_SIZE)
+}
+
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&TRACE_FLAG_SAMPLED != 0
+}
+
+//Return the traceparent value of the context
+func (tc TraceContext) TraceParent() string {
+	return fmt.Sprintf("%s-%s-%s-%02x", TRACEPARENT_VERSION, tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+//Parses the traceparent and tracestate values.
+//
+//Versions other than 00 are accepted as long as the first four fields are valid, as the specification requires
+func ParseTraceParent(traceparent string, tracestate string) (TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	version := parts[0]
+	if len(version) != 2 || !isHex(version) || version == "ff" {
+		return TraceContext{}, fmt.Errorf("invalid traceparent version %q", version)
+	}
+	if version == TRACEPARENT_VERSION && len(parts) != 4 {
+		return TraceContext{}, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	tc := TraceContext{
+		TraceID:    parts[1],
+		SpanID:     parts[2],
+		TraceState: strings.TrimSpace(tracestate),
+	}
+	if !tc.IsValid() {
+		return TraceContext{}, fmt.Errorf("invalid traceparent ids %q", traceparent)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return TraceContext{}, fmt.Errorf("invalid traceparent flags %q", parts[3])
+	}
+	tc.Flags = flags[0]
+	return tc, nil
+}
+
+//Return the trace context sent in the traceparent and tracestate headers
+func ExtractHTTPHeaders(header http.Header) (TraceContext, bool) {
+	if header == nil {
+		return TraceContext{}, false
+	}
+	tc, err := ParseTraceParent(header.Get(TRACEPARENT_KEY), header.Get(TRACESTATE_KEY))
+	if err != nil {
+		return TraceContext{}, false
+	}
+	return tc, true
+}
+
+//Sets the traceparent and tracestate headers, nothing is set if the context is not valid
+func InjectHTTPHeaders(header http.Header, tc TraceContext) {
+	if header == nil || !tc.IsValid() {
+		return
+	}
+	header.Set(TRACEPARENT_KEY, tc.TraceParent())
+	if tc.TraceState != "" {
+		header.Set(TRACESTATE_KEY, tc.TraceState)
+	}
+}
+
+//Return the trace context sent in the _meta of a request or notification
+func ExtractMeta(meta map[string]interface{}) (TraceContext, bool) {
+	traceparent, _ := meta[TRACEPARENT_KEY].(string)
+	if traceparent == "" {
+		return TraceContext{}, false
+	}
+	tracestate, _ := meta[TRACESTATE_KEY].(string)
+	tc, err := ParseTraceParent(traceparent, tracestate)
+	if err != nil {
+		return TraceContext{}, false
+	}
+	return tc, true
+}
+
+//Sets the traceparent and tracestate keys of the _meta, nothing is set if the context is not valid
+func InjectMeta(meta map[string]interface{}, tc TraceContext) {
+	if meta == nil || !tc.IsValid() {
+		return
+	}
+	meta[TRACEPARENT_KEY] = tc.TraceParent()
+	if tc.TraceState != "" {
+		meta[TRACESTATE_KEY] = tc.TraceState
+	}
+}
+
+type remoteTraceContextKey struct{}
+type spanKey struct{}
+
+//Return a context whose spans are children of the trace context received from another process
+func ContextWithRemoteTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	if !tc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteTraceContextKey{}, tc)
+}
+
+//Return a context whose spans are children of the span
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+//Return the span of the context, nil if there is none
+func SpanFromContext(ctx context.Context) Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+//Return the parent of the spans started with the context: its span or else the remote trace context
+func ParentFromContext(ctx context.Context) (TraceContext, bool) {
+	if ctx == nil {
+		return TraceContext{}, false
+	}
+	if span := SpanFromContext(ctx); span != nil {
+		if tc := span.TraceContext(); tc.IsValid() {
+			return tc, true
+		}
+	}
+	tc, ok := ctx.Value(remoteTraceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+func isValidID(id string, size int) bool {
+	return len(id) == size && isHex(id) && strings.Trim(id, "0") != ""
+}
+
+//Only lowercase hex is valid in traceparent
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+)
+
+type SpanKind int
+
+const (
+	SPAN_KIND_INTERNAL SpanKind = iota
+	//Handling of a request received from the remote side
+	SPAN_KIND_SERVER
+	//Request sent to the remote side
+	SPAN_KIND_CLIENT
+	//Notification sent to the remote side
+	SPAN_KIND_PRODUCER
+	//Notification received from the remote side
+	SPAN_KIND_CONSUMER
+)
+
+func (sk SpanKind) String() string {
+	switch sk {
+	case SPAN_KIND_SERVER:
+		return "server"
+	case SPAN_KIND_CLIENT:
+		return "client"
+	case SPAN_KIND_PRODUCER:
+		return "producer"
+	case SPAN_KIND_CONSUMER:
+		return "consumer"
+	}
+	return "internal"
+}
+
+//Creates the spans, implement it to send the spans to a tracing backend (e.g. wrapping an OpenTelemetry tracer).
+type Tracer interface {
+	//Starts a span, parent is the span or remote context the new span is child of and is not valid for a root span.
+	//
+	//ctx is the context of the caller, given to let the implementations read their own values
+	StartSpan(ctx context.Context, name string, kind SpanKind, parent TraceContext, attributes map[string]interface{}) Span
+}
+
+//Operation being traced, its methods must be safe for concurrent use
+type Span interface {
+	SetAttribute(key string, value interface{})
+	//Marks the span as failed, nil errors are ignored
+	RecordError(err error)
+	//Ends the span, calls after the first one do nothing
+	End()
+	//Return the context propagated to the remote side in the requests and notifications sent inside the span
+	TraceContext() TraceContext
+}
+
+//Starts a span child of the span or remote trace context of ctx and return the context with the new span.
+//
+//If tracer is nil NoopTracer is used
+func StartSpan(ctx context.Context, tracer Tracer, name string, kind SpanKind, attributes map[string]interface{}) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if tracer == nil {
+		tracer = NoopTracer{}
+	}
+	parent, _ := ParentFromContext(ctx)
+	span := tracer.StartSpan(ctx, name, kind, parent, attributes)
+	return ContextWithSpan(ctx, span), span
+}
+
+//Tracer that records nothing, the received trace context is still propagated to the remote side
+type NoopTracer struct{}
+
+func (NoopTracer) StartSpan(ctx context.Context, name string, kind SpanKind, parent TraceContext, attributes map[string]interface{}) Span {
+	return noopSpan{parent: parent}
+}
+
+type noopSpan struct {
+	parent TraceContext
+}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) RecordError(err error)                      {}
+func (noopSpan) End()                                       {}
+func (ns noopSpan) TraceContext() TraceContext              { return ns.parent }
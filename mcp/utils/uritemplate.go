@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"regexp"
@@ -297,6 +298,24 @@ func (ut *UriTemplate) String() string {
 	return ut.template
 }
 
+//Marshals the template as its string, a value receiver so the templates held by value are marshaled too
+func (ut UriTemplate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ut.template)
+}
+
+func (ut *UriTemplate) UnmarshalJSON(data []byte) error {
+	var template string
+	if err := json.Unmarshal(data, &template); err != nil {
+		return fmt.Errorf("json.Unmarshal %v", err)
+	}
+	parsed, err := NewUriTemplate(template)
+	if err != nil {
+		return fmt.Errorf("NewUriTemplate %v", err)
+	}
+	*ut = *parsed
+	return nil
+}
+
 func (ut *UriTemplate) Expand(variables UriVariables) (string, error) {
 	var result string
 	var hasQueryParam bool